@@ -1,9 +1,14 @@
 package action
 
 import (
+	"bytes"
 	"fmt"
+	"github.com/akmalfairuz/df-replay/internal"
 	"github.com/samber/lo"
 	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"io"
+	"reflect"
+	"slices"
 )
 
 type Action interface {
@@ -44,28 +49,177 @@ var (
 		IDGeneralSound:            func() Action { return &GeneralSound{} },
 		IDSetPlayerVisibleEffects: func() Action { return &SetPlayerVisibleEffects{} },
 		IDEntityAnimate:           func() Action { return &EntityAnimate{} },
+		IDKeyframe:                func() Action { return &Keyframe{} },
+		IDPlayerSkinRef:           func() Action { return &PlayerSkinRef{} },
+		IDSetSource:               func() Action { return &SetSource{} },
+		IDSetTime:                 func() Action { return &SetTime{} },
+		IDSetWeather:              func() Action { return &SetWeather{} },
 	}
 )
 
-// Read ...
-func Read(io *protocol.Reader, act *Action) (err error) {
+// actionNames maps the names of the types of all actions, as returned by Name, to the IDs of the actions.
+var actionNames = func() map[string]uint8 {
+	names := make(map[string]uint8, len(actionPool))
+	for id, f := range actionPool {
+		names[Name(f())] = id
+	}
+	return names
+}()
+
+// Name returns the name of the type of an action, such as "PlayerMove" for a *PlayerMove.
+func Name(act Action) string {
+	return reflect.TypeOf(act).Elem().Name()
+}
+
+// ByName returns a new, empty action of the type with the name passed, as returned by Name. False is
+// returned if no action has the name passed.
+func ByName(name string) (Action, bool) {
+	if name == Name(&Unknown{}) {
+		return &Unknown{}, true
+	}
+	id, ok := actionNames[name]
+	if !ok {
+		return nil, false
+	}
+	return actionPool[id](), true
+}
+
+// Versioned is implemented by actions of which the encoding changed after they were introduced. Actions
+// that do not implement Versioned have version 0.
+type Versioned interface {
+	// Version returns the version of the encoding written by Marshal.
+	Version() uint8
+}
+
+// LegacyUnmarshaler is implemented by versioned actions that are able to decode payloads written using an
+// older version of their encoding. Actions written using an older version are read as Unknown if the
+// action does not implement LegacyUnmarshaler.
+type LegacyUnmarshaler interface {
+	// UnmarshalVersion decodes a payload written using the version passed, which is lower than the
+	// version returned by Version.
+	UnmarshalVersion(io protocol.IO, version uint8)
+}
+
+// Hashed is implemented by actions that refer to blocks or items by their hash. Hashes are computed from the
+// block and item registry of the server that recorded the action, which may differ from the registry of the
+// server playing it.
+type Hashed interface {
+	// VisitHashes calls block for the hash of every block and item for the hash of every item held by the
+	// action. The hashes may be replaced through the pointers passed.
+	VisitHashes(block, item func(hash *uint32))
+}
+
+// Identified is implemented by actions that refer to players or entities by the IDs assigned to them by the
+// Recorder.
+type Identified interface {
+	// VisitIDs calls player for the ID of every player and entity for the ID of every entity held by the
+	// action. The IDs may be replaced through the pointers passed.
+	VisitIDs(player, entity func(id *uint32))
+}
+
+// payloadChunkSize is the maximum amount of bytes of the payload of an action that Read allocates at once.
+const payloadChunkSize = 4096
+
+// Read reads an action written by Write. Actions with an ID that is not known, that were written using a
+// newer version of their encoding, or of which the payload holds more data than their encoding, are read as
+// Unknown.
+func Read(r interface {
+	io.Reader
+	io.ByteReader
+}, act *Action) (err error) {
+	var id uint8
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("action read id=%d panic: %v", id, r)
+		}
+	}()
+	var version uint8
+	var length uint32
+	header := protocol.NewReader(r, 0, false)
+	header.Uint8(&id)
+	header.Uint8(&version)
+	header.Varuint32(&length)
+	// The payload is read in chunks, so that a corrupted length does not result in a huge allocation before
+	// the end of the data is reached.
+	payload := make([]byte, 0, min(length, payloadChunkSize))
+	for uint32(len(payload)) < length {
+		n := int(min(length-uint32(len(payload)), payloadChunkSize))
+		payload = slices.Grow(payload, n)
+		if _, err := io.ReadFull(r, payload[len(payload):len(payload)+n]); err != nil {
+			return fmt.Errorf("failed to read payload of action id=%d: %w", id, err)
+		}
+		payload = payload[:len(payload)+n]
+	}
+
+	f, ok := actionPool[id]
+	if !ok {
+		*act = &Unknown{ActionID: id, ActionVersion: version, Payload: payload}
+		return nil
+	}
+	a := f()
+	buf := bytes.NewReader(payload)
+	pr := protocol.NewReader(buf, 0, false)
+	if current := VersionOf(a); version == current {
+		a.Marshal(pr)
+	} else if legacy, ok := a.(LegacyUnmarshaler); ok && version < current {
+		legacy.UnmarshalVersion(pr, version)
+	} else {
+		*act = &Unknown{ActionID: id, ActionVersion: version, Payload: payload}
+		return nil
+	}
+	if buf.Len() != 0 {
+		// The payload was written using an encoding that does not match the one known, so the action is kept
+		// as it is rather than played with fields that may be wrong.
+		*act = &Unknown{ActionID: id, ActionVersion: version, Payload: payload}
+		return nil
+	}
+	*act = a
+	return nil
+}
+
+// ReadLegacy reads an action written before actions were prefixed with their version and length. Unlike
+// Read, ReadLegacy fails if the ID of the action is not known, as the action cannot be skipped.
+func ReadLegacy(r interface {
+	io.Reader
+	io.ByteReader
+}, act *Action) (err error) {
 	var id uint8
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("action read id=%d panic: %v", id, r)
 		}
 	}()
-	io.Uint8(&id)
+	pr := protocol.NewReader(r, 0, false)
+	pr.Uint8(&id)
 	if f, ok := actionPool[id]; ok {
 		*act = f()
-		(*act).Marshal(io)
+		(*act).Marshal(pr)
 		return nil
 	}
 	return fmt.Errorf("unknown action id: %d", id)
 }
 
-// Write ...
+// Write writes an action, prefixed with its ID, the version of its encoding and the length of its payload,
+// so that readers that do not know the action are able to skip it.
 func Write(io *protocol.Writer, act Action) {
+	buf := internal.BufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		buf.Reset()
+		internal.BufferPool.Put(buf)
+	}()
+	act.Marshal(protocol.NewWriter(buf, 0))
+	payload := buf.Bytes()
+
 	io.Uint8(lo.ToPtr(act.ID()))
-	act.Marshal(io)
+	io.Uint8(lo.ToPtr(VersionOf(act)))
+	io.ByteSlice(&payload)
+}
+
+// VersionOf returns the version of the encoding of an action, which is 0 for actions that do not implement
+// Versioned.
+func VersionOf(act Action) uint8 {
+	if v, ok := act.(Versioned); ok {
+		return v.Version()
+	}
+	return 0
 }
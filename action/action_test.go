@@ -0,0 +1,95 @@
+package action
+
+import (
+	"bytes"
+	"github.com/samber/lo"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"reflect"
+	"testing"
+)
+
+// writeRaw writes an action with the ID, version and payload passed like Write does.
+func writeRaw(buf *bytes.Buffer, id, version uint8, payload []byte) {
+	w := protocol.NewWriter(buf, 0)
+	w.Uint8(&id)
+	w.Uint8(&version)
+	w.ByteSlice(&payload)
+}
+
+// encodePayload returns the payload written by Write for the action passed.
+func encodePayload(a Action) []byte {
+	buf := bytes.NewBuffer(nil)
+	a.Marshal(protocol.NewWriter(buf, 0))
+	return buf.Bytes()
+}
+
+// TestReadUnknown tests that actions with an ID that is not known, written using a newer version of their
+// encoding or of which the payload holds more data than their encoding are read as Unknown, that the
+// actions following them are still read, and that they are written again unchanged.
+func TestReadUnknown(t *testing.T) {
+	keyframe := encodePayload(&Keyframe{OpenChests: []protocol.BlockPos{{1, 2, 3}}})
+	tests := map[string]*Unknown{
+		"unknown ID":     {ActionID: 250, ActionVersion: 3, Payload: []byte{1, 2, 3}},
+		"newer version":  {ActionID: IDKeyframe, ActionVersion: 2, Payload: append(keyframe, 1, 2)},
+		"unread payload": {ActionID: IDSetSource, ActionVersion: 0, Payload: []byte{7, 0}},
+	}
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			buf := bytes.NewBuffer(nil)
+			writeRaw(buf, want.ActionID, want.ActionVersion, want.Payload)
+			raw := bytes.Clone(buf.Bytes())
+			Write(protocol.NewWriter(buf, 0), &SetSource{Source: 7})
+
+			var a Action
+			if err := Read(buf, &a); err != nil {
+				t.Fatalf("failed to read action: %v", err)
+			}
+			if !reflect.DeepEqual(a, want) {
+				t.Fatalf("read %#v, expected %#v", a, want)
+			}
+			if err := Read(buf, &a); err != nil {
+				t.Fatalf("failed to read action following it: %v", err)
+			}
+			if s, ok := a.(*SetSource); !ok || s.Source != 7 {
+				t.Fatalf("read %#v after it, expected the SetSource written", a)
+			}
+
+			rewritten := bytes.NewBuffer(nil)
+			Write(protocol.NewWriter(rewritten, 0), want)
+			if !bytes.Equal(rewritten.Bytes(), raw) {
+				t.Fatalf("action was written again as %x, expected %x", rewritten.Bytes(), raw)
+			}
+		})
+	}
+}
+
+// TestReadTruncated tests that reading an action of which the payload is cut off, or does not hold all
+// fields of its encoding, fails.
+func TestReadTruncated(t *testing.T) {
+	payload := encodePayload(&Keyframe{OpenChests: []protocol.BlockPos{{1, 2, 3}}})
+
+	buf := bytes.NewBuffer(nil)
+	writeRaw(buf, IDKeyframe, 1, payload)
+	truncated := buf.Bytes()[:buf.Len()-3]
+	var a Action
+	if err := Read(bytes.NewBuffer(truncated), &a); err == nil {
+		t.Fatalf("read %#v from a truncated payload, expected an error", a)
+	}
+
+	// A corrupted length larger than the data left fails without reading past the data.
+	buf = bytes.NewBuffer(nil)
+	w := protocol.NewWriter(buf, 0)
+	w.Uint8(lo.ToPtr[uint8](IDSetSource))
+	w.Uint8(lo.ToPtr[uint8](0))
+	w.Varuint32(lo.ToPtr[uint32](1 << 30))
+	buf.Write(payload)
+	if err := Read(buf, &a); err == nil {
+		t.Fatalf("read %#v with a length past the end of the data, expected an error", a)
+	}
+
+	buf = bytes.NewBuffer(nil)
+	writeRaw(buf, IDKeyframe, 1, payload[:len(payload)-3])
+	if err := Read(buf, &a); err == nil {
+		t.Fatalf("read %#v from a payload missing fields, expected an error", a)
+	}
+}
@@ -45,3 +45,7 @@ func (a *BlockParticle) Play(ctx *PlayContext) {
 	ctx.OnReverse(do)
 	do(ctx)
 }
+
+func (a *BlockParticle) VisitHashes(block, item func(hash *uint32)) {
+	block(&a.Block.Hash)
+}
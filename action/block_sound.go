@@ -40,3 +40,7 @@ func (a *BlockSound) Play(ctx *PlayContext) {
 	ctx.OnReverse(do)
 	do(ctx)
 }
+
+func (a *BlockSound) VisitHashes(block, item func(hash *uint32)) {
+	block(&a.Block.Hash)
+}
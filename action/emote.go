@@ -26,3 +26,7 @@ func (a *Emote) Play(ctx *PlayContext) {
 	ctx.OnReverse(do)
 	do(ctx)
 }
+
+func (a *Emote) VisitIDs(player, entity func(id *uint32)) {
+	player(&a.PlayerID)
+}
@@ -39,3 +39,7 @@ func (a *EntityAnimate) Play(ctx *PlayContext) {
 		do(ctx)
 	}
 }
+
+func (a *EntityAnimate) VisitIDs(player, entity func(id *uint32)) {
+	entity(&a.EntityID)
+}
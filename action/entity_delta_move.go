@@ -11,6 +11,10 @@ const (
 	EntityDeltaMoveHasZFlag
 	EntityDeltaMoveHasYawFlag
 	EntityDeltaMoveHasPitchFlag
+	// EntityDeltaMoveRelativeFlag is set if the axes of the position hold the change since the previous
+	// position rather than the new position. The MovementPrecision of the change is stored in the two
+	// highest bits of the flags.
+	EntityDeltaMoveRelativeFlag
 )
 
 type EntityDeltaMove struct {
@@ -44,7 +48,41 @@ func (a *EntityDeltaMove) HasPitch() bool {
 	return a.Flags&EntityDeltaMoveHasPitchFlag != 0
 }
 
+func (a *EntityDeltaMove) Relative() bool {
+	return a.Flags&EntityDeltaMoveRelativeFlag != 0
+}
+
+func (a *EntityDeltaMove) Precision() MovementPrecision {
+	if !a.Relative() {
+		return MovementPrecisionExact
+	}
+	return MovementPrecision(a.Flags >> deltaMovePrecisionShift)
+}
+
+func (*EntityDeltaMove) Version() uint8 {
+	return 1
+}
+
 func (a *EntityDeltaMove) Marshal(io protocol.IO) {
+	io.Uint8(&a.Flags)
+	io.Varuint32(&a.EntityID)
+	marshalDeltaPosition(io, a.Flags, &a.Position)
+
+	if a.HasYaw() {
+		io.Uint16(&a.Yaw)
+	} else {
+		a.Yaw = 0
+	}
+
+	if a.HasPitch() {
+		io.Uint16(&a.Pitch)
+	} else {
+		a.Pitch = 0
+	}
+}
+
+func (a *EntityDeltaMove) UnmarshalVersion(io protocol.IO, version uint8) {
+	// Version 0 stored every changed axis as an absolute float32.
 	io.Uint8(&a.Flags)
 	io.Varuint32(&a.EntityID)
 	if a.HasX() {
@@ -89,17 +127,8 @@ func (a *EntityDeltaMove) Play(ctx *PlayContext) {
 	ctx.OnReverse(func(ctx *PlayContext) {
 		ctx.Playback().MoveEntity(ctx.Tx(), a.EntityID, prevPos, prevRot)
 	})
-	pos := vec32To64(a.Position)
+	pos := ApplyDeltaPosition(prevPos, a.Flags, a.Position)
 	rot := DecodeRotation16(a.Yaw, a.Pitch)
-	if !a.HasX() {
-		pos[0] = prevPos[0]
-	}
-	if !a.HasY() {
-		pos[1] = prevPos[1]
-	}
-	if !a.HasZ() {
-		pos[2] = prevPos[2]
-	}
 	if !a.HasYaw() {
 		rot[0] = prevRot[0]
 	}
@@ -108,3 +137,7 @@ func (a *EntityDeltaMove) Play(ctx *PlayContext) {
 	}
 	ctx.Playback().MoveEntity(ctx.Tx(), a.EntityID, pos, rot)
 }
+
+func (a *EntityDeltaMove) VisitIDs(player, entity func(id *uint32)) {
+	entity(&a.EntityID)
+}
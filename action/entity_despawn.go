@@ -29,3 +29,7 @@ func (a *EntityDespawn) Play(ctx *PlayContext) {
 	}
 	ctx.Playback().DespawnEntity(ctx.Tx(), a.EntityID)
 }
+
+func (a *EntityDespawn) VisitIDs(player, entity func(id *uint32)) {
+	entity(&a.EntityID)
+}
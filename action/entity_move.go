@@ -32,3 +32,7 @@ func (a *EntityMove) Play(ctx *PlayContext) {
 	}
 	ctx.Playback().MoveEntity(ctx.Tx(), a.EntityID, vec32To64(a.Position), DecodeRotation16(a.Yaw, a.Pitch))
 }
+
+func (a *EntityMove) VisitIDs(player, entity func(id *uint32)) {
+	entity(&a.EntityID)
+}
@@ -25,3 +25,7 @@ func (a *EntityNameTagUpdate) Play(ctx *PlayContext) {
 	})
 	ctx.Playback().SetEntityNameTag(ctx.Tx(), a.EntityID, a.NameTag)
 }
+
+func (a *EntityNameTagUpdate) VisitIDs(player, entity func(id *uint32)) {
+	entity(&a.EntityID)
+}
@@ -35,3 +35,38 @@ func (a *EntitySpawn) Play(ctx *PlayContext) {
 	})
 	ctx.Playback().SpawnEntity(ctx.Tx(), a.EntityID, a.EntityIdentifier, a.NameTag, vec32To64(a.Position), DecodeRotation16(a.Yaw, a.Pitch), a.ExtraData)
 }
+
+func (a *EntitySpawn) VisitHashes(block, item func(hash *uint32)) {
+	visitExtraDataHashes(a.ExtraData, block, item)
+}
+
+func (a *EntitySpawn) VisitIDs(player, entity func(id *uint32)) {
+	entity(&a.EntityID)
+	visitExtraDataIDs(a.ExtraData, player)
+}
+
+// visitExtraDataHashes visits the hash of the item of an item entity and the hash of the block of a falling
+// block held by the extra data of an entity. The extra data is updated if a hash is replaced.
+func visitExtraDataHashes(data map[string]any, block, item func(hash *uint32)) {
+	// Fireworks also store an item, but encoded as NBT rather than as a hash.
+	if v, ok := data["Item"].(int64); ok {
+		hash := uint32(v)
+		item(&hash)
+		data["Item"] = int64(hash)
+	}
+	if v, ok := data["Block"].(int32); ok {
+		hash := uint32(v)
+		block(&hash)
+		data["Block"] = int32(hash)
+	}
+}
+
+// visitExtraDataIDs visits the ID of the player owning an entity, such as the player that shot an arrow, held
+// by the extra data of an entity. The extra data is updated if the ID is replaced.
+func visitExtraDataIDs(data map[string]any, player func(id *uint32)) {
+	if v, ok := data["Owner"].(int32); ok {
+		id := uint32(v)
+		player(&id)
+		data["Owner"] = int32(id)
+	}
+}
@@ -32,4 +32,9 @@ const (
 	IDGeneralSound
 	IDSetPlayerVisibleEffects
 	IDEntityAnimate
+	IDKeyframe
+	IDPlayerSkinRef
+	IDSetSource
+	IDSetTime
+	IDSetWeather
 )
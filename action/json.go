@@ -0,0 +1,522 @@
+package action
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/akmalfairuz/df-replay/internal"
+	"github.com/df-mc/dragonfly/server/world"
+	"github.com/sandertv/gophertunnel/minecraft/nbt"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"math"
+)
+
+// Actions may be encoded as JSON using encoding/json. Blocks and items are encoded by their name and
+// properties rather than by their hash, so that the JSON may be read and edited by hand, and are resolved
+// against the registry of the server when decoded. NBT values are encoded with their type, as in
+// {"$int": 1}, so that they are decoded to the same type. Strings, booleans, lists and compounds are encoded
+// as plain JSON values.
+
+// blockJSON is the JSON encoding of a block. Blocks that are not known to the server are encoded by their
+// hash instead.
+type blockJSON struct {
+	Name       string          `json:",omitempty"`
+	Properties map[string]any  `json:",omitempty"`
+	Hash       uint32          `json:",omitempty"`
+	NBT        json.RawMessage `json:",omitempty"`
+}
+
+// encodeBlockJSON encodes the block with the hash passed.
+func encodeBlockJSON(hash uint32) (blockJSON, error) {
+	b, ok := internal.BlockByHash(hash)
+	if !ok {
+		return blockJSON{Hash: hash}, nil
+	}
+	name, properties := b.EncodeBlock()
+	encoded, err := encodeNBTJSON(properties)
+	if err != nil {
+		return blockJSON{}, err
+	}
+	return blockJSON{Name: name, Properties: encoded.(map[string]any)}, nil
+}
+
+// hash returns the hash of the block, looking it up in the registry of the server.
+func (b blockJSON) hash() (uint32, error) {
+	if b.Name == "" {
+		return b.Hash, nil
+	}
+	properties := map[string]any{}
+	if b.Properties != nil {
+		decoded, err := decodeNBTJSON(b.Properties)
+		if err != nil {
+			return 0, err
+		}
+		properties = decoded.(map[string]any)
+	}
+	block, ok := world.BlockByName(b.Name, properties)
+	if !ok {
+		return 0, fmt.Errorf("unknown block %v %v", b.Name, b.Properties)
+	}
+	return internal.BlockToHash(block), nil
+}
+
+func (b Block) MarshalJSON() ([]byte, error) {
+	enc, err := encodeBlockJSON(b.Hash)
+	if err != nil {
+		return nil, err
+	}
+	if b.HasNBT {
+		if enc.NBT, err = marshalNBTJSON(b.NBT); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(enc)
+}
+
+func (b *Block) UnmarshalJSON(data []byte) error {
+	var dec blockJSON
+	if err := json.Unmarshal(data, &dec); err != nil {
+		return err
+	}
+	hash, err := dec.hash()
+	if err != nil {
+		return err
+	}
+	*b = Block{Hash: hash}
+	if len(dec.NBT) > 0 {
+		b.HasNBT = true
+		return unmarshalNBTJSON(dec.NBT, &b.NBT)
+	}
+	return nil
+}
+
+// itemJSON is the JSON encoding of an item. Items that are not known to the server, such as the item of an
+// empty stack, are encoded by their hash instead.
+type itemJSON struct {
+	Name  string          `json:",omitempty"`
+	Meta  int16           `json:",omitempty"`
+	Hash  uint32          `json:",omitempty"`
+	Flags uint8           `json:",omitempty"`
+	NBT   json.RawMessage `json:",omitempty"`
+}
+
+// encodeItemJSON encodes the item with the hash passed.
+func encodeItemJSON(hash uint32) itemJSON {
+	it, ok := internal.ItemByHash(hash)
+	if !ok {
+		return itemJSON{Hash: hash}
+	}
+	name, meta := it.EncodeItem()
+	return itemJSON{Name: name, Meta: meta}
+}
+
+// hash returns the hash of the item, looking it up in the registry of the server.
+func (i itemJSON) hash() (uint32, error) {
+	if i.Name == "" {
+		return i.Hash, nil
+	}
+	it, ok := world.ItemByName(i.Name, i.Meta)
+	if !ok {
+		return 0, fmt.Errorf("unknown item %v:%v", i.Name, i.Meta)
+	}
+	return internal.ItemToHash(it), nil
+}
+
+func (i Item) MarshalJSON() ([]byte, error) {
+	enc := encodeItemJSON(i.Hash)
+	enc.Flags = i.Flags
+	if i.Flags&ItemFlagHasNBT != 0 {
+		var data map[string]any
+		if err := nbt.UnmarshalEncoding(i.NBT, &data, nbt.NetworkLittleEndian); err != nil {
+			return nil, fmt.Errorf("failed to decode item NBT: %w", err)
+		}
+		var err error
+		if enc.NBT, err = marshalNBTJSON(data); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(enc)
+}
+
+func (i *Item) UnmarshalJSON(data []byte) error {
+	var dec itemJSON
+	if err := json.Unmarshal(data, &dec); err != nil {
+		return err
+	}
+	hash, err := dec.hash()
+	if err != nil {
+		return err
+	}
+	*i = Item{Hash: hash, Flags: dec.Flags}
+	if i.Flags&ItemFlagHasNBT != 0 {
+		var nbtData map[string]any
+		if err := unmarshalNBTJSON(dec.NBT, &nbtData); err != nil {
+			return err
+		}
+		if i.NBT, err = nbt.MarshalEncoding(nbtData, nbt.NetworkLittleEndian); err != nil {
+			return fmt.Errorf("failed to encode item NBT: %w", err)
+		}
+	}
+	return nil
+}
+
+// liquidJSON is the JSON encoding of an action or keyframe entry that sets a liquid.
+type liquidJSON struct {
+	Position protocol.BlockPos
+	Liquid   blockJSON
+}
+
+func (a *SetLiquid) MarshalJSON() ([]byte, error) {
+	liquid, err := encodeBlockJSON(a.LiquidHash)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(liquidJSON{Position: a.Position, Liquid: liquid})
+}
+
+func (a *SetLiquid) UnmarshalJSON(data []byte) (err error) {
+	var dec liquidJSON
+	if err := json.Unmarshal(data, &dec); err != nil {
+		return err
+	}
+	a.Position = dec.Position
+	a.LiquidHash, err = dec.Liquid.hash()
+	return err
+}
+
+func (l KeyframeLiquid) MarshalJSON() ([]byte, error) {
+	return (&SetLiquid{Position: l.Position, LiquidHash: l.LiquidHash}).MarshalJSON()
+}
+
+func (l *KeyframeLiquid) UnmarshalJSON(data []byte) error {
+	var a SetLiquid
+	if err := a.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	l.Position, l.LiquidHash = a.Position, a.LiquidHash
+	return nil
+}
+
+func (a *PlayerSkinRef) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		PlayerID uint32
+		SkinHash string
+	}{PlayerID: a.PlayerID, SkinHash: hex.EncodeToString(a.SkinHash[:])})
+}
+
+func (a *PlayerSkinRef) UnmarshalJSON(data []byte) error {
+	var dec struct {
+		PlayerID uint32
+		SkinHash string
+	}
+	if err := json.Unmarshal(data, &dec); err != nil {
+		return err
+	}
+	hash, err := hex.DecodeString(dec.SkinHash)
+	if err != nil || len(hash) != len(a.SkinHash) {
+		return fmt.Errorf("invalid skin hash %q", dec.SkinHash)
+	}
+	a.PlayerID, a.SkinHash = dec.PlayerID, [32]byte(hash)
+	return nil
+}
+
+// extraData is the extra data of an entity, of which the item and block hashes are encoded as JSON by the
+// name of the item or block.
+type extraData map[string]any
+
+func (e extraData) MarshalJSON() ([]byte, error) {
+	data := make(map[string]any, len(e))
+	for k, v := range e {
+		data[k] = v
+	}
+	encoded, err := encodeNBTJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	m := encoded.(map[string]any)
+	if v, ok := e["Item"].(int64); ok {
+		m["Item"] = map[string]any{"$item": encodeItemJSON(uint32(v))}
+	}
+	if v, ok := e["Block"].(int32); ok {
+		b, err := encodeBlockJSON(uint32(v))
+		if err != nil {
+			return nil, err
+		}
+		m["Block"] = map[string]any{"$block": b}
+	}
+	return json.Marshal(m)
+}
+
+func (e *extraData) UnmarshalJSON(data []byte) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	var hashes struct {
+		Item  *itemJSON  `json:"$item"`
+		Block *blockJSON `json:"$block"`
+	}
+	*e = make(extraData, len(m))
+	for k, raw := range m {
+		hashes.Item, hashes.Block = nil, nil
+		if k == "Item" || k == "Block" {
+			_ = json.Unmarshal(raw, &hashes)
+		}
+		switch {
+		case hashes.Item != nil:
+			hash, err := hashes.Item.hash()
+			if err != nil {
+				return err
+			}
+			(*e)[k] = int64(hash)
+		case hashes.Block != nil:
+			hash, err := hashes.Block.hash()
+			if err != nil {
+				return err
+			}
+			(*e)[k] = int32(hash)
+		default:
+			var v any
+			if err := unmarshalNBTJSON(raw, &v); err != nil {
+				return err
+			}
+			(*e)[k] = v
+		}
+	}
+	return nil
+}
+
+func (a *EntitySpawn) MarshalJSON() ([]byte, error) {
+	type alias EntitySpawn
+	return json.Marshal(struct {
+		*alias
+		ExtraData extraData
+	}{alias: (*alias)(a), ExtraData: a.ExtraData})
+}
+
+func (a *EntitySpawn) UnmarshalJSON(data []byte) error {
+	type alias EntitySpawn
+	dec := struct {
+		*alias
+		ExtraData extraData
+	}{alias: (*alias)(a)}
+	if err := json.Unmarshal(data, &dec); err != nil {
+		return err
+	}
+	a.ExtraData = dec.ExtraData
+	return nil
+}
+
+func (e KeyframeEntity) MarshalJSON() ([]byte, error) {
+	type alias KeyframeEntity
+	return json.Marshal(struct {
+		alias
+		ExtraData extraData
+	}{alias: alias(e), ExtraData: e.ExtraData})
+}
+
+func (e *KeyframeEntity) UnmarshalJSON(data []byte) error {
+	type alias KeyframeEntity
+	dec := struct {
+		*alias
+		ExtraData extraData
+	}{alias: (*alias)(e)}
+	if err := json.Unmarshal(data, &dec); err != nil {
+		return err
+	}
+	e.ExtraData = dec.ExtraData
+	return nil
+}
+
+// marshalNBTJSON encodes NBT data as JSON, keeping the types of its values.
+func marshalNBTJSON(v any) (json.RawMessage, error) {
+	encoded, err := encodeNBTJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(encoded)
+}
+
+// unmarshalNBTJSON decodes NBT data encoded using marshalNBTJSON into the value pointed to by v, which must
+// be a *any or a *map[string]any.
+func unmarshalNBTJSON(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw any
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	decoded, err := decodeNBTJSON(raw)
+	if err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case *any:
+		*v = decoded
+	case *map[string]any:
+		m, ok := decoded.(map[string]any)
+		if !ok && decoded != nil {
+			return fmt.Errorf("expected NBT compound, got %T", decoded)
+		}
+		*v = m
+	}
+	return nil
+}
+
+// nbtTags holds the tags used to encode NBT values of which the type cannot be derived from their JSON
+// encoding.
+var nbtTags = map[string]func(v any) (any, error){
+	"$byte":   func(v any) (any, error) { return convertNumber[uint8](v) },
+	"$short":  func(v any) (any, error) { return convertNumber[int16](v) },
+	"$int":    func(v any) (any, error) { return convertNumber[int32](v) },
+	"$long":   func(v any) (any, error) { return convertNumber[int64](v) },
+	"$float":  func(v any) (any, error) { return convertNumber[float32](v) },
+	"$double": func(v any) (any, error) { return convertNumber[float64](v) },
+	"$bytes":  func(v any) (any, error) { return convertNumbers[uint8](v) },
+	"$ints":   func(v any) (any, error) { return convertNumbers[int32](v) },
+	"$longs":  func(v any) (any, error) { return convertNumbers[int64](v) },
+}
+
+// encodeNBTJSON converts an NBT value into a value that may be encoded as JSON without losing its type.
+func encodeNBTJSON(v any) (any, error) {
+	switch v := v.(type) {
+	case nil, string, bool:
+		return v, nil
+	case uint8:
+		return map[string]any{"$byte": v}, nil
+	case int8:
+		return map[string]any{"$byte": uint8(v)}, nil
+	case int16:
+		return map[string]any{"$short": v}, nil
+	case int32:
+		return map[string]any{"$int": v}, nil
+	case int64:
+		return map[string]any{"$long": v}, nil
+	case float32:
+		return map[string]any{"$float": v}, nil
+	case float64:
+		return map[string]any{"$double": v}, nil
+	case []uint8:
+		// Byte slices are encoded as a list of numbers rather than base64, so that they remain readable.
+		ints := make([]int, len(v))
+		for i, b := range v {
+			ints[i] = int(b)
+		}
+		return map[string]any{"$bytes": ints}, nil
+	case []int32:
+		return map[string]any{"$ints": v}, nil
+	case []int64:
+		return map[string]any{"$longs": v}, nil
+	case []any:
+		list := make([]any, len(v))
+		for i, e := range v {
+			var err error
+			if list[i], err = encodeNBTJSON(e); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case []map[string]any:
+		list := make([]any, len(v))
+		for i, e := range v {
+			var err error
+			if list[i], err = encodeNBTJSON(e); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			var err error
+			if m[k], err = encodeNBTJSON(e); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unsupported NBT value of type %T", v)
+}
+
+// decodeNBTJSON converts a value decoded from JSON using json.Decoder.UseNumber, encoded using
+// encodeNBTJSON, back into an NBT value. Numbers without a type are decoded as int32 if they are whole
+// numbers and as float64 otherwise.
+func decodeNBTJSON(v any) (any, error) {
+	switch v := v.(type) {
+	case nil, string, bool:
+		return v, nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil && i >= math.MinInt32 && i <= math.MaxInt32 {
+			return int32(i), nil
+		}
+		return v.Float64()
+	case float64:
+		// Values decoded without UseNumber, such as block properties.
+		if v == math.Trunc(v) && v >= math.MinInt32 && v <= math.MaxInt32 {
+			return int32(v), nil
+		}
+		return v, nil
+	case []any:
+		list := make([]any, len(v))
+		for i, e := range v {
+			var err error
+			if list[i], err = decodeNBTJSON(e); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case map[string]any:
+		if len(v) == 1 {
+			for k, e := range v {
+				if convert, ok := nbtTags[k]; ok {
+					return convert(e)
+				}
+			}
+		}
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			var err error
+			if m[k], err = decodeNBTJSON(e); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return nil, fmt.Errorf("unsupported JSON value of type %T", v)
+}
+
+// convertNumber converts a number decoded from JSON to the numeric type T.
+func convertNumber[T uint8 | int16 | int32 | int64 | float32 | float64](v any) (any, error) {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return T(i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %v", v)
+		}
+		return T(f), nil
+	case float64:
+		return T(v), nil
+	}
+	return nil, fmt.Errorf("expected number, got %T", v)
+}
+
+// convertNumbers converts a list of numbers decoded from JSON to a slice of the numeric type T.
+func convertNumbers[T uint8 | int32 | int64](v any) (any, error) {
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("expected list, got %T", v)
+	}
+	s := make([]T, len(list))
+	for i, e := range list {
+		n, err := convertNumber[T](e)
+		if err != nil {
+			return nil, err
+		}
+		s[i] = n.(T)
+	}
+	return s, nil
+}
@@ -0,0 +1,204 @@
+package action
+
+import (
+	"github.com/df-mc/dragonfly/server/world"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/sandertv/gophertunnel/minecraft/nbt"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+)
+
+// Keyframe holds the complete state of the scene at the start of the tick it was recorded in, before any
+// of the other actions of that tick. Keyframes are written periodically so that a playback can jump to any
+// tick by restoring the nearest keyframe and playing the ticks after it, rather than playing every tick
+// from the start of the replay.
+type Keyframe struct {
+	Players  []KeyframePlayer
+	Entities []KeyframeEntity
+	// Blocks holds every block changed since the start of the recording.
+	Blocks []KeyframeBlock
+	// Liquids holds every liquid changed since the start of the recording.
+	Liquids []KeyframeLiquid
+	// OpenChests holds the positions of all chests that are open.
+	OpenChests []protocol.BlockPos
+	// Time is the time of the world during the tick of the keyframe, or nil if no time was recorded before
+	// the keyframe.
+	Time *SetTime
+	// Weather is the weather of the world, or nil if no weather was recorded before the keyframe.
+	Weather *SetWeather
+}
+
+func (*Keyframe) ID() uint8 {
+	return IDKeyframe
+}
+
+func (*Keyframe) Version() uint8 {
+	return 1
+}
+
+func (a *Keyframe) Marshal(io protocol.IO) {
+	protocol.Slice(io, &a.Players)
+	protocol.Slice(io, &a.Entities)
+	protocol.Slice(io, &a.Blocks)
+	protocol.Slice(io, &a.Liquids)
+	protocol.FuncSlice(io, &a.OpenChests, io.BlockPos)
+	optional(io, &a.Time)
+	optional(io, &a.Weather)
+}
+
+func (a *Keyframe) UnmarshalVersion(io protocol.IO, version uint8) {
+	// Version 0 did not hold the time and weather of the world.
+	protocol.Slice(io, &a.Players)
+	protocol.Slice(io, &a.Entities)
+	protocol.Slice(io, &a.Blocks)
+	protocol.Slice(io, &a.Liquids)
+	protocol.FuncSlice(io, &a.OpenChests, io.BlockPos)
+}
+
+// optional reads or writes a value that may be nil, preceded by a bool that is true if it is not nil.
+func optional[T any, M interface {
+	*T
+	Marshal(io protocol.IO)
+}](io protocol.IO, x **T) {
+	set := *x != nil
+	io.Bool(&set)
+	if !set {
+		*x = nil
+		return
+	}
+	if *x == nil {
+		*x = new(T)
+	}
+	M(*x).Marshal(io)
+}
+
+func (a *Keyframe) VisitHashes(block, item func(hash *uint32)) {
+	for i := range a.Players {
+		p := &a.Players[i]
+		for _, it := range []*Item{&p.Helmet, &p.Chestplate, &p.Leggings, &p.Boots, &p.MainHand, &p.OffHand} {
+			item(&it.Hash)
+		}
+	}
+	for _, e := range a.Entities {
+		visitExtraDataHashes(e.ExtraData, block, item)
+	}
+	for i := range a.Blocks {
+		block(&a.Blocks[i].Block.Hash)
+	}
+	for i := range a.Liquids {
+		block(&a.Liquids[i].LiquidHash)
+	}
+}
+
+func (a *Keyframe) VisitIDs(player, entity func(id *uint32)) {
+	for i := range a.Players {
+		player(&a.Players[i].PlayerID)
+	}
+	for i := range a.Entities {
+		entity(&a.Entities[i].EntityID)
+		visitExtraDataIDs(a.Entities[i].ExtraData, player)
+	}
+}
+
+// Play does nothing: the state held by the keyframe is already reached by playing the ticks before it. The
+// keyframe is only used when seeking.
+func (a *Keyframe) Play(*PlayContext) {}
+
+// KeyframePlayer is the state of a player in a Keyframe.
+type KeyframePlayer struct {
+	PlayerID   uint32
+	PlayerName string
+	NameTag    string
+
+	Position   mgl32.Vec3
+	Yaw, Pitch uint16
+
+	Helmet, Chestplate, Leggings, Boots Item
+	MainHand, OffHand                   Item
+
+	// States holds a bit for every SetPlayerState type, set if the state is enabled.
+	States  uint16
+	Effects []uint8
+	// SkinTick is the tick holding the PlayerSkin or PlayerSkinRef action of the skin of the player, or 0 if
+	// no skin was recorded for the player.
+	SkinTick uint32
+}
+
+// State returns the value of the SetPlayerState type passed.
+func (p *KeyframePlayer) State(stateType uint8) bool {
+	return p.States&(1<<stateType) != 0
+}
+
+// SetState sets the value of the SetPlayerState type passed.
+func (p *KeyframePlayer) SetState(stateType uint8, value bool) {
+	if value {
+		p.States |= 1 << stateType
+	} else {
+		p.States &^= 1 << stateType
+	}
+}
+
+func (p *KeyframePlayer) Marshal(io protocol.IO) {
+	io.Varuint32(&p.PlayerID)
+	io.String(&p.PlayerName)
+	io.String(&p.NameTag)
+	io.Vec3(&p.Position)
+	io.Uint16(&p.Yaw)
+	io.Uint16(&p.Pitch)
+	protocol.Single(io, &p.Helmet)
+	protocol.Single(io, &p.Chestplate)
+	protocol.Single(io, &p.Leggings)
+	protocol.Single(io, &p.Boots)
+	protocol.Single(io, &p.MainHand)
+	protocol.Single(io, &p.OffHand)
+	io.Uint16(&p.States)
+	protocol.FuncSlice(io, &p.Effects, io.Uint8)
+	io.Varuint32(&p.SkinTick)
+}
+
+// KeyframeEntity is the state of an entity in a Keyframe.
+type KeyframeEntity struct {
+	EntityID         uint32
+	EntityIdentifier string
+	NameTag          string
+	Position         mgl32.Vec3
+	Yaw, Pitch       uint16
+	ExtraData        map[string]any
+}
+
+func (e *KeyframeEntity) Marshal(io protocol.IO) {
+	io.Varuint32(&e.EntityID)
+	io.String(&e.EntityIdentifier)
+	io.String(&e.NameTag)
+	io.Vec3(&e.Position)
+	io.Uint16(&e.Yaw)
+	io.Uint16(&e.Pitch)
+	io.NBT(&e.ExtraData, nbt.LittleEndian)
+}
+
+// KeyframeBlock is a block changed since the start of the recording.
+type KeyframeBlock struct {
+	Position protocol.BlockPos
+	Block    Block
+}
+
+func (b *KeyframeBlock) Marshal(io protocol.IO) {
+	io.BlockPos(&b.Position)
+	protocol.Single(io, &b.Block)
+}
+
+// KeyframeLiquid is a liquid changed since the start of the recording. LiquidHash is the hash of air if
+// the liquid was removed.
+type KeyframeLiquid struct {
+	Position   protocol.BlockPos
+	LiquidHash uint32
+}
+
+// Liquid returns the liquid of the KeyframeLiquid. A nil liquid is returned if the liquid was removed.
+func (l *KeyframeLiquid) Liquid() (world.Liquid, bool) {
+	return liquidFromHash(l.LiquidHash)
+}
+
+func (l *KeyframeLiquid) Marshal(io protocol.IO) {
+	io.BlockPos(&l.Position)
+	io.Uint32(&l.LiquidHash)
+}
@@ -0,0 +1,44 @@
+package action
+
+import (
+	"bytes"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/samber/lo"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"reflect"
+	"testing"
+)
+
+// TestKeyframeVersion0 tests that keyframes written using version 0 of their encoding, which did not hold
+// the time and weather of the world, are read with the rest of their state intact.
+func TestKeyframeVersion0(t *testing.T) {
+	want := &Keyframe{
+		Players:    []KeyframePlayer{{PlayerID: 1, PlayerName: "alice", NameTag: "alice", Position: mgl32.Vec3{1, 64, 2}, Yaw: 100, States: 1, Effects: []uint8{}}},
+		Entities:   []KeyframeEntity{{EntityID: 3, EntityIdentifier: "minecraft:pig", Position: mgl32.Vec3{4, 64, 4}, ExtraData: map[string]any{}}},
+		Blocks:     []KeyframeBlock{{Position: protocol.BlockPos{1, 63, 0}, Block: Block{Hash: 12345}}},
+		Liquids:    []KeyframeLiquid{},
+		OpenChests: []protocol.BlockPos{{5, 64, 5}},
+	}
+	buf := bytes.NewBuffer(nil)
+	want.Marshal(protocol.NewWriter(buf, 0))
+	// Version 0 ended after the open chests, so the two bools that mark the time and weather absent are cut off.
+	payload := buf.Bytes()[:buf.Len()-2]
+
+	buf = bytes.NewBuffer(nil)
+	w := protocol.NewWriter(buf, 0)
+	w.Uint8(lo.ToPtr[uint8](IDKeyframe))
+	w.Uint8(lo.ToPtr[uint8](0))
+	w.ByteSlice(&payload)
+
+	var a Action
+	if err := Read(buf, &a); err != nil {
+		t.Fatalf("failed to read keyframe: %v", err)
+	}
+	got, ok := a.(*Keyframe)
+	if !ok {
+		t.Fatalf("read %T, expected a *Keyframe", a)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("read keyframe %+v, expected %+v", got, want)
+	}
+}
@@ -0,0 +1,124 @@
+package action
+
+import (
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/go-gl/mathgl/mgl64"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"math"
+)
+
+// MovementPrecision is the precision with which PlayerDeltaMove and EntityDeltaMove store the change of a
+// position. Changes are stored as varints counting steps of a fixed size, which is a lot smaller than the
+// absolute float32 values stored otherwise when a player or entity moves only a little every tick.
+type MovementPrecision uint8
+
+const (
+	// MovementPrecisionFine stores changes of position in steps of 1/4096 block.
+	MovementPrecisionFine MovementPrecision = iota
+	// MovementPrecisionMedium stores changes of position in steps of 1/1024 block.
+	MovementPrecisionMedium
+	// MovementPrecisionCoarse stores changes of position in steps of 1/256 block.
+	MovementPrecisionCoarse
+	// MovementPrecisionLow stores changes of position in steps of 1/32 block.
+	MovementPrecisionLow
+	// MovementPrecisionExact stores every changed axis as an absolute float32 instead of a change.
+	MovementPrecisionExact
+)
+
+// movementSteps holds the size of the steps of every MovementPrecision but MovementPrecisionExact.
+var movementSteps = [...]float64{1.0 / 4096, 1.0 / 1024, 1.0 / 256, 1.0 / 32}
+
+// maxMovementSteps is the amount of steps from which a change of position is stored as an absolute position
+// instead, as is the case for teleports. Varints of changes this large take up as much space as a float32.
+const maxMovementSteps = 1 << 20
+
+// Step returns the size of the steps in blocks in which changes of position are stored, or 0 for
+// MovementPrecisionExact.
+func (p MovementPrecision) Step() float64 {
+	if int(p) >= len(movementSteps) {
+		return 0
+	}
+	return movementSteps[p]
+}
+
+// Flags of PlayerDeltaMove and EntityDeltaMove that are shared between both actions. The axes of the
+// position of actions with deltaMoveRelativeFlag set hold the change since the previous position, and the
+// precision of the change is stored in the two highest bits.
+const (
+	deltaMoveRelativeFlag   = 1 << 5
+	deltaMovePrecisionShift = 6
+)
+
+// EncodeDeltaPosition returns the flags and the position of a PlayerDeltaMove or EntityDeltaMove that moves
+// a player or entity from prev to pos using the precision passed, along with the position that the player or
+// entity ends up at when the action is played. Axes that change by less than half a step are left unchanged.
+// Changes that are too large to be stored efficiently, such as teleports, are stored as absolute values.
+// Only the flags of the axes of the position are set.
+func EncodeDeltaPosition(prev, pos mgl64.Vec3, precision MovementPrecision) (flags uint8, position mgl32.Vec3, reached mgl64.Vec3) {
+	reached = prev
+	if step := precision.Step(); step != 0 {
+		var steps [3]float64
+		relative := true
+		for i := range 3 {
+			steps[i] = math.Round((pos[i] - prev[i]) / step)
+			relative = relative && math.Abs(steps[i]) < maxMovementSteps
+		}
+		if relative {
+			for i := range 3 {
+				if steps[i] == 0 {
+					continue
+				}
+				flags |= 1 << i
+				position[i] = float32(steps[i] * step)
+				reached[i] += float64(position[i])
+			}
+			if flags != 0 {
+				flags |= deltaMoveRelativeFlag | uint8(precision)<<deltaMovePrecisionShift
+			}
+			return flags, position, reached
+		}
+	}
+	for i := range 3 {
+		if float32(pos[i]) == float32(prev[i]) {
+			continue
+		}
+		flags |= 1 << i
+		position[i] = float32(pos[i])
+		reached[i] = float64(position[i])
+	}
+	return flags, position, reached
+}
+
+// marshalDeltaPosition encodes or decodes the axes of the position of a PlayerDeltaMove or EntityDeltaMove
+// with the flags passed. Changes of position are written as the amount of steps of their precision.
+func marshalDeltaPosition(io protocol.IO, flags uint8, pos *mgl32.Vec3) {
+	step := MovementPrecision(flags >> deltaMovePrecisionShift).Step()
+	for i := range 3 {
+		switch {
+		case flags&(1<<i) == 0:
+			pos[i] = 0
+		case flags&deltaMoveRelativeFlag != 0:
+			steps := int32(math.Round(float64(pos[i]) / step))
+			io.Varint32(&steps)
+			pos[i] = float32(float64(steps) * step)
+		default:
+			io.Float32(&pos[i])
+		}
+	}
+}
+
+// ApplyDeltaPosition returns the position that a PlayerDeltaMove or EntityDeltaMove with the flags and
+// position passed moves a player or entity at prev to. It is the counterpart of EncodeDeltaPosition.
+func ApplyDeltaPosition(prev mgl64.Vec3, flags uint8, pos mgl32.Vec3) mgl64.Vec3 {
+	for i := range 3 {
+		if flags&(1<<i) == 0 {
+			continue
+		}
+		if flags&deltaMoveRelativeFlag != 0 {
+			prev[i] += float64(pos[i])
+		} else {
+			prev[i] = float64(pos[i])
+		}
+	}
+	return prev
+}
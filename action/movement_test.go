@@ -0,0 +1,149 @@
+package action
+
+import (
+	"bytes"
+	"github.com/go-gl/mathgl/mgl64"
+	"github.com/klauspost/compress/zstd"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"math"
+	"testing"
+)
+
+// movementTraceTicks is the amount of ticks in the trace returned by movementTrace.
+const movementTraceTicks = 2400
+
+// movementTrace returns the positions and yaw of a player running in circles far from the origin of the
+// world, jumping every second and standing still every now and then, for every tick of the trace.
+func movementTrace() ([]mgl64.Vec3, []uint16) {
+	positions, yaws := make([]mgl64.Vec3, movementTraceTicks), make([]uint16, movementTraceTicks)
+	centre, angle := mgl64.Vec3{123456.5, 64, -654321.5}, 0.0
+	for tick := range movementTraceTicks {
+		if tick%200 >= 160 {
+			// The player stands still for the last two seconds of every ten.
+			positions[tick], yaws[tick] = positions[tick-1], yaws[tick-1]
+			continue
+		}
+		angle += 0.28 / 24
+		y := centre[1]
+		if t := float64(tick%20) / 20; t < 0.6 {
+			y += 1.25 * math.Sin(t/0.6*math.Pi)
+		}
+		positions[tick] = mgl64.Vec3{centre[0] + 24*math.Cos(angle), y, centre[2] + 24*math.Sin(angle)}
+		yaws[tick] = uint16(angle / (2 * math.Pi) * math.MaxUint16)
+	}
+	return positions, yaws
+}
+
+// encodeMovementTrace encodes the trace returned by movementTrace as PlayerDeltaMove actions using the
+// precision passed. If legacy is true, the actions are encoded like version 0 of PlayerDeltaMove, which
+// stored every changed axis as an absolute float32.
+func encodeMovementTrace(precision MovementPrecision, legacy bool) []byte {
+	positions, yaws := movementTrace()
+	buf := bytes.NewBuffer(nil)
+	w := protocol.NewWriter(buf, 0)
+	prev, prevYaw := positions[0], yaws[0]
+	for tick := 1; tick < len(positions); tick++ {
+		a := &PlayerDeltaMove{PlayerID: 1, Yaw: yaws[tick]}
+		if legacy {
+			a.Flags, a.Position, prev = EncodeDeltaPosition(prev, positions[tick], MovementPrecisionExact)
+		} else {
+			a.Flags, a.Position, prev = EncodeDeltaPosition(prev, positions[tick], precision)
+		}
+		if yaws[tick] != prevYaw {
+			a.Flags |= PlayerDeltaMoveHasYawFlag
+			prevYaw = yaws[tick]
+		}
+		if a.Flags == 0 {
+			continue
+		}
+		if legacy {
+			// Version 0 is read and written the same way, so reading it using a writer writes it.
+			a.UnmarshalVersion(w, 0)
+		} else {
+			a.Marshal(w)
+		}
+	}
+	return buf.Bytes()
+}
+
+// TestDeltaPositionLargeCoordinates tests that positions far from the origin of the world, where float32
+// cannot hold small changes of position, are followed by delta movements of every MovementPrecision that are
+// encoded and decoded again, without the error growing over time.
+func TestDeltaPositionLargeCoordinates(t *testing.T) {
+	start := mgl64.Vec3{3000000.3, 70, -3000000.7}
+	for _, precision := range []MovementPrecision{MovementPrecisionFine, MovementPrecisionMedium, MovementPrecisionCoarse, MovementPrecisionLow} {
+		step := precision.Step()
+		encoded, decoded := start, start
+		buf := bytes.NewBuffer(nil)
+		for tick := 1; tick <= 2000; tick++ {
+			pos := start.Add(mgl64.Vec3{float64(tick) * 0.1301, math.Sin(float64(tick)/10) * 1.2, float64(tick) * -0.0457})
+			a := &PlayerDeltaMove{PlayerID: 1}
+			a.Flags, a.Position, encoded = EncodeDeltaPosition(encoded, pos, precision)
+			if a.Flags != 0 && !a.Relative() {
+				t.Fatalf("precision %d: movement of tick %d was not stored as a change of position", precision, tick)
+			}
+
+			buf.Reset()
+			a.Marshal(protocol.NewWriter(buf, 0))
+			read := &PlayerDeltaMove{}
+			read.Marshal(protocol.NewReader(buf, 0, false))
+			decoded = ApplyDeltaPosition(decoded, read.Flags, read.Position)
+			if decoded != encoded {
+				t.Fatalf("precision %d: tick %d was decoded as %v, expected %v", precision, tick, decoded, encoded)
+			}
+			for i := range 3 {
+				if diff := math.Abs(decoded[i] - pos[i]); diff > step/2+1e-9 {
+					t.Fatalf("precision %d: axis %d of tick %d is off by %v, more than half a step of %v", precision, i, tick, diff, step)
+				}
+			}
+		}
+	}
+}
+
+// TestDeltaPositionTeleport tests that changes of position too large to store as steps are stored as
+// absolute positions.
+func TestDeltaPositionTeleport(t *testing.T) {
+	prev, pos := mgl64.Vec3{3000000.3, 70, 5}, mgl64.Vec3{-100.25, 80, 5}
+	flags, position, reached := EncodeDeltaPosition(prev, pos, MovementPrecisionFine)
+	if flags&deltaMoveRelativeFlag != 0 || flags != PlayerDeltaMoveHasXFlag|PlayerDeltaMoveHasYFlag {
+		t.Fatalf("teleport was encoded with flags %08b, expected absolute X and Y", flags)
+	}
+	if position[0] != -100.25 || position[1] != 80 || reached != pos {
+		t.Fatalf("teleport was encoded as %v reaching %v, expected %v", position, reached, pos)
+	}
+	if got := ApplyDeltaPosition(prev, flags, position); got != pos {
+		t.Fatalf("teleport was applied as %v, expected %v", got, pos)
+	}
+}
+
+// BenchmarkDeltaMoveSize reports the size per tick of the movements of a fixed trace encoded like version 0
+// of PlayerDeltaMove and using every MovementPrecision, both as encoded and compressed using zstd.
+func BenchmarkDeltaMoveSize(b *testing.B) {
+	encodings := []struct {
+		name      string
+		precision MovementPrecision
+		legacy    bool
+	}{
+		{"v0", MovementPrecisionExact, true},
+		{"exact", MovementPrecisionExact, false},
+		{"fine", MovementPrecisionFine, false},
+		{"medium", MovementPrecisionMedium, false},
+		{"coarse", MovementPrecisionCoarse, false},
+		{"low", MovementPrecisionLow, false},
+	}
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer enc.Close()
+	for _, e := range encodings {
+		b.Run(e.name, func(b *testing.B) {
+			var encoded []byte
+			for b.Loop() {
+				encoded = encodeMovementTrace(e.precision, e.legacy)
+			}
+			b.ReportMetric(float64(len(encoded))/movementTraceTicks, "B/tick")
+			b.ReportMetric(float64(len(enc.EncodeAll(encoded, nil)))/movementTraceTicks, "zstd-B/tick")
+		})
+	}
+}
@@ -29,3 +29,7 @@ func (a *PlaceBlock) Play(ctx *PlayContext) {
 	ctx.Playback().SetBlock(ctx.Tx(), pos, b)
 	ctx.Playback().PlaySound(ctx.Tx(), pos.Vec3Centre(), sound.BlockPlace{Block: b})
 }
+
+func (a *PlaceBlock) VisitHashes(block, item func(hash *uint32)) {
+	block(&a.Block.Hash)
+}
@@ -33,6 +33,8 @@ type Playback interface {
 	AddParticle(tx *world.Tx, pos mgl64.Vec3, p world.Particle)
 	PlaySound(tx *world.Tx, pos mgl64.Vec3, s world.Sound)
 	UpdatePlayerSkin(tx *world.Tx, id uint32, skin skin.Skin)
+	PlayerSkinRef(id uint32) ([32]byte, bool)
+	UpdatePlayerSkinRef(tx *world.Tx, id uint32, hash [32]byte)
 	PlayerName(id uint32) string
 	PlayerNameTag(tx *world.Tx, id uint32) string
 	SpawnEntity(tx *world.Tx, id uint32, identifier, nameTag string, pos mgl64.Vec3, rot cube.Rotation, extraData map[string]interface{})
@@ -72,4 +74,8 @@ type Playback interface {
 	DoPlayerEnchantedHit(tx *world.Tx, id uint32)
 	DoFireworkExplosion(tx *world.Tx, id uint32)
 	DoArrowShake(tx *world.Tx, id uint32)
+	Time(tx *world.Tx) (time int64, cycle bool)
+	SetTime(tx *world.Tx, time int64, cycle bool)
+	Weather(tx *world.Tx) (raining, thundering bool)
+	SetWeather(tx *world.Tx, raining, thundering bool)
 }
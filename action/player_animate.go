@@ -79,3 +79,7 @@ func (a *PlayerAnimate) Play(ctx *PlayContext) {
 		// Unknown animation.
 	}
 }
+
+func (a *PlayerAnimate) VisitIDs(player, entity func(id *uint32)) {
+	player(&a.PlayerID)
+}
@@ -33,3 +33,14 @@ func (a *PlayerArmorChange) Play(ctx *PlayContext) {
 	}
 	ctx.Playback().UpdatePlayerArmours(ctx.Tx(), a.PlayerID, a.Helmet.ToStack(), a.Chestplate.ToStack(), a.Leggings.ToStack(), a.Boots.ToStack())
 }
+
+func (a *PlayerArmorChange) VisitHashes(block, item func(hash *uint32)) {
+	item(&a.Helmet.Hash)
+	item(&a.Chestplate.Hash)
+	item(&a.Leggings.Hash)
+	item(&a.Boots.Hash)
+}
+
+func (a *PlayerArmorChange) VisitIDs(player, entity func(id *uint32)) {
+	player(&a.PlayerID)
+}
@@ -11,6 +11,10 @@ const (
 	PlayerDeltaMoveHasZFlag
 	PlayerDeltaMoveHasYawFlag
 	PlayerDeltaMoveHasPitchFlag
+	// PlayerDeltaMoveRelativeFlag is set if the axes of the position hold the change since the previous
+	// position rather than the new position. The MovementPrecision of the change is stored in the two
+	// highest bits of the flags.
+	PlayerDeltaMoveRelativeFlag
 )
 
 type PlayerDeltaMove struct {
@@ -44,7 +48,41 @@ func (a *PlayerDeltaMove) HasPitch() bool {
 	return a.Flags&PlayerDeltaMoveHasPitchFlag != 0
 }
 
+func (a *PlayerDeltaMove) Relative() bool {
+	return a.Flags&PlayerDeltaMoveRelativeFlag != 0
+}
+
+func (a *PlayerDeltaMove) Precision() MovementPrecision {
+	if !a.Relative() {
+		return MovementPrecisionExact
+	}
+	return MovementPrecision(a.Flags >> deltaMovePrecisionShift)
+}
+
+func (*PlayerDeltaMove) Version() uint8 {
+	return 1
+}
+
 func (a *PlayerDeltaMove) Marshal(io protocol.IO) {
+	io.Uint8(&a.Flags)
+	io.Varuint32(&a.PlayerID)
+	marshalDeltaPosition(io, a.Flags, &a.Position)
+
+	if a.HasYaw() {
+		io.Uint16(&a.Yaw)
+	} else {
+		a.Yaw = 0
+	}
+
+	if a.HasPitch() {
+		io.Uint16(&a.Pitch)
+	} else {
+		a.Pitch = 0
+	}
+}
+
+func (a *PlayerDeltaMove) UnmarshalVersion(io protocol.IO, version uint8) {
+	// Version 0 stored every changed axis as an absolute float32.
 	io.Uint8(&a.Flags)
 	io.Varuint32(&a.PlayerID)
 	if a.HasX() {
@@ -89,17 +127,8 @@ func (a *PlayerDeltaMove) Play(ctx *PlayContext) {
 	ctx.OnReverse(func(ctx *PlayContext) {
 		ctx.Playback().MovePlayer(ctx.Tx(), a.PlayerID, prevPos, prevRot)
 	})
-	pos := vec32To64(a.Position)
+	pos := ApplyDeltaPosition(prevPos, a.Flags, a.Position)
 	rot := DecodeRotation16(a.Yaw, a.Pitch)
-	if !a.HasX() {
-		pos[0] = prevPos[0]
-	}
-	if !a.HasY() {
-		pos[1] = prevPos[1]
-	}
-	if !a.HasZ() {
-		pos[2] = prevPos[2]
-	}
 	if !a.HasYaw() {
 		rot[0] = prevRot[0]
 	}
@@ -108,3 +137,7 @@ func (a *PlayerDeltaMove) Play(ctx *PlayContext) {
 	}
 	ctx.Playback().MovePlayer(ctx.Tx(), a.PlayerID, pos, rot)
 }
+
+func (a *PlayerDeltaMove) VisitIDs(player, entity func(id *uint32)) {
+	player(&a.PlayerID)
+}
@@ -34,3 +34,7 @@ func (a *PlayerDespawn) Play(ctx *PlayContext) {
 	}
 	ctx.Playback().DespawnPlayer(ctx.Tx(), a.PlayerID)
 }
+
+func (a *PlayerDespawn) VisitIDs(player, entity func(id *uint32)) {
+	player(&a.PlayerID)
+}
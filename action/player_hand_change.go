@@ -29,3 +29,12 @@ func (a *PlayerHandChange) Play(ctx *PlayContext) {
 	}
 	ctx.Playback().UpdatePlayerHeldItems(ctx.Tx(), a.PlayerID, a.MainHand.ToStack(), a.OffHand.ToStack())
 }
+
+func (a *PlayerHandChange) VisitHashes(block, item func(hash *uint32)) {
+	item(&a.MainHand.Hash)
+	item(&a.OffHand.Hash)
+}
+
+func (a *PlayerHandChange) VisitIDs(player, entity func(id *uint32)) {
+	player(&a.PlayerID)
+}
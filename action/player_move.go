@@ -32,3 +32,7 @@ func (a *PlayerMove) Play(ctx *PlayContext) {
 	}
 	ctx.Playback().MovePlayer(ctx.Tx(), a.PlayerID, vec32To64(a.Position), DecodeRotation16(a.Yaw, a.Pitch))
 }
+
+func (a *PlayerMove) VisitIDs(player, entity func(id *uint32)) {
+	player(&a.PlayerID)
+}
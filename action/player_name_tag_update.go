@@ -25,3 +25,7 @@ func (a *PlayerNameTagUpdate) Play(ctx *PlayContext) {
 	})
 	ctx.Playback().SetPlayerNameTag(ctx.Tx(), a.PlayerID, a.NameTag)
 }
+
+func (a *PlayerNameTagUpdate) VisitIDs(player, entity func(id *uint32)) {
+	player(&a.PlayerID)
+}
@@ -48,6 +48,11 @@ func (a *PlayerSkin) Play(ctx *PlayContext) {
 			ctx.Playback().UpdatePlayerSkin(ctx.Tx(), a.PlayerID, prevSkin)
 		})
 	}
+	ctx.Playback().UpdatePlayerSkin(ctx.Tx(), a.PlayerID, a.Skin())
+}
+
+// Skin returns the skin held by the action.
+func (a *PlayerSkin) Skin() skin.Skin {
 	sk := skin.New(int(a.SkinWidth), int(a.SkinHeight))
 	sk.Pix = a.SkinData
 	if a.HasCape {
@@ -58,5 +63,9 @@ func (a *PlayerSkin) Play(ctx *PlayContext) {
 	if a.HasGeometryData {
 		sk.Model = a.GeometryData
 	}
-	ctx.Playback().UpdatePlayerSkin(ctx.Tx(), a.PlayerID, sk)
+	return sk
+}
+
+func (a *PlayerSkin) VisitIDs(player, entity func(id *uint32)) {
+	player(&a.PlayerID)
 }
@@ -0,0 +1,39 @@
+package action
+
+import "github.com/sandertv/gophertunnel/minecraft/protocol"
+
+// PlayerSkinRef changes the skin of a player to a skin stored in the skin table of the replay. Skins are
+// stored once per replay and referred to by the SHA-256 hash of their encoding, so that players who swap
+// between the same skins do not store the skin data again.
+type PlayerSkinRef struct {
+	PlayerID uint32
+	SkinHash [32]byte
+}
+
+func (*PlayerSkinRef) ID() uint8 {
+	return IDPlayerSkinRef
+}
+
+func (a *PlayerSkinRef) Marshal(io protocol.IO) {
+	io.Varuint32(&a.PlayerID)
+	for i := range a.SkinHash {
+		io.Uint8(&a.SkinHash[i])
+	}
+}
+
+func (a *PlayerSkinRef) Play(ctx *PlayContext) {
+	if prevHash, ok := ctx.Playback().PlayerSkinRef(a.PlayerID); ok {
+		ctx.OnReverse(func(ctx *PlayContext) {
+			ctx.Playback().UpdatePlayerSkinRef(ctx.Tx(), a.PlayerID, prevHash)
+		})
+	} else if prevSkin, ok := ctx.Playback().PlayerSkin(a.PlayerID); ok {
+		ctx.OnReverse(func(ctx *PlayContext) {
+			ctx.Playback().UpdatePlayerSkin(ctx.Tx(), a.PlayerID, prevSkin)
+		})
+	}
+	ctx.Playback().UpdatePlayerSkinRef(ctx.Tx(), a.PlayerID, a.SkinHash)
+}
+
+func (a *PlayerSkinRef) VisitIDs(player, entity func(id *uint32)) {
+	player(&a.PlayerID)
+}
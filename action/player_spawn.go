@@ -47,3 +47,13 @@ func (a *PlayerSpawn) Play(ctx *PlayContext) {
 		[4]item.Stack{a.Helmet.ToStack(), a.Chestplate.ToStack(), a.Leggings.ToStack(), a.Boots.ToStack()},
 		[2]item.Stack{a.MainHand.ToStack(), a.OffHand.ToStack()})
 }
+
+func (a *PlayerSpawn) VisitHashes(block, item func(hash *uint32)) {
+	for _, it := range []*Item{&a.Helmet, &a.Chestplate, &a.Leggings, &a.Boots, &a.MainHand, &a.OffHand} {
+		item(&it.Hash)
+	}
+}
+
+func (a *PlayerSpawn) VisitIDs(player, entity func(id *uint32)) {
+	player(&a.PlayerID)
+}
@@ -26,3 +26,7 @@ func (a *SetBlock) Play(ctx *PlayContext) {
 	})
 	ctx.Playback().SetBlock(ctx.Tx(), pos, a.Block.ToBlock())
 }
+
+func (a *SetBlock) VisitHashes(block, item func(hash *uint32)) {
+	block(&a.Block.Hash)
+}
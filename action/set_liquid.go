@@ -27,10 +27,21 @@ func (a *SetLiquid) Play(ctx *PlayContext) {
 	ctx.OnReverse(func(ctx *PlayContext) {
 		ctx.Playback().SetLiquid(ctx.Tx(), pos, prev)
 	})
-	l := internal.HashToBlock(a.LiquidHash)
-	if liq, ok := l.(world.Liquid); ok {
+	if liq, ok := liquidFromHash(a.LiquidHash); ok {
 		ctx.Playback().SetLiquid(ctx.Tx(), pos, liq)
-	} else if l == (block.Air{}) {
-		ctx.Playback().SetLiquid(ctx.Tx(), pos, nil)
 	}
 }
+
+// liquidFromHash returns the liquid with the hash passed. A nil liquid is returned for the hash of air, and
+// false is returned if the hash is neither that of a liquid nor that of air.
+func liquidFromHash(hash uint32) (world.Liquid, bool) {
+	l := internal.HashToBlock(hash)
+	if liq, ok := l.(world.Liquid); ok {
+		return liq, true
+	}
+	return nil, l == (block.Air{})
+}
+
+func (a *SetLiquid) VisitHashes(block, item func(hash *uint32)) {
+	block(&a.LiquidHash)
+}
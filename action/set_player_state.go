@@ -83,3 +83,7 @@ func (a *SetPlayerState) Play(ctx *PlayContext) {
 		ctx.Playback().SetPlayerOnFire(ctx.Tx(), a.PlayerID, a.Value)
 	}
 }
+
+func (a *SetPlayerState) VisitIDs(player, entity func(id *uint32)) {
+	player(&a.PlayerID)
+}
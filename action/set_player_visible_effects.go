@@ -28,3 +28,7 @@ func (a *SetPlayerVisibleEffects) Play(ctx *PlayContext) {
 		return int(e)
 	}))
 }
+
+func (a *SetPlayerVisibleEffects) VisitIDs(player, entity func(id *uint32)) {
+	player(&a.PlayerID)
+}
@@ -0,0 +1,22 @@
+package action
+
+import "github.com/sandertv/gophertunnel/minecraft/protocol"
+
+// SetSource marks the actions following it in the same tick as actions of one of the replays that a merged
+// replay was created from, as listed in the metadata of the merged replay. Sources are numbered from 1.
+// Actions that precede the first SetSource action of a tick belong to source 0, which is the source of all
+// actions of replays that were not merged.
+type SetSource struct {
+	Source uint32
+}
+
+func (*SetSource) ID() uint8 {
+	return IDSetSource
+}
+
+func (a *SetSource) Marshal(io protocol.IO) {
+	io.Varuint32(&a.Source)
+}
+
+// Play does nothing: the playback keeps track of the source of the actions it plays by itself.
+func (a *SetSource) Play(*PlayContext) {}
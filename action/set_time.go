@@ -0,0 +1,27 @@
+package action
+
+import "github.com/sandertv/gophertunnel/minecraft/protocol"
+
+// SetTime sets the time of the world. If Cycle is true, the time advances by one every tick after the tick
+// that the action was recorded in, until the next SetTime action.
+type SetTime struct {
+	Time  int64
+	Cycle bool
+}
+
+func (*SetTime) ID() uint8 {
+	return IDSetTime
+}
+
+func (a *SetTime) Marshal(io protocol.IO) {
+	io.Varint64(&a.Time)
+	io.Bool(&a.Cycle)
+}
+
+func (a *SetTime) Play(ctx *PlayContext) {
+	prev, prevCycle := ctx.Playback().Time(ctx.Tx())
+	ctx.OnReverse(func(ctx *PlayContext) {
+		ctx.Playback().SetTime(ctx.Tx(), prev, prevCycle)
+	})
+	ctx.Playback().SetTime(ctx.Tx(), a.Time, a.Cycle)
+}
@@ -0,0 +1,26 @@
+package action
+
+import "github.com/sandertv/gophertunnel/minecraft/protocol"
+
+// SetWeather sets the weather of the world. Thundering is only true if it is also raining.
+type SetWeather struct {
+	Raining    bool
+	Thundering bool
+}
+
+func (*SetWeather) ID() uint8 {
+	return IDSetWeather
+}
+
+func (a *SetWeather) Marshal(io protocol.IO) {
+	io.Bool(&a.Raining)
+	io.Bool(&a.Thundering)
+}
+
+func (a *SetWeather) Play(ctx *PlayContext) {
+	raining, thundering := ctx.Playback().Weather(ctx.Tx())
+	ctx.OnReverse(func(ctx *PlayContext) {
+		ctx.Playback().SetWeather(ctx.Tx(), raining, thundering)
+	})
+	ctx.Playback().SetWeather(ctx.Tx(), a.Raining, a.Thundering)
+}
@@ -0,0 +1,29 @@
+package action
+
+import (
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+)
+
+// Unknown is an action that could not be decoded, because its ID is not known, because it was written
+// using a newer version of its encoding than the one known or because its payload holds more data than the
+// encoding known. The payload of the action is kept, so that it is written unchanged when the replay is
+// written again. Playing an Unknown action does nothing.
+type Unknown struct {
+	ActionID      uint8
+	ActionVersion uint8
+	Payload       []byte
+}
+
+func (a *Unknown) ID() uint8 {
+	return a.ActionID
+}
+
+func (a *Unknown) Version() uint8 {
+	return a.ActionVersion
+}
+
+func (a *Unknown) Marshal(io protocol.IO) {
+	io.Bytes(&a.Payload)
+}
+
+func (a *Unknown) Play(*PlayContext) {}
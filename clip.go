@@ -0,0 +1,228 @@
+package replay
+
+import (
+	"fmt"
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/df-mc/dragonfly/server/player/skin"
+	"github.com/google/uuid"
+	"io"
+	"time"
+)
+
+// Clip writes a new replay to w holding only the ticks of the range passed, which are renumbered to start at
+// tick 1. The first tick of the clip starts with the actions needed to recreate the scene as it was at the
+// start of the range: every block and liquid changed earlier is set, open chests are opened and every player
+// and entity present is spawned, along with the skins, equipment, name tags, states and effects of the
+// players. The clip therefore plays back correctly on its own, and holds the snapshot of the replay. It
+// receives a new ID, and its start and end time are those of the range. If the replay is encrypted, the clip
+// is encrypted using the same key.
+func (d *Data) Clip(w io.Writer, r TickRange) error {
+	d.mu.Lock()
+	meta, totalTicks, c := d.meta, uint32(d.totalTicks), d.cipher
+	d.mu.Unlock()
+
+	if r.First == 0 || r.First > r.Last || r.Last > totalTicks {
+		return fmt.Errorf("invalid tick range %d-%d for replay of %d ticks", r.First, r.Last, totalTicks)
+	}
+	sc, err := d.sceneAt(r.First)
+	if err != nil {
+		return err
+	}
+	var setup []action.Action
+	players := make(map[uint32]struct{})
+	sources := sc.sources()
+	merged := len(sources) > 1 || (len(sources) == 1 && sources[0] != 0)
+	for _, src := range sources {
+		k := sc.scene(src).keyframe(r.First)
+		if merged {
+			setup = append(setup, &action.SetSource{Source: src})
+		}
+		actions, err := d.setupActions(k)
+		if err != nil {
+			return err
+		}
+		setup = append(setup, actions...)
+		for _, p := range k.Players {
+			players[p.PlayerID] = struct{}{}
+		}
+	}
+	if merged {
+		setup = append(setup, &action.SetSource{})
+	}
+	for tick := r.First; tick <= r.Last; tick++ {
+		actions, err := d.Actions(tick)
+		if err != nil {
+			return err
+		}
+		for _, a := range actions {
+			if spawn, ok := a.(*action.PlayerSpawn); ok {
+				players[spawn.PlayerID] = struct{}{}
+			}
+		}
+	}
+	meta = clipMetadata(meta, players, r)
+	lookup := func(hash [32]byte) (skin.Skin, bool) {
+		sk, err := d.Skin(hash)
+		return sk, err == nil
+	}
+	chunks, err := d.snapshotChunks()
+	if err != nil {
+		return err
+	}
+	// Chunks saved before the range are restored before the first tick of the clip, and chunks saved after it
+	// are never restored.
+	snapshot := make([]snapshotChunk, 0, len(chunks))
+	for _, s := range chunks {
+		if s.tick > r.Last {
+			continue
+		}
+		s.tick = max(s.tick, r.First-1) - (r.First - 1)
+		snapshot = append(snapshot, s)
+	}
+	return writeReplayWith(w, writeOptions{version: FormatVersion, cipher: c, snapshot: snapshot}, meta, r.Last-r.First+1, func(tick uint32) ([]action.Action, error) {
+		actions, err := d.Actions(r.First + tick - 1)
+		if err != nil {
+			return nil, err
+		}
+		actions = resolveSkinRefs(actions, lookup)
+		if tick == 1 {
+			actions = append(setup, actions...)
+		}
+		return actions, nil
+	})
+}
+
+// sceneAt returns the scenes of all sources at the start of the tick passed, before any of the actions of
+// that tick other than its keyframes.
+func (d *Data) sceneAt(tick uint32) (*sceneSet, error) {
+	keyframeTick, _, err := d.Keyframe(tick)
+	if err != nil {
+		return nil, err
+	}
+	sc := newSceneSet()
+	for t := max(keyframeTick, 1); t <= tick; t++ {
+		actions, err := d.Actions(t)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range actions {
+			// Keyframes hold the state at the start of the tick, so they precede all other actions.
+			if t == tick && !isKeyframe(a) && !isSetSource(a) {
+				break
+			}
+			sc.apply(t, a)
+		}
+	}
+	return sc, nil
+}
+
+// setupActions returns the actions that recreate the state held by the keyframe passed in a replay that
+// starts empty.
+func (d *Data) setupActions(k *action.Keyframe) ([]action.Action, error) {
+	actions := make([]action.Action, 0, len(k.Blocks)+len(k.Liquids)+len(k.OpenChests)+len(k.Players)*4+len(k.Entities)+2)
+	if k.Time != nil {
+		t := *k.Time
+		actions = append(actions, &t)
+	}
+	if k.Weather != nil {
+		weather := *k.Weather
+		actions = append(actions, &weather)
+	}
+	for _, b := range k.Blocks {
+		actions = append(actions, &action.SetBlock{Position: b.Position, Block: b.Block})
+	}
+	for _, l := range k.Liquids {
+		actions = append(actions, &action.SetLiquid{Position: l.Position, LiquidHash: l.LiquidHash})
+	}
+	for _, pos := range k.OpenChests {
+		actions = append(actions, &action.ChestUpdate{Position: pos, Open: true})
+	}
+	for _, p := range k.Players {
+		// The skin is added before the player is spawned, so that the player is spawned with it.
+		if p.SkinTick != 0 {
+			sk, err := d.skinAt(p.PlayerID, p.SkinTick)
+			if err != nil {
+				return nil, err
+			}
+			actions = append(actions, skinToAction(p.PlayerID, sk))
+		}
+		actions = append(actions, &action.PlayerSpawn{
+			PlayerID:   p.PlayerID,
+			PlayerName: p.PlayerName,
+			NameTag:    p.NameTag,
+			Position:   p.Position,
+			Yaw:        p.Yaw,
+			Pitch:      p.Pitch,
+			Helmet:     p.Helmet,
+			Chestplate: p.Chestplate,
+			Leggings:   p.Leggings,
+			Boots:      p.Boots,
+			MainHand:   p.MainHand,
+			OffHand:    p.OffHand,
+		})
+		// Players are spawned visible and without any of the other states enabled.
+		for stateType := action.SetPlayerStateTypeVisibility; stateType <= action.SetPlayerStateTypeOnFire; stateType++ {
+			if value := p.State(stateType); value != (stateType == action.SetPlayerStateTypeVisibility) {
+				actions = append(actions, &action.SetPlayerState{PlayerID: p.PlayerID, Type: stateType, Value: value})
+			}
+		}
+		if len(p.Effects) > 0 {
+			actions = append(actions, &action.SetPlayerVisibleEffects{PlayerID: p.PlayerID, Effects: p.Effects})
+		}
+	}
+	for _, e := range k.Entities {
+		actions = append(actions, &action.EntitySpawn{
+			EntityID:         e.EntityID,
+			EntityIdentifier: e.EntityIdentifier,
+			NameTag:          e.NameTag,
+			Position:         e.Position,
+			Yaw:              e.Yaw,
+			Pitch:            e.Pitch,
+			ExtraData:        e.ExtraData,
+		})
+	}
+	return actions, nil
+}
+
+// skinAt returns the skin of a player recorded in the PlayerSkin or PlayerSkinRef action at the tick passed.
+func (d *Data) skinAt(playerID, tick uint32) (skin.Skin, error) {
+	actions, err := d.Actions(tick)
+	if err != nil {
+		return skin.Skin{}, err
+	}
+	for i := len(actions) - 1; i >= 0; i-- {
+		switch a := actions[i].(type) {
+		case *action.PlayerSkin:
+			if a.PlayerID == playerID {
+				return a.Skin(), nil
+			}
+		case *action.PlayerSkinRef:
+			if a.PlayerID == playerID {
+				return d.Skin(a.SkinHash)
+			}
+		}
+	}
+	return skin.Skin{}, fmt.Errorf("no skin of player %d at tick %d", playerID, tick)
+}
+
+// clipMetadata returns the metadata of a clip of the range passed of a replay with the metadata passed. The
+// players of the clip are limited to the players with the IDs passed.
+func clipMetadata(meta Metadata, ids map[uint32]struct{}, r TickRange) Metadata {
+	rate := meta.TickRate
+	if rate == 0 {
+		rate = tickRate
+	}
+	tickDuration := time.Second / time.Duration(rate)
+	meta.ID = uuid.New()
+	meta.StartTime = meta.StartTime.Add(time.Duration(r.First-1) * tickDuration)
+	meta.EndTime = meta.StartTime.Add(time.Duration(r.Last-r.First+1) * tickDuration)
+
+	players := make([]PlayerInfo, 0, len(meta.Players))
+	for _, p := range meta.Players {
+		if _, ok := ids[p.ID]; ok {
+			players = append(players, p)
+		}
+	}
+	meta.Players = players
+	return meta
+}
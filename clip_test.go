@@ -0,0 +1,94 @@
+package replay
+
+import (
+	"bytes"
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/google/uuid"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"testing"
+	"time"
+)
+
+// encodeKeyframe encodes the keyframe passed without the ticks of the skins of its players, which differ
+// between replays that hold the same scene.
+func encodeKeyframe(k *action.Keyframe) []byte {
+	for i := range k.Players {
+		k.Players[i].SkinTick = 0
+	}
+	buf := bytes.NewBuffer(nil)
+	action.Write(protocol.NewWriter(buf, 0), k)
+	return buf.Bytes()
+}
+
+// TestClip tests that a clip holds the ticks of the range clipped, renumbered to start at tick 1, and that
+// the scene of the clip matches the scene of the replay at every tick of the range.
+func TestClip(t *testing.T) {
+	d := openTestReplay(t, writeTestReplay(t, writeOptions{}), nil)
+	r := TickRange{First: 60, Last: 180}
+	buf := bytes.NewBuffer(nil)
+	if err := d.Clip(buf, r); err != nil {
+		t.Fatalf("failed to clip replay: %v", err)
+	}
+	c := openTestReplay(t, buf.Bytes(), nil)
+	offset := r.First - 1
+	if c.TotalTicks() != uint(r.Last-offset) {
+		t.Fatalf("clip holds %d ticks, expected %d", c.TotalTicks(), r.Last-offset)
+	}
+
+	meta := c.Metadata()
+	if meta.ID == d.ID() || meta.ID == uuid.Nil {
+		t.Fatalf("clip has ID %v, expected a new ID", meta.ID)
+	}
+	wantStart := d.Metadata().StartTime.Add(time.Duration(offset) * time.Second / tickRate)
+	if !meta.StartTime.Equal(wantStart) || meta.EndTime.Sub(meta.StartTime) != time.Duration(r.Last-offset)*time.Second/tickRate {
+		t.Fatalf("clip runs from %v to %v, expected it to start at %v", meta.StartTime, meta.EndTime, wantStart)
+	}
+	if len(meta.Players) != 2 {
+		t.Fatalf("clip has players %v, expected both players present during the range", meta.Players)
+	}
+
+	// The first tick of the clip starts with the setup of the scene, followed by the actions of the first tick
+	// of the range. The ticks after it hold the same actions as the range.
+	first, err := c.Actions(1)
+	if err != nil {
+		t.Fatalf("failed to read first tick of clip: %v", err)
+	}
+	want, _ := d.Actions(r.First)
+	if got := encodeActions(first); len(first) <= len(want) || !bytes.HasSuffix(got, encodeActions(want)) {
+		t.Fatalf("first tick of clip holds %d actions that do not end with the %d of tick %d", len(first), len(want), r.First)
+	}
+	for tick := uint32(2); tick <= r.Last-offset; tick++ {
+		got, err := c.Actions(tick)
+		if err != nil {
+			t.Fatalf("failed to read tick %d of clip: %v", tick, err)
+		}
+		want, _ := d.Actions(tick + offset)
+		if !bytes.Equal(encodeActions(got), encodeActions(want)) {
+			t.Fatalf("tick %d of clip differs from tick %d of the replay", tick, tick+offset)
+		}
+	}
+
+	for _, tick := range []uint32{1, 2, 40, 61, 62, 91, r.Last - offset} {
+		sc, err := c.sceneAt(tick + 1)
+		if err != nil {
+			t.Fatalf("failed to get scene of clip at tick %d: %v", tick+1, err)
+		}
+		orig, err := d.sceneAt(tick + offset + 1)
+		if err != nil {
+			t.Fatalf("failed to get scene of replay at tick %d: %v", tick+offset+1, err)
+		}
+		if !bytes.Equal(encodeKeyframe(sc.scene(0).keyframe(0)), encodeKeyframe(orig.scene(0).keyframe(0))) {
+			t.Fatalf("scene of clip after tick %d differs from the scene of the replay after tick %d", tick, tick+offset)
+		}
+	}
+}
+
+// TestClipInvalidRange tests that ranges that are empty or fall outside the replay are refused.
+func TestClipInvalidRange(t *testing.T) {
+	d := openTestReplay(t, writeTestReplay(t, writeOptions{}), nil)
+	for _, r := range []TickRange{{First: 0, Last: 10}, {First: 20, Last: 10}, {First: 1, Last: testTicks + 1}} {
+		if err := d.Clip(bytes.NewBuffer(nil), r); err == nil {
+			t.Fatalf("range %d-%d was clipped", r.First, r.Last)
+		}
+	}
+}
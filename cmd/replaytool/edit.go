@@ -0,0 +1,209 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	replay "github.com/akmalfairuz/df-replay"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runTrim writes a range of ticks of a replay to a new replay using Data.Clip.
+func runTrim(fs *flag.FlagSet, args []string) error {
+	opts := openFlags(fs)
+	from := fs.Uint("from", 1, "first `tick` of the range kept")
+	to := fs.Uint("to", 0, "last `tick` of the range kept, or 0 to keep all ticks up to the end of the replay")
+	start := fs.Duration("start", 0, "start of the range kept as a `duration` into the replay, overriding -from")
+	end := fs.Duration("end", 0, "end of the range kept as a `duration` into the replay, overriding -to")
+	args, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	o, err := opts()
+	if err != nil {
+		return err
+	}
+	d, err := openReplay(args[0], o)
+	if err != nil {
+		return err
+	}
+	rate := d.Metadata().TickRate
+	if rate == 0 {
+		rate = 20
+	}
+	r := replay.TickRange{First: uint32(*from), Last: uint32(*to)}
+	if *start != 0 {
+		r.First = durationToTick(*start, rate)
+	}
+	if *end != 0 {
+		r.Last = durationToTick(*end, rate)
+	}
+	if r.Last == 0 {
+		r.Last = uint32(d.TotalTicks())
+	}
+	return writeOutput(args[1], func(w io.Writer) error {
+		return d.Clip(w, r)
+	})
+}
+
+// durationToTick returns the tick played at the duration passed into a replay with the tick rate passed.
+func durationToTick(d time.Duration, rate uint32) uint32 {
+	return uint32(d/(time.Second/time.Duration(rate))) + 1
+}
+
+// runMerge merges replays into a single replay using replay.MergeConfig.Merge.
+func runMerge(fs *flag.FlagSet, args []string) error {
+	opts := openFlags(fs)
+	align := fs.Bool("align", false, "align the replays by the time at which their recordings were started, ignoring offsets")
+	args, err := parse(fs, args, 3, -1)
+	if err != nil {
+		return err
+	}
+	o, err := opts()
+	if err != nil {
+		return err
+	}
+	sources := make([]replay.MergeSource, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		path, offset, hasOffset := strings.Cut(arg, "@")
+		src := replay.MergeSource{Name: strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))}
+		if hasOffset {
+			n, err := strconv.ParseUint(offset, 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid offset of %v: %w", path, err)
+			}
+			src.Offset = uint32(n)
+		}
+		if src.Data, err = openReplay(path, o); err != nil {
+			return err
+		}
+		sources = append(sources, src)
+	}
+	return writeOutput(args[0], func(w io.Writer) error {
+		return replay.MergeConfig{AlignByStartTime: *align}.Merge(w, sources...)
+	})
+}
+
+// runExport writes the actions of a replay as NDJSON using Data.ExportNDJSON.
+func runExport(fs *flag.FlagSet, args []string) error {
+	opts := openFlags(fs)
+	args, err := parse(fs, args, 1, 2)
+	if err != nil {
+		return err
+	}
+	o, err := opts()
+	if err != nil {
+		return err
+	}
+	d, err := openReplay(args[0], o)
+	if err != nil {
+		return err
+	}
+	out := "-"
+	if len(args) == 2 {
+		out = args[1]
+	}
+	return writeOutput(out, d.ExportNDJSON)
+}
+
+// runImport creates a replay from NDJSON using replay.ImportNDJSON.
+func runImport(fs *flag.FlagSet, args []string) error {
+	args, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	in, err := openInput(args[0])
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeOutput(args[1], func(w io.Writer) error {
+		return replay.ImportNDJSON(in, w)
+	})
+}
+
+// compressionLevels maps the names accepted by the -level flag of reencode to zstd levels.
+var compressionLevels = map[string]zstd.EncoderLevel{
+	"fastest": zstd.SpeedFastest,
+	"default": zstd.SpeedDefault,
+	"better":  zstd.SpeedBetterCompression,
+	"best":    zstd.SpeedBestCompression,
+}
+
+// runReencode writes a replay again using replay.ReencodeConfig.Reencode.
+func runReencode(fs *flag.FlagSet, args []string) error {
+	opts := openFlags(fs)
+	level := fs.String("level", "best", "zstd compression `level`: fastest, default, better or best")
+	segmentTicks := fs.Uint("segment", 600, "amount of `ticks` in every segment")
+	tracks := fs.Bool("tracks", false, "store movements as columnar tracks apart from other actions")
+	dict := fs.String("compress-dict", "", "`path` of the dictionary to compress the replay using")
+	args, err := parse(fs, args, 2, 2)
+	if err != nil {
+		return err
+	}
+	o, err := opts()
+	if err != nil {
+		return err
+	}
+	conf := replay.ReencodeConfig{SegmentTicks: uint32(*segmentTicks), MovementTracks: *tracks}
+	var ok bool
+	if conf.Level, ok = compressionLevels[*level]; !ok {
+		return fmt.Errorf("unknown compression level %q", *level)
+	}
+	if conf.SegmentTicks == 0 {
+		return errors.New("segments must hold at least one tick")
+	}
+	if *dict != "" {
+		if conf.Dictionary, err = readDictionary(*dict); err != nil {
+			return err
+		}
+	}
+	d, err := openReplay(args[0], o)
+	if err != nil {
+		return err
+	}
+	return writeOutput(args[1], func(w io.Writer) error {
+		return conf.Reencode(w, d)
+	})
+}
+
+// runTrain trains a dictionary from replays using replay.DictionaryConfig.Train.
+func runTrain(fs *flag.FlagSet, args []string) error {
+	opts := openFlags(fs)
+	id := fs.Uint("id", 0, "`ID` of the dictionary, or 0 to pick a random ID")
+	size := fs.Int("size", 112640, "maximum size of the dictionary in `bytes`")
+	args, err := parse(fs, args, 2, -1)
+	if err != nil {
+		return err
+	}
+	o, err := opts()
+	if err != nil {
+		return err
+	}
+	replays := make([]*replay.Data, 0, len(args)-1)
+	for _, path := range args[1:] {
+		d, err := openReplay(path, o)
+		if err != nil {
+			return err
+		}
+		replays = append(replays, d)
+	}
+	dict, err := replay.DictionaryConfig{ID: uint32(*id), Size: *size}.Train(replays...)
+	if err != nil {
+		return err
+	}
+	if err := writeOutput(args[0], func(w io.Writer) error {
+		_, err := w.Write(dict.Bytes())
+		return err
+	}); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "trained dictionary %d of %d bytes from %d replays\n", dict.ID(), len(dict.Bytes()), len(replays))
+	return nil
+}
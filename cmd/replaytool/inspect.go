@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	replay "github.com/akmalfairuz/df-replay"
+	"maps"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// runInspect prints the metadata and the players of a replay. Encrypted replays are inspected without their
+// key, as their metadata is not encrypted, and replays compressed using a dictionary without it.
+func runInspect(fs *flag.FlagSet, args []string) error {
+	opts := openFlags(fs)
+	args, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	o, err := opts()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	d, err := openReplay(args[0], o)
+	if encrypted := errors.Is(err, replay.ErrEncrypted); encrypted || errors.Is(err, replay.ErrMissingDictionary) {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		meta, err := replay.ReadMetadata(f)
+		if err != nil {
+			return err
+		}
+		if encrypted {
+			_, _ = fmt.Fprintln(tw, "Encrypted:\tyes, pass -key to read the actions")
+		} else {
+			_, _ = fmt.Fprintln(tw, "Dictionary:\tmissing, pass -dict to read the actions")
+		}
+		printMetadata(tw, meta)
+		return tw.Flush()
+	} else if err != nil {
+		return err
+	}
+	meta := d.Metadata()
+	_, _ = fmt.Fprintf(tw, "Format version:\t%d\n", d.Version())
+	_, _ = fmt.Fprintf(tw, "Ticks:\t%d\n", d.TotalTicks())
+	if n := len(d.SnapshotChunks()); n > 0 {
+		_, _ = fmt.Fprintf(tw, "Snapshot:\t%d chunks\n", n)
+	}
+	printMetadata(tw, meta)
+	if report := d.PaletteReport(); !report.Resolved() {
+		_, _ = fmt.Fprintf(tw, "Unresolved:\t%d blocks, %d items\n", len(report.UnresolvedBlocks), len(report.UnresolvedItems))
+	}
+	return tw.Flush()
+}
+
+// printMetadata prints the metadata passed to tw.
+func printMetadata(tw *tabwriter.Writer, meta replay.Metadata) {
+	_, _ = fmt.Fprintf(tw, "ID:\t%v\n", meta.ID)
+	_, _ = fmt.Fprintf(tw, "World:\t%v (dimension %d)\n", meta.WorldName, meta.Dimension)
+	_, _ = fmt.Fprintf(tw, "Start:\t%v\n", meta.StartTime.Format(time.RFC3339))
+	_, _ = fmt.Fprintf(tw, "End:\t%v\n", meta.EndTime.Format(time.RFC3339))
+	_, _ = fmt.Fprintf(tw, "Duration:\t%v\n", meta.Duration().Round(time.Millisecond))
+	_, _ = fmt.Fprintf(tw, "Tick rate:\t%d\n", meta.TickRate)
+	if meta.DictionaryID != 0 {
+		_, _ = fmt.Fprintf(tw, "Dictionary ID:\t%d\n", meta.DictionaryID)
+	}
+	for _, k := range slices.Sorted(maps.Keys(meta.Tags)) {
+		_, _ = fmt.Fprintf(tw, "Tag %v:\t%v\n", k, meta.Tags[k])
+	}
+	if len(meta.Sources) > 0 {
+		_, _ = fmt.Fprintf(tw, "\nSOURCE\tNAME\tID\tWORLD\tOFFSET\tSTART\n")
+		for i, src := range meta.Sources {
+			_, _ = fmt.Fprintf(tw, "%d\t%v\t%v\t%v\t%d\t%v\n", i+1, src.Name, src.ID, src.WorldName, src.Offset, src.StartTime.Format(time.RFC3339))
+		}
+	}
+	_, _ = fmt.Fprintf(tw, "\nPLAYER\tNAME\tUUID\tSOURCE\n")
+	for _, p := range meta.Players {
+		_, _ = fmt.Fprintf(tw, "%d\t%v\t%v\t%d\n", p.ID, p.Name, p.UUID, p.Source)
+	}
+}
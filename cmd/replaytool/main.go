@@ -0,0 +1,207 @@
+// Command replaytool inspects and edits replay files without running a server.
+//
+// Usage:
+//
+//	replaytool <command> [flags] [arguments]
+//
+// Run replaytool without arguments to list the commands. Every command that reads a replay accepts a -key
+// flag of the form id:hex, holding the ID and the hex encoded secret of the key used to decrypt encrypted
+// replays, and a -dict flag holding the paths of the dictionaries that replays may be compressed using,
+// separated by commas.
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	replay "github.com/akmalfairuz/df-replay"
+	"github.com/google/uuid"
+	"io"
+	"os"
+	"strings"
+	_ "unsafe"
+)
+
+// command is a subcommand of replaytool.
+type command struct {
+	name        string
+	args        string
+	description string
+	run         func(fs *flag.FlagSet, args []string) error
+}
+
+var commands = []command{
+	{name: "inspect", args: "<replay>", description: "print the header and the players of a replay", run: runInspect},
+	{name: "stats", args: "<replay>", description: "print the amount and encoded size of every type of action", run: runStats},
+	{name: "validate", args: "<replay>", description: "verify the checksums and signature of a replay and decode all of its ticks", run: runValidate},
+	{name: "trim", args: "<replay> <output>", description: "write a range of ticks of a replay as a standalone replay", run: runTrim},
+	{name: "merge", args: "<output> <replay>[@offset]...", description: "merge replays into a single replay", run: runMerge},
+	{name: "export", args: "<replay> [output.ndjson]", description: "export the actions of a replay as NDJSON, to stdout if no output is passed", run: runExport},
+	{name: "import", args: "<input.ndjson> <output>", description: "create a replay from NDJSON written by export", run: runImport},
+	{name: "movement", args: "<replay>", description: "compare the size of the movements of a replay encoded using every movement precision", run: runMovement},
+	{name: "train", args: "<output> <replay>...", description: "train a dictionary to compress replays similar to the replays passed", run: runTrain},
+	{name: "reencode", args: "<replay> <output>", description: "write a replay again with different compression settings, upgrading it to the current format version", run: runReencode},
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	for _, cmd := range commands {
+		if cmd.name != os.Args[1] {
+			continue
+		}
+		fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+		fs.Usage = func() {
+			_, _ = fmt.Fprintf(fs.Output(), "usage: replaytool %s [flags] %s\n\n%s.\n\n", cmd.name, cmd.args, cmd.description)
+			fs.PrintDefaults()
+		}
+		world_finaliseBlockRegistry()
+		replay.Init()
+		if err := cmd.run(fs, os.Args[2:]); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "replaytool %s: %v\n", cmd.name, err)
+			os.Exit(1)
+		}
+		return
+	}
+	usage()
+	os.Exit(2)
+}
+
+// usage prints the commands of replaytool to stderr.
+func usage() {
+	_, _ = fmt.Fprintln(os.Stderr, "usage: replaytool <command> [flags] [arguments]\n\ncommands:")
+	for _, cmd := range commands {
+		_, _ = fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.description)
+	}
+}
+
+// errUsage is returned by commands that were passed the wrong amount of arguments.
+var errUsage = errors.New("invalid arguments, run with -h for usage")
+
+// parse parses the flags passed to a command and checks if the amount of arguments left is between min and
+// max. A max of -1 allows any amount of arguments above min.
+func parse(fs *flag.FlagSet, args []string, min, max int) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() < min || (max >= 0 && fs.NArg() > max) {
+		return nil, errUsage
+	}
+	return fs.Args(), nil
+}
+
+// openOptions holds the settings used to load replays, as set using the flags added by openFlags.
+type openOptions struct {
+	// keys is used to decrypt encrypted replays, or nil if the -key flag was not set.
+	keys replay.KeyProvider
+	// dicts holds the dictionaries that replays may be compressed using.
+	dicts []*replay.Dictionary
+}
+
+// openFlags adds the -key and -dict flags to the flag set passed.
+func openFlags(fs *flag.FlagSet) func() (openOptions, error) {
+	key := fs.String("key", "", "`id:hex` key used to decrypt encrypted replays")
+	dicts := fs.String("dict", "", "comma separated `paths` of the dictionaries that replays may be compressed using")
+	return func() (openOptions, error) {
+		var opts openOptions
+		if *key != "" {
+			id, secret, ok := strings.Cut(*key, ":")
+			if !ok {
+				return opts, fmt.Errorf("key %q is not of the form id:hex", *key)
+			}
+			b, err := hex.DecodeString(secret)
+			if err != nil {
+				return opts, fmt.Errorf("failed to decode key: %w", err)
+			}
+			opts.keys = replay.StaticKey{ID: id, Secret: b}
+		}
+		if *dicts != "" {
+			for _, path := range strings.Split(*dicts, ",") {
+				dict, err := readDictionary(path)
+				if err != nil {
+					return opts, err
+				}
+				opts.dicts = append(opts.dicts, dict)
+			}
+		}
+		return opts, nil
+	}
+}
+
+// readDictionary reads the dictionary at the path passed.
+func readDictionary(path string) (*replay.Dictionary, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dict, err := replay.ParseDictionary(b)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	return dict, nil
+}
+
+// openReplay loads the replay at the path passed, decrypting and decompressing it using the options passed.
+func openReplay(path string, opts openOptions) (*replay.Data, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d := replay.NewData(uuid.Nil)
+	if opts.keys != nil {
+		d.SetKeyProvider(opts.keys)
+	}
+	d.SetDictionaries(opts.dicts...)
+	if err := d.LoadActions(f); err != nil {
+		return nil, fmt.Errorf("failed to load %v: %w", path, err)
+	}
+	return d, nil
+}
+
+// openInput opens the file at the path passed for reading, or stdin if the path is "-".
+func openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(path)
+}
+
+// writeOutput calls write with a writer to the file at the path passed, or stdout if the path is "-". Files
+// are written to a temporary file first, which replaces the file at the path passed once write returns
+// without an error, so that existing files, including the input, are not lost if writing fails.
+func writeOutput(path string, write func(w io.Writer) error) error {
+	if path == "-" {
+		bw := bufio.NewWriter(os.Stdout)
+		if err := write(bw); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(f)
+	if err := write(bw); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := errors.Join(bw.Flush(), f.Sync(), f.Close()); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write %v: %w", path, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// world_finaliseBlockRegistry finalises the block registry of dragonfly, which is otherwise done when a
+// server is created. Blocks cannot be looked up by their name or hash before it is called.
+//
+//go:linkname world_finaliseBlockRegistry github.com/df-mc/dragonfly/server/world.finaliseBlockRegistry
+func world_finaliseBlockRegistry()
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/go-gl/mathgl/mgl64"
+	"github.com/klauspost/compress/zstd"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"os"
+	"text/tabwriter"
+)
+
+// movementPrecisions holds every movement precision compared by the movement command, along with its name.
+var movementPrecisions = []struct {
+	name      string
+	precision action.MovementPrecision
+}{
+	{"exact", action.MovementPrecisionExact},
+	{"fine", action.MovementPrecisionFine},
+	{"medium", action.MovementPrecisionMedium},
+	{"coarse", action.MovementPrecisionCoarse},
+	{"low", action.MovementPrecisionLow},
+}
+
+// movementKey identifies a player or an entity, which have separate IDs.
+type movementKey struct {
+	entity bool
+	id     uint32
+}
+
+// movementEncoding holds the delta movements of a replay encoded using one movement precision.
+type movementEncoding struct {
+	precision action.MovementPrecision
+	buf       *bytes.Buffer
+	w         *protocol.Writer
+	// reached holds the position of every player and entity as played back using the precision.
+	reached  map[movementKey]mgl64.Vec3
+	count    int
+	maxError float64
+}
+
+// runMovement encodes the delta movements of a replay using every movement precision and compares the size
+// of the movements and the error of the positions played back.
+func runMovement(fs *flag.FlagSet, args []string) error {
+	opts := openFlags(fs)
+	args, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	o, err := opts()
+	if err != nil {
+		return err
+	}
+	d, err := openReplay(args[0], o)
+	if err != nil {
+		return err
+	}
+
+	encodings := make([]*movementEncoding, len(movementPrecisions))
+	for i, p := range movementPrecisions {
+		buf := bytes.NewBuffer(nil)
+		encodings[i] = &movementEncoding{precision: p.precision, buf: buf, w: protocol.NewWriter(buf, 0), reached: make(map[movementKey]mgl64.Vec3)}
+	}
+	// positions holds the position of every player and entity as played back from the replay itself.
+	positions := make(map[movementKey]mgl64.Vec3)
+	teleport := func(key movementKey, pos mgl64.Vec3) {
+		positions[key] = pos
+		for _, enc := range encodings {
+			enc.reached[key] = pos
+		}
+	}
+	move := func(key movementKey, flags uint8, pos mgl64.Vec3, yaw, pitch uint16) {
+		for _, enc := range encodings {
+			prev, ok := enc.reached[key]
+			if !ok {
+				continue
+			}
+			posFlags, delta, reached := action.EncodeDeltaPosition(prev, pos, enc.precision)
+			flags := posFlags | flags
+			if key.entity {
+				action.Write(enc.w, &action.EntityDeltaMove{Flags: flags, EntityID: key.id, Position: delta, Yaw: yaw, Pitch: pitch})
+			} else {
+				action.Write(enc.w, &action.PlayerDeltaMove{Flags: flags, PlayerID: key.id, Position: delta, Yaw: yaw, Pitch: pitch})
+			}
+			enc.reached[key] = reached
+			enc.count++
+			enc.maxError = max(enc.maxError, reached.Sub(pos).Len())
+		}
+	}
+	// rotationFlags are the flags of a delta movement that are kept as they are for every precision.
+	const rotationFlags = action.PlayerDeltaMoveHasYawFlag | action.PlayerDeltaMoveHasPitchFlag
+
+	for tick := uint32(1); tick <= uint32(d.TotalTicks()); tick++ {
+		actions, err := d.Actions(tick)
+		if err != nil {
+			return err
+		}
+		for _, a := range actions {
+			switch a := a.(type) {
+			case *action.PlayerSpawn:
+				teleport(movementKey{id: a.PlayerID}, vec32To64(a.Position))
+			case *action.PlayerMove:
+				teleport(movementKey{id: a.PlayerID}, vec32To64(a.Position))
+			case *action.EntitySpawn:
+				teleport(movementKey{entity: true, id: a.EntityID}, vec32To64(a.Position))
+			case *action.EntityMove:
+				teleport(movementKey{entity: true, id: a.EntityID}, vec32To64(a.Position))
+			case *action.PlayerDeltaMove:
+				key := movementKey{id: a.PlayerID}
+				if prev, ok := positions[key]; ok {
+					positions[key] = action.ApplyDeltaPosition(prev, a.Flags, a.Position)
+					move(key, a.Flags&rotationFlags, positions[key], a.Yaw, a.Pitch)
+				}
+			case *action.EntityDeltaMove:
+				key := movementKey{entity: true, id: a.EntityID}
+				if prev, ok := positions[key]; ok {
+					positions[key] = action.ApplyDeltaPosition(prev, a.Flags, a.Position)
+					move(key, a.Flags&rotationFlags, positions[key], a.Yaw, a.Pitch)
+				}
+			}
+		}
+	}
+
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	if err != nil {
+		return err
+	}
+	defer encoder.Close()
+	exactCompressed := len(encoder.EncodeAll(encodings[0].buf.Bytes(), nil))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "PRECISION\tSTEP\tMOVEMENTS\tBYTES\tCOMPRESSED\tSIZE\tMAX ERROR\n")
+	for i, enc := range encodings {
+		step := "-"
+		if s := enc.precision.Step(); s != 0 {
+			step = fmt.Sprintf("1/%d", int(1/s))
+		}
+		compressed := len(encoder.EncodeAll(enc.buf.Bytes(), nil))
+		size := 100.0
+		if exactCompressed > 0 {
+			size = float64(compressed) / float64(exactCompressed) * 100
+		}
+		_, _ = fmt.Fprintf(tw, "%v\t%v\t%d\t%d\t%d\t%.1f%%\t%.5f\n", movementPrecisions[i].name, step, enc.count, enc.buf.Len(), compressed, size, enc.maxError)
+	}
+	return tw.Flush()
+}
+
+// vec32To64 converts a float32 vector to a float64 vector.
+func vec32To64(v [3]float32) mgl64.Vec3 {
+	return mgl64.Vec3{float64(v[0]), float64(v[1]), float64(v[2])}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"os"
+	"slices"
+	"text/tabwriter"
+)
+
+// actionStats holds the amount and the total encoded size of the actions of one type.
+type actionStats struct {
+	name  string
+	count int
+	bytes int
+}
+
+// runStats prints the amount of actions of every type in a replay and the size of their encoding before
+// compression, ordered by size.
+func runStats(fs *flag.FlagSet, args []string) error {
+	opts := openFlags(fs)
+	args, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	o, err := opts()
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(args[0])
+	if err != nil {
+		return err
+	}
+	d, err := openReplay(args[0], o)
+	if err != nil {
+		return err
+	}
+
+	stats := make(map[string]*actionStats)
+	buf := bytes.NewBuffer(nil)
+	w := protocol.NewWriter(buf, 0)
+	total := actionStats{name: "total"}
+	for tick := uint32(1); tick <= uint32(d.TotalTicks()); tick++ {
+		actions, err := d.Actions(tick)
+		if err != nil {
+			return err
+		}
+		for _, a := range actions {
+			buf.Reset()
+			action.Write(w, a)
+
+			name := action.Name(a)
+			s, ok := stats[name]
+			if !ok {
+				s = &actionStats{name: name}
+				stats[name] = s
+			}
+			s.count++
+			s.bytes += buf.Len()
+			total.count++
+			total.bytes += buf.Len()
+		}
+	}
+	sorted := make([]*actionStats, 0, len(stats))
+	for _, s := range stats {
+		sorted = append(sorted, s)
+	}
+	slices.SortFunc(sorted, func(a, b *actionStats) int {
+		if a.bytes != b.bytes {
+			return b.bytes - a.bytes
+		}
+		return a.count - b.count
+	})
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "ACTION\tCOUNT\tBYTES\tSHARE\n")
+	for _, s := range append(sorted, &total) {
+		share := 0.0
+		if total.bytes > 0 {
+			share = float64(s.bytes) / float64(total.bytes) * 100
+		}
+		_, _ = fmt.Fprintf(tw, "%v\t%d\t%d\t%.1f%%\n", s.name, s.count, s.bytes, share)
+	}
+	_, _ = fmt.Fprintf(tw, "\nticks\t%d\n", d.TotalTicks())
+	_, _ = fmt.Fprintf(tw, "file size\t%d\n", info.Size())
+	if info.Size() > 0 {
+		_, _ = fmt.Fprintf(tw, "compression ratio\t%.2f\n", float64(total.bytes)/float64(info.Size()))
+	}
+	return tw.Flush()
+}
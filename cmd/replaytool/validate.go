@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	replay "github.com/akmalfairuz/df-replay"
+)
+
+// runValidate verifies the checksums and, if a key is passed, the signature of a replay, and decodes every
+// tick of it. An error is returned if any of these checks fail.
+func runValidate(fs *flag.FlagSet, args []string) error {
+	opts := openFlags(fs)
+	hmacKey := fs.String("hmac", "", "hex encoded HMAC-SHA256 `key` to verify the signature with")
+	ed25519Key := fs.String("ed25519", "", "hex encoded Ed25519 public `key` to verify the signature with")
+	args, err := parse(fs, args, 1, 1)
+	if err != nil {
+		return err
+	}
+	o, err := opts()
+	if err != nil {
+		return err
+	}
+	var v replay.Verifier
+	switch {
+	case *hmacKey != "" && *ed25519Key != "":
+		return errors.New("only one of -hmac and -ed25519 may be passed")
+	case *hmacKey != "":
+		b, err := hex.DecodeString(*hmacKey)
+		if err != nil {
+			return fmt.Errorf("failed to decode HMAC key: %w", err)
+		}
+		v = replay.HMACKey(b)
+	case *ed25519Key != "":
+		b, err := hex.DecodeString(*ed25519Key)
+		if err != nil {
+			return fmt.Errorf("failed to decode Ed25519 key: %w", err)
+		}
+		v = replay.Ed25519PublicKey(b)
+	}
+	d, err := openReplay(args[0], o)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	report, err := d.Verify(v)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !report.Signed:
+		fmt.Println("signature: replay is not signed")
+		if v != nil {
+			problems = append(problems, "replay is not signed")
+		}
+	case v == nil:
+		fmt.Println("signature: not verified, pass -hmac or -ed25519 to verify it")
+	case report.SignatureValid:
+		fmt.Println("signature: valid")
+	default:
+		fmt.Println("signature: INVALID")
+		problems = append(problems, "signature is invalid")
+	}
+	for _, r := range report.FailedTicks {
+		problems = append(problems, fmt.Sprintf("ticks %d-%d fail verification", r.First, r.Last))
+	}
+	if report.FailedRecords > 0 {
+		problems = append(problems, fmt.Sprintf("%d records fail verification", report.FailedRecords))
+	}
+
+	var lastErr string
+	for tick := uint32(1); tick <= uint32(d.TotalTicks()); tick++ {
+		// All ticks of a segment that cannot be decoded fail with the same error, which is only reported
+		// for the first of them.
+		if _, err := d.Actions(tick); err != nil && err.Error() != lastErr {
+			lastErr = err.Error()
+			problems = append(problems, fmt.Sprintf("tick %d: %v", tick, err))
+		}
+	}
+	fmt.Printf("ticks: %d checked\n", d.TotalTicks())
+
+	palette := d.PaletteReport()
+	for _, b := range palette.UnresolvedBlocks {
+		fmt.Printf("warning: block %v %v does not exist and is played back as air\n", b.Name, b.Properties)
+	}
+	for _, it := range palette.UnresolvedItems {
+		fmt.Printf("warning: item %v:%d does not exist and is played back as air\n", it.Name, it.Meta)
+	}
+
+	for _, p := range problems {
+		fmt.Println("error:", p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("replay is invalid: %d problems found", len(problems))
+	}
+	fmt.Println("replay is valid")
+	return nil
+}
@@ -0,0 +1,341 @@
+package replay
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/sandertv/gophertunnel/minecraft/nbt"
+	"io"
+)
+
+// Replays of format version 2 and up are stored as a sequence of records following the magic number and the
+// format version. Each record starts with a one byte kind and a four byte payload length, so that readers
+// may skip records they are not interested in. The first record is always a metadata record. The file ends
+// with an index record listing the offsets of all other records, followed by a trailer holding the offset of
+// the index record and the trailer magic. Readers skip records of kinds they do not know. New kinds of
+// records that change how the segments of a replay are decoded therefore require bumping FormatVersion, so
+// that readers that do not know them refuse the replay instead of silently playing it without them.
+const (
+	recordMetadata uint8 = iota + 1
+	recordSegment
+	recordIndex
+	recordPalette
+	recordSkin
+	recordSignature
+	recordEncryption
+	recordPrivateMetadata
+	recordTracks
+	recordSnapshot
+	recordTickedSnapshot
+)
+
+const (
+	// trailerMagic is the magic number at the very end of a replay file, directly following the offset of
+	// the index record.
+	trailerMagic = "DFRI"
+	// trailerSize is the size of the trailer at the end of a replay file.
+	trailerSize = 8 + len(trailerMagic)
+	// recordHeaderSize is the size of the kind and length prefix of every record.
+	recordHeaderSize = 5
+	// segmentHeaderSize is the size of the tick range at the start of a segment record payload.
+	segmentHeaderSize = 8
+	// indexEntrySize is the size of a single entry in the index record.
+	indexEntrySize = 21
+)
+
+// indexEntry is a single entry in the index of a replay file, pointing to a record.
+type indexEntry struct {
+	kind uint8
+	// offset is the offset of the record from the start of the file, including the record header.
+	offset int64
+	// length is the length of the payload of the record.
+	length uint32
+	// firstTick and lastTick hold the tick range covered by a segment record. Both are zero for other
+	// records.
+	firstTick, lastTick uint32
+}
+
+// containerWriter writes the records of a replay file to an underlying io.Writer, keeping track of the
+// offsets of the records written so that an index may be written when the replay is closed.
+type containerWriter struct {
+	w       io.Writer
+	version uint16
+	offset  int64
+	index   []indexEntry
+
+	// signer, if not nil, is used to sign the replay when it is closed. checksums then holds the SHA-256
+	// checksum of the payload of every record in the index.
+	signer    Signer
+	checksums [][32]byte
+	// cipher, if not nil, is used to encrypt the payloads of records.
+	cipher *recordCipher
+}
+
+// newContainerWriter creates a containerWriter that writes a replay of the current format version to w.
+func newContainerWriter(w io.Writer) *containerWriter {
+	return &containerWriter{w: w, version: FormatVersion}
+}
+
+// writeHeader writes the magic number and format version, followed by a metadata record and, if the replay
+// is encrypted, an encryption record.
+func (c *containerWriter) writeHeader(meta Metadata) error {
+	buf := make([]byte, 0, len(formatMagic)+2)
+	buf = append(buf, formatMagic...)
+	buf = binary.LittleEndian.AppendUint16(buf, c.version)
+	if err := c.write(buf); err != nil {
+		return err
+	}
+	if c.cipher == nil {
+		return c.writeMetadata(meta)
+	}
+	// The private metadata record is written after the encryption record, so that it may be decrypted when
+	// the replay is read sequentially.
+	if err := c.writeMetadataRecord(recordMetadata, publicMetadata(meta)); err != nil {
+		return err
+	}
+	if err := c.writeEncryption(); err != nil {
+		return err
+	}
+	return c.writeMetadataRecord(recordPrivateMetadata, meta)
+}
+
+// writeMetadata writes a metadata record. Readers use the last metadata record in the file. If the replay is
+// encrypted, the metadata record only holds the public part of the metadata, and is followed by a private
+// metadata record holding the complete metadata.
+func (c *containerWriter) writeMetadata(meta Metadata) error {
+	if c.cipher == nil {
+		return c.writeMetadataRecord(recordMetadata, meta)
+	}
+	if err := c.writeMetadataRecord(recordMetadata, publicMetadata(meta)); err != nil {
+		return err
+	}
+	return c.writeMetadataRecord(recordPrivateMetadata, meta)
+}
+
+// writeMetadataRecord writes a record of the kind passed holding the metadata passed.
+func (c *containerWriter) writeMetadataRecord(kind uint8, meta Metadata) error {
+	encoded, err := nbt.MarshalEncoding(meta.EncodeNBT(), nbt.LittleEndian)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	return c.writeRecord(kind, encoded, 0, 0)
+}
+
+// writePalette writes a palette record holding a palette encoded using encodePalette. Readers use the last
+// palette record in the file.
+func (c *containerWriter) writePalette(encoded []byte) error {
+	return c.writeRecord(recordPalette, encoded, 0, 0)
+}
+
+// writeSegment writes a segment record holding the compressed actions of the ticks firstTick through
+// lastTick.
+func (c *containerWriter) writeSegment(firstTick, lastTick uint32, compressed []byte) error {
+	return c.writeTickRecord(recordSegment, firstTick, lastTick, compressed)
+}
+
+// writeTracks writes a tracks record holding the compressed movements split off the actions of the ticks
+// firstTick through lastTick. The tracks record must be written directly before the segment record of the
+// same ticks.
+func (c *containerWriter) writeTracks(firstTick, lastTick uint32, compressed []byte) error {
+	return c.writeTickRecord(recordTracks, firstTick, lastTick, compressed)
+}
+
+// writeTickRecord writes a record of the kind passed holding compressed data of the ticks firstTick through
+// lastTick, prefixed with the tick range.
+func (c *containerWriter) writeTickRecord(kind uint8, firstTick, lastTick uint32, compressed []byte) error {
+	payload := make([]byte, 0, segmentHeaderSize+len(compressed))
+	payload = binary.LittleEndian.AppendUint32(payload, firstTick)
+	payload = binary.LittleEndian.AppendUint32(payload, lastTick)
+	payload = append(payload, compressed...)
+	return c.writeRecord(kind, payload, firstTick, lastTick)
+}
+
+// writeRecord writes a record of the kind passed and adds it to the index. The payload is encrypted first if
+// the replay is encrypted and records of the kind are encrypted.
+func (c *containerWriter) writeRecord(kind uint8, payload []byte, firstTick, lastTick uint32) error {
+	if c.cipher != nil && encryptedRecord(kind) {
+		sealed, err := c.cipher.seal(kind, c.offset, payload)
+		if err != nil {
+			return err
+		}
+		payload = sealed
+	}
+	entry := indexEntry{kind: kind, offset: c.offset, length: uint32(len(payload)), firstTick: firstTick, lastTick: lastTick}
+	buf := make([]byte, 0, recordHeaderSize+len(payload))
+	buf = append(buf, kind)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = append(buf, payload...)
+	if err := c.write(buf); err != nil {
+		return err
+	}
+	c.index = append(c.index, entry)
+	if c.signer != nil {
+		c.checksums = append(c.checksums, sha256.Sum256(payload))
+	}
+	return nil
+}
+
+// close writes the final metadata record, the signature record if the replay is signed, the index and the
+// trailer.
+func (c *containerWriter) close(meta Metadata) error {
+	if err := c.writeMetadata(meta); err != nil {
+		return err
+	}
+	if c.signer != nil {
+		if err := c.writeSignature(); err != nil {
+			return err
+		}
+	}
+	indexOffset := c.offset
+	payload := make([]byte, 0, 4+len(c.index)*indexEntrySize)
+	payload = binary.LittleEndian.AppendUint32(payload, uint32(len(c.index)))
+	for _, e := range c.index {
+		payload = appendIndexEntry(payload, e)
+	}
+	buf := make([]byte, 0, recordHeaderSize+len(payload)+trailerSize)
+	buf = append(buf, recordIndex)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = append(buf, payload...)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(indexOffset))
+	buf = append(buf, trailerMagic...)
+	return c.write(buf)
+}
+
+// write writes b to the underlying writer and advances the offset.
+func (c *containerWriter) write(b []byte) error {
+	n, err := c.w.Write(b)
+	c.offset += int64(n)
+	return err
+}
+
+// readRecordHeader reads the kind and payload length of the record at the current position of r.
+func readRecordHeader(r io.Reader) (kind uint8, length uint32, err error) {
+	var buf [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, 0, err
+	}
+	return buf[0], binary.LittleEndian.Uint32(buf[1:]), nil
+}
+
+// readRecordAt reads the payload of the record pointed to by e.
+func readRecordAt(r io.ReaderAt, e indexEntry) ([]byte, error) {
+	payload := make([]byte, e.length)
+	if _, err := r.ReadAt(payload, e.offset+recordHeaderSize); err != nil {
+		return nil, fmt.Errorf("failed to read record at offset %d: %w", e.offset, err)
+	}
+	return payload, nil
+}
+
+// readIndex reads the index of a replay file of the given size using its trailer.
+func readIndex(r io.ReaderAt, size int64) ([]indexEntry, error) {
+	if size < int64(trailerSize) {
+		return nil, errors.New("replay is too small to hold a trailer")
+	}
+	trailer := make([]byte, trailerSize)
+	if _, err := r.ReadAt(trailer, size-int64(trailerSize)); err != nil {
+		return nil, fmt.Errorf("failed to read trailer: %w", err)
+	}
+	if string(trailer[8:]) != trailerMagic {
+		return nil, errors.New("replay has no trailer, it may be truncated")
+	}
+	indexOffset := int64(binary.LittleEndian.Uint64(trailer))
+	if indexOffset < 0 || indexOffset+recordHeaderSize > size-int64(trailerSize) {
+		return nil, fmt.Errorf("index offset %d out of bounds", indexOffset)
+	}
+	header := make([]byte, recordHeaderSize)
+	if _, err := r.ReadAt(header, indexOffset); err != nil {
+		return nil, fmt.Errorf("failed to read index record: %w", err)
+	}
+	if header[0] != recordIndex {
+		return nil, fmt.Errorf("expected index record at offset %d, got record kind %d", indexOffset, header[0])
+	}
+	payload, err := readRecordAt(r, indexEntry{offset: indexOffset, length: binary.LittleEndian.Uint32(header[1:])})
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < 4 {
+		return nil, errors.New("index record is too short")
+	}
+	count := binary.LittleEndian.Uint32(payload)
+	payload = payload[4:]
+	if uint64(len(payload)) != uint64(count)*indexEntrySize {
+		return nil, fmt.Errorf("index record holds %d bytes, expected %d entries", len(payload), count)
+	}
+	entries := make([]indexEntry, count)
+	for i := range entries {
+		entries[i] = decodeIndexEntry(payload[i*indexEntrySize:])
+		if entries[i].offset < 0 || entries[i].offset+recordHeaderSize+int64(entries[i].length) > indexOffset {
+			return nil, fmt.Errorf("index entry %d out of bounds", i)
+		}
+	}
+	return entries, nil
+}
+
+// appendIndexEntry appends the encoding of an index entry to b.
+func appendIndexEntry(b []byte, e indexEntry) []byte {
+	b = append(b, e.kind)
+	b = binary.LittleEndian.AppendUint64(b, uint64(e.offset))
+	b = binary.LittleEndian.AppendUint32(b, e.length)
+	b = binary.LittleEndian.AppendUint32(b, e.firstTick)
+	return binary.LittleEndian.AppendUint32(b, e.lastTick)
+}
+
+// decodeIndexEntry decodes an index entry encoded using appendIndexEntry. b must hold at least
+// indexEntrySize bytes.
+func decodeIndexEntry(b []byte) indexEntry {
+	return indexEntry{
+		kind:      b[0],
+		offset:    int64(binary.LittleEndian.Uint64(b[1:])),
+		length:    binary.LittleEndian.Uint32(b[9:]),
+		firstTick: binary.LittleEndian.Uint32(b[13:]),
+		lastTick:  binary.LittleEndian.Uint32(b[17:]),
+	}
+}
+
+// decodeMetadata decodes the payload of a metadata record.
+func decodeMetadata(payload []byte) (Metadata, error) {
+	var meta Metadata
+	var m map[string]any
+	if err := nbt.UnmarshalEncoding(payload, &m, nbt.LittleEndian); err != nil {
+		return meta, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	meta.DecodeNBT(m)
+	return meta, nil
+}
+
+// encodePalette encodes a palette for a palette record.
+func encodePalette(p *Palette) ([]byte, error) {
+	encoded, err := nbt.MarshalEncoding(p.EncodeNBT(), nbt.LittleEndian)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode palette: %w", err)
+	}
+	return encoded, nil
+}
+
+// decodePalette decodes the payload of a palette record.
+func decodePalette(payload []byte) (*Palette, error) {
+	var m map[string]any
+	if err := nbt.UnmarshalEncoding(payload, &m, nbt.LittleEndian); err != nil {
+		return nil, fmt.Errorf("failed to decode palette: %w", err)
+	}
+	p := &Palette{}
+	p.DecodeNBT(m)
+	return p, nil
+}
+
+// readPaletteAt reads the last palette record pointed to by the index entries passed, decrypting it using
+// c if it is not nil. A nil palette is returned if the replay does not hold a palette.
+func readPaletteAt(r io.ReaderAt, entries []indexEntry, c *recordCipher) (*Palette, error) {
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].kind != recordPalette {
+			continue
+		}
+		payload, err := readPayloadAt(r, entries[i], c)
+		if err != nil {
+			return nil, err
+		}
+		return decodePalette(payload)
+	}
+	return nil, nil
+}
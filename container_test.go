@@ -0,0 +1,128 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/google/uuid"
+	"io"
+	"slices"
+	"testing"
+	"time"
+)
+
+// TestContainerRoundTrip tests that the actions and metadata of a replay are read back as written, both
+// when opened with random access and when loaded from a stream.
+func TestContainerRoundTrip(t *testing.T) {
+	b := writeTestReplay(t, writeOptions{})
+	d := openTestReplay(t, b, nil)
+	requireActions(t, d, testTicks, testActions)
+
+	want, got := testMetadata(), d.Metadata()
+	if got.ID != want.ID || !got.StartTime.Equal(want.StartTime) || !got.EndTime.Equal(want.EndTime) {
+		t.Fatalf("metadata %+v does not match %+v", got, want)
+	}
+	if !slices.Equal(got.Players, want.Players) || got.Tags["map"] != want.Tags["map"] {
+		t.Fatalf("players %v and tags %v do not match %v and %v", got.Players, got.Tags, want.Players, want.Tags)
+	}
+	if d.ID() != want.ID || d.Version() != FormatVersion {
+		t.Fatalf("replay has ID %v and version %d", d.ID(), d.Version())
+	}
+
+	loaded := NewData(uuid.Nil)
+	if err := loaded.LoadActions(bytes.NewReader(b)); err != nil {
+		t.Fatalf("failed to load replay: %v", err)
+	}
+	requireActions(t, loaded, testTicks, testActions)
+}
+
+// TestContainerIndex tests that every index entry points to a record of the same kind and length, and that
+// the segments cover every tick of the replay exactly once.
+func TestContainerIndex(t *testing.T) {
+	b := writeTestReplay(t, writeOptions{segmentTicks: 40})
+	if string(b[:len(formatMagic)]) != formatMagic || binary.LittleEndian.Uint16(b[len(formatMagic):]) != FormatVersion {
+		t.Fatalf("replay starts with %q", b[:len(formatMagic)+2])
+	}
+	entries, err := readIndex(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("failed to read index: %v", err)
+	}
+	var segments []indexEntry
+	for i, e := range entries {
+		kind, length, err := readRecordHeader(bytes.NewReader(b[e.offset:]))
+		if err != nil {
+			t.Fatalf("failed to read record header of entry %d: %v", i, err)
+		}
+		if kind != e.kind || length != e.length {
+			t.Fatalf("entry %d points to a record of kind %d and length %d, expected %d and %d", i, kind, length, e.kind, e.length)
+		}
+		if e.kind == recordSegment {
+			segments = append(segments, e)
+		}
+	}
+	slices.SortFunc(segments, func(a, b indexEntry) int {
+		return int(a.firstTick) - int(b.firstTick)
+	})
+	next := uint32(1)
+	for _, s := range segments {
+		if s.firstTick != next || s.lastTick < s.firstTick || s.lastTick-s.firstTick >= 40 {
+			t.Fatalf("segment covers ticks %d through %d, expected it to start at %d", s.firstTick, s.lastTick, next)
+		}
+		next = s.lastTick + 1
+	}
+	if next != testTicks+1 {
+		t.Fatalf("segments end at tick %d, expected %d", next-1, testTicks)
+	}
+}
+
+// TestReadMetadata tests that ReadMetadata returns the metadata written when the replay is closed if it can
+// read the index, and the metadata of the header otherwise.
+func TestReadMetadata(t *testing.T) {
+	initial := testMetadata()
+	initial.EndTime = time.Time{}
+	final := testMetadata()
+
+	buf := bytes.NewBuffer(nil)
+	cw := newContainerWriter(buf)
+	if err := cw.writeHeader(initial); err != nil {
+		t.Fatalf("failed to write header: %v", err)
+	}
+	if err := cw.close(final); err != nil {
+		t.Fatalf("failed to close replay: %v", err)
+	}
+
+	meta, err := ReadMetadata(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("failed to read metadata using the index: %v", err)
+	}
+	if !meta.EndTime.Equal(final.EndTime) {
+		t.Fatalf("metadata read using the index ends at %v, expected %v", meta.EndTime, final.EndTime)
+	}
+	meta, err = ReadMetadata(io.MultiReader(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatalf("failed to read metadata from the header: %v", err)
+	}
+	if meta.ID != initial.ID || !meta.EndTime.IsZero() {
+		t.Fatalf("metadata read from the header is %+v, expected %+v", meta, initial)
+	}
+}
+
+// TestContainerTruncated tests that replays that are cut off or have a damaged trailer are refused.
+func TestContainerTruncated(t *testing.T) {
+	b := writeTestReplay(t, writeOptions{})
+	for _, size := range []int{len(b) - 1, len(b) - trailerSize/2, len(b) / 2, trailerSize - 1} {
+		d := NewData(uuid.Nil)
+		if err := d.Open(bytes.NewReader(b[:size]), int64(size)); err == nil {
+			t.Fatalf("replay cut off at %d of %d bytes was opened", size, len(b))
+		}
+	}
+	damaged := bytes.Clone(b)
+	damaged[len(damaged)-1] ^= 0xff
+	if err := NewData(uuid.Nil).Open(bytes.NewReader(damaged), int64(len(damaged))); err == nil {
+		t.Fatal("replay with a damaged trailer was opened")
+	}
+	damaged = bytes.Clone(b)
+	binary.LittleEndian.PutUint64(damaged[len(damaged)-trailerSize:], uint64(len(b)))
+	if err := NewData(uuid.Nil).Open(bytes.NewReader(damaged), int64(len(damaged))); err == nil {
+		t.Fatal("replay with an index offset out of bounds was opened")
+	}
+}
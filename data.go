@@ -4,63 +4,511 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/akmalfairuz/df-replay/action"
-	"github.com/akmalfairuz/df-replay/internal"
+	"github.com/df-mc/dragonfly/server/player/skin"
+	"github.com/df-mc/dragonfly/server/world"
 	"github.com/google/uuid"
 	"github.com/klauspost/compress/zstd"
 	"github.com/sandertv/gophertunnel/minecraft/protocol"
 	"io"
+	"sort"
+	"sync"
 )
 
+// segmentDecoder is used to decompress the segments of all replays. DecodeAll may be called concurrently.
+var segmentDecoder, _ = zstd.NewReader(nil)
+
+// Data holds the actions and metadata of a replay. Replays are split into segments that are only decoded
+// once the actions of one of their ticks are requested.
 type Data struct {
-	id         uuid.UUID
-	actions    map[uint32][]action.Action
+	id   uuid.UUID
+	meta Metadata
+	// version is the format version of the replay read, which may differ from writtenVersion if the replay
+	// was upgraded when it was loaded.
+	version        uint16
+	writtenVersion uint16
+
+	// keys is used to decrypt encrypted replays, and cipher decrypts the records of the replay if it is
+	// encrypted.
+	keys   KeyProvider
+	cipher *recordCipher
+	// dictionaries holds the dictionaries that the replay may be compressed using, and decoder decompresses
+	// the records of the replay using the dictionary it was compressed using.
+	dictionaries []*Dictionary
+	decoder      *zstd.Decoder
+
+	palette       *Palette
+	paletteReport PaletteReport
+	// remap holds the block and item hashes of the replay that are replaced when segments are decoded. It is
+	// not changed once the replay is opened.
+	remap paletteRemap
+
+	mu         sync.Mutex
+	r          io.ReaderAt
+	entries    []indexEntry
+	segments   []*segment
 	totalTicks uint
+	// skins points to the skin records of the replay by the hash of their skin, and decodedSkins holds the
+	// skins that were read.
+	skins        map[[32]byte]indexEntry
+	decodedSkins map[[32]byte]skin.Skin
+	// snapshot points to the snapshot records of the replay by the position of their chunk.
+	snapshot map[world.ChunkPos]snapshotEntry
+
+	windowed     bool
+	windowBehind uint32
+	windowAhead  uint32
 }
 
+// segment is a range of ticks in a replay that is compressed on its own.
+type segment struct {
+	indexEntry
+	// tracks points to the tracks record holding the movements of the segment, or is nil if the movements
+	// are stored in the segment itself.
+	tracks *indexEntry
+	// actions holds the decoded actions of the segment, or nil if the segment has not been decoded.
+	actions map[uint32][]action.Action
+	// prefetching is true while the segment is being decoded in the background.
+	prefetching bool
+}
+
+// NewData creates a new Data with the given ID. The ID is replaced by the ID stored in the header of the
+// replay once it is loaded, unless the replay was written in the legacy format without a header.
 func NewData(id uuid.UUID) *Data {
 	return &Data{
 		id: id,
 	}
 }
 
+// ID returns the ID of the replay.
+func (d *Data) ID() uuid.UUID {
+	return d.id
+}
+
+// Metadata returns the metadata read from the header of the replay. It is empty for replays written in the
+// legacy format without a header.
+func (d *Data) Metadata() Metadata {
+	return d.meta
+}
+
+// Version returns the format version that the loaded replay was written in. Replays of format versions
+// older than 2 are upgraded to the current format version when loaded.
+func (d *Data) Version() uint16 {
+	return d.writtenVersion
+}
+
+// Palette returns the palette of the blocks and items used in the replay, or nil if the replay was written
+// without a palette. Replays without a palette are assumed to use the block and item hashes of the server.
+func (d *Data) Palette() *Palette {
+	return d.palette
+}
+
+// PaletteReport returns the result of resolving the palette of the replay against the block and item
+// registry of the server when the replay was opened. Blocks and items that could not be resolved are
+// played back as air.
+func (d *Data) PaletteReport() PaletteReport {
+	return d.paletteReport
+}
+
+// TotalTicks returns the last tick recorded in the replay.
+func (d *Data) TotalTicks() uint {
+	return d.totalTicks
+}
+
+// LoadActions reads a complete replay from r into memory. Segments of the replay are decompressed once the
+// actions of one of their ticks are requested. Replays of format versions older than 2, which are not split
+// into segments, are upgraded to the current format version first.
 func (d *Data) LoadActions(r io.Reader) error {
-	decoder, err := zstd.NewReader(r)
+	buf, err := io.ReadAll(r)
 	if err != nil {
-		return fmt.Errorf("failed to create zstd reader: %w", err)
+		return fmt.Errorf("failed to read replay: %w", err)
 	}
-	defer decoder.Close()
+	version, _, _, err := readHeader(bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	if version < 2 {
+		if buf, _, err = upgrade(buf); err != nil {
+			return err
+		}
+	}
+	if err := d.Open(bytes.NewReader(buf), int64(len(buf))); err != nil {
+		return err
+	}
+	d.writtenVersion = version
+	return nil
+}
 
-	buffer := internal.BufferPool.Get().(*bytes.Buffer)
-	defer func() {
-		buffer.Reset()
-		internal.BufferPool.Put(buffer)
-	}()
-	_, err = io.Copy(buffer, decoder)
+// Open opens a replay of format version 2 or up for random access. Only the header and the index of the
+// replay are read; segments are read from r and decoded once the actions of one of their ticks are
+// requested. r must remain valid for as long as the Data is used. Replays of older format versions must be
+// upgraded using Upgrade first.
+func (d *Data) Open(r io.ReaderAt, size int64) error {
+	version, _, _, err := readHeader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return err
+	}
+	if version < 2 {
+		return fmt.Errorf("format version %d does not support random access", version)
+	}
+	entries, err := readIndex(r, size)
+	if err != nil {
+		return err
+	}
+	meta, err := readMetadataAt(r, size)
 	if err != nil {
-		return fmt.Errorf("failed to copy decompressed data: %w", err)
+		return err
+	}
+	d.mu.Lock()
+	keys, dicts := d.keys, d.dictionaries
+	d.mu.Unlock()
+	c, err := readEncryptionAt(r, entries, keys)
+	if err != nil {
+		return err
+	}
+	if c != nil {
+		// The metadata records of encrypted replays only hold the public part of the metadata.
+		private, ok, err := readPrivateMetadataAt(r, entries, c)
+		if err != nil {
+			return err
+		}
+		if ok {
+			meta = private
+		}
+	}
+	decoder, err := findDictionary(meta.DictionaryID, dicts)
+	if err != nil {
+		return err
+	}
+	palette, err := readPaletteAt(r, entries, c)
+	if err != nil {
+		return err
+	}
+	skins, err := readSkinHashes(r, entries, c)
+	if err != nil {
+		return err
+	}
+	snapshot, err := readSnapshotPositions(r, entries, c)
+	if err != nil {
+		return err
+	}
+	var (
+		remap  paletteRemap
+		report PaletteReport
+	)
+	if palette != nil {
+		remap, report = palette.resolve()
 	}
 
-	dec := protocol.NewReader(buffer, 0, false)
-	var tickLen uint32
-	dec.Uint32(&tickLen)
-	d.actions = make(map[uint32][]action.Action, tickLen)
+	segments := make([]*segment, 0, len(entries))
+	tracks := make(map[uint32]indexEntry)
 	totalTicks := uint(0)
-	for i := uint32(0); i < tickLen; i++ {
-		var tick uint32
+	for _, e := range entries {
+		switch e.kind {
+		case recordTracks:
+			tracks[e.firstTick] = e
+		case recordSegment:
+			seg := &segment{indexEntry: e}
+			if t, ok := tracks[e.firstTick]; ok && t.lastTick == e.lastTick {
+				seg.tracks = &t
+			}
+			segments = append(segments, seg)
+			totalTicks = max(totalTicks, uint(e.lastTick))
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].firstTick < segments[j].firstTick
+	})
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.version, d.writtenVersion, d.meta = version, version, meta
+	if meta.ID != uuid.Nil {
+		// Replays upgraded from the legacy format without a header do not have an ID.
+		d.id = meta.ID
+	}
+	d.palette, d.paletteReport, d.remap = palette, report, remap
+	d.r, d.entries, d.cipher, d.decoder = r, entries, c, decoder
+	d.segments = segments
+	d.skins, d.decodedSkins = skins, make(map[[32]byte]skin.Skin)
+	d.snapshot = snapshot
+	d.totalTicks = totalTicks
+	return nil
+}
+
+// SetKeyProvider sets the KeyProvider used to decrypt the replay if it is encrypted. SetKeyProvider must be
+// called before the replay is loaded. Loading an encrypted replay without a KeyProvider fails with
+// ErrEncrypted.
+func (d *Data) SetKeyProvider(keys KeyProvider) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.keys = keys
+}
+
+// SetDictionaries sets the dictionaries that the replay may have been compressed using. The Dictionary with
+// the ID stored in the metadata of the replay is used to decompress it. SetDictionaries must be called
+// before the replay is loaded. Loading a replay compressed using a Dictionary that was not passed fails with
+// ErrMissingDictionary.
+func (d *Data) SetDictionaries(dicts ...*Dictionary) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dictionaries = dicts
+}
+
+// SetWindow enables windowed decoding, which limits the memory used by long replays. When the actions of a
+// tick are requested, segments that end more than behind ticks before it or start more than ahead ticks
+// after it are evicted, and the next segment is decoded in the background once the tick is within ahead
+// ticks of the end of its segment. Evicted segments are decoded again when one of their ticks is requested.
+// Passing 0 for both values disables windowed decoding. Windowed decoding works best if the Data is used by
+// a single Playback.
+func (d *Data) SetWindow(behind, ahead uint32) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.windowed = behind != 0 || ahead != 0
+	d.windowBehind, d.windowAhead = behind, ahead
+}
+
+// Actions returns the actions recorded at the tick passed. The segment holding the tick is decoded if it
+// was not decoded yet.
+func (d *Data) Actions(tick uint32) ([]action.Action, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.windowed {
+		d.evict(tick)
+	}
+	seg, ok := d.segmentOf(tick)
+	if !ok {
+		return nil, nil
+	}
+	if seg.actions == nil {
+		actions, err := d.decodeSegment(seg.indexEntry, seg.tracks, d.version)
+		if err != nil {
+			return nil, err
+		}
+		seg.actions = actions
+	}
+	if d.windowed && tick+d.windowAhead > seg.lastTick {
+		d.prefetch(seg.lastTick + 1)
+	}
+	return seg.actions[tick], nil
+}
+
+// Skin returns the skin in the skin table of the replay with the hash passed, as referred to by
+// PlayerSkinRef actions. The skin is read from the replay the first time it is requested.
+func (d *Data) Skin(hash [32]byte) (skin.Skin, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if sk, ok := d.decodedSkins[hash]; ok {
+		return sk, nil
+	}
+	e, ok := d.skins[hash]
+	if !ok {
+		return skin.Skin{}, fmt.Errorf("skin %x not found", hash)
+	}
+	payload, err := readPayloadAt(d.r, e, d.cipher)
+	if err != nil {
+		return skin.Skin{}, err
+	}
+	_, sk, err := decodeSkinRecord(payload)
+	if err != nil {
+		return skin.Skin{}, err
+	}
+	d.decodedSkins[hash] = sk
+	return sk, nil
+}
+
+// Keyframe returns the last keyframe written at or before the tick passed, along with the tick it was
+// written in. A nil keyframe is returned if there is no such keyframe, such as for ticks in the first
+// segment or for replays recorded without keyframes, in which case the replay must be played from the start.
+// The keyframes of all sources of a merged replay are combined into a single keyframe.
+func (d *Data) Keyframe(tick uint32) (uint32, *action.Keyframe, error) {
+	return d.keyframe(tick, nil)
+}
+
+// keyframe returns the last keyframe written at or before the tick passed like Keyframe, combining only the
+// keyframes of the sources for which include returns true. If include is nil, the keyframes of all sources
+// are combined.
+func (d *Data) keyframe(tick uint32, include func(source uint32) bool) (uint32, *action.Keyframe, error) {
+	d.mu.Lock()
+	segments := d.segments
+	d.mu.Unlock()
+
+	// Keyframes are only written at the start of a segment, so only the first tick of the segment holding
+	// the tick passed has to be checked.
+	i := sort.Search(len(segments), func(i int) bool {
+		return segments[i].firstTick > tick
+	}) - 1
+	if i < 0 {
+		return 0, nil, nil
+	}
+	first := segments[i].firstTick
+	actions, err := d.Actions(first)
+	if err != nil {
+		return 0, nil, err
+	}
+	var (
+		source    uint32
+		found     bool
+		keyframes []*action.Keyframe
+	)
+	for _, a := range actions {
+		switch a := a.(type) {
+		case *action.SetSource:
+			source = a.Source
+		case *action.Keyframe:
+			found = true
+			if include == nil || include(source) {
+				keyframes = append(keyframes, a)
+			}
+		}
+	}
+	if !found {
+		return 0, nil, nil
+	}
+	if len(keyframes) == 0 {
+		return first, &action.Keyframe{}, nil
+	}
+	return first, mergeKeyframes(keyframes), nil
+}
+
+// evict drops the decoded actions of segments outside the window around the tick passed. evict must be
+// called while holding mu.
+func (d *Data) evict(tick uint32) {
+	for _, seg := range d.segments {
+		if seg.actions == nil {
+			continue
+		}
+		if seg.lastTick+d.windowBehind < tick || seg.firstTick > tick+d.windowAhead {
+			seg.actions = nil
+		}
+	}
+}
+
+// prefetch decodes the segment holding the tick passed in the background, if it was not decoded yet.
+// prefetch must be called while holding mu.
+func (d *Data) prefetch(tick uint32) {
+	seg, ok := d.segmentOf(tick)
+	if !ok || seg.actions != nil || seg.prefetching {
+		return
+	}
+	seg.prefetching = true
+	version := d.version
+	go func() {
+		actions, err := d.decodeSegment(seg.indexEntry, seg.tracks, version)
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		seg.prefetching = false
+		if err == nil && seg.actions == nil {
+			// Errors are ignored here: they are returned once the segment is decoded by Actions.
+			seg.actions = actions
+		}
+	}()
+}
+
+// segmentOf returns the segment holding the tick passed.
+func (d *Data) segmentOf(tick uint32) (*segment, bool) {
+	i := sort.Search(len(d.segments), func(i int) bool {
+		return d.segments[i].lastTick >= tick
+	})
+	if i == len(d.segments) || d.segments[i].firstTick > tick {
+		return nil, false
+	}
+	return d.segments[i], true
+}
+
+// decodeSegment reads and decodes the actions of the segment pointed to by e, written using the format
+// version passed, replacing block and item hashes using the palette of the replay. If tracks is not nil,
+// the movements in the tracks record it points to are merged back into the actions of the segment.
+// decodeSegment does not access any fields of the Data other than the reader, the cipher, the decoder and the
+// remap, so it may be called without holding mu.
+func (d *Data) decodeSegment(e indexEntry, tracks *indexEntry, version uint16) (map[uint32][]action.Action, error) {
+	decompressed, err := d.decompressRecord(e)
+	if err != nil {
+		return nil, err
+	}
+	actions := make(map[uint32][]action.Action, e.lastTick-e.firstTick+1)
+	if _, err := decodeTicks(decompressed, actions, version); err != nil {
+		return nil, err
+	}
+	if tracks != nil {
+		decompressed, err := d.decompressRecord(*tracks)
+		if err != nil {
+			return nil, err
+		}
+		moves, err := decodeTracks(decompressed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode tracks at offset %d: %w", tracks.offset, err)
+		}
+		for tick, tickMoves := range moves {
+			if actions[tick], err = mergeTracks(actions[tick], tickMoves); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !d.remap.empty() {
+		for _, tickActions := range actions {
+			for _, a := range tickActions {
+				d.remap.apply(a)
+			}
+		}
+	}
+	return actions, nil
+}
+
+// decompressRecord reads and decompresses the payload of the segment or tracks record pointed to by e.
+func (d *Data) decompressRecord(e indexEntry) ([]byte, error) {
+	payload, err := readPayloadAt(d.r, e, d.cipher)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < segmentHeaderSize {
+		return nil, fmt.Errorf("record at offset %d is too short", e.offset)
+	}
+	decompressed, err := d.recordDecoder().DecodeAll(payload[segmentHeaderSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress record at offset %d: %w", e.offset, err)
+	}
+	return decompressed, nil
+}
+
+// recordDecoder returns the zstd.Decoder used to decompress the records of the replay.
+func (d *Data) recordDecoder() *zstd.Decoder {
+	if d.decoder == nil {
+		return segmentDecoder
+	}
+	return d.decoder
+}
+
+// decodeTicks decodes a stream of ticks, written using the format version passed, into the map passed
+// until the stream is exhausted. The highest tick decoded is returned.
+func decodeTicks(b []byte, into map[uint32][]action.Action, version uint16) (lastTick uint32, err error) {
+	read := action.Read
+	if version < prefixedActionsVersion {
+		read = action.ReadLegacy
+	}
+	buf := bytes.NewBuffer(b)
+	dec := protocol.NewReader(buf, 0, false)
+	var tick uint32
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("tick read error after tick %d: %v", tick, r)
+		}
+	}()
+	for buf.Len() > 0 {
 		dec.Varuint32(&tick)
-		totalTicks = max(totalTicks, uint(tick))
+		lastTick = max(lastTick, tick)
 		var actionLen uint32
 		dec.Varuint32(&actionLen)
-		actions := make([]action.Action, actionLen)
+		// Every action takes up at least one byte, which limits the allocation for corrupted counts.
+		actions := make([]action.Action, 0, min(int(actionLen), buf.Len()))
 		for j := uint32(0); j < actionLen; j++ {
 			var act action.Action
-			if err := action.Read(dec, &act); err != nil {
-				return fmt.Errorf("action read error at tick %d, index %d: %w", tick, j, err)
+			if err := read(buf, &act); err != nil {
+				return lastTick, fmt.Errorf("action read error at tick %d, index %d: %w", tick, j, err)
 			}
-			actions[j] = act
+			actions = append(actions, act)
 		}
-		d.actions[tick] = actions
+		into[tick] = actions
 	}
-	d.totalTicks = totalTicks
-	return nil
+	return lastTick, nil
 }
@@ -0,0 +1,81 @@
+package replay
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// decodedSegments returns the indices of the segments of the replay passed that are decoded, after waiting
+// for segments decoded in the background to finish.
+func decodedSegments(t *testing.T, d *Data) []int {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		d.mu.Lock()
+		var decoded []int
+		prefetching := false
+		for i, seg := range d.segments {
+			prefetching = prefetching || seg.prefetching
+			if seg.actions != nil {
+				decoded = append(decoded, i)
+			}
+		}
+		d.mu.Unlock()
+		if !prefetching {
+			return decoded
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("segments were still being decoded in the background after 5 seconds")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestWindow tests that windowed decoding only keeps the segments around the tick last read decoded, and
+// that evicted segments are decoded again with the same actions once their ticks are read.
+func TestWindow(t *testing.T) {
+	b := writeTestReplay(t, writeOptions{})
+	d := openTestReplay(t, b, nil)
+	d.SetWindow(10, 10)
+
+	for tick := uint32(1); tick <= testTicks; tick++ {
+		want, _ := testActions(tick)
+		got, err := d.Actions(tick)
+		if err != nil {
+			t.Fatalf("failed to read tick %d: %v", tick, err)
+		}
+		if !bytes.Equal(encodeActions(got), encodeActions(want)) {
+			t.Fatalf("tick %d differs from the actions written", tick)
+		}
+		// Besides the segment of the tick, only the segment before it, if it ended at most 10 ticks ago, and
+		// the segment after it, if the tick is within 10 ticks of the end of its segment, are decoded.
+		if decoded := decodedSegments(t, d); len(decoded) > 2 || (len(decoded) == 2 && (tick-1)%50 >= 10 && (tick-1)%50 < 40) {
+			t.Fatalf("segments %v are decoded after reading tick %d", decoded, tick)
+		}
+	}
+	if decoded := decodedSegments(t, d); len(decoded) != 1 || decoded[0] != 4 {
+		t.Fatalf("segments %v are decoded after reading the last tick, expected only the last segment", decoded)
+	}
+
+	// Ticks of evicted segments are decoded again, in any order.
+	for _, tick := range []uint32{1, 249, 120, 51, 3, 200, 100, 101} {
+		want, _ := testActions(tick)
+		got, err := d.Actions(tick)
+		if err != nil {
+			t.Fatalf("failed to read tick %d again: %v", tick, err)
+		}
+		if !bytes.Equal(encodeActions(got), encodeActions(want)) {
+			t.Fatalf("tick %d differs from the actions written after decoding it again", tick)
+		}
+		if decoded := decodedSegments(t, d); len(decoded) > 2 {
+			t.Fatalf("segments %v are decoded after reading tick %d", decoded, tick)
+		}
+	}
+
+	d.SetWindow(0, 0)
+	requireActions(t, d, testTicks, testActions)
+	if decoded := decodedSegments(t, d); len(decoded) != 5 {
+		t.Fatalf("segments %v are decoded with windowed decoding disabled, expected all 5", decoded)
+	}
+}
@@ -0,0 +1,293 @@
+package replay
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"math/rand/v2"
+	"sync"
+)
+
+// ErrMissingDictionary is returned when a replay compressed using a Dictionary is loaded without passing the
+// Dictionary to Data.SetDictionaries.
+var ErrMissingDictionary = errors.New("replay was compressed using a dictionary that was not provided")
+
+// Dictionary is a zstd dictionary that replays may be compressed with. A dictionary holds data that is
+// common to many replays, such as the encoding of the actions recorded at the start of a match, which
+// greatly improves the compression of short replays. Replays compressed using a Dictionary store its ID in
+// their metadata and can only be loaded by passing the same Dictionary to Data.SetDictionaries. Dictionaries
+// are created using DictionaryConfig.Train, and may be saved using Dictionary.Bytes and loaded again using
+// ParseDictionary.
+type Dictionary struct {
+	id  uint32
+	raw []byte
+
+	once   sync.Once
+	dec    *zstd.Decoder
+	decErr error
+}
+
+// ParseDictionary parses a Dictionary from the bytes returned by Dictionary.Bytes.
+func ParseDictionary(b []byte) (*Dictionary, error) {
+	d, err := zstd.InspectDictionary(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse dictionary: %w", err)
+	}
+	if d.ID() == 0 {
+		return nil, errors.New("dictionary has no ID")
+	}
+	return &Dictionary{id: d.ID(), raw: b}, nil
+}
+
+// ID returns the ID of the Dictionary, which is stored in the metadata of replays compressed using it.
+func (d *Dictionary) ID() uint32 {
+	return d.id
+}
+
+// Bytes returns the Dictionary encoded in the zstd dictionary format, which may be saved and parsed again
+// using ParseDictionary. The slice returned must not be modified.
+func (d *Dictionary) Bytes() []byte {
+	return d.raw
+}
+
+// decoder returns a zstd.Decoder that decompresses data compressed with or without the Dictionary. The
+// decoder is created the first time it is needed and shared by all replays using the Dictionary.
+func (d *Dictionary) decoder() (*zstd.Decoder, error) {
+	d.once.Do(func() {
+		d.dec, d.decErr = zstd.NewReader(nil, zstd.WithDecoderDicts(d.raw))
+	})
+	return d.dec, d.decErr
+}
+
+// findDictionary returns the decoder of the dictionary with the ID passed out of the dictionaries passed. The
+// default decoder is returned if the ID is 0.
+func findDictionary(id uint32, dicts []*Dictionary) (*zstd.Decoder, error) {
+	if id == 0 {
+		return segmentDecoder, nil
+	}
+	for _, d := range dicts {
+		if d.id == id {
+			return d.decoder()
+		}
+	}
+	return nil, fmt.Errorf("dictionary %d: %w", id, ErrMissingDictionary)
+}
+
+// newEncoder creates a zstd.Encoder compressing at the level passed, using the Dictionary passed if it is
+// not nil.
+func newEncoder(level zstd.EncoderLevel, dict *Dictionary) (*zstd.Encoder, error) {
+	opts := []zstd.EOption{zstd.WithEncoderLevel(level)}
+	if dict != nil {
+		opts = append(opts, zstd.WithEncoderDict(dict.raw))
+	}
+	return zstd.NewWriter(nil, opts...)
+}
+
+// dictionaryID returns the ID of the Dictionary passed, or 0 if it is nil.
+func dictionaryID(dict *Dictionary) uint32 {
+	if dict == nil {
+		return 0
+	}
+	return dict.id
+}
+
+const (
+	// defaultDictionarySize is the size of a Dictionary if DictionaryConfig.Size is not set, which is the
+	// default size of dictionaries trained by zstd itself.
+	defaultDictionarySize = 112640 // 110 KB
+	// dictionaryKmer is the length of the byte sequences counted when training a Dictionary.
+	dictionaryKmer = 8
+	// dictionarySegment is the length of the parts of the replays that the content of a Dictionary is made
+	// up of.
+	dictionarySegment = 256
+	// minDictionaryID and maxDictionaryID are the lowest and highest IDs of dictionaries that zstd does not
+	// reserve for its registrar, out of which the IDs of dictionaries trained without an ID are picked.
+	minDictionaryID = 1 << 15
+	maxDictionaryID = 1<<31 - 1
+	// dictionarySampleRatio is the maximum size of the replays used to train a Dictionary relative to its
+	// size. Replays beyond it are skipped evenly.
+	dictionarySampleRatio = 100
+)
+
+// DictionaryConfig holds the settings used to train a Dictionary using DictionaryConfig.Train.
+type DictionaryConfig struct {
+	// ID is the ID of the Dictionary trained, which is stored in the metadata of replays compressed using it.
+	// Every Dictionary in use should have a different ID. If 0, a random ID is picked out of the range 32768
+	// through 2^31-1, as zstd reserves the IDs below and above it for a future registrar of dictionaries.
+	ID uint32
+	// Size is the maximum size of the Dictionary trained in bytes. Larger dictionaries compress better, but
+	// must be kept in memory by every Recorder and Data using them. If 0, dictionaries of 110 KB are trained.
+	Size int
+}
+
+// Train trains a Dictionary from the replays passed, which should be similar to the replays that will be
+// compressed using it, such as earlier recordings of the same game mode. The Dictionary holds the parts of
+// the segments of the replays that are found in most replays. At least a few dozen replays should be passed
+// for the Dictionary to be effective.
+func (conf DictionaryConfig) Train(replays ...*Data) (*Dictionary, error) {
+	if conf.ID == 0 {
+		conf.ID = minDictionaryID + rand.Uint32N(maxDictionaryID-minDictionaryID+1)
+	}
+	if conf.Size <= 0 {
+		conf.Size = defaultDictionarySize
+	}
+	var samples [][]byte
+	for _, d := range replays {
+		s, err := d.dictionarySamples()
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, s...)
+	}
+	samples = limitSamples(samples, conf.Size*dictionarySampleRatio)
+	content := dictionaryContent(samples, conf.Size)
+	if len(content) < dictionarySegment {
+		return nil, errors.New("replays do not hold enough common data to train a dictionary")
+	}
+	raw, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       conf.ID,
+		Contents: samples,
+		History:  content,
+		Offsets:  [3]int{1, 4, 8},
+		Level:    zstd.SpeedBestCompression,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to build dictionary: %w", err)
+	}
+	return ParseDictionary(raw)
+}
+
+// dictionarySamples returns the decompressed segments and tracks of the replay, which are used as samples to
+// train a Dictionary.
+func (d *Data) dictionarySamples() ([][]byte, error) {
+	d.mu.Lock()
+	segments := d.segments
+	d.mu.Unlock()
+
+	samples := make([][]byte, 0, len(segments))
+	for _, seg := range segments {
+		entries := []indexEntry{seg.indexEntry}
+		if seg.tracks != nil {
+			entries = append(entries, *seg.tracks)
+		}
+		for _, e := range entries {
+			decompressed, err := d.decompressRecord(e)
+			if err != nil {
+				return nil, err
+			}
+			samples = append(samples, decompressed)
+		}
+	}
+	return samples, nil
+}
+
+// limitSamples drops samples evenly until the samples left hold at most size bytes together.
+func limitSamples(samples [][]byte, size int) [][]byte {
+	total := 0
+	for _, s := range samples {
+		total += len(s)
+	}
+	if total <= size {
+		return samples
+	}
+	kept := samples[:0]
+	budget := 0
+	for _, s := range samples {
+		// Every sample adds the share of it that fits in the size, and samples are kept whenever the shares
+		// add up to their length.
+		budget += int(int64(len(s)) * int64(size) / int64(total))
+		if budget >= len(s) {
+			budget -= len(s)
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// dictionaryContent selects the content of a Dictionary of the size passed out of the samples passed. The
+// samples are split into overlapping parts, which are picked greedily by the amount of samples that the
+// sequences of bytes in them are found in, not counting sequences that are already in the content picked. The
+// parts picked first are placed at the end of the content, where zstd finds them most cheaply.
+func dictionaryContent(samples [][]byte, size int) []byte {
+	// occurrences holds the amount of samples that every sequence of dictionaryKmer bytes is found in.
+	occurrences := make(map[uint64]int)
+	for _, s := range samples {
+		seen := make(map[uint64]struct{})
+		for i := 0; i+dictionaryKmer <= len(s); i++ {
+			k := binary.LittleEndian.Uint64(s[i:])
+			if _, ok := seen[k]; !ok {
+				seen[k] = struct{}{}
+				occurrences[k]++
+			}
+		}
+	}
+	score := func(part []byte) int {
+		total := 0
+		for i := 0; i+dictionaryKmer <= len(part); i++ {
+			// Sequences only found in a single sample do not help compressing other replays.
+			if n := occurrences[binary.LittleEndian.Uint64(part[i:])]; n > 1 {
+				total += n
+			}
+		}
+		return total
+	}
+
+	parts := &dictionaryParts{}
+	for _, s := range samples {
+		for start := 0; start+dictionarySegment <= len(s); start += dictionarySegment / 2 {
+			part := s[start : start+dictionarySegment]
+			if sc := score(part); sc > 0 {
+				parts.items = append(parts.items, dictionaryPart{b: part, score: sc})
+			}
+		}
+	}
+	heap.Init(parts)
+
+	var picked [][]byte
+	length := 0
+	for parts.Len() > 0 && length+dictionarySegment <= size {
+		top := heap.Pop(parts).(dictionaryPart)
+		// The score of a part only ever decreases as other parts are picked, so the part is picked if its
+		// current score is still the highest.
+		if top.score = score(top.b); top.score == 0 {
+			continue
+		}
+		if parts.Len() > 0 && top.score < parts.items[0].score {
+			heap.Push(parts, top)
+			continue
+		}
+		picked = append(picked, top.b)
+		length += len(top.b)
+		for i := 0; i+dictionaryKmer <= len(top.b); i++ {
+			delete(occurrences, binary.LittleEndian.Uint64(top.b[i:]))
+		}
+	}
+	content := make([]byte, 0, length)
+	for i := len(picked) - 1; i >= 0; i-- {
+		content = append(content, picked[i]...)
+	}
+	return content
+}
+
+// dictionaryPart is a part of a sample that may be picked as content of a Dictionary.
+type dictionaryPart struct {
+	b     []byte
+	score int
+}
+
+// dictionaryParts is a max-heap of dictionaryParts ordered by their score.
+type dictionaryParts struct {
+	items []dictionaryPart
+}
+
+func (p *dictionaryParts) Len() int           { return len(p.items) }
+func (p *dictionaryParts) Less(i, j int) bool { return p.items[i].score > p.items[j].score }
+func (p *dictionaryParts) Swap(i, j int)      { p.items[i], p.items[j] = p.items[j], p.items[i] }
+func (p *dictionaryParts) Push(x any)         { p.items = append(p.items, x.(dictionaryPart)) }
+func (p *dictionaryParts) Pop() any {
+	last := p.items[len(p.items)-1]
+	p.items = p.items[:len(p.items)-1]
+	return last
+}
@@ -0,0 +1,215 @@
+package replay
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A replay may be encrypted by passing a KeyProvider to RecorderConfig.Keys. Encrypted replays hold an
+// encryption record directly after the first metadata record, holding the ID of the key used. The payloads
+// of all segment, palette, skin and snapshot records are then encrypted using AES-GCM, while metadata records
+// and the index remain readable, so that replays may still be listed using ReadMetadata without the key. The
+// metadata records of encrypted replays only hold the ID, start time and end time of the replay. Every one
+// of them is followed by an encrypted private metadata record holding the complete metadata, including the
+// players and tags, which Data reads once the replay is decrypted.
+
+// encryptionAESGCM is the algorithm of replays encrypted using AES-GCM. It is the only algorithm supported.
+const encryptionAESGCM uint8 = 1
+
+// ErrEncrypted is returned when an encrypted replay is opened without a KeyProvider.
+var ErrEncrypted = errors.New("replay is encrypted")
+
+// KeyProvider provides the keys used to encrypt and decrypt replays. Keys must be 16, 24 or 32 bytes long to
+// select AES-128, AES-192 or AES-256.
+type KeyProvider interface {
+	// EncryptionKey returns the key used to encrypt new replays, along with its ID. The ID is stored in the
+	// replay without encryption, so that the key may be looked up again when the replay is decrypted.
+	EncryptionKey() (id string, key []byte, err error)
+	// Key returns the key with the ID passed, as previously returned by EncryptionKey.
+	Key(id string) ([]byte, error)
+}
+
+// StaticKey is a KeyProvider that always provides the same key.
+type StaticKey struct {
+	// ID is the ID stored in replays encrypted using the key.
+	ID string
+	// Secret is the key itself.
+	Secret []byte
+}
+
+// EncryptionKey ...
+func (k StaticKey) EncryptionKey() (string, []byte, error) {
+	return k.ID, k.Secret, nil
+}
+
+// Key ...
+func (k StaticKey) Key(id string) ([]byte, error) {
+	if id != k.ID {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+	return k.Secret, nil
+}
+
+// recordCipher encrypts and decrypts the payloads of records.
+type recordCipher struct {
+	keyID string
+	aead  cipher.AEAD
+}
+
+// newRecordCipher creates a recordCipher using the key passed.
+func newRecordCipher(keyID string, key []byte) (*recordCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return &recordCipher{keyID: keyID, aead: aead}, nil
+}
+
+// encryptingCipher creates a recordCipher using the encryption key of the KeyProvider passed.
+func encryptingCipher(keys KeyProvider) (*recordCipher, error) {
+	id, key, err := keys.EncryptionKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	return newRecordCipher(id, key)
+}
+
+// encryptedRecord checks if records of the kind passed are encrypted in encrypted replays.
+func encryptedRecord(kind uint8) bool {
+	return kind == recordSegment || kind == recordTracks || kind == recordPalette || kind == recordSkin || kind == recordSnapshot || kind == recordTickedSnapshot || kind == recordPrivateMetadata
+}
+
+// publicMetadata returns the part of the metadata passed that is written to the metadata records of
+// encrypted replays, which are not encrypted.
+func publicMetadata(meta Metadata) Metadata {
+	return Metadata{ID: meta.ID, StartTime: meta.StartTime, EndTime: meta.EndTime}
+}
+
+// additionalData returns the additional data authenticated with the payload of a record, which binds the
+// payload to the kind and offset of the record so that records cannot be swapped.
+func additionalData(kind uint8, offset int64) []byte {
+	return binary.LittleEndian.AppendUint64([]byte{kind}, uint64(offset))
+}
+
+// seal encrypts the payload of a record of the kind passed at the offset passed. The nonce used is
+// prepended to the encrypted payload.
+func (c *recordCipher) seal(kind uint8, offset int64, payload []byte) ([]byte, error) {
+	sealed := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(payload)+c.aead.Overhead())
+	if _, err := rand.Read(sealed); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return c.aead.Seal(sealed, sealed, payload, additionalData(kind, offset)), nil
+}
+
+// open decrypts the payload of a record encrypted using seal.
+func (c *recordCipher) open(kind uint8, offset int64, sealed []byte) ([]byte, error) {
+	if len(sealed) < c.aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted record at offset %d is too short", offset)
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	payload, err := c.aead.Open(nil, nonce, ciphertext, additionalData(kind, offset))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt record at offset %d: %w", offset, err)
+	}
+	return payload, nil
+}
+
+// writeEncryption writes an encryption record holding the ID of the key used to encrypt the replay.
+func (c *containerWriter) writeEncryption() error {
+	payload := make([]byte, 0, 3+len(c.cipher.keyID))
+	payload = append(payload, encryptionAESGCM)
+	payload = binary.LittleEndian.AppendUint16(payload, uint16(len(c.cipher.keyID)))
+	payload = append(payload, c.cipher.keyID...)
+	return c.writeRecord(recordEncryption, payload, 0, 0)
+}
+
+// decodeEncryption decodes the payload of an encryption record and creates a recordCipher using the key it
+// refers to.
+func decodeEncryption(payload []byte, keys KeyProvider) (*recordCipher, error) {
+	if keys == nil {
+		return nil, ErrEncrypted
+	}
+	if len(payload) < 3 {
+		return nil, errors.New("encryption record is too short")
+	}
+	if payload[0] != encryptionAESGCM {
+		return nil, fmt.Errorf("unsupported encryption algorithm %d", payload[0])
+	}
+	idLen := int(binary.LittleEndian.Uint16(payload[1:]))
+	if len(payload) != 3+idLen {
+		return nil, errors.New("encryption record has an invalid key ID length")
+	}
+	id := string(payload[3:])
+	key, err := keys.Key(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get key %q: %w", id, err)
+	}
+	return newRecordCipher(id, key)
+}
+
+// readEncryptionAt reads the encryption record pointed to by the index entries passed, creating a
+// recordCipher using the key it refers to. A nil recordCipher is returned if the replay is not encrypted.
+func readEncryptionAt(r io.ReaderAt, entries []indexEntry, keys KeyProvider) (*recordCipher, error) {
+	for _, e := range entries {
+		if e.kind != recordEncryption {
+			continue
+		}
+		payload, err := readRecordAt(r, e)
+		if err != nil {
+			return nil, err
+		}
+		return decodeEncryption(payload, keys)
+	}
+	return nil, nil
+}
+
+// readPrivateMetadataAt reads the last private metadata record pointed to by the index entries passed using
+// the recordCipher passed. False is returned if the replay holds no private metadata record, which is the
+// case for replays that are not encrypted.
+func readPrivateMetadataAt(r io.ReaderAt, entries []indexEntry, c *recordCipher) (Metadata, bool, error) {
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].kind != recordPrivateMetadata {
+			continue
+		}
+		payload, err := readPayloadAt(r, entries[i], c)
+		if err != nil {
+			return Metadata{}, false, err
+		}
+		meta, err := decodeMetadata(payload)
+		return meta, true, err
+	}
+	return Metadata{}, false, nil
+}
+
+// readPayloadAt reads the payload of the record pointed to by e, decrypting it using the recordCipher passed
+// if the record is encrypted. c may be nil if the replay is not encrypted.
+func readPayloadAt(r io.ReaderAt, e indexEntry, c *recordCipher) ([]byte, error) {
+	payload, err := readRecordAt(r, e)
+	if err != nil || c == nil || !encryptedRecord(e.kind) {
+		return payload, err
+	}
+	return c.open(e.kind, e.offset, payload)
+}
+
+// countingReader counts the bytes read from an io.Reader, so that the offsets of records read sequentially
+// are known.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+// Read ...
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
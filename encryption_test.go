@@ -0,0 +1,128 @@
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"github.com/google/uuid"
+	"slices"
+	"testing"
+)
+
+// testKey is the key used to encrypt replays in tests.
+var testKey = StaticKey{ID: "test", Secret: bytes.Repeat([]byte{7}, 32)}
+
+// TestEncryptionRoundTrip tests that encrypted replays, both written and recorded, are read back as written
+// using the right key, and that their players, tags and palette are not stored in plain text.
+func TestEncryptionRoundTrip(t *testing.T) {
+	c, err := encryptingCipher(testKey)
+	if err != nil {
+		t.Fatalf("failed to create cipher: %v", err)
+	}
+	sink := bytes.NewBuffer(nil)
+	r := RecorderConfig{SegmentTicks: 50, Keys: testKey, Sink: sink}.New(testMetadata().ID)
+	r.SetTag("map", "lobby")
+	recordTestActions(r, testTicks)
+	if err := r.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	for name, b := range map[string][]byte{"written": writeTestReplay(t, writeOptions{cipher: c}), "recorded": sink.Bytes()} {
+		for _, plain := range []string{"lobby", "alice", "minecraft:stone"} {
+			if bytes.Contains(b, []byte(plain)) {
+				t.Fatalf("%s: encrypted replay holds %q in plain text", name, plain)
+			}
+		}
+		d := openTestReplay(t, b, testKey)
+		requireActions(t, d, testTicks, testActions)
+		if d.Metadata().Tags["map"] != "lobby" {
+			t.Fatalf("%s: decrypted metadata has tags %v", name, d.Metadata().Tags)
+		}
+
+		meta, err := ReadMetadata(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%s: failed to read metadata: %v", name, err)
+		}
+		if meta.ID != testMetadata().ID || len(meta.Players) != 0 || len(meta.Tags) != 0 {
+			t.Fatalf("%s: metadata read without the key is %+v, expected only its ID and times", name, meta)
+		}
+	}
+	d := openTestReplay(t, writeTestReplay(t, writeOptions{cipher: c}), testKey)
+	if !slices.Equal(d.Metadata().Players, testMetadata().Players) {
+		t.Fatalf("decrypted metadata has players %v, expected %v", d.Metadata().Players, testMetadata().Players)
+	}
+}
+
+// TestEncryptionWrongKey tests that encrypted replays cannot be opened without the right key, and that
+// modified ciphertext is refused.
+func TestEncryptionWrongKey(t *testing.T) {
+	c, err := encryptingCipher(testKey)
+	if err != nil {
+		t.Fatalf("failed to create cipher: %v", err)
+	}
+	b := writeTestReplay(t, writeOptions{cipher: c})
+	if err := NewData(uuid.Nil).Open(bytes.NewReader(b), int64(len(b))); !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("opening without a key returned %v, expected ErrEncrypted", err)
+	}
+	d := NewData(uuid.Nil)
+	d.SetKeyProvider(StaticKey{ID: testKey.ID, Secret: bytes.Repeat([]byte{8}, 32)})
+	if err := d.Open(bytes.NewReader(b), int64(len(b))); err == nil {
+		t.Fatal("replay was opened using the wrong secret")
+	}
+	d = NewData(uuid.Nil)
+	d.SetKeyProvider(StaticKey{ID: "other", Secret: testKey.Secret})
+	if err := d.Open(bytes.NewReader(b), int64(len(b))); err == nil {
+		t.Fatal("replay was opened using a key with another ID")
+	}
+
+	var seg indexEntry
+	for _, e := range openTestReplay(t, b, testKey).entries {
+		if e.kind == recordSegment && e.firstTick > 1 {
+			seg = e
+			break
+		}
+	}
+	tampered := bytes.Clone(b)
+	tampered[seg.offset+recordHeaderSize+int64(seg.length)/2] ^= 0xff
+	d = openTestReplay(t, tampered, testKey)
+	if _, err := d.Actions(seg.firstTick); err == nil {
+		t.Fatal("actions of a modified encrypted segment were decoded")
+	}
+	if _, err := d.Actions(1); err != nil {
+		t.Fatalf("failed to read unmodified segment: %v", err)
+	}
+}
+
+// TestRecordCipher tests that sealed payloads are only opened with the kind and offset they were sealed
+// with, and only if they were not modified.
+func TestRecordCipher(t *testing.T) {
+	c, err := newRecordCipher(testKey.ID, testKey.Secret)
+	if err != nil {
+		t.Fatalf("failed to create cipher: %v", err)
+	}
+	payload := []byte("segment payload")
+	sealed, err := c.seal(recordSegment, 1024, payload)
+	if err != nil {
+		t.Fatalf("failed to seal payload: %v", err)
+	}
+	if bytes.Contains(sealed, payload) {
+		t.Fatal("sealed payload holds the payload in plain text")
+	}
+	opened, err := c.open(recordSegment, 1024, sealed)
+	if err != nil || !bytes.Equal(opened, payload) {
+		t.Fatalf("opened payload %q, %v, expected %q", opened, err, payload)
+	}
+	if _, err := c.open(recordPalette, 1024, sealed); err == nil {
+		t.Fatal("payload was opened as another kind of record")
+	}
+	if _, err := c.open(recordSegment, 1025, sealed); err == nil {
+		t.Fatal("payload was opened at another offset")
+	}
+	modified := bytes.Clone(sealed)
+	modified[len(modified)-1] ^= 0xff
+	if _, err := c.open(recordSegment, 1024, modified); err == nil {
+		t.Fatal("modified payload was opened")
+	}
+	if _, err := c.open(recordSegment, 1024, sealed[:4]); err == nil {
+		t.Fatal("truncated payload was opened")
+	}
+}
@@ -0,0 +1,173 @@
+package replay
+
+import (
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/df-mc/dragonfly/server/world"
+	"time"
+)
+
+const (
+	// maxTimeDrift is the amount of ticks that the time of the world may differ from the time expected from
+	// the last action.SetTime recorded before it is recorded again. The world and the Recorder count their
+	// ticks separately, so the time of the world drifts slightly from the ticks recorded.
+	maxTimeDrift = tickRate
+	// weatherDuration is the duration that rain and thunder set by a Playback last for if the weather cycle of
+	// the world is enabled.
+	weatherDuration = time.Hour * 24
+)
+
+// recordEnvironment records the time and weather of the world passed. It is called by the tick counter of the
+// Recorder every tick. The weather can only be read within a transaction, so it is recorded by a transaction
+// that the tick counter does not wait for.
+func (r *Recorder) recordEnvironment(w *world.World) {
+	r.recordTime(int64(w.Time()), w.TimeCycle())
+	w.Exec(r.recordWeather)
+}
+
+// recordTime records an action.SetTime if the time passed differs from the time expected from the last
+// action.SetTime recorded by more than maxTimeDrift, or if the time cycle was started or stopped.
+func (r *Recorder) recordTime(time int64, cycle bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if prev := r.time; prev == nil || prev.Cycle != cycle || abs(time-r.expectedTime()) > maxTimeDrift {
+		r.time, r.timeTick = &action.SetTime{Time: time, Cycle: cycle}, r.tick
+		r.pushActionNoMutex(r.time)
+	}
+}
+
+// recordWeather records an action.SetWeather if the weather of the world changed. recordWeather must be
+// called within a transaction of the world recorded.
+func (r *Recorder) recordWeather(tx *world.Tx) {
+	raining := tx.Raining()
+	weather := &action.SetWeather{Raining: raining, Thundering: raining && tx.Thundering()}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case <-r.closing:
+		// The transaction may run after the Recorder was closed.
+		return
+	default:
+	}
+	if r.weather == nil || *r.weather != *weather {
+		r.weather = weather
+		r.pushActionNoMutex(weather)
+	}
+}
+
+// expectedTime returns the time that the world is expected to have during the current tick, following from
+// the last action.SetTime recorded. expectedTime must be called while holding mu.
+func (r *Recorder) expectedTime() int64 {
+	if !r.time.Cycle {
+		return r.time.Time
+	}
+	return r.time.Time + int64(r.tick) - int64(r.timeTick)
+}
+
+// Time returns the time of the world and whether it advances every tick, as set by the replay during the tick
+// of which the actions are currently played. The time of the world itself is returned if the replay did not
+// set the time yet.
+func (w *Playback) Time(tx *world.Tx) (int64, bool) {
+	if w.originalTime == nil {
+		return int64(tx.World().Time()), tx.World().TimeCycle()
+	}
+	return w.timeAt(w.playingTick), w.timeCycle
+}
+
+// SetTime sets the time of the world during the tick of which the actions are currently played. If cycle is
+// true, the time advances every tick played after it. The time cycle of the world itself is stopped, so that
+// the time follows the ticks played, also while paused, rewinding or seeking.
+func (w *Playback) SetTime(tx *world.Tx, time int64, cycle bool) {
+	w.setTime(tx, time, cycle, w.playingTick)
+}
+
+// setTime sets the time of the world during the tick passed.
+func (w *Playback) setTime(tx *world.Tx, time int64, cycle bool, tick uint) {
+	if w.originalTime == nil {
+		w.originalTime = &action.SetTime{Time: int64(tx.World().Time()), Cycle: tx.World().TimeCycle()}
+		tx.World().StopTime()
+	}
+	w.time, w.timeTick, w.timeCycle = time, tick, cycle
+	w.shownTime = time
+	tx.World().SetTime(int(time))
+}
+
+// timeAt returns the time set by the replay during the tick passed.
+func (w *Playback) timeAt(tick uint) int64 {
+	if !w.timeCycle {
+		return w.time
+	}
+	return w.time + int64(tick) - int64(w.timeTick)
+}
+
+// showTime sets the time of the world to the time of the replay after the current tick. The time cycle of
+// the world is stopped while the replay sets the time, so viewers do not advance the time by themselves and
+// the time is updated every tick while it cycles.
+func (w *Playback) showTime(tx *world.Tx) {
+	if w.originalTime == nil {
+		return
+	}
+	if t := w.timeAt(w.playbackTick); t != w.shownTime {
+		w.shownTime = t
+		tx.World().SetTime(int(t))
+	}
+}
+
+// Weather returns the weather of the world.
+func (w *Playback) Weather(tx *world.Tx) (raining, thundering bool) {
+	raining = tx.Raining()
+	return raining, raining && tx.Thundering()
+}
+
+// SetWeather sets the weather of the world. The weather cycle of the world is not changed, so it should be
+// disabled in the world that the replay is played in, as is done for worlds created using
+// PlaybackWorldConfig.New.
+func (w *Playback) SetWeather(tx *world.Tx, raining, thundering bool) {
+	if w.originalWeather == nil {
+		prevRaining, prevThundering := w.Weather(tx)
+		w.originalWeather = &action.SetWeather{Raining: prevRaining, Thundering: prevThundering}
+	}
+	setWeather(tx.World(), raining, thundering)
+}
+
+// restoreEnvironment sets the time and weather of the world to those held by a keyframe recorded at the tick
+// passed. If the keyframe does not hold them, the time and weather of the world before the playback first
+// set them are restored.
+func (w *Playback) restoreEnvironment(tx *world.Tx, k *action.Keyframe, tick uint) {
+	if k.Time != nil {
+		w.setTime(tx, k.Time.Time, k.Time.Cycle, tick)
+	} else if orig := w.originalTime; orig != nil {
+		w.originalTime = nil
+		tx.World().SetTime(int(orig.Time))
+		if orig.Cycle {
+			tx.World().StartTime()
+		}
+	}
+	if k.Weather != nil {
+		w.SetWeather(tx, k.Weather.Raining, k.Weather.Thundering)
+	} else if orig := w.originalWeather; orig != nil {
+		w.originalWeather = nil
+		setWeather(tx.World(), orig.Raining, orig.Thundering)
+	}
+}
+
+// setWeather starts or stops rain and thunder in the world passed.
+func setWeather(w *world.World, raining, thundering bool) {
+	switch {
+	case thundering:
+		w.StartThundering(weatherDuration)
+	case raining:
+		w.StopThundering()
+		w.StartRaining(weatherDuration)
+	default:
+		w.StopRaining()
+	}
+}
+
+// abs returns the absolute value of x.
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
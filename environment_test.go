@@ -0,0 +1,162 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/df-mc/dragonfly/server/entity"
+	"github.com/df-mc/dragonfly/server/world"
+	"github.com/google/uuid"
+	"testing"
+)
+
+// TestRecordEnvironment tests that the time is only recorded if the time cycle is started or stopped or if
+// the time drifts more than maxTimeDrift ticks from the time expected from the last action.SetTime, and
+// that the weather is only recorded if it changes.
+func TestRecordEnvironment(t *testing.T) {
+	rec := RecorderConfig{}.New(uuid.New())
+	w := world.Config{Provider: world.NopProvider{}, Entities: entity.DefaultRegistry, SaveInterval: -1, RandomTickSpeed: -1}.New()
+	defer w.Close()
+	w.StopWeatherCycle()
+
+	steps := []struct {
+		time           int64
+		cycle          bool
+		weather        func()
+		recordsTime    bool
+		recordsWeather bool
+	}{
+		{time: 1000, cycle: true, weather: func() { w.StopRaining() }, recordsTime: true, recordsWeather: true},
+		// The time cycle of the world may run slightly ahead of or behind the ticks recorded.
+		{time: 1010 + maxTimeDrift, cycle: true},
+		{time: 1020 - maxTimeDrift, cycle: true},
+		{time: 1030 + maxTimeDrift + 1, cycle: true, recordsTime: true},
+		{time: 1040 + maxTimeDrift + 1, cycle: false, recordsTime: true},
+		{time: 1040 + maxTimeDrift + 1, cycle: false, weather: func() { w.StartRaining(weatherDuration) }, recordsWeather: true},
+		{time: 500, cycle: false, weather: func() { w.StartThundering(weatherDuration) }, recordsTime: true, recordsWeather: true},
+		{time: 500, cycle: false, weather: func() { w.StartThundering(weatherDuration) }},
+		{time: 500, cycle: true, weather: func() { w.StopRaining() }, recordsTime: true, recordsWeather: true},
+	}
+	want := make(map[uint32][]action.Action)
+	for i, step := range steps {
+		tick := uint32(i*10 + 1)
+		rec.mu.Lock()
+		rec.tick = tick
+		rec.mu.Unlock()
+
+		if step.weather != nil {
+			step.weather()
+		}
+		rec.recordTime(step.time, step.cycle)
+		<-w.Exec(rec.recordWeather)
+
+		if step.recordsTime {
+			want[tick] = append(want[tick], &action.SetTime{Time: step.time, Cycle: step.cycle})
+		}
+		if step.recordsWeather {
+			var raining, thundering bool
+			<-w.Exec(func(tx *world.Tx) {
+				raining, thundering = tx.Raining(), tx.Thundering()
+			})
+			want[tick] = append(want[tick], &action.SetWeather{Raining: raining, Thundering: thundering})
+		}
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := rec.CloseAndSaveActions(buf); err != nil {
+		t.Fatalf("failed to save replay: %v", err)
+	}
+	requireActions(t, openTestReplay(t, buf.Bytes(), nil), uint32(len(steps)-1)*10+1, func(tick uint32) ([]action.Action, error) {
+		return want[tick], nil
+	})
+}
+
+// testEnvironmentTicks is the amount of ticks in the replay returned by testEnvironmentActions.
+const testEnvironmentTicks = 150
+
+// testEnvironmentActions returns the actions of a tick of a replay that sets the time and weather. The time
+// cycles from tick 1, stops at tick 60 and cycles again from tick 120, and it rains and thunders from tick
+// 80 until tick 120.
+func testEnvironmentActions(tick uint32) ([]action.Action, error) {
+	switch tick {
+	case 1:
+		return []action.Action{&action.SetTime{Time: 1000, Cycle: true}}, nil
+	case 60:
+		return []action.Action{&action.SetTime{Time: 5000}}, nil
+	case 80:
+		return []action.Action{&action.SetWeather{Raining: true, Thundering: true}}, nil
+	case 120:
+		return []action.Action{&action.SetTime{Time: 200, Cycle: true}, &action.SetWeather{}}, nil
+	}
+	return nil, nil
+}
+
+// testEnvironment returns the time and weather that a playback of the replay returned by
+// testEnvironmentActions has after playing the tick passed.
+func testEnvironment(tick uint) (time int64, cycle, raining, thundering bool) {
+	switch {
+	case tick < 60:
+		return 1000 + int64(tick) - 1, true, false, false
+	case tick < 80:
+		return 5000, false, false, false
+	case tick < 120:
+		return 5000, false, true, true
+	}
+	return 200 + int64(tick) - 120, true, false, false
+}
+
+// TestPlaybackEnvironment tests that the time and weather set by a replay are played tick by tick, and that
+// seeking forwards and backwards, and back to the start of the replay, leaves the playback with the time and
+// weather of the tick sought to.
+func TestPlaybackEnvironment(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	if err := writeReplayWith(buf, writeOptions{version: FormatVersion, segmentTicks: 50}, testMetadata(), testEnvironmentTicks, testEnvironmentActions); err != nil {
+		t.Fatalf("failed to write replay: %v", err)
+	}
+	p := newTestPlayback(t, openTestReplay(t, buf.Bytes(), nil))
+
+	// The test may not fail inside the transaction, as that would stop the goroutine of the world.
+	var failures []string
+	check := func(tx *world.Tx, tick uint) {
+		wantTime, wantCycle, wantRaining, wantThundering := testEnvironment(tick)
+		if time, cycle := p.Time(tx); time != wantTime || cycle != wantCycle {
+			failures = append(failures, fmt.Sprintf("playback time at tick %d is %d (cycle=%v), expected %d (cycle=%v)", tick, time, cycle, wantTime, wantCycle))
+		}
+		if time := tx.World().Time(); int64(time) != wantTime {
+			failures = append(failures, fmt.Sprintf("world time at tick %d is %d, expected %d", tick, time, wantTime))
+		}
+		if raining, thundering := p.Weather(tx); raining != wantRaining || thundering != wantThundering {
+			failures = append(failures, fmt.Sprintf("weather at tick %d is raining=%v thundering=%v, expected raining=%v thundering=%v", tick, raining, thundering, wantRaining, wantThundering))
+		}
+	}
+	<-p.World().Exec(func(tx *world.Tx) {
+		origTime, origCycle := p.Time(tx)
+		origRaining, origThundering := p.Weather(tx)
+
+		for tick := uint(1); tick <= testEnvironmentTicks; tick++ {
+			p.Seek(tx, int(tick))
+			check(tx, tick)
+		}
+		if tx.World().TimeCycle() {
+			failures = append(failures, "time cycle of the world runs while the replay sets the time")
+		}
+		for _, tick := range []int{100, 70, 55, 1, 130, 119, 120, 59, 60, 81, 79, 150} {
+			p.Seek(tx, tick)
+			check(tx, uint(tick))
+		}
+
+		p.Seek(tx, 0)
+		if time, cycle := p.Time(tx); int64(time) != int64(origTime) || cycle != origCycle {
+			failures = append(failures, fmt.Sprintf("time after seeking to the start is %d (cycle=%v), expected the original %d (cycle=%v)", time, cycle, origTime, origCycle))
+		}
+		if raining, thundering := p.Weather(tx); raining != origRaining || thundering != origThundering {
+			failures = append(failures, fmt.Sprintf("weather after seeking to the start is raining=%v thundering=%v, expected the original raining=%v thundering=%v", raining, thundering, origRaining, origThundering))
+		}
+	})
+	if err := p.Err(); err != nil {
+		t.Fatalf("failed to play replay: %v", err)
+	}
+	for _, f := range failures {
+		t.Error(f)
+	}
+}
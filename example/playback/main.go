@@ -1,33 +1,40 @@
 package main
 
 import (
-	"bytes"
 	replay "github.com/akmalfairuz/df-replay"
 	"github.com/bedrock-gophers/intercept/intercept"
 	"github.com/df-mc/dragonfly/server"
 	"github.com/df-mc/dragonfly/server/item"
 	"github.com/df-mc/dragonfly/server/player"
 	"github.com/df-mc/dragonfly/server/world"
-	"github.com/google/uuid"
 	"log/slog"
-	"os"
 )
 
 func main() {
-	buf, err := os.ReadFile("replay_buffer.bin")
+	store, err := replay.DirStoreConfig{Dir: "replays"}.New()
 	if err != nil {
 		panic(err)
 	}
+	replays, err := store.List()
+	if err != nil {
+		panic(err)
+	}
+	if len(replays) == 0 {
+		panic("no replays recorded")
+	}
 
 	conf, _ := server.DefaultConfig().Config(slog.Default())
 	conf.ReadOnlyWorld = true
 	conf.PlayerProvider = nil
 	srv := conf.New()
 	replay.Init()
-	data := replay.NewData(uuid.New())
-	if err := data.LoadActions(bytes.NewReader(buf)); err != nil {
+	// Play the replay that was saved last.
+	data, err := replay.Load(store, replays[len(replays)-1].ID, nil)
+	if err != nil {
 		panic(err)
 	}
+	// The time and weather are set by the replay, so the weather must not change by itself.
+	srv.World().StopWeatherCycle()
 	playback := replay.NewPlayback(srv.World(), data)
 
 	srv.Listen()
@@ -42,7 +49,6 @@ func main() {
 		_ = p.Inventory().SetItem(4, item.NewStack(item.GoldIngot{}, 1).WithValue("replay_item", "speed_down").WithCustomName("Speed Down"))
 		playback.Play()
 		p.SetGameMode(world.GameModeSpectator)
-		srv.World().SetTime(3000)
 	}
 }
 
@@ -8,7 +8,6 @@ import (
 	"github.com/df-mc/dragonfly/server"
 	"github.com/google/uuid"
 	"log/slog"
-	"os"
 	"time"
 )
 
@@ -20,7 +19,10 @@ func main() {
 	srv := conf.New()
 
 	replay.Init()
-	_ = os.Remove("replay_buffer.bin")
+	store, err := replay.DirStoreConfig{Dir: "replays", MaxAge: time.Hour * 24 * 7}.New()
+	if err != nil {
+		panic(err)
+	}
 
 	replayID := uuid.New()
 	recorder := replay.NewRecorder(replayID)
@@ -34,10 +36,10 @@ func main() {
 			if err := recorder.CloseAndSaveActions(buf); err != nil {
 				panic(err)
 			}
-			if err := os.WriteFile("replay_buffer.bin", buf.Bytes(), 0644); err != nil {
+			if err := store.Save(replayID, buf); err != nil {
 				panic(err)
 			}
-			fmt.Println("Replay saved to buffer")
+			fmt.Println("Replay saved to store")
 			_ = srv.Close()
 		})
 	}()
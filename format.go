@@ -0,0 +1,132 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// FormatVersion is the version of the replay file format written by this library. It must be bumped
+	// whenever the way segments are decoded changes, including the addition of record kinds that segments
+	// depend on, so that older readers refuse replays they would otherwise play incorrectly.
+	FormatVersion uint16 = 4
+	// prefixedActionsVersion is the first format version in which actions are prefixed with their version
+	// and length.
+	prefixedActionsVersion uint16 = 3
+
+	// formatMagic is the magic number that every replay file starts with. Files written before the
+	// introduction of the header do not start with it and are treated as format version 0.
+	formatMagic = "DFRP"
+	// tickRate is the amount of ticks recorded per second.
+	tickRate = 20
+)
+
+// ErrNoHeader is returned by ReadMetadata when a replay was written in the legacy format that does not
+// have a header.
+var ErrNoHeader = errors.New("replay has no header")
+
+// readHeader reads the header of a replay from r. If the replay does not start with the magic number,
+// version 0 is returned and the returned reader yields the complete input. Otherwise, the returned reader
+// is positioned directly after the header. For format version 2 and up, the header includes the first
+// metadata record.
+func readHeader(r io.Reader) (version uint16, meta Metadata, rest io.Reader, err error) {
+	magic := make([]byte, len(formatMagic))
+	n, err := io.ReadFull(r, magic)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, meta, nil, fmt.Errorf("failed to read magic: %w", err)
+	}
+	if string(magic[:n]) != formatMagic {
+		return 0, meta, io.MultiReader(bytes.NewReader(magic[:n]), r), nil
+	}
+	var v [2]byte
+	if _, err := io.ReadFull(r, v[:]); err != nil {
+		return 0, meta, nil, fmt.Errorf("failed to read format version: %w", err)
+	}
+	version = binary.LittleEndian.Uint16(v[:])
+	if version > FormatVersion {
+		return version, meta, nil, fmt.Errorf("unsupported format version %d (latest supported is %d)", version, FormatVersion)
+	}
+
+	var length uint32
+	if version == 1 {
+		var l [4]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return version, meta, nil, fmt.Errorf("failed to read metadata length: %w", err)
+		}
+		length = binary.LittleEndian.Uint32(l[:])
+	} else {
+		var kind uint8
+		if kind, length, err = readRecordHeader(r); err != nil {
+			return version, meta, nil, fmt.Errorf("failed to read metadata record: %w", err)
+		}
+		if kind != recordMetadata {
+			return version, meta, nil, fmt.Errorf("expected metadata record, got record kind %d", kind)
+		}
+	}
+	encoded := make([]byte, length)
+	if _, err := io.ReadFull(r, encoded); err != nil {
+		return version, meta, nil, fmt.Errorf("failed to read metadata: %w", err)
+	}
+	meta, err = decodeMetadata(encoded)
+	return version, meta, r, err
+}
+
+// ReadMetadata reads the metadata from the header of a replay without decompressing any of its actions.
+// If r also implements io.ReaderAt and io.Seeker, such as an *os.File, the final metadata is read using the
+// index at the end of the replay. Otherwise, the metadata written at the start of the replay is returned,
+// which might not include information only known once the recording was closed if the replay was
+// streamed. Only the ID, start time and end time are returned for encrypted replays, of which the rest of
+// the metadata is only available through Data. ErrNoHeader is returned if the replay was written in the
+// legacy format without a header.
+func ReadMetadata(r io.Reader) (Metadata, error) {
+	if ra, ok := r.(interface {
+		io.ReaderAt
+		io.Seeker
+	}); ok {
+		size, err := ra.Seek(0, io.SeekEnd)
+		if err != nil {
+			return Metadata{}, err
+		}
+		return readMetadataAt(ra, size)
+	}
+	version, meta, _, err := readHeader(r)
+	if err != nil {
+		return meta, err
+	}
+	if version == 0 {
+		return meta, ErrNoHeader
+	}
+	return meta, nil
+}
+
+// readMetadataAt reads the final metadata of a replay of the given size.
+func readMetadataAt(r io.ReaderAt, size int64) (Metadata, error) {
+	version, meta, _, err := readHeader(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return meta, err
+	}
+	if version == 0 {
+		return meta, ErrNoHeader
+	}
+	if version < 2 {
+		return meta, nil
+	}
+	entries, err := readIndex(r, size)
+	if err != nil {
+		return meta, err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].kind != recordMetadata {
+			continue
+		}
+		payload, err := readRecordAt(r, entries[i])
+		if err != nil {
+			return meta, err
+		}
+		return decodeMetadata(payload)
+	}
+	return meta, nil
+}
@@ -5,6 +5,8 @@ go 1.24.0
 require (
 	github.com/bedrock-gophers/intercept v0.2.4
 	github.com/df-mc/dragonfly v0.10.11-0.20260109070725-56fe7b1c866a
+	github.com/df-mc/goleveldb v1.1.9
+	github.com/df-mc/worldupgrader v1.0.20
 	github.com/go-gl/mathgl v1.2.0
 	github.com/google/uuid v1.6.0
 	github.com/klauspost/compress v1.18.3
@@ -16,9 +18,7 @@ require (
 require (
 	github.com/brentp/intintmap v0.0.0-20251106190759-56907b1f8479 // indirect
 	github.com/cespare/xxhash/v2 v2.3.0 // indirect
-	github.com/df-mc/goleveldb v1.1.9 // indirect
 	github.com/df-mc/jsonc v1.0.5 // indirect
-	github.com/df-mc/worldupgrader v1.0.20 // indirect
 	github.com/go-jose/go-jose/v4 v4.1.3 // indirect
 	github.com/golang/snappy v1.0.0 // indirect
 	github.com/muhammadmuzzammil1998/jsonc v1.0.0 // indirect
@@ -32,3 +32,11 @@ func skinToAction(playerID uint32, sk skin.Skin) *action.PlayerSkin {
 		GeometryData:    sk.Model,
 	}
 }
+
+func vec32To64(v mgl32.Vec3) mgl64.Vec3 {
+	return mgl64.Vec3{float64(v[0]), float64(v[1]), float64(v[2])}
+}
+
+func blockPosToCubePos(pos protocol.BlockPos) cube.Pos {
+	return cube.Pos{int(pos[0]), int(pos[1]), int(pos[2])}
+}
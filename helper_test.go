@@ -0,0 +1,181 @@
+package replay
+
+import (
+	"bytes"
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/df-mc/dragonfly/server/block"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/google/uuid"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"os"
+	"testing"
+	"time"
+	_ "unsafe"
+)
+
+//go:linkname finaliseBlockRegistry github.com/df-mc/dragonfly/server/world.finaliseBlockRegistry
+func finaliseBlockRegistry()
+
+// TestMain finalises the block registry, which dragonfly otherwise does when a server is started, and
+// initialises the package before running the tests.
+func TestMain(m *testing.M) {
+	finaliseBlockRegistry()
+	Init()
+	os.Exit(m.Run())
+}
+
+// testTicks is the amount of ticks in the replays written using writeTestReplay.
+const testTicks = 250
+
+// testMetadata returns the metadata of the replays written using writeTestReplay.
+func testMetadata() Metadata {
+	start := time.UnixMilli(1700000000000)
+	return Metadata{
+		ID:        uuid.MustParse("6f1b2c1e-52a4-4d7e-9f3a-1d2b3c4d5e6f"),
+		StartTime: start,
+		EndTime:   start.Add(testTicks * time.Second / tickRate),
+		TickRate:  tickRate,
+		WorldName: "world",
+		Players: []PlayerInfo{
+			{ID: 1, UUID: uuid.MustParse("0c5e1a7e-2f4b-4b1a-8d3e-5a6b7c8d9e0f"), Name: "alice"},
+			{ID: 2, UUID: uuid.MustParse("9a8b7c6d-5e4f-4a3b-8c2d-1e0f9a8b7c6d"), Name: "bob"},
+		},
+		Tags: map[string]string{"map": "lobby"},
+	}
+}
+
+// testActions returns the actions of a tick of the replays written using writeTestReplay. Two players and
+// an entity are spawned during the first tick, after which the players move every tick, blocks are placed
+// and the entity and one of the players despawn along the way.
+func testActions(tick uint32) ([]action.Action, error) {
+	var actions []action.Action
+	if tick == 1 {
+		actions = append(actions,
+			&action.PlayerSpawn{PlayerID: 1, PlayerName: "alice", NameTag: "alice", Position: mgl32.Vec3{0, 64, 0}},
+			&action.PlayerSpawn{PlayerID: 2, PlayerName: "bob", NameTag: "bob", Position: mgl32.Vec3{8, 64, 8}},
+			&action.EntitySpawn{EntityID: 3, EntityIdentifier: "minecraft:pig", Position: mgl32.Vec3{4, 64, 4}, ExtraData: map[string]any{}},
+		)
+	}
+	actions = append(actions, &action.PlayerMove{PlayerID: 1, Position: mgl32.Vec3{float32(tick) / 2, 64, 0}, Yaw: uint16(tick * 100)})
+	if tick < 200 {
+		actions = append(actions, &action.PlayerDeltaMove{
+			Flags:    action.PlayerDeltaMoveHasXFlag | action.PlayerDeltaMoveHasZFlag,
+			PlayerID: 2,
+			Position: mgl32.Vec3{8 + float32(tick)/4, 0, 8 - float32(tick)/4},
+		})
+	}
+	if tick%10 == 0 {
+		actions = append(actions, &action.SetBlock{Position: protocol.BlockPos{int32(tick / 10), 63, 0}, Block: action.FromBlock(block.Stone{})})
+	}
+	if tick%7 == 0 {
+		actions = append(actions, &action.PlayerAnimate{PlayerID: 1, Animation: 1})
+	}
+	switch tick {
+	case 120:
+		actions = append(actions, &action.EntityDespawn{EntityID: 3})
+	case 150:
+		actions = append(actions, &action.SetPlayerState{PlayerID: 2, Type: action.SetPlayerStateTypeSneaking, Value: true})
+	case 200:
+		actions = append(actions, &action.PlayerDespawn{PlayerID: 2})
+	}
+	return actions, nil
+}
+
+// writeTestReplay writes a replay of testTicks ticks holding the actions returned by testActions using the
+// options passed and returns it. The replay is written using segments of 50 ticks if opts does not specify
+// the segment size.
+func writeTestReplay(t testing.TB, opts writeOptions) []byte {
+	t.Helper()
+	if opts.version == 0 {
+		opts.version = FormatVersion
+	}
+	if opts.segmentTicks == 0 {
+		opts.segmentTicks = 50
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := writeReplayWith(buf, opts, testMetadata(), testTicks, testActions); err != nil {
+		t.Fatalf("failed to write replay: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// recordTestActions records the actions returned by testActions for the amount of ticks passed using the
+// Recorder passed, which must not have advanced past its first tick yet. The tick of the Recorder is advanced
+// and the Recorder is flushed between ticks like its tick counter does.
+func recordTestActions(r *Recorder, ticks uint32) {
+	for tick := uint32(1); tick <= ticks; tick++ {
+		if tick > 1 {
+			r.mu.Lock()
+			r.tick++
+			r.mu.Unlock()
+
+			if (tick-1)%r.flushTicks() == 0 {
+				r.Flush()
+			}
+		}
+		actions, _ := testActions(tick)
+		for _, a := range actions {
+			r.PushAction(a)
+		}
+	}
+}
+
+// openTestReplay opens the replay passed, after setting the KeyProvider passed if it is not nil.
+func openTestReplay(t testing.TB, b []byte, keys KeyProvider) *Data {
+	t.Helper()
+	d := NewData(uuid.Nil)
+	if keys != nil {
+		d.SetKeyProvider(keys)
+	}
+	if err := d.Open(bytes.NewReader(b), int64(len(b))); err != nil {
+		t.Fatalf("failed to open replay: %v", err)
+	}
+	return d
+}
+
+// encodeActions encodes the actions passed, leaving out keyframes, so that actions may be compared
+// regardless of how they were decoded and where keyframes were inserted.
+func encodeActions(actions []action.Action) []byte {
+	buf := bytes.NewBuffer(nil)
+	w := protocol.NewWriter(buf, 0)
+	for _, a := range actions {
+		if !isKeyframe(a) {
+			action.Write(w, a)
+		}
+	}
+	return buf.Bytes()
+}
+
+// requireActions fails the test if the replay passed does not hold exactly lastTick ticks with the actions
+// returned by actionsAt, leaving out keyframes.
+func requireActions(t testing.TB, d *Data, lastTick uint32, actionsAt func(tick uint32) ([]action.Action, error)) {
+	t.Helper()
+	if d.TotalTicks() != uint(lastTick) {
+		t.Fatalf("replay holds %d ticks, expected %d", d.TotalTicks(), lastTick)
+	}
+	for tick := uint32(1); tick <= lastTick; tick++ {
+		want, err := actionsAt(tick)
+		if err != nil {
+			t.Fatalf("failed to get expected actions of tick %d: %v", tick, err)
+		}
+		got, err := d.Actions(tick)
+		if err != nil {
+			t.Fatalf("failed to read tick %d: %v", tick, err)
+		}
+		if !bytes.Equal(encodeActions(got), encodeActions(want)) {
+			t.Fatalf("tick %d holds %d actions that differ from the %d expected", tick, len(got), len(want))
+		}
+	}
+}
+
+// newTestPlayback creates a Playback of the replay passed in a world held in memory, which is closed once
+// the test finishes.
+func newTestPlayback(t testing.TB, d *Data) *Playback {
+	t.Helper()
+	p, err := PlaybackWorldConfig{}.New(d)
+	if err != nil {
+		t.Fatalf("failed to create playback world: %v", err)
+	}
+	t.Cleanup(p.Close)
+	return p
+}
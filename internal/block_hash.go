@@ -20,6 +20,14 @@ func ConstructBlockHashMappings() {
 	blockToHashMapping = make(map[uint32]uint32, len(blocks))
 	for _, b := range blocks {
 		hash := computeHash(b)
+		// Blocks are registered in the same order every time, so colliding hashes are resolved the same way
+		// on every run of the same server version.
+		for {
+			if _, ok := hashToBlockMapping[hash]; !ok {
+				break
+			}
+			hash++
+		}
 		hashToBlockMapping[hash] = b
 		blockToHashMapping[world.BlockRuntimeID(b)] = hash
 	}
@@ -58,6 +66,12 @@ func HashToBlock(hash uint32) world.Block {
 	return b
 }
 
+// BlockByHash returns the block with the hash passed, or false if no block has the hash.
+func BlockByHash(hash uint32) (world.Block, bool) {
+	b, ok := hashToBlockMapping[hash]
+	return b, ok
+}
+
 func BlockToHash(b world.Block) uint32 {
 	k := world.BlockRuntimeID(b)
 	hash, ok := blockToHashMapping[k]
@@ -19,7 +19,18 @@ func ConstructItemHashMappings() {
 	for _, it := range world.Items() {
 		name, meta := it.EncodeItem()
 		itemStr := fmt.Sprintf("%s:%d", name, meta)
+		if _, ok := itemToHashMapping[itemStr]; ok {
+			continue
+		}
 		hash := fnv1.HashString32(itemStr)
+		// Items are registered in the same order every time, so colliding hashes are resolved the same way
+		// on every run of the same server version.
+		for {
+			if _, ok := hashToItemMapping[hash]; !ok {
+				break
+			}
+			hash++
+		}
 		hashToItemMapping[hash] = it
 		itemToHashMapping[itemStr] = hash
 	}
@@ -43,6 +54,12 @@ func ItemToHash(it world.Item) uint32 {
 	return hash
 }
 
+// ItemByHash returns the item with the hash passed, or false if no item has the hash.
+func ItemByHash(hash uint32) (world.Item, bool) {
+	it, ok := hashToItemMapping[hash]
+	return it, ok
+}
+
 func HashToItem(hash uint32) world.Item {
 	it, ok := hashToItemMapping[hash]
 	if !ok {
@@ -0,0 +1,332 @@
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/df-mc/dragonfly/server/player/skin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/sandertv/gophertunnel/minecraft/nbt"
+	"io"
+	"os"
+	"time"
+)
+
+// defaultJournalTicks is the amount of ticks after which the journal is written to if
+// RecorderConfig.JournalTicks is not set.
+const defaultJournalTicks = 20 // 1 second
+
+// journalChunk captures the ticks in the buffer that have not yet been written to the journal, ending with
+// lastTick. journalChunk must be called while holding mu.
+func (r *Recorder) journalChunk(lastTick uint32) recordedSegment {
+	chunk := recordedSegment{
+		firstTick: r.journalFirstTick,
+		lastTick:  lastTick,
+		data:      bytes.Clone(r.buffer.Bytes()[r.journalOffset:]),
+	}
+	r.journalFirstTick = lastTick + 1
+	r.journalOffset = r.buffer.Len()
+	return chunk
+}
+
+// storeJournalChunk appends a chunk of ticks to the journal and syncs the journal to disk. The metadata and
+// tables passed are written to the journal first if they changed since they were last written.
+// storeJournalChunk must be called while holding writeMu.
+func (r *Recorder) storeJournalChunk(chunk recordedSegment, meta Metadata, tables recordedTables) {
+	if r.journalErr != nil {
+		return
+	}
+	if r.journal == nil {
+		f, err := os.OpenFile(r.journalPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if err != nil {
+			r.journalErr = fmt.Errorf("failed to create journal: %w", err)
+			return
+		}
+		r.journalFile, r.journal = f, newContainerWriter(f)
+		r.journal.cipher = r.cipher
+		if r.journalErr = r.journal.writeHeader(meta); r.journalErr != nil {
+			return
+		}
+		r.journalMeta, _ = nbt.MarshalEncoding(meta.EncodeNBT(), nbt.LittleEndian)
+	} else if encoded, err := nbt.MarshalEncoding(meta.EncodeNBT(), nbt.LittleEndian); err == nil && !bytes.Equal(encoded, r.journalMeta) {
+		if r.journalErr = r.journal.writeMetadata(meta); r.journalErr != nil {
+			return
+		}
+		r.journalMeta = encoded
+	}
+	for _, s := range tables.skins[r.journalSkins:] {
+		if r.journalErr = r.journal.writeSkin(s); r.journalErr != nil {
+			return
+		}
+		r.journalSkins++
+	}
+	for _, s := range tables.snapshot[r.journalSnapshot:] {
+		if r.journalErr = r.journal.writeSnapshotChunk(s); r.journalErr != nil {
+			return
+		}
+		r.journalSnapshot++
+	}
+	if len(tables.palette) > 0 && !bytes.Equal(tables.palette, r.journalPalette) {
+		if r.journalErr = r.journal.writePalette(tables.palette); r.journalErr != nil {
+			return
+		}
+		r.journalPalette = tables.palette
+	}
+	if r.journalErr = r.journal.writeSegment(chunk.firstTick, chunk.lastTick, r.journalEncoder.EncodeAll(chunk.data, nil)); r.journalErr != nil {
+		return
+	}
+	r.journalErr = r.journalFile.Sync()
+}
+
+// removeJournal closes and removes the journal once the recording was closed successfully.
+func (r *Recorder) removeJournal() error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	if r.journalFile == nil {
+		return r.journalErr
+	}
+	_ = r.journalFile.Close()
+	r.journalFile, r.journal = nil, nil
+	if err := os.Remove(r.journalPath); err != nil {
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+	return r.journalErr
+}
+
+// RecoverJournal turns the journal at the path passed, left behind by a Recorder that was not closed, into
+// a valid replay written to w. The replay ends at the last tick that was completely written to the
+// journal, which is returned.
+func RecoverJournal(path string, w io.Writer) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer f.Close()
+	return Salvage(f, w)
+}
+
+// RecoverEncryptedJournal recovers a journal like RecoverJournal, for a Recorder that encrypted the replay
+// using RecorderConfig.Keys. The KeyProvider passed is used to decrypt the journal and to encrypt the
+// replay written to w.
+func RecoverEncryptedJournal(path string, w io.Writer, keys KeyProvider) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer f.Close()
+	return SalvageEncrypted(f, w, keys)
+}
+
+// Salvage reads a replay that may be truncated, such as a journal or a replay streamed to a sink that was
+// never closed, and writes a valid replay holding every complete tick and snapshot chunk that could be read
+// to w. The last tick recovered is returned. The metadata of the replay is taken from the last metadata record that could
+// be read. If the end time is unknown, it is derived from the amount of ticks recovered. Block and item
+// hashes are resolved using the last palette record that could be read, if any. If the replay was compressed
+// using a Dictionary, such as a replay streamed to a sink with RecorderConfig.Dictionary set, the Dictionary
+// must be passed. ErrEncrypted is returned if the replay is encrypted.
+func Salvage(r io.Reader, w io.Writer, dicts ...*Dictionary) (uint32, error) {
+	return salvage(r, w, nil, dicts)
+}
+
+// SalvageEncrypted salvages a replay like Salvage, for a replay that may be encrypted. The KeyProvider passed
+// is used to decrypt the replay, and the replay written to w is encrypted using the same key.
+func SalvageEncrypted(r io.Reader, w io.Writer, keys KeyProvider, dicts ...*Dictionary) (uint32, error) {
+	return salvage(r, w, keys, dicts)
+}
+
+// salvage salvages a replay, decrypting it using the KeyProvider passed if it is encrypted and decompressing
+// it using one of the dictionaries passed if it was compressed using a Dictionary.
+func salvage(r io.Reader, w io.Writer, keys KeyProvider, dicts []*Dictionary) (uint32, error) {
+	// The offsets of records are counted, as they are authenticated along with encrypted payloads.
+	cr := &countingReader{r: r}
+	version, meta, _, err := readHeader(cr)
+	if err != nil {
+		return 0, err
+	}
+	if version < 2 {
+		return 0, fmt.Errorf("format version %d cannot be salvaged", version)
+	}
+
+	actions := make(map[uint32][]action.Action)
+	var (
+		palette *Palette
+		c       *recordCipher
+		// tracks holds the payload of the last tracks record read, which precedes the segment it belongs to.
+		tracks []byte
+		// decoder decompresses the segments read. It is found once the first segment is read, as the metadata
+		// of encrypted replays refers to the dictionary only once it was decrypted. dictErr holds the error
+		// returned if the dictionary was not passed, in which case only segments compressed without it, such
+		// as those of journals, are recovered.
+		decoder *zstd.Decoder
+		dictErr error
+	)
+	skins := make(map[[32]byte]skin.Skin)
+	var snapshot []snapshotChunk
+	for {
+		offset := cr.n
+		kind, length, err := readRecordHeader(cr)
+		if err != nil {
+			break
+		}
+		payload := make([]byte, 0, min(int(length), 1<<20))
+		buf := bytes.NewBuffer(payload)
+		n, err := io.CopyN(buf, cr, int64(length))
+		truncated := n < int64(length)
+		if err != nil && !truncated {
+			return 0, fmt.Errorf("failed to read record: %w", err)
+		}
+		if truncated && (c != nil || kind == recordEncryption) {
+			// Encrypted payloads cannot be authenticated unless they are complete.
+			break
+		}
+		if c != nil && encryptedRecord(kind) {
+			decrypted, err := c.open(kind, offset, buf.Bytes())
+			if err != nil {
+				// The record was corrupted, so none of the records after it may be trusted either.
+				break
+			}
+			buf = bytes.NewBuffer(decrypted)
+		}
+		switch kind {
+		case recordMetadata, recordPrivateMetadata:
+			if truncated || (c != nil && kind == recordMetadata) {
+				// The metadata records of encrypted journals only hold the public part of the metadata.
+				break
+			}
+			if m, err := decodeMetadata(buf.Bytes()); err == nil {
+				meta = m
+			}
+		case recordEncryption:
+			if c, err = decodeEncryption(buf.Bytes(), keys); err != nil {
+				return 0, err
+			}
+		case recordPalette:
+			if truncated {
+				break
+			}
+			if p, err := decodePalette(buf.Bytes()); err == nil {
+				palette = p
+			}
+		case recordSkin:
+			if truncated {
+				break
+			}
+			if hash, sk, err := decodeSkinRecord(buf.Bytes()); err == nil {
+				skins[hash] = sk
+			}
+		case recordSnapshot, recordTickedSnapshot:
+			if truncated {
+				break
+			}
+			if s, err := decodeSnapshotRecord(kind, buf.Bytes()); err == nil {
+				snapshot = append(snapshot, s)
+			}
+		case recordTracks:
+			if !truncated {
+				tracks = buf.Bytes()
+			}
+		case recordSegment:
+			if decoder == nil {
+				if decoder, dictErr = findDictionary(meta.DictionaryID, dicts); dictErr != nil {
+					decoder = segmentDecoder
+				}
+			}
+			salvageSegment(buf.Bytes(), tracks, actions, version, decoder, dicts)
+			tracks = nil
+		}
+		if truncated {
+			break
+		}
+	}
+
+	lastTick := uint32(0)
+	for tick := range actions {
+		lastTick = max(lastTick, tick)
+	}
+	if lastTick == 0 {
+		if dictErr != nil {
+			return 0, dictErr
+		}
+		return 0, errors.New("no complete ticks could be recovered")
+	}
+	if palette != nil {
+		if remap, _ := palette.resolve(); !remap.empty() {
+			for _, tickActions := range actions {
+				for _, a := range tickActions {
+					remap.apply(a)
+				}
+			}
+		}
+	}
+	if meta.TickRate == 0 {
+		meta.TickRate = tickRate
+	}
+	if meta.EndTime.Before(meta.StartTime) || meta.EndTime.IsZero() {
+		meta.EndTime = meta.StartTime.Add(time.Duration(lastTick) * time.Second / time.Duration(meta.TickRate))
+	}
+	err = writeReplayWith(w, writeOptions{version: FormatVersion, cipher: c, snapshot: snapshot}, meta, lastTick, func(tick uint32) ([]action.Action, error) {
+		return resolveSkinRefs(actions[tick], func(hash [32]byte) (skin.Skin, bool) {
+			sk, ok := skins[hash]
+			return sk, ok
+		}), nil
+	})
+	return lastTick, err
+}
+
+// salvageSegment decodes as many complete ticks as possible from the payload of a segment record, written
+// using the format version passed, that may be truncated. If tracks is not nil, it is the payload of the
+// tracks record preceding the segment, of which the movements are merged into the ticks decoded. The segment
+// and tracks are decompressed using the decoder passed, or a stream decoder using the dictionaries passed if
+// the segment is incomplete.
+func salvageSegment(payload, tracks []byte, into map[uint32][]action.Action, version uint16, decoder *zstd.Decoder, dicts []*Dictionary) {
+	if len(payload) < segmentHeaderSize {
+		return
+	}
+	decompressed, err := decoder.DecodeAll(payload[segmentHeaderSize:], nil)
+	if err != nil {
+		// The frame is incomplete: decode it as a stream to recover the blocks that were written.
+		raw := make([][]byte, len(dicts))
+		for i, d := range dicts {
+			raw[i] = d.raw
+		}
+		dec, err := zstd.NewReader(bytes.NewReader(payload[segmentHeaderSize:]), zstd.WithDecoderDicts(raw...))
+		if err != nil {
+			return
+		}
+		decompressed, _ = io.ReadAll(dec)
+		dec.Close()
+	}
+	// decodeTicks only adds ticks of which all actions could be decoded, so an error here means that the
+	// remaining ticks are incomplete.
+	decoded := make(map[uint32][]action.Action)
+	_, _ = decodeTicks(decompressed, decoded, version)
+	if len(tracks) >= segmentHeaderSize && bytes.Equal(tracks[:segmentHeaderSize], payload[:segmentHeaderSize]) {
+		salvageTracks(tracks[segmentHeaderSize:], decoded, decoder)
+	}
+	for tick, tickActions := range decoded {
+		into[tick] = tickActions
+	}
+}
+
+// salvageTracks merges the movements of a compressed tracks record into the ticks decoded from its segment.
+// Ticks of which the movements could not be merged are dropped, as they are incomplete.
+func salvageTracks(compressed []byte, decoded map[uint32][]action.Action, decoder *zstd.Decoder) {
+	decompressed, err := decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return
+	}
+	moves, err := decodeTracks(decompressed)
+	if err != nil {
+		return
+	}
+	for tick, tickMoves := range moves {
+		tickActions, ok := decoded[tick]
+		if !ok {
+			continue
+		}
+		if decoded[tick], err = mergeTracks(tickActions, tickMoves); err != nil {
+			delete(decoded, tick)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"github.com/google/uuid"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRecoverJournal tests that a journal left behind by a Recorder that was not closed is recovered into a
+// replay holding the same ticks as the replay saved by the Recorder.
+func TestRecoverJournal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "replay.journal")
+	r := RecorderConfig{SegmentTicks: 50, JournalPath: path, JournalTicks: 10}.New(testMetadata().ID)
+	recordTestActions(r, 173)
+	r.Flush()
+
+	// The journal is copied before closing the Recorder, as if the process crashed at this point.
+	journal, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read journal: %v", err)
+	}
+	crashed := filepath.Join(dir, "crashed.journal")
+	if err := os.WriteFile(crashed, journal, 0644); err != nil {
+		t.Fatalf("failed to copy journal: %v", err)
+	}
+	saved := bytes.NewBuffer(nil)
+	if err := r.CloseAndSaveActions(saved); err != nil {
+		t.Fatalf("failed to save replay: %v", err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("journal was not removed after closing the recorder: %v", err)
+	}
+	requireActions(t, openTestReplay(t, saved.Bytes(), nil), 173, testActions)
+
+	recovered := bytes.NewBuffer(nil)
+	lastTick, err := RecoverJournal(crashed, recovered)
+	if err != nil {
+		t.Fatalf("failed to recover journal: %v", err)
+	}
+	if lastTick < 160 || lastTick > 173 {
+		t.Fatalf("journal was recovered up to tick %d, expected a tick between 160 and 173", lastTick)
+	}
+	d := openTestReplay(t, recovered.Bytes(), nil)
+	requireActions(t, d, lastTick, testActions)
+	if d.ID() != testMetadata().ID {
+		t.Fatalf("recovered replay has ID %v, expected %v", d.ID(), testMetadata().ID)
+	}
+}
+
+// TestSalvageTruncated tests that a replay cut off in the middle of a segment is salvaged up to the ticks
+// that were completely written, and that a replay cut off at its trailer is salvaged completely.
+func TestSalvageTruncated(t *testing.T) {
+	b := writeTestReplay(t, writeOptions{})
+	entries, err := readIndex(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("failed to read index: %v", err)
+	}
+	var segments []indexEntry
+	for _, e := range entries {
+		if e.kind == recordSegment {
+			segments = append(segments, e)
+		}
+	}
+	for i := 1; i < len(segments); i++ {
+		prev, s := segments[i-1], segments[i]
+		size := s.offset + recordHeaderSize + int64(s.length)/2
+		out := bytes.NewBuffer(nil)
+		lastTick, err := Salvage(bytes.NewReader(b[:size]), out)
+		if err != nil {
+			t.Fatalf("failed to salvage replay cut off in segment %d: %v", i, err)
+		}
+		if lastTick < prev.lastTick || lastTick >= s.lastTick {
+			t.Fatalf("replay cut off in segment %d was salvaged up to tick %d, expected a tick between %d and %d", i, lastTick, prev.lastTick, s.lastTick-1)
+		}
+		requireActions(t, openTestReplay(t, out.Bytes(), nil), lastTick, testActions)
+	}
+
+	out := bytes.NewBuffer(nil)
+	lastTick, err := Salvage(bytes.NewReader(b[:len(b)-trailerSize]), out)
+	if err != nil {
+		t.Fatalf("failed to salvage replay without trailer: %v", err)
+	}
+	if lastTick != testTicks {
+		t.Fatalf("replay without trailer was salvaged up to tick %d, expected %d", lastTick, testTicks)
+	}
+	requireActions(t, openTestReplay(t, out.Bytes(), nil), testTicks, testActions)
+
+	if _, err := Salvage(bytes.NewReader(b[:segments[0].offset]), bytes.NewBuffer(nil)); err == nil {
+		t.Fatal("replay cut off before its first segment was salvaged")
+	}
+	if err := NewData(uuid.Nil).LoadActions(bytes.NewReader(b[:len(b)/2])); err == nil {
+		t.Fatal("replay cut off halfway was loaded without salvaging it")
+	}
+}
+
+// TestSalvageDictionary tests that a replay streamed to a sink using a Dictionary is salvaged if the
+// Dictionary is passed, also if its last segment is cut off, and that salvaging it fails otherwise.
+func TestSalvageDictionary(t *testing.T) {
+	var replays []*Data
+	for _, segmentTicks := range []uint32{10, 20, 25, 50} {
+		replays = append(replays, openTestReplay(t, writeTestReplay(t, writeOptions{segmentTicks: segmentTicks}), nil))
+	}
+	dict, err := DictionaryConfig{Size: 4096}.Train(replays...)
+	if err != nil {
+		t.Fatalf("failed to train dictionary: %v", err)
+	}
+	sink := bytes.NewBuffer(nil)
+	r := RecorderConfig{SegmentTicks: 50, Sink: sink, Dictionary: dict}.New(testMetadata().ID)
+	recordTestActions(r, 173)
+	r.Flush()
+	// The Recorder is not closed, as if the process crashed at this point.
+	streamed := bytes.Clone(sink.Bytes())
+
+	if _, err := Salvage(bytes.NewReader(streamed), bytes.NewBuffer(nil)); !errors.Is(err, ErrMissingDictionary) {
+		t.Fatalf("salvaging without the dictionary returned %v, expected ErrMissingDictionary", err)
+	}
+	out := bytes.NewBuffer(nil)
+	lastTick, err := Salvage(bytes.NewReader(streamed), out, dict)
+	if err != nil {
+		t.Fatalf("failed to salvage replay: %v", err)
+	}
+	if lastTick != 150 {
+		t.Fatalf("replay was salvaged up to tick %d, expected 150", lastTick)
+	}
+	requireActions(t, openTestReplay(t, out.Bytes(), nil), lastTick, testActions)
+
+	out.Reset()
+	lastTick, err = Salvage(bytes.NewReader(streamed[:len(streamed)-16]), out, dict)
+	if err != nil {
+		t.Fatalf("failed to salvage replay cut off in its last segment: %v", err)
+	}
+	if lastTick < 100 || lastTick >= 150 {
+		t.Fatalf("replay cut off in its last segment was salvaged up to tick %d, expected a tick between 100 and 149", lastTick)
+	}
+	requireActions(t, openTestReplay(t, out.Bytes(), nil), lastTick, testActions)
+	_ = r.Close()
+}
@@ -0,0 +1,231 @@
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/df-mc/dragonfly/server/player/skin"
+	"github.com/df-mc/dragonfly/server/world"
+	"github.com/google/uuid"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"io"
+	"time"
+)
+
+// MergeSource is a replay merged into a single replay using MergeConfig.Merge.
+type MergeSource struct {
+	// Data is the replay merged. It must be loaded.
+	Data *Data
+	// Name is the name of the source in the metadata of the merged replay. If empty, the name of the world
+	// recorded is used.
+	Name string
+	// Offset is the amount of ticks by which the replay is delayed in the merged replay, so that its first
+	// tick is played at tick Offset+1. Offset is ignored if MergeConfig.AlignByStartTime is true.
+	Offset uint32
+}
+
+// MergeConfig holds the settings used to merge replays into a single replay.
+type MergeConfig struct {
+	// AlignByStartTime aligns the replays merged by the wall-clock time at which their recordings were
+	// started, so that ticks recorded at the same time are played at the same time. The replay that was
+	// started first starts at tick 1 of the merged replay. If false, every replay is delayed by the Offset of
+	// its MergeSource instead.
+	AlignByStartTime bool
+}
+
+// Merge writes a single replay combining the replays passed to w. The IDs of the players and entities of
+// every replay are remapped so that they do not collide, and the actions of every replay are preceded by an
+// action.SetSource, so that Playback.SetSources may be used to only play some of the replays. The replays
+// are listed in the Sources of the metadata of the merged replay, in the order passed, and are numbered from
+// 1 in that order. If any of the replays is encrypted, the merged replay is encrypted using the key of the
+// first encrypted replay. Replays that were created using Merge cannot be merged again.
+func (conf MergeConfig) Merge(w io.Writer, sources ...MergeSource) error {
+	if len(sources) == 0 {
+		return errors.New("no replays to merge")
+	}
+	metas := make([]Metadata, len(sources))
+	totalTicks := make([]uint32, len(sources))
+	var c *recordCipher
+	for i, src := range sources {
+		if src.Data == nil {
+			return fmt.Errorf("replay %d to merge is nil", i)
+		}
+		src.Data.mu.Lock()
+		metas[i], totalTicks[i] = src.Data.meta, uint32(src.Data.totalTicks)
+		if c == nil {
+			c = src.Data.cipher
+		}
+		src.Data.mu.Unlock()
+		if len(metas[i].Sources) > 0 {
+			return fmt.Errorf("replay %d to merge was merged itself", i)
+		}
+		if metas[i].TickRate == 0 {
+			metas[i].TickRate = tickRate
+		}
+		if metas[i].TickRate != metas[0].TickRate {
+			return fmt.Errorf("replay %d to merge has tick rate %d, expected %d", i, metas[i].TickRate, metas[0].TickRate)
+		}
+	}
+	tickDuration := time.Second / time.Duration(metas[0].TickRate)
+
+	offsets := make([]uint32, len(sources))
+	if conf.AlignByStartTime {
+		earliest := metas[0].StartTime
+		for _, meta := range metas[1:] {
+			if meta.StartTime.Before(earliest) {
+				earliest = meta.StartTime
+			}
+		}
+		for i, meta := range metas {
+			offsets[i] = uint32(meta.StartTime.Sub(earliest).Round(tickDuration) / tickDuration)
+		}
+	} else {
+		for i, src := range sources {
+			offsets[i] = src.Offset
+		}
+	}
+
+	m := newIDMerger(len(sources))
+	merged := Metadata{
+		ID:        uuid.New(),
+		TickRate:  metas[0].TickRate,
+		WorldName: metas[0].WorldName,
+		Dimension: metas[0].Dimension,
+		Sources:   make([]SourceInfo, len(sources)),
+	}
+	lastTick := uint32(0)
+	for i, meta := range metas {
+		start := meta.StartTime.Add(-time.Duration(offsets[i]) * tickDuration)
+		if i == 0 || start.Before(merged.StartTime) {
+			merged.StartTime = start
+		}
+		lastTick = max(lastTick, offsets[i]+totalTicks[i])
+
+		name := sources[i].Name
+		if name == "" {
+			name = meta.WorldName
+		}
+		merged.Sources[i] = SourceInfo{
+			ID:        meta.ID,
+			Name:      name,
+			StartTime: meta.StartTime,
+			EndTime:   meta.EndTime,
+			WorldName: meta.WorldName,
+			Dimension: meta.Dimension,
+			Offset:    offsets[i],
+			Tags:      meta.Tags,
+		}
+		for _, p := range meta.Players {
+			merged.Players = append(merged.Players, PlayerInfo{ID: m.id(i, p.ID), UUID: p.UUID, Name: p.Name, Source: uint32(i + 1)})
+		}
+	}
+	merged.EndTime = merged.StartTime.Add(time.Duration(lastTick) * tickDuration)
+
+	// The snapshots of the replays are combined, with chunks saved by more than one replay taken from the
+	// first of them. Chunks are restored at the tick they were saved in, offset like the ticks of their replay.
+	var snapshot []snapshotChunk
+	snapshotted := make(map[world.ChunkPos]struct{})
+	for i, src := range sources {
+		chunks, err := src.Data.snapshotChunks()
+		if err != nil {
+			return fmt.Errorf("failed to read snapshot of replay %d: %w", i, err)
+		}
+		for _, s := range chunks {
+			if _, ok := snapshotted[s.pos]; !ok {
+				snapshotted[s.pos] = struct{}{}
+				s.tick += offsets[i]
+				snapshot = append(snapshot, s)
+			}
+		}
+	}
+
+	return writeReplayWith(w, writeOptions{version: FormatVersion, cipher: c, snapshot: snapshot}, merged, lastTick, func(tick uint32) ([]action.Action, error) {
+		var actions []action.Action
+		for i, src := range sources {
+			if tick <= offsets[i] || tick-offsets[i] > totalTicks[i] {
+				continue
+			}
+			sourceActions, err := src.Data.Actions(tick - offsets[i])
+			if err != nil {
+				return nil, fmt.Errorf("failed to read tick %d of replay %d: %w", tick-offsets[i], i, err)
+			}
+			sourceActions = resolveSkinRefs(sourceActions, func(hash [32]byte) (skin.Skin, bool) {
+				sk, err := src.Data.Skin(hash)
+				return sk, err == nil
+			})
+			if len(sourceActions) == 0 {
+				continue
+			}
+			actions = append(actions, &action.SetSource{Source: uint32(i + 1)})
+			for _, a := range sourceActions {
+				// The keyframes of the merged replay are created from the actions of all replays, so the
+				// keyframes of the replays merged are dropped.
+				if isKeyframe(a) {
+					continue
+				}
+				if a, err = m.remap(i, a); err != nil {
+					return nil, err
+				}
+				actions = append(actions, a)
+			}
+		}
+		return actions, nil
+	})
+}
+
+// idMerger assigns new IDs to the players and entities of replays that are merged, so that the IDs of
+// different replays do not collide.
+type idMerger struct {
+	ids    []map[uint32]uint32
+	nextID uint32
+}
+
+// newIDMerger creates an idMerger for the amount of replays passed.
+func newIDMerger(n int) *idMerger {
+	m := &idMerger{ids: make([]map[uint32]uint32, n), nextID: 1}
+	for i := range m.ids {
+		m.ids[i] = make(map[uint32]uint32)
+	}
+	return m
+}
+
+// id returns the new ID of a player or entity of the replay with the index passed, assigning a new ID if the
+// player or entity did not yet have one. Players and entities share the same IDs, as they do when recorded.
+func (m *idMerger) id(source int, id uint32) uint32 {
+	if merged, ok := m.ids[source][id]; ok {
+		return merged
+	}
+	merged := m.nextID
+	m.nextID++
+	m.ids[source][id] = merged
+	return merged
+}
+
+// remap returns the action passed with the IDs of players and entities replaced with their new IDs. Actions
+// that hold IDs are copied, as the actions passed are shared with the Data they were read from.
+func (m *idMerger) remap(source int, a action.Action) (action.Action, error) {
+	if _, ok := a.(action.Identified); !ok {
+		return a, nil
+	}
+	a, err := cloneAction(a)
+	if err != nil {
+		return nil, err
+	}
+	replace := func(id *uint32) {
+		*id = m.id(source, *id)
+	}
+	a.(action.Identified).VisitIDs(replace, replace)
+	return a, nil
+}
+
+// cloneAction returns a deep copy of the action passed by encoding and decoding it.
+func cloneAction(a action.Action) (action.Action, error) {
+	buf := bytes.NewBuffer(nil)
+	action.Write(protocol.NewWriter(buf, 0), a)
+	var clone action.Action
+	if err := action.Read(buf, &clone); err != nil {
+		return nil, fmt.Errorf("failed to copy action: %w", err)
+	}
+	return clone, nil
+}
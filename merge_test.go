@@ -0,0 +1,138 @@
+package replay
+
+import (
+	"bytes"
+	"github.com/akmalfairuz/df-replay/action"
+	"slices"
+	"sort"
+	"testing"
+)
+
+// remapIDs returns a copy of the action passed with the IDs of players and entities replaced using the map
+// passed.
+func remapIDs(t *testing.T, a action.Action, ids map[uint32]uint32) action.Action {
+	t.Helper()
+	if _, ok := a.(action.Identified); !ok {
+		return a
+	}
+	a, err := cloneAction(a)
+	if err != nil {
+		t.Fatalf("failed to copy action: %v", err)
+	}
+	replace := func(id *uint32) {
+		merged, ok := ids[*id]
+		if !ok {
+			t.Fatalf("no merged ID for ID %d", *id)
+		}
+		*id = merged
+	}
+	a.(action.Identified).VisitIDs(replace, replace)
+	return a
+}
+
+// TestMerge tests that merged replays hold the actions of every replay at their offset, preceded by the
+// source they belong to, with IDs that do not collide, and that the scene of every source matches the scene
+// of the replay merged.
+func TestMerge(t *testing.T) {
+	a := openTestReplay(t, writeTestReplay(t, writeOptions{}), nil)
+	b := openTestReplay(t, writeTestReplay(t, writeOptions{segmentTicks: 30}), nil)
+	offsets := []uint32{0, 30}
+	buf := bytes.NewBuffer(nil)
+	err := MergeConfig{}.Merge(buf, MergeSource{Data: a, Name: "first", Offset: offsets[0]}, MergeSource{Data: b, Name: "second", Offset: offsets[1]})
+	if err != nil {
+		t.Fatalf("failed to merge replays: %v", err)
+	}
+	m := openTestReplay(t, buf.Bytes(), nil)
+	if m.TotalTicks() != testTicks+uint(offsets[1]) {
+		t.Fatalf("merged replay holds %d ticks, expected %d", m.TotalTicks(), testTicks+offsets[1])
+	}
+
+	meta := m.Metadata()
+	if len(meta.Sources) != 2 || meta.Sources[0].Name != "first" || meta.Sources[1].Name != "second" || meta.Sources[1].Offset != offsets[1] {
+		t.Fatalf("merged replay has sources %+v", meta.Sources)
+	}
+	// ids maps the IDs of every source to the IDs in the merged replay. used holds every ID of the merged
+	// replay, so that collisions are detected.
+	ids := []map[uint32]uint32{{}, {}}
+	used := make(map[uint32]struct{})
+	assign := func(source int, id, merged uint32) {
+		if _, ok := used[merged]; ok {
+			t.Fatalf("merged ID %d of source %d was assigned before", merged, source+1)
+		}
+		used[merged] = struct{}{}
+		ids[source][id] = merged
+	}
+	if len(meta.Players) != 4 {
+		t.Fatalf("merged replay has players %v, expected the players of both sources", meta.Players)
+	}
+	for _, p := range meta.Players {
+		i := slices.IndexFunc(testMetadata().Players, func(orig PlayerInfo) bool { return orig.Name == p.Name })
+		if i == -1 || p.Source == 0 || p.Source > 2 {
+			t.Fatalf("merged replay has unexpected player %+v", p)
+		}
+		assign(int(p.Source-1), testMetadata().Players[i].ID, p.ID)
+	}
+
+	for tick := uint32(1); tick <= uint32(m.TotalTicks()); tick++ {
+		actions, err := m.Actions(tick)
+		if err != nil {
+			t.Fatalf("failed to read tick %d of merged replay: %v", tick, err)
+		}
+		groups := make([][]action.Action, 2)
+		source := -1
+		for _, act := range actions {
+			if isKeyframe(act) {
+				continue
+			}
+			if s, ok := act.(*action.SetSource); ok {
+				if int(s.Source)-1 <= source || s.Source > 2 {
+					t.Fatalf("tick %d sets source %d after source %d", tick, s.Source, source+1)
+				}
+				source = int(s.Source) - 1
+				continue
+			}
+			if source == -1 {
+				t.Fatalf("tick %d holds a %T before setting its source", tick, act)
+			}
+			if spawn, ok := act.(*action.EntitySpawn); ok {
+				assign(source, 3, spawn.EntityID)
+			}
+			groups[source] = append(groups[source], act)
+		}
+		for i, src := range []*Data{a, b} {
+			var want []action.Action
+			if tick > offsets[i] {
+				// The actions are copied, as they are shared with the Data they were read from.
+				actions, _ := src.Actions(tick - offsets[i])
+				want = slices.Clone(actions)
+			}
+			for j, act := range want {
+				if !isKeyframe(act) {
+					want[j] = remapIDs(t, act, ids[i])
+				}
+			}
+			if !bytes.Equal(encodeActions(groups[i]), encodeActions(want)) {
+				t.Fatalf("actions of source %d at tick %d differ from tick %d of the replay merged", i+1, tick, tick-offsets[i])
+			}
+		}
+	}
+
+	for _, tick := range []uint32{31, 100, 131, 201, 260} {
+		sc, err := m.sceneAt(tick)
+		if err != nil {
+			t.Fatalf("failed to get scene of merged replay at tick %d: %v", tick, err)
+		}
+		for i, src := range []*Data{a, b} {
+			orig, err := src.sceneAt(tick - offsets[i])
+			if err != nil {
+				t.Fatalf("failed to get scene of replay %d at tick %d: %v", i+1, tick-offsets[i], err)
+			}
+			want := remapIDs(t, orig.scene(0).keyframe(0), ids[i]).(*action.Keyframe)
+			sort.Slice(want.Players, func(i, j int) bool { return want.Players[i].PlayerID < want.Players[j].PlayerID })
+			sort.Slice(want.Entities, func(i, j int) bool { return want.Entities[i].EntityID < want.Entities[j].EntityID })
+			if !bytes.Equal(encodeKeyframe(sc.scene(uint32(i+1)).keyframe(0)), encodeKeyframe(want)) {
+				t.Fatalf("scene of source %d at tick %d differs from the scene of the replay merged at tick %d", i+1, tick, tick-offsets[i])
+			}
+		}
+	}
+}
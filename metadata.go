@@ -0,0 +1,202 @@
+package replay
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// Metadata holds information about a replay that is stored in the header of a replay file. It may be read
+// without decompressing the actions of the replay using ReadMetadata.
+type Metadata struct {
+	// ID is the unique ID of the replay, as passed to the Recorder that recorded it.
+	ID uuid.UUID
+	// StartTime is the wall-clock time at which the recording was started.
+	StartTime time.Time
+	// EndTime is the wall-clock time at which the recording was closed.
+	EndTime time.Time
+	// TickRate is the amount of ticks recorded per second.
+	TickRate uint32
+	// WorldName is the name of the world that was recorded.
+	WorldName string
+	// Dimension is the ID of the dimension of the world that was recorded.
+	Dimension int32
+	// Players holds every player that was added to the recording at some point.
+	Players []PlayerInfo
+	// Tags holds free-form key/value pairs set using Recorder.SetTag.
+	Tags map[string]string
+	// Sources holds the replays that the replay was created from if it was created using Merge. The actions
+	// of source n are preceded by an action.SetSource with Source n+1.
+	Sources []SourceInfo
+	// DictionaryID is the ID of the Dictionary that the replay was compressed using, or 0 if it was
+	// compressed without a dictionary. It is set when the replay is written.
+	DictionaryID uint32
+}
+
+// SourceInfo holds information about a replay that was merged into another replay.
+type SourceInfo struct {
+	// ID is the ID of the replay merged.
+	ID uuid.UUID
+	// Name is the name given to the replay when it was merged.
+	Name string
+	// StartTime and EndTime are the wall-clock times at which the recording of the replay was started and
+	// closed.
+	StartTime, EndTime time.Time
+	// WorldName and Dimension are the name and the dimension of the world that was recorded.
+	WorldName string
+	Dimension int32
+	// Offset is the amount of ticks by which the replay was delayed in the merged replay, so that the first
+	// tick of the replay is tick Offset+1 of the merged replay.
+	Offset uint32
+	// Tags holds the tags of the replay merged.
+	Tags map[string]string
+}
+
+// PlayerInfo holds information about a player that appears in a replay.
+type PlayerInfo struct {
+	// ID is the ID of the player used by the actions in the replay.
+	ID uint32
+	// UUID is the UUID of the player at the time of recording.
+	UUID uuid.UUID
+	// Name is the name of the player at the time of recording.
+	Name string
+	// Source is the number of the source that the player was recorded in if the replay was merged, as used
+	// by action.SetSource, or 0 otherwise.
+	Source uint32
+}
+
+// Duration returns the wall-clock duration of the recording.
+func (m Metadata) Duration() time.Duration {
+	return m.EndTime.Sub(m.StartTime)
+}
+
+// EncodeNBT encodes the metadata into a map that may be encoded as NBT.
+func (m Metadata) EncodeNBT() map[string]any {
+	players := make([]any, 0, len(m.Players))
+	for _, p := range m.Players {
+		players = append(players, map[string]any{
+			"ID":     int32(p.ID),
+			"UUID":   p.UUID.String(),
+			"Name":   p.Name,
+			"Source": int32(p.Source),
+		})
+	}
+	sources := make([]any, 0, len(m.Sources))
+	for _, s := range m.Sources {
+		sources = append(sources, map[string]any{
+			"ID":        s.ID.String(),
+			"Name":      s.Name,
+			"StartTime": s.StartTime.UnixMilli(),
+			"EndTime":   s.EndTime.UnixMilli(),
+			"WorldName": s.WorldName,
+			"Dimension": s.Dimension,
+			"Offset":    int32(s.Offset),
+			"Tags":      encodeTags(s.Tags),
+		})
+	}
+	return map[string]any{
+		"ID":           m.ID.String(),
+		"StartTime":    m.StartTime.UnixMilli(),
+		"EndTime":      m.EndTime.UnixMilli(),
+		"TickRate":     int32(m.TickRate),
+		"WorldName":    m.WorldName,
+		"Dimension":    m.Dimension,
+		"Players":      players,
+		"Tags":         encodeTags(m.Tags),
+		"Sources":      sources,
+		"DictionaryID": int32(m.DictionaryID),
+	}
+}
+
+// DecodeNBT decodes metadata from a map decoded from NBT. Fields missing from the map are left empty.
+func (m *Metadata) DecodeNBT(data map[string]any) {
+	if v, ok := data["ID"].(string); ok {
+		m.ID, _ = uuid.Parse(v)
+	}
+	if v, ok := data["StartTime"].(int64); ok {
+		m.StartTime = time.UnixMilli(v)
+	}
+	if v, ok := data["EndTime"].(int64); ok {
+		m.EndTime = time.UnixMilli(v)
+	}
+	if v, ok := data["TickRate"].(int32); ok {
+		m.TickRate = uint32(v)
+	}
+	m.WorldName, _ = data["WorldName"].(string)
+	m.Dimension, _ = data["Dimension"].(int32)
+	if players, ok := data["Players"].([]any); ok {
+		m.Players = make([]PlayerInfo, 0, len(players))
+		for _, v := range players {
+			p, ok := v.(map[string]any)
+			if !ok {
+				continue
+			}
+			var info PlayerInfo
+			if id, ok := p["ID"].(int32); ok {
+				info.ID = uint32(id)
+			}
+			if id, ok := p["UUID"].(string); ok {
+				info.UUID, _ = uuid.Parse(id)
+			}
+			info.Name, _ = p["Name"].(string)
+			if source, ok := p["Source"].(int32); ok {
+				info.Source = uint32(source)
+			}
+			m.Players = append(m.Players, info)
+		}
+	}
+	m.Tags = decodeTags(data["Tags"])
+	if sources, ok := data["Sources"].([]any); ok && len(sources) > 0 {
+		m.Sources = make([]SourceInfo, 0, len(sources))
+		for _, v := range sources {
+			s, ok := v.(map[string]any)
+			if !ok {
+				continue
+			}
+			var info SourceInfo
+			if id, ok := s["ID"].(string); ok {
+				info.ID, _ = uuid.Parse(id)
+			}
+			info.Name, _ = s["Name"].(string)
+			if t, ok := s["StartTime"].(int64); ok {
+				info.StartTime = time.UnixMilli(t)
+			}
+			if t, ok := s["EndTime"].(int64); ok {
+				info.EndTime = time.UnixMilli(t)
+			}
+			info.WorldName, _ = s["WorldName"].(string)
+			info.Dimension, _ = s["Dimension"].(int32)
+			if offset, ok := s["Offset"].(int32); ok {
+				info.Offset = uint32(offset)
+			}
+			info.Tags = decodeTags(s["Tags"])
+			m.Sources = append(m.Sources, info)
+		}
+	}
+	if id, ok := data["DictionaryID"].(int32); ok {
+		m.DictionaryID = uint32(id)
+	}
+}
+
+// encodeTags encodes tags into a map that may be encoded as NBT.
+func encodeTags(tags map[string]string) map[string]any {
+	encoded := make(map[string]any, len(tags))
+	for k, v := range tags {
+		encoded[k] = v
+	}
+	return encoded
+}
+
+// decodeTags decodes tags encoded using encodeTags. Nil is returned if v does not hold tags.
+func decodeTags(v any) map[string]string {
+	encoded, ok := v.(map[string]any)
+	if !ok {
+		return nil
+	}
+	tags := make(map[string]string, len(encoded))
+	for k, v := range encoded {
+		if s, ok := v.(string); ok {
+			tags[k] = s
+		}
+	}
+	return tags
+}
@@ -0,0 +1,194 @@
+package replay
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/df-mc/dragonfly/server/player/skin"
+	"github.com/df-mc/dragonfly/server/world"
+	"io"
+)
+
+// A replay may be exported as newline-delimited JSON using Data.ExportNDJSON and turned back into a replay
+// using ImportNDJSON. The first line holds the metadata of the replay and its amount of ticks:
+//
+//	{"metadata":{...},"ticks":1200}
+//
+// The chunks of the snapshot of the replay follow, each with its position, the tick it was saved in if
+// that is not 0, and the chunk as stored in the replay, compressed and encoded using base64:
+//
+//	{"chunk":[0,-1],"tick":40,"data":"KLUv/W..."}
+//
+// Every following line holds either an action, with the tick it was recorded in, the name of its type and
+// its fields, or a skin of the skin table, before the first action referring to it:
+//
+//	{"skin":"5f3c...","data":{"SkinWidth":64,...}}
+//	{"tick":1,"action":"PlayerSkinRef","data":{"PlayerID":1,"SkinHash":"5f3c..."}}
+//	{"tick":1,"action":"SetBlock","data":{"Position":[0,64,0],"Block":{"Name":"minecraft:stone"}}}
+//
+// Blocks and items are exported by their name and properties, as described in the documentation of the
+// action package. Keyframes are not exported, as they are created again when the replay is imported.
+
+// ndjsonLine is a single line of a replay exported as NDJSON.
+type ndjsonLine struct {
+	Metadata *Metadata       `json:"metadata,omitempty"`
+	Ticks    uint32          `json:"ticks,omitempty"`
+	Skin     string          `json:"skin,omitempty"`
+	Chunk    *world.ChunkPos `json:"chunk,omitempty"`
+	Tick     uint32          `json:"tick,omitempty"`
+	Action   string          `json:"action,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+}
+
+// ExportNDJSON writes the replay to w as newline-delimited JSON, with one line for every action, so that it
+// may be searched, compared and edited using standard tools. ImportNDJSON turns the JSON back into a
+// replay.
+func (d *Data) ExportNDJSON(w io.Writer) error {
+	d.mu.Lock()
+	meta, totalTicks := d.meta, uint32(d.totalTicks)
+	d.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(ndjsonLine{Metadata: &meta, Ticks: totalTicks}); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	snapshot, err := d.snapshotChunks()
+	if err != nil {
+		return err
+	}
+	for _, s := range snapshot {
+		data, err := json.Marshal(s.compressed)
+		if err != nil {
+			return fmt.Errorf("failed to encode chunk %v: %w", s.pos, err)
+		}
+		if err := enc.Encode(ndjsonLine{Chunk: &s.pos, Tick: s.tick, Data: data}); err != nil {
+			return fmt.Errorf("failed to write chunk: %w", err)
+		}
+	}
+	exported := make(map[[32]byte]struct{})
+	for tick := uint32(1); tick <= totalTicks; tick++ {
+		actions, err := d.Actions(tick)
+		if err != nil {
+			return err
+		}
+		for _, a := range actions {
+			if isKeyframe(a) {
+				continue
+			}
+			if ref, ok := a.(*action.PlayerSkinRef); ok {
+				if _, ok := exported[ref.SkinHash]; !ok {
+					if err := d.exportSkin(enc, ref.SkinHash); err != nil {
+						return err
+					}
+					exported[ref.SkinHash] = struct{}{}
+				}
+			}
+			data, err := json.Marshal(a)
+			if err != nil {
+				return fmt.Errorf("failed to encode %v action at tick %d: %w", action.Name(a), tick, err)
+			}
+			if err := enc.Encode(ndjsonLine{Tick: tick, Action: action.Name(a), Data: data}); err != nil {
+				return fmt.Errorf("failed to write action: %w", err)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// exportSkin writes a line holding the skin with the hash passed.
+func (d *Data) exportSkin(enc *json.Encoder, hash [32]byte) error {
+	sk, err := d.Skin(hash)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(skinToAction(0, sk))
+	if err != nil {
+		return fmt.Errorf("failed to encode skin %x: %w", hash, err)
+	}
+	if err := enc.Encode(ndjsonLine{Skin: hex.EncodeToString(hash[:]), Data: data}); err != nil {
+		return fmt.Errorf("failed to write skin: %w", err)
+	}
+	return nil
+}
+
+// ImportNDJSON reads a replay exported using Data.ExportNDJSON from r and writes it to w as a replay of the
+// current format version. The blocks and items of the actions are looked up by their name in the registry
+// of the server.
+func ImportNDJSON(r io.Reader, w io.Writer) error {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var header ndjsonLine
+	if err := dec.Decode(&header); err != nil {
+		return fmt.Errorf("failed to read metadata: %w", err)
+	}
+	if header.Metadata == nil {
+		return errors.New("first line does not hold metadata")
+	}
+
+	lastTick := header.Ticks
+	actions := make(map[uint32][]action.Action)
+	skins := make(map[[32]byte]skin.Skin)
+	var snapshot []snapshotChunk
+	for n := 2; ; n++ {
+		var line ndjsonLine
+		if err := dec.Decode(&line); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		switch {
+		case line.Skin != "":
+			hash, err := hex.DecodeString(line.Skin)
+			if err != nil || len(hash) != 32 {
+				return fmt.Errorf("line %d: invalid skin hash %q", n, line.Skin)
+			}
+			var sk action.PlayerSkin
+			if err := json.Unmarshal(line.Data, &sk); err != nil {
+				return fmt.Errorf("line %d: %w", n, err)
+			}
+			skins[[32]byte(hash)] = sk.Skin()
+		case line.Chunk != nil:
+			s := snapshotChunk{pos: *line.Chunk, tick: line.Tick}
+			if err := json.Unmarshal(line.Data, &s.compressed); err != nil {
+				return fmt.Errorf("line %d: %w", n, err)
+			}
+			decompressed, err := segmentDecoder.DecodeAll(s.compressed, nil)
+			if err != nil {
+				return fmt.Errorf("line %d: failed to decompress chunk %v: %w", n, s.pos, err)
+			}
+			if _, err := decodeSnapshotChunk(decompressed); err != nil {
+				return fmt.Errorf("line %d: failed to decode chunk %v: %w", n, s.pos, err)
+			}
+			snapshot = append(snapshot, s)
+		case line.Action != "":
+			a, ok := action.ByName(line.Action)
+			if !ok {
+				return fmt.Errorf("line %d: unknown action %q", n, line.Action)
+			}
+			if err := json.Unmarshal(line.Data, a); err != nil {
+				return fmt.Errorf("line %d: %w", n, err)
+			}
+			if ref, ok := a.(*action.PlayerSkinRef); ok {
+				sk, ok := skins[ref.SkinHash]
+				if !ok {
+					return fmt.Errorf("line %d: unknown skin %x", n, ref.SkinHash)
+				}
+				a = skinToAction(ref.PlayerID, sk)
+			}
+			if line.Tick == 0 {
+				return fmt.Errorf("line %d: action has no tick", n)
+			}
+			actions[line.Tick] = append(actions[line.Tick], a)
+			lastTick = max(lastTick, line.Tick)
+		default:
+			return fmt.Errorf("line %d: line holds neither an action, a skin nor a chunk", n)
+		}
+	}
+	return writeReplayWith(w, writeOptions{version: FormatVersion, snapshot: snapshot}, *header.Metadata, lastTick, func(tick uint32) ([]action.Action, error) {
+		return actions[tick], nil
+	})
+}
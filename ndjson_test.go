@@ -0,0 +1,114 @@
+package replay
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/akmalfairuz/df-replay/action"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testSkinActions returns the actions returned by testActions, with the skin of the first player changed
+// every 100 ticks.
+func testSkinActions(tick uint32) ([]action.Action, error) {
+	actions, err := testActions(tick)
+	if tick%100 == 5 {
+		pix := make([]byte, 64*32*4)
+		for i := range pix {
+			pix[i] = byte(i * int(tick))
+		}
+		actions = append(actions, &action.PlayerSkin{PlayerID: 1, SkinWidth: 64, SkinHeight: 32, SkinData: pix, GeometryName: "geometry.humanoid.custom"})
+	}
+	return actions, err
+}
+
+// TestNDJSONRoundTrip tests that a replay exported as NDJSON and imported again holds the same metadata,
+// actions and skins, and that exporting it again results in the same NDJSON.
+func TestNDJSONRoundTrip(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	if err := writeReplayWith(buf, writeOptions{version: FormatVersion, segmentTicks: 50}, testMetadata(), testTicks, testSkinActions); err != nil {
+		t.Fatalf("failed to write replay: %v", err)
+	}
+	d := openTestReplay(t, buf.Bytes(), nil)
+
+	exported := bytes.NewBuffer(nil)
+	if err := d.ExportNDJSON(exported); err != nil {
+		t.Fatalf("failed to export replay: %v", err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(exported.Bytes()))
+	scanner.Buffer(nil, 1<<20)
+	var lines, skins int
+	for scanner.Scan() {
+		var line ndjsonLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("line %d is not valid JSON: %v", lines+1, err)
+		}
+		if line.Skin != "" {
+			skins++
+		}
+		if line.Action == "Keyframe" {
+			t.Fatalf("line %d holds a keyframe", lines+1)
+		}
+		lines++
+	}
+	if skins != 3 {
+		t.Fatalf("export holds %d skins, expected 3", skins)
+	}
+
+	imported := bytes.NewBuffer(nil)
+	if err := ImportNDJSON(bytes.NewReader(exported.Bytes()), imported); err != nil {
+		t.Fatalf("failed to import replay: %v", err)
+	}
+	i := openTestReplay(t, imported.Bytes(), nil)
+	requireActions(t, i, testTicks, d.Actions)
+	for tick := uint32(1); tick <= testTicks; tick++ {
+		actions, _ := i.Actions(tick)
+		for _, a := range actions {
+			ref, ok := a.(*action.PlayerSkinRef)
+			if !ok {
+				continue
+			}
+			got, err := i.Skin(ref.SkinHash)
+			if err != nil {
+				t.Fatalf("failed to read skin of tick %d: %v", tick, err)
+			}
+			want, err := d.Skin(ref.SkinHash)
+			if err != nil || !bytes.Equal(got.Pix, want.Pix) {
+				t.Fatalf("skin of tick %d differs from the skin exported: %v", tick, err)
+			}
+		}
+	}
+	meta := i.Metadata()
+	if meta.ID != d.ID() || !meta.StartTime.Equal(d.Metadata().StartTime) || !slices.Equal(meta.Players, d.Metadata().Players) || meta.Tags["map"] != "lobby" {
+		t.Fatalf("imported metadata %+v differs from %+v", meta, d.Metadata())
+	}
+
+	reexported := bytes.NewBuffer(nil)
+	if err := i.ExportNDJSON(reexported); err != nil {
+		t.Fatalf("failed to export imported replay: %v", err)
+	}
+	if reexported.String() != exported.String() {
+		t.Fatal("exporting the imported replay resulted in different NDJSON")
+	}
+}
+
+// TestNDJSONInvalid tests that importing lines that are not valid fails.
+func TestNDJSONInvalid(t *testing.T) {
+	exported := bytes.NewBuffer(nil)
+	if err := openTestReplay(t, writeTestReplay(t, writeOptions{}), nil).ExportNDJSON(exported); err != nil {
+		t.Fatalf("failed to export replay: %v", err)
+	}
+	first, _, _ := strings.Cut(exported.String(), "\n")
+	for _, input := range []string{
+		"",
+		"not json\n",
+		first + "\n{\"tick\":1,\"action\":\"NoSuchAction\",\"data\":{}}\n",
+		first + "\n{\"tick\":1}\n",
+	} {
+		if err := ImportNDJSON(strings.NewReader(input), bytes.NewBuffer(nil)); err == nil {
+			t.Fatalf("input %q was imported", input)
+		}
+	}
+}
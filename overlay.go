@@ -0,0 +1,150 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"github.com/df-mc/dragonfly/server/block"
+	"github.com/df-mc/dragonfly/server/block/cube"
+	"github.com/df-mc/dragonfly/server/world"
+	"github.com/df-mc/dragonfly/server/world/chunk"
+	"github.com/df-mc/goleveldb/leveldb"
+	"github.com/google/uuid"
+	"io/fs"
+	"sync"
+)
+
+// OverlayProvider is a world.Provider that reads a world from another Provider, but never writes to it. The
+// chunks stored by the world are kept in memory on top of the base Provider instead, so that the changes
+// made to the world, such as those made by a Playback, may be discarded. The settings of the world are not
+// saved either. An OverlayProvider may only be used by a single world.
+type OverlayProvider struct {
+	base world.Provider
+	gen  world.Generator
+
+	mu sync.Mutex
+	// columns holds the chunks stored by the world, which are loaded instead of those of the base Provider.
+	columns map[world.ChunkPos]*chunk.Column
+	// loaded holds the positions of all chunks that the world has loaded, which are the chunks that may have
+	// been changed.
+	loaded map[world.ChunkPos]struct{}
+	spawns map[uuid.UUID]cube.Pos
+}
+
+// Compile time check to make sure OverlayProvider implements world.Provider.
+var _ world.Provider = (*OverlayProvider)(nil)
+
+// NewOverlayProvider creates an OverlayProvider on top of the base world.Provider passed. The base Provider
+// is closed when the OverlayProvider is closed. The world.Generator passed must be the Generator of the
+// world, which is used to restore the chunks that the base Provider does not hold. If nil,
+// world.NopGenerator is assumed.
+func NewOverlayProvider(base world.Provider, gen world.Generator) *OverlayProvider {
+	if gen == nil {
+		gen = world.NopGenerator{}
+	}
+	return &OverlayProvider{
+		base:    base,
+		gen:     gen,
+		columns: make(map[world.ChunkPos]*chunk.Column),
+		loaded:  make(map[world.ChunkPos]struct{}),
+		spawns:  make(map[uuid.UUID]cube.Pos),
+	}
+}
+
+// Discard discards all chunks stored by the world, so that the chunks of the base Provider are loaded again
+// once the world unloads them. Chunks that are loaded at the time of calling are not changed. Use Restore
+// to restore those too.
+func (o *OverlayProvider) Discard() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	clear(o.columns)
+}
+
+// Restore discards all chunks stored by the world and sets every block, liquid and block entity of the
+// chunks loaded in the world that differs from the base Provider back to that of the base Provider, which
+// undoes all changes made to the world. Chunks that the base Provider does not hold are set back to the
+// chunks created by the Generator of the world. The transaction passed must be of the world that the
+// OverlayProvider is used by.
+func (o *OverlayProvider) Restore(tx *world.Tx) error {
+	o.mu.Lock()
+	clear(o.columns)
+	positions := make([]world.ChunkPos, 0, len(o.loaded))
+	for pos := range o.loaded {
+		positions = append(positions, pos)
+	}
+	o.mu.Unlock()
+
+	for _, pos := range positions {
+		col, err := o.base.LoadColumn(pos, tx.World().Dimension())
+		if chunkNotFound(col, err) {
+			// The world generated the chunk when it was first loaded, so it is generated again.
+			col = &chunk.Column{Chunk: chunk.New(world.BlockRuntimeID(block.Air{}), tx.Range())}
+			o.gen.GenerateChunk(pos, col.Chunk)
+		} else if err != nil {
+			return fmt.Errorf("failed to load chunk %v: %w", pos, err)
+		}
+		restoreColumn(tx, pos, col)
+	}
+	return nil
+}
+
+// chunkNotFound checks if the column and error returned by world.Provider.LoadColumn mean that the Provider
+// does not hold the chunk. Providers should return leveldb.ErrNotFound, but some return fs.ErrNotExist or no
+// column without an error instead.
+func chunkNotFound(col *chunk.Column, err error) bool {
+	if err != nil {
+		return errors.Is(err, leveldb.ErrNotFound) || errors.Is(err, fs.ErrNotExist)
+	}
+	return col == nil || col.Chunk == nil
+}
+
+// Settings ...
+func (o *OverlayProvider) Settings() *world.Settings {
+	return o.base.Settings()
+}
+
+// SaveSettings ...
+func (o *OverlayProvider) SaveSettings(*world.Settings) {}
+
+// LoadPlayerSpawnPosition ...
+func (o *OverlayProvider) LoadPlayerSpawnPosition(id uuid.UUID) (cube.Pos, bool, error) {
+	o.mu.Lock()
+	pos, ok := o.spawns[id]
+	o.mu.Unlock()
+	if ok {
+		return pos, true, nil
+	}
+	return o.base.LoadPlayerSpawnPosition(id)
+}
+
+// SavePlayerSpawnPosition ...
+func (o *OverlayProvider) SavePlayerSpawnPosition(id uuid.UUID, pos cube.Pos) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.spawns[id] = pos
+	return nil
+}
+
+// LoadColumn ...
+func (o *OverlayProvider) LoadColumn(pos world.ChunkPos, dim world.Dimension) (*chunk.Column, error) {
+	o.mu.Lock()
+	o.loaded[pos] = struct{}{}
+	col, ok := o.columns[pos]
+	o.mu.Unlock()
+	if ok {
+		return col, nil
+	}
+	return o.base.LoadColumn(pos, dim)
+}
+
+// StoreColumn ...
+func (o *OverlayProvider) StoreColumn(pos world.ChunkPos, _ world.Dimension, col *chunk.Column) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.columns[pos] = col
+	return nil
+}
+
+// Close ...
+func (o *OverlayProvider) Close() error {
+	return o.base.Close()
+}
@@ -0,0 +1,106 @@
+package replay
+
+import (
+	"github.com/df-mc/dragonfly/server/block"
+	"github.com/df-mc/dragonfly/server/block/cube"
+	"github.com/df-mc/dragonfly/server/entity"
+	"github.com/df-mc/dragonfly/server/world"
+	"github.com/df-mc/dragonfly/server/world/chunk"
+	"github.com/df-mc/dragonfly/server/world/mcdb"
+	"testing"
+)
+
+// dirtGenerator is a world.Generator that generates chunks with a layer of dirt at y=63.
+type dirtGenerator struct{}
+
+// GenerateChunk ...
+func (dirtGenerator) GenerateChunk(_ world.ChunkPos, c *chunk.Chunk) {
+	dirt := world.BlockRuntimeID(block.Dirt{})
+	for x := uint8(0); x < 16; x++ {
+		for z := uint8(0); z < 16; z++ {
+			c.SetBlock(x, 63, z, 0, dirt)
+		}
+	}
+}
+
+// overlayBlocks returns the names of the blocks at y=63 and z=0 that the replays written using
+// writeTestReplay change, both in the chunk written by writeTemplate and in the chunk next to it.
+func overlayBlocks(tx *world.Tx, p *Playback) [3]string {
+	var names [3]string
+	for i, x := range []int{templateBlockPos[0], 2, 20} {
+		names[i], _ = p.Block(tx, cube.Pos{x, 63, 0}).EncodeBlock()
+	}
+	return names
+}
+
+// TestOverlay tests that a Playback using an OverlayProvider restores the world to its state before the
+// playback started when it is rewound to the start and when it is closed, both in chunks of the base
+// Provider and in generated chunks, and that nothing is written to the base Provider.
+func TestOverlay(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir)
+	base, err := mcdb.Config{}.Open(dir)
+	if err != nil {
+		t.Fatalf("failed to open template world: %v", err)
+	}
+	overlay := NewOverlayProvider(base, dirtGenerator{})
+	w := world.Config{
+		Provider:        overlay,
+		Generator:       dirtGenerator{},
+		SaveInterval:    -1,
+		RandomTickSpeed: -1,
+		Entities:        entity.DefaultRegistry,
+	}.New()
+	d := openTestReplay(t, writeTestReplay(t, writeOptions{}), nil)
+	p := NewPlayback(w, d)
+	p.SetOverlay(overlay)
+
+	original := [3]string{"minecraft:gold_block", "minecraft:air", "minecraft:dirt"}
+	played := [3]string{"minecraft:stone", "minecraft:stone", "minecraft:stone"}
+	var before, seeked, rewound [3]string
+	var present bool
+	<-w.Exec(func(tx *world.Tx) {
+		before = overlayBlocks(tx, p)
+		p.Seek(tx, testTicks)
+		seeked = overlayBlocks(tx, p)
+		p.Seek(tx, 0)
+		rewound = overlayBlocks(tx, p)
+		_, present = p.PlayerPosition(tx, 1)
+		p.Seek(tx, 100)
+	})
+	if before != original || seeked != played || rewound != original {
+		t.Fatalf("blocks %v before playing, %v after playing and %v after rewinding, expected %v, %v and %v", before, seeked, rewound, original, played, original)
+	}
+	if present {
+		t.Fatal("player is still present after rewinding to the start")
+	}
+
+	// Closing the playback restores the world as well, after which the world only holds the original blocks.
+	p.Close()
+	var closed [3]string
+	<-w.Exec(func(tx *world.Tx) {
+		closed = overlayBlocks(tx, p)
+	})
+	if closed != original {
+		t.Fatalf("blocks %v after closing the playback, expected %v", closed, original)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close world: %v", err)
+	}
+
+	base, err = mcdb.Config{}.Open(dir)
+	if err != nil {
+		t.Fatalf("failed to open template world: %v", err)
+	}
+	defer base.Close()
+	col, err := base.LoadColumn(world.ChunkPos{}, world.Overworld)
+	if err != nil {
+		t.Fatalf("failed to read template world: %v", err)
+	}
+	if got := col.Chunk.Block(uint8(templateBlockPos[0]), 63, 0, 0); got != world.BlockRuntimeID(block.Gold{}) {
+		t.Fatalf("template holds block %d at %v after playing the replay, expected gold", got, templateBlockPos)
+	}
+	if _, err := base.LoadColumn(world.ChunkPos{1, 0}, world.Overworld); !chunkNotFound(nil, err) {
+		t.Fatalf("generated chunk was written to the base provider: %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package replay
+
+import (
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/akmalfairuz/df-replay/internal"
+	"github.com/df-mc/dragonfly/server/block"
+	"github.com/df-mc/dragonfly/server/world"
+	"github.com/df-mc/dragonfly/server/world/chunk"
+	"github.com/df-mc/worldupgrader/blockupgrader"
+	"github.com/df-mc/worldupgrader/itemupgrader"
+	"maps"
+	"sort"
+)
+
+// Palette maps the hashes of the blocks and items used in a replay to their names and properties. Hashes
+// are computed from the block and item registry of the server that recorded the replay, so the palette is
+// stored in the replay to allow the hashes to be resolved on servers with a different registry, such as
+// servers running a newer version of dragonfly.
+type Palette struct {
+	// BlockVersion is the version of the block states held by the palette.
+	BlockVersion int32
+	// Blocks holds the blocks of the palette by their hash.
+	Blocks map[uint32]PaletteBlock
+	// Items holds the items of the palette by their hash.
+	Items map[uint32]PaletteItem
+}
+
+// PaletteBlock is a block state in a Palette.
+type PaletteBlock struct {
+	Name       string
+	Properties map[string]any
+}
+
+// PaletteItem is an item in a Palette.
+type PaletteItem struct {
+	Name string
+	Meta int16
+}
+
+// PaletteReport describes the result of resolving the palette of a replay against the block and item
+// registry of the server.
+type PaletteReport struct {
+	// RemappedBlocks and RemappedItems hold the amount of block and item hashes of the replay that refer to
+	// a different block or item on the server, and that were replaced with the hash used by the server.
+	RemappedBlocks, RemappedItems int
+	// UnresolvedBlocks and UnresolvedItems hold the blocks and items of the palette that do not exist on the
+	// server, even after upgrading them to the current version. They are played back as air.
+	UnresolvedBlocks []PaletteBlock
+	UnresolvedItems  []PaletteItem
+}
+
+// Resolved checks if all blocks and items of the palette were resolved.
+func (r PaletteReport) Resolved() bool {
+	return len(r.UnresolvedBlocks) == 0 && len(r.UnresolvedItems) == 0
+}
+
+// paletteRemap holds the hashes of a replay that must be replaced with the hashes used by the server.
+type paletteRemap struct {
+	blocks, items map[uint32]uint32
+}
+
+// newPalette creates an empty palette for block states of the current version.
+func newPalette() *Palette {
+	return &Palette{
+		BlockVersion: chunk.CurrentBlockVersion,
+		Blocks:       make(map[uint32]PaletteBlock),
+		Items:        make(map[uint32]PaletteItem),
+	}
+}
+
+// add adds the blocks and items that the action passed refers to to the palette, looking up their names
+// and properties in the registry of the server. Hashes that are not known to the server are ignored. True
+// is returned if the palette grew.
+func (p *Palette) add(a action.Action) bool {
+	h, ok := a.(action.Hashed)
+	if !ok {
+		return false
+	}
+	grew := false
+	h.VisitHashes(func(hash *uint32) {
+		if _, ok := p.Blocks[*hash]; ok {
+			return
+		}
+		if b, ok := internal.BlockByHash(*hash); ok {
+			name, properties := b.EncodeBlock()
+			p.Blocks[*hash] = PaletteBlock{Name: name, Properties: properties}
+			grew = true
+		}
+	}, func(hash *uint32) {
+		if _, ok := p.Items[*hash]; ok {
+			return
+		}
+		if it, ok := internal.ItemByHash(*hash); ok {
+			name, meta := it.EncodeItem()
+			p.Items[*hash] = PaletteItem{Name: name, Meta: meta}
+			grew = true
+		}
+	})
+	return grew
+}
+
+// resolve looks up every block and item of the palette in the registry of the server, upgrading blocks and
+// items that were renamed or changed since the replay was recorded. The hashes that must be replaced are
+// returned, along with a report of the blocks and items that could not be resolved.
+func (p *Palette) resolve() (paletteRemap, PaletteReport) {
+	remap := paletteRemap{blocks: make(map[uint32]uint32), items: make(map[uint32]uint32)}
+	var report PaletteReport
+
+	airHash := internal.BlockToHash(block.Air{})
+	for hash, entry := range p.Blocks {
+		b, ok := world.BlockByName(entry.Name, entry.Properties)
+		if !ok {
+			upgraded := blockupgrader.Upgrade(blockupgrader.BlockState{
+				Name:       entry.Name,
+				Properties: maps.Clone(entry.Properties),
+				Version:    p.BlockVersion,
+			})
+			b, ok = world.BlockByName(upgraded.Name, upgraded.Properties)
+		}
+		if !ok {
+			report.UnresolvedBlocks = append(report.UnresolvedBlocks, entry)
+			remap.blocks[hash] = airHash
+			continue
+		}
+		if current := internal.BlockToHash(b); current != hash {
+			report.RemappedBlocks++
+			remap.blocks[hash] = current
+		}
+	}
+
+	airItemHash := internal.ItemToHash(block.Air{})
+	for hash, entry := range p.Items {
+		it, ok := world.ItemByName(entry.Name, entry.Meta)
+		if !ok {
+			upgraded := itemupgrader.Upgrade(itemupgrader.ItemMeta{Name: entry.Name, Meta: entry.Meta})
+			it, ok = world.ItemByName(upgraded.Name, upgraded.Meta)
+		}
+		if !ok {
+			report.UnresolvedItems = append(report.UnresolvedItems, entry)
+			remap.items[hash] = airItemHash
+			continue
+		}
+		if current := internal.ItemToHash(it); current != hash {
+			report.RemappedItems++
+			remap.items[hash] = current
+		}
+	}
+
+	sort.Slice(report.UnresolvedBlocks, func(i, j int) bool {
+		return report.UnresolvedBlocks[i].Name < report.UnresolvedBlocks[j].Name
+	})
+	sort.Slice(report.UnresolvedItems, func(i, j int) bool {
+		a, b := report.UnresolvedItems[i], report.UnresolvedItems[j]
+		return a.Name < b.Name || (a.Name == b.Name && a.Meta < b.Meta)
+	})
+	return remap, report
+}
+
+// empty checks if the remap does not replace any hashes.
+func (m paletteRemap) empty() bool {
+	return len(m.blocks) == 0 && len(m.items) == 0
+}
+
+// apply replaces the hashes held by the action passed.
+func (m paletteRemap) apply(a action.Action) {
+	h, ok := a.(action.Hashed)
+	if !ok {
+		return
+	}
+	h.VisitHashes(func(hash *uint32) {
+		if replacement, ok := m.blocks[*hash]; ok {
+			*hash = replacement
+		}
+	}, func(hash *uint32) {
+		if replacement, ok := m.items[*hash]; ok {
+			*hash = replacement
+		}
+	})
+}
+
+// EncodeNBT encodes the palette into a map that may be encoded as NBT.
+func (p *Palette) EncodeNBT() map[string]any {
+	blocks := make([]any, 0, len(p.Blocks))
+	for _, hash := range sortedHashes(p.Blocks) {
+		b := p.Blocks[hash]
+		properties := b.Properties
+		if properties == nil {
+			properties = map[string]any{}
+		}
+		blocks = append(blocks, map[string]any{
+			"Hash":       int32(hash),
+			"Name":       b.Name,
+			"Properties": properties,
+		})
+	}
+	items := make([]any, 0, len(p.Items))
+	for _, hash := range sortedHashes(p.Items) {
+		it := p.Items[hash]
+		items = append(items, map[string]any{
+			"Hash": int32(hash),
+			"Name": it.Name,
+			"Meta": it.Meta,
+		})
+	}
+	return map[string]any{
+		"BlockVersion": p.BlockVersion,
+		"Blocks":       blocks,
+		"Items":        items,
+	}
+}
+
+// DecodeNBT decodes a palette from a map decoded from NBT. Entries that are malformed are skipped.
+func (p *Palette) DecodeNBT(data map[string]any) {
+	if p.Blocks == nil {
+		p.Blocks = make(map[uint32]PaletteBlock)
+	}
+	if p.Items == nil {
+		p.Items = make(map[uint32]PaletteItem)
+	}
+	if v, ok := data["BlockVersion"].(int32); ok {
+		p.BlockVersion = v
+	}
+	if v, ok := data["Blocks"].([]any); ok {
+		for _, e := range v {
+			m, ok := e.(map[string]any)
+			if !ok {
+				continue
+			}
+			hash, _ := m["Hash"].(int32)
+			name, _ := m["Name"].(string)
+			properties, _ := m["Properties"].(map[string]any)
+			p.Blocks[uint32(hash)] = PaletteBlock{Name: name, Properties: properties}
+		}
+	}
+	if v, ok := data["Items"].([]any); ok {
+		for _, e := range v {
+			m, ok := e.(map[string]any)
+			if !ok {
+				continue
+			}
+			hash, _ := m["Hash"].(int32)
+			name, _ := m["Name"].(string)
+			meta, _ := m["Meta"].(int16)
+			p.Items[uint32(hash)] = PaletteItem{Name: name, Meta: meta}
+		}
+	}
+}
+
+// sortedHashes returns the hashes of the map passed in ascending order, so that the same palette is always
+// encoded the same way.
+func sortedHashes[V any](m map[uint32]V) []uint32 {
+	hashes := make([]uint32, 0, len(m))
+	for hash := range m {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	return hashes
+}
@@ -0,0 +1,53 @@
+package replay
+
+import (
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/akmalfairuz/df-replay/internal"
+	"github.com/df-mc/dragonfly/server/block"
+	"github.com/df-mc/dragonfly/server/item"
+	"testing"
+)
+
+// TestPaletteResolve tests that blocks and items of a palette that exist on the server under another hash
+// are remapped to it, and that blocks and items that do not exist are reported and played back as air.
+func TestPaletteResolve(t *testing.T) {
+	stone, apple := internal.BlockToHash(block.Stone{}), internal.ItemToHash(item.Apple{})
+	p := newPalette()
+	p.add(&action.SetBlock{Block: action.FromBlock(block.Stone{})})
+	p.Blocks[1] = p.Blocks[stone]
+	p.Blocks[2] = PaletteBlock{Name: "minecraft:not_a_block", Properties: map[string]any{}}
+	p.Items[3] = PaletteItem{Name: "minecraft:apple"}
+	p.Items[4] = PaletteItem{Name: "minecraft:not_an_item", Meta: 1}
+
+	// Encoding and decoding the palette does not change how it resolves.
+	decoded := &Palette{}
+	decoded.DecodeNBT(p.EncodeNBT())
+	remap, report := decoded.resolve()
+	if report.Resolved() || len(report.UnresolvedBlocks) != 1 || report.UnresolvedBlocks[0].Name != "minecraft:not_a_block" {
+		t.Fatalf("unresolved blocks %v, expected only the unknown block", report.UnresolvedBlocks)
+	}
+	if len(report.UnresolvedItems) != 1 || report.UnresolvedItems[0] != p.Items[4] {
+		t.Fatalf("unresolved items %v, expected only the unknown item", report.UnresolvedItems)
+	}
+	if report.RemappedBlocks != 1 || report.RemappedItems != 1 {
+		t.Fatalf("remapped %d blocks and %d items, expected one of each", report.RemappedBlocks, report.RemappedItems)
+	}
+
+	air, airItem := internal.BlockToHash(block.Air{}), internal.ItemToHash(block.Air{})
+	blocks := map[uint32]uint32{stone: stone, 1: stone, 2: air}
+	for hash, want := range blocks {
+		a := &action.SetBlock{Block: action.Block{Hash: hash}}
+		remap.apply(a)
+		if a.Block.Hash != want {
+			t.Fatalf("block hash %d was remapped to %d, expected %d", hash, a.Block.Hash, want)
+		}
+	}
+	items := map[uint32]uint32{3: apple, 4: airItem}
+	for hash, want := range items {
+		a := &action.PlayerHandChange{MainHand: action.Item{Hash: hash}}
+		remap.apply(a)
+		if a.MainHand.Hash != want {
+			t.Fatalf("item hash %d was remapped to %d, expected %d", hash, a.MainHand.Hash, want)
+		}
+	}
+}
@@ -31,34 +31,77 @@ type Playback struct {
 	speed        float64
 	reverse      bool
 	ended        bool
+	err          error
+	// seeking is true while ticks are played to seek to a tick, during which no particles, sounds or
+	// animations are shown.
+	seeking bool
 
 	closed  atomic.Bool
 	running sync.WaitGroup
 	once    sync.Once
 	closing chan struct{}
 
-	players         map[uint32]*Player
-	entities        map[uint32]*Entity
-	skins           map[uint32]skin.Skin
+	players  map[uint32]*Player
+	entities map[uint32]*Entity
+	skins    map[uint32]skin.Skin
+	// skinRefs holds the hashes of the skins of players that were set using a PlayerSkinRef. These skins are
+	// only read from the skin table of the replay once they are needed.
+	skinRefs        map[uint32][32]byte
 	reverseHandlers map[uint32][]func(ctx *action.PlayContext)
 	chestState      map[cube.Pos]bool
+	// originalBlocks and originalLiquids hold the blocks and liquids found in the world before they were
+	// first changed by the playback.
+	originalBlocks  map[cube.Pos]world.Block
+	originalLiquids map[cube.Pos]world.Liquid
+	// sources holds the sources of a merged replay that are played, or nil if all sources are played.
+	sources map[uint32]struct{}
+	// snapshot holds the chunks of the snapshot of the replay sorted by the tick they were saved in, without
+	// their compressed data, of which the first snapshotRestored are restored in the world. snapshotOriginals
+	// holds the chunks saved after the first tick as they were before they were restored.
+	snapshot          []snapshotChunk
+	snapshotRestored  int
+	snapshotOriginals map[world.ChunkPos][]byte
+	// ownsWorld is true if the world was created for the playback by PlaybackWorldConfig.New, in which case
+	// it is closed along with the playback.
+	ownsWorld bool
+	// overlay is the OverlayProvider of the world, used to restore the world when the playback is rewound to
+	// the start and when it is closed. It is nil if not set using SetOverlay.
+	overlay *OverlayProvider
+	// playingTick is the tick of which the actions are currently played or reversed. Once a tick is
+	// reversed, it is the tick before it.
+	playingTick uint
+	// time is the time of the world during timeTick as set by the replay, which advances every tick if
+	// timeCycle is true. shownTime is the time that the world was last set to.
+	time      int64
+	timeTick  uint
+	timeCycle bool
+	shownTime int64
+	// originalTime and originalWeather hold the time and weather of the world before they were first set by
+	// the playback, or nil if the playback did not set them.
+	originalTime    *action.SetTime
+	originalWeather *action.SetWeather
 }
 
 // Compile time check to ensure that Playback implements action.Playback.
 var _ action.Playback = (*Playback)(nil)
 
-// NewPlayback creates a new playback instance with the given world and data.
+// NewPlayback creates a new playback instance with the given world and data. If the replay holds a snapshot,
+// its chunks are restored in the world before the actions of the tick they were saved in are played.
 func NewPlayback(w *world.World, data *Data) *Playback {
 	return &Playback{
-		w:               w,
-		data:            data,
-		players:         make(map[uint32]*Player),
-		entities:        make(map[uint32]*Entity),
-		skins:           make(map[uint32]skin.Skin),
-		reverseHandlers: make(map[uint32][]func(ctx *action.PlayContext)),
-		closing:         make(chan struct{}),
-		speed:           1.0,
-		chestState:      make(map[cube.Pos]bool, 16),
+		w:                 w,
+		data:              data,
+		players:           make(map[uint32]*Player),
+		entities:          make(map[uint32]*Entity),
+		skins:             make(map[uint32]skin.Skin),
+		skinRefs:          make(map[uint32][32]byte),
+		reverseHandlers:   make(map[uint32][]func(ctx *action.PlayContext)),
+		closing:           make(chan struct{}),
+		speed:             1.0,
+		chestState:        make(map[cube.Pos]bool, 16),
+		originalBlocks:    make(map[cube.Pos]world.Block),
+		originalLiquids:   make(map[cube.Pos]world.Liquid),
+		snapshotOriginals: make(map[world.ChunkPos][]byte),
 	}
 }
 
@@ -105,6 +148,9 @@ func (w *Playback) UpdateChestState(tx *world.Tx, pos cube.Pos, open bool) {
 	for _, v := range tx.Viewers(pos.Vec3Centre()) {
 		if open {
 			v.ViewBlockAction(pos, block.OpenAction{})
+			if w.seeking {
+				continue
+			}
 			if isEnderChest {
 				v.ViewSound(pos.Vec3Centre(), sound.EnderChestOpen{})
 			} else {
@@ -112,6 +158,9 @@ func (w *Playback) UpdateChestState(tx *world.Tx, pos cube.Pos, open bool) {
 			}
 		} else {
 			v.ViewBlockAction(pos, block.CloseAction{})
+			if w.seeking {
+				continue
+			}
 			if isEnderChest {
 				v.ViewSound(pos.Vec3Centre(), sound.EnderChestClose{})
 			} else {
@@ -138,6 +187,9 @@ func (w *Playback) Liquid(tx *world.Tx, pos cube.Pos) (world.Liquid, bool) {
 }
 
 func (w *Playback) SetLiquid(tx *world.Tx, pos cube.Pos, l world.Liquid) {
+	if _, ok := w.originalLiquids[pos]; !ok {
+		w.originalLiquids[pos], _ = tx.Liquid(pos)
+	}
 	tx.SetLiquid(pos, l)
 }
 
@@ -273,8 +325,24 @@ func (w *Playback) EntityExtraData(id uint32) (map[string]any, bool) {
 }
 
 func (w *Playback) PlayerSkin(id uint32) (skin.Skin, bool) {
-	s, ok := w.skins[id]
-	return s, ok
+	if s, ok := w.skins[id]; ok {
+		return s, true
+	}
+	hash, ok := w.skinRefs[id]
+	if !ok {
+		return skin.Skin{}, false
+	}
+	s, err := w.data.Skin(hash)
+	if err != nil {
+		return skin.Skin{}, false
+	}
+	w.skins[id] = s
+	return s, true
+}
+
+func (w *Playback) PlayerSkinRef(id uint32) ([32]byte, bool) {
+	hash, ok := w.skinRefs[id]
+	return hash, ok
 }
 
 func (w *Playback) PlayerPosition(tx *world.Tx, id uint32) (mgl64.Vec3, bool) {
@@ -354,6 +422,9 @@ func (w *Playback) MovePlayer(tx *world.Tx, id uint32, pos mgl64.Vec3, rot cube.
 }
 
 func (w *Playback) SetBlock(tx *world.Tx, pos cube.Pos, b world.Block) {
+	if _, ok := w.originalBlocks[pos]; !ok {
+		w.originalBlocks[pos] = tx.Block(pos)
+	}
 	tx.SetBlock(pos, b, &world.SetOpts{
 		DisableBlockUpdates:       true,
 		DisableLiquidDisplacement: true,
@@ -381,7 +452,7 @@ func (w *Playback) SpawnPlayer(tx *world.Tx, username, nameTag string, id uint32
 	conf.Armour.Set(armour[0], armour[1], armour[2], armour[3])
 	_ = conf.Inventory.SetItem(0, heldItems[0])
 	_ = conf.OffHand.SetItem(0, heldItems[1])
-	if s, ok := w.skins[id]; ok {
+	if s, ok := w.PlayerSkin(id); ok {
 		conf.Skin = s
 	}
 	h := opts.New(playerType, conf)
@@ -445,6 +516,9 @@ func (w *Playback) UpdatePlayerArmor(tx *world.Tx, id uint32, helmet, chestplate
 }
 
 func (w *Playback) DoPlayerSwingArm(tx *world.Tx, id uint32) {
+	if w.seeking {
+		return
+	}
 	p, ok := w.openPlayer(tx, id)
 	if !ok {
 		return
@@ -481,15 +555,22 @@ func (w *Playback) SetPlayerUsingItem(tx *world.Tx, id uint32, usingItem bool) {
 }
 
 func (w *Playback) AddParticle(tx *world.Tx, pos mgl64.Vec3, p world.Particle) {
+	if w.seeking {
+		return
+	}
 	tx.AddParticle(pos, p)
 }
 
 func (w *Playback) PlaySound(tx *world.Tx, pos mgl64.Vec3, s world.Sound) {
+	if w.seeking {
+		return
+	}
 	tx.PlaySound(pos, s)
 }
 
 func (w *Playback) UpdatePlayerSkin(tx *world.Tx, id uint32, skin skin.Skin) {
 	w.skins[id] = skin
+	delete(w.skinRefs, id)
 
 	p, ok := w.openPlayer(tx, id)
 	if !ok {
@@ -498,7 +579,23 @@ func (w *Playback) UpdatePlayerSkin(tx *world.Tx, id uint32, skin skin.Skin) {
 	p.SetSkin(skin)
 }
 
+func (w *Playback) UpdatePlayerSkinRef(tx *world.Tx, id uint32, hash [32]byte) {
+	w.skinRefs[id] = hash
+	delete(w.skins, id)
+
+	p, ok := w.openPlayer(tx, id)
+	if !ok {
+		return
+	}
+	if s, ok := w.PlayerSkin(id); ok {
+		p.SetSkin(s)
+	}
+}
+
 func (w *Playback) Emote(tx *world.Tx, id uint32, emoteId uuid.UUID) {
+	if w.seeking {
+		return
+	}
 	p, ok := w.openPlayer(tx, id)
 	if !ok {
 		return
@@ -610,18 +707,27 @@ func (w *Playback) PlayerSprinting(tx *world.Tx, id uint32) bool {
 }
 
 func (w *Playback) StartCrackBlock(tx *world.Tx, pos cube.Pos, duration time.Duration) {
+	if w.seeking {
+		return
+	}
 	for _, v := range tx.Viewers(pos.Vec3Centre()) {
 		v.ViewBlockAction(pos, block.StartCrackAction{BreakTime: duration})
 	}
 }
 
 func (w *Playback) StopCrackBlock(tx *world.Tx, pos cube.Pos) {
+	if w.seeking {
+		return
+	}
 	for _, v := range tx.Viewers(pos.Vec3Centre()) {
 		v.ViewBlockAction(pos, block.StopCrackAction{})
 	}
 }
 
 func (w *Playback) ContinueCrackBlock(tx *world.Tx, pos cube.Pos, duration time.Duration) {
+	if w.seeking {
+		return
+	}
 	for _, v := range tx.Viewers(pos.Vec3Centre()) {
 		v.ViewBlockAction(pos, block.ContinueCrackAction{BreakTime: duration})
 	}
@@ -709,6 +815,9 @@ func (w *Playback) DoPlayerEnchantedHit(tx *world.Tx, id uint32) {
 }
 
 func (w *Playback) doPlayerAction(tx *world.Tx, id uint32, action world.EntityAction) {
+	if w.seeking {
+		return
+	}
 	p, ok := w.openPlayer(tx, id)
 	if !ok {
 		return
@@ -727,6 +836,9 @@ func (w *Playback) DoArrowShake(tx *world.Tx, id uint32) {
 }
 
 func (w *Playback) doEntityAction(tx *world.Tx, id uint32, action world.EntityAction) {
+	if w.seeking {
+		return
+	}
 	e, ok := w.openEntity(tx, id)
 	if !ok {
 		return
@@ -802,28 +914,47 @@ func (w *Playback) Tick(tx *world.Tx) {
 		// Play the next tick and update counter
 		w.playTick(tx, w.playbackTick+1)
 		w.playbackTick++
+		w.showTime(tx)
 		return
 	}
 
 	// Handle reverse playback
 	// Check if we've reached the beginning
-	if int(w.playbackTick)-1 < 0 {
+	if w.playbackTick == 0 {
 		return
 	}
 
 	// Play the previous tick and update counter
-	w.reverseTick(tx, w.playbackTick)
+	if !w.reverseTick(tx, w.playbackTick) {
+		// The tick was not played forward since the last seek, so it cannot be reversed. Seeking to the tick
+		// before it plays the ticks from the nearest keyframe, which allows reversing them afterwards.
+		w.Seek(tx, int(w.playbackTick)-1)
+		return
+	}
 	w.playbackTick--
+	w.restoreSnapshot(tx, w.playbackTick)
+	w.showTime(tx)
 }
 
 // playTick executes all actions for the specified tick and stores reverse handlers.
 func (w *Playback) playTick(tx *world.Tx, tick uint) {
-	actions, ok := w.data.actions[uint32(tick)]
-	if !ok {
+	w.restoreSnapshot(tx, tick)
+	w.playingTick = tick
+	actions, err := w.data.Actions(uint32(tick))
+	if err != nil {
+		w.err = err
+		w.ended = true
 		return
 	}
 	reverseHandlers := make([]func(ctx *action.PlayContext), 0, len(actions))
+	source := uint32(0)
 	for _, a := range actions {
+		if src, ok := a.(*action.SetSource); ok {
+			source = src.Source
+		}
+		if !w.includeSource(source) {
+			continue
+		}
 		playCtx := action.NewPlayContext(tx, w)
 		a.Play(playCtx)
 		reverseHandler, hasReverseHandler := playCtx.ReverseHandler()
@@ -831,26 +962,33 @@ func (w *Playback) playTick(tx *world.Tx, tick uint) {
 			reverseHandlers = append(reverseHandlers, reverseHandler)
 		}
 	}
-	w.reverseHandlers[uint32(tick-1)] = reverseHandlers
+	w.reverseHandlers[uint32(tick)] = reverseHandlers
 }
 
-// reverseTick executes the reverse handlers for the specified tick.
-func (w *Playback) reverseTick(tx *world.Tx, tick uint) {
+// reverseTick executes the reverse handlers for the specified tick. False is returned if the tick was not
+// played, in which case its reverse handlers are unknown.
+func (w *Playback) reverseTick(tx *world.Tx, tick uint) bool {
 	reverseHandlers, ok := w.reverseHandlers[uint32(tick)]
 	if !ok {
-		return
+		return false
 	}
+	w.playingTick = tick
 
 	for i := len(reverseHandlers) - 1; i >= 0; i-- {
 		h := reverseHandlers[i]
 		playCtx := action.NewPlayContext(tx, w)
 		h(playCtx)
 	}
+	// The scene is back at the end of the tick before, so the time is no longer that of the tick reversed.
+	w.playingTick = tick - 1
 
 	delete(w.reverseHandlers, uint32(tick))
+	return true
 }
 
-// Close stops the playback and releases resources.
+// Close stops the playback and releases resources. If the world of the playback was created using
+// PlaybackWorldConfig.New, it is closed too, so Close must not be called from a transaction of that world.
+// If an OverlayProvider was set using SetOverlay, the world is restored instead.
 func (w *Playback) Close() {
 	w.once.Do(w.doClose)
 }
@@ -860,6 +998,40 @@ func (w *Playback) doClose() {
 	close(w.closing)
 	w.running.Wait()
 	w.closed.Store(true)
+	if w.ownsWorld {
+		_ = w.w.Close()
+	} else if w.overlay != nil {
+		<-w.w.Exec(w.restoreWorld)
+	}
+}
+
+// World returns the world that the playback is played in.
+func (w *Playback) World() *world.World {
+	return w.w
+}
+
+// SetOverlay sets the OverlayProvider that the world of the playback uses. Once set, the world is restored to
+// its state before the playback started whenever the playback is rewound to tick 0 and when the playback is
+// closed, in which case Close must not be called from a transaction of the world.
+func (w *Playback) SetOverlay(o *OverlayProvider) {
+	w.overlay = o
+}
+
+// restoreWorld resets the scene to the start of the replay and restores all blocks of the world using the
+// overlay, which also undoes the snapshot of the replay.
+func (w *Playback) restoreWorld(tx *world.Tx) {
+	clear(w.reverseHandlers)
+	w.restoreScene(tx, &action.Keyframe{}, 0)
+	if err := w.overlay.Restore(tx); err != nil {
+		w.err = err
+		w.ended = true
+		return
+	}
+	clear(w.originalBlocks)
+	clear(w.originalLiquids)
+	// The snapshot is restored again once the first tick is played.
+	w.snapshotRestored = 0
+	clear(w.snapshotOriginals)
 }
 
 // Reversed returns true if the playback is in reverse.
@@ -888,6 +1060,34 @@ func (w *Playback) Paused() bool {
 	return w.paused
 }
 
+// SetSources limits the playback of a merged replay to the sources passed, as numbered by action.SetSource.
+// The actions of the other sources are not played. If no sources are passed, all sources are played. The
+// scene is restored to match the sources passed at the current tick.
+func (w *Playback) SetSources(tx *world.Tx, sources ...uint32) {
+	if len(sources) == 0 {
+		w.sources = nil
+	} else {
+		w.sources = make(map[uint32]struct{}, len(sources))
+		for _, src := range sources {
+			w.sources[src] = struct{}{}
+		}
+	}
+	if w.playbackTick > 0 {
+		w.seeking = true
+		w.reload(tx, w.playbackTick)
+		w.seeking = false
+	}
+}
+
+// includeSource checks if the actions of the source passed are played.
+func (w *Playback) includeSource(source uint32) bool {
+	if w.sources == nil {
+		return true
+	}
+	_, ok := w.sources[source]
+	return ok
+}
+
 // Speed returns the current playback speed.
 func (w *Playback) Speed() float64 {
 	return w.speed
@@ -905,28 +1105,18 @@ func (w *Playback) SetSpeed(speed float64) {
 
 // FastForward moves the playback forward by the given number of ticks.
 func (w *Playback) FastForward(tx *world.Tx, ticks int) {
-	untilTick := uint(min(int(w.playbackTick)+ticks, int(w.data.totalTicks)))
-
-	for i := w.playbackTick + 1; i < untilTick; i++ {
-		w.playTick(tx, i)
-		w.playbackTick++
-	}
+	w.Seek(tx, int(w.playbackTick)+ticks)
 }
 
 // Rewind moves the playback backward by the given number of ticks.
 func (w *Playback) Rewind(tx *world.Tx, ticks int) {
-	if w.playbackTick == 0 {
-		return
-	}
-	untilTick := uint(max(int(w.playbackTick)-ticks, 0))
+	w.Seek(tx, int(w.playbackTick)-ticks)
+}
 
-	for i := w.playbackTick - 1; i > untilTick; i-- {
-		w.reverseTick(tx, i)
-		if w.playbackTick == 0 {
-			break
-		}
-		w.playbackTick--
-	}
+// Err returns the error that ended the playback, such as a segment of the replay that could not be
+// decoded, or nil if no such error occurred.
+func (w *Playback) Err() error {
+	return w.err
 }
 
 // PlaybackTick returns the current tick of the playback.
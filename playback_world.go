@@ -0,0 +1,198 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"github.com/df-mc/dragonfly/server/block/cube"
+	"github.com/df-mc/dragonfly/server/entity"
+	"github.com/df-mc/dragonfly/server/world"
+	"github.com/df-mc/dragonfly/server/world/chunk"
+	"github.com/df-mc/dragonfly/server/world/mcdb"
+	"github.com/df-mc/goleveldb/leveldb"
+	"github.com/df-mc/goleveldb/leveldb/opt"
+	"github.com/google/uuid"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// PlaybackWorldConfig holds the settings of a world created to play a replay in using PlaybackWorldConfig.New.
+type PlaybackWorldConfig struct {
+	// Log is the Logger used by the world. If nil, slog.Default() is used.
+	Log *slog.Logger
+	// Template is the directory of a world that the chunks of the world are copied from. All chunks of the
+	// dimension that the replay was recorded in are copied into memory when the world is created, so the
+	// template should only hold the area that the replay was recorded in. Nothing is ever written to the template.
+	// The snapshot of the replay, if it has one, is still restored over the template once playback starts.
+	// If empty, the world is made up of the chunks of the snapshot of the replay saved before the first tick,
+	// and empty chunks elsewhere. Chunks of the snapshot saved later are restored once their tick is played.
+	Template string
+	// Entities is the registry of the entity types that may be loaded from the template. If empty,
+	// entity.DefaultRegistry is used. Lightning struck by the world during thunderstorms of the replay never
+	// deals damage or sets fire, regardless of the registry.
+	Entities world.EntityRegistry
+}
+
+// New creates a world that is only held in memory and a Playback that plays the replay passed in it, so that
+// several replays may be played at the same time without changing any world that is shared. The world is
+// closed when the Playback is closed, after which all changes made to it are lost. Players watching the
+// replay must be moved to another world before closing the Playback, as the entities left in the world are
+// closed with it. The world is returned by Playback.World.
+func (conf PlaybackWorldConfig) New(data *Data) (*Playback, error) {
+	if conf.Log == nil {
+		conf.Log = slog.Default()
+	}
+	if len(conf.Entities.Types()) == 0 {
+		conf.Entities = entity.DefaultRegistry
+	}
+	reg := conf.Entities.Config()
+	reg.Lightning = func(opts world.EntitySpawnOpts) *world.EntityHandle {
+		return entity.NewLightningWithDamage(opts, 0, false, 0)
+	}
+	conf.Entities = reg.New(conf.Entities.Types())
+
+	meta := data.Metadata()
+	dim, ok := world.DimensionByID(int(meta.Dimension))
+	if !ok {
+		return nil, fmt.Errorf("replay was recorded in unknown dimension %d", meta.Dimension)
+	}
+	// The world is only used to play the replay in, so time, weather and blocks are never ticked.
+	settings := &world.Settings{Name: meta.WorldName, DefaultGameMode: world.GameModeSpectator}
+
+	prov := &memoryProvider{settings: settings, columns: make(map[world.ChunkPos]*chunk.Column), spawns: make(map[uuid.UUID]cube.Pos)}
+	if conf.Template != "" {
+		spawn, err := prov.copyTemplate(conf.Template, dim, conf.Log)
+		if err != nil {
+			return nil, err
+		}
+		settings.Spawn = spawn
+	} else {
+		for _, pos := range data.SnapshotChunks() {
+			if tick, _ := data.SnapshotTick(pos); tick != 0 {
+				continue
+			}
+			col, err := data.SnapshotChunk(pos)
+			if err != nil {
+				return nil, err
+			}
+			prov.columns[pos] = col
+		}
+	}
+
+	w := world.Config{
+		Log:             conf.Log,
+		Dim:             dim,
+		Provider:        prov,
+		SaveInterval:    -1,
+		RandomTickSpeed: -1,
+		Entities:        conf.Entities,
+	}.New()
+	playback := NewPlayback(w, data)
+	playback.ownsWorld = true
+	return playback, nil
+}
+
+// memoryProvider is a world.Provider that keeps all data of a world in memory. Chunks stored are kept as
+// they are, so a memoryProvider may only be used by a single world.
+type memoryProvider struct {
+	mu       sync.Mutex
+	settings *world.Settings
+	columns  map[world.ChunkPos]*chunk.Column
+	spawns   map[uuid.UUID]cube.Pos
+}
+
+// Compile time check to make sure memoryProvider implements world.Provider.
+var _ world.Provider = (*memoryProvider)(nil)
+
+// copyTemplate copies all chunks of the dimension passed from the world in the directory passed into the
+// memoryProvider. The spawn position of the world is returned.
+func (m *memoryProvider) copyTemplate(dir string, dim world.Dimension, log *slog.Logger) (cube.Pos, error) {
+	// mcdb creates a new world if none exists in the directory, so the directory is checked first.
+	level, err := os.ReadFile(filepath.Join(dir, "level.dat"))
+	if err != nil {
+		return cube.Pos{}, fmt.Errorf("failed to open template world: %w", err)
+	}
+	// mcdb writes the level.dat of a world when it is closed, so the world is opened from a temporary
+	// directory holding a copy of the level.dat and a link to the database of the template instead.
+	tmp, err := os.MkdirTemp("", "df-replay-template-*")
+	if err != nil {
+		return cube.Pos{}, fmt.Errorf("failed to open template world: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	ldb, err := filepath.Abs(filepath.Join(dir, "db"))
+	if err != nil {
+		return cube.Pos{}, fmt.Errorf("failed to open template world: %w", err)
+	}
+	if err := errors.Join(os.WriteFile(filepath.Join(tmp, "level.dat"), level, 0644), os.Symlink(ldb, filepath.Join(tmp, "db"))); err != nil {
+		return cube.Pos{}, fmt.Errorf("failed to open template world: %w", err)
+	}
+	db, err := mcdb.Config{Log: log, LDBOptions: &opt.Options{ReadOnly: true}}.Open(tmp)
+	if err != nil {
+		return cube.Pos{}, fmt.Errorf("failed to open template world: %w", err)
+	}
+	defer db.Close()
+
+	iter := db.NewColumnIterator(&mcdb.IteratorRange{Dimension: dim})
+	defer iter.Release()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for iter.Next() {
+		m.columns[iter.Position()] = iter.Column()
+	}
+	if err := iter.Error(); err != nil {
+		return cube.Pos{}, fmt.Errorf("failed to read template world: %w", err)
+	}
+	return db.Settings().Spawn, nil
+}
+
+// Settings ...
+func (m *memoryProvider) Settings() *world.Settings {
+	return m.settings
+}
+
+// SaveSettings ...
+func (m *memoryProvider) SaveSettings(*world.Settings) {}
+
+// LoadPlayerSpawnPosition ...
+func (m *memoryProvider) LoadPlayerSpawnPosition(id uuid.UUID) (cube.Pos, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pos, ok := m.spawns[id]
+	return pos, ok, nil
+}
+
+// SavePlayerSpawnPosition ...
+func (m *memoryProvider) SavePlayerSpawnPosition(id uuid.UUID, pos cube.Pos) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spawns[id] = pos
+	return nil
+}
+
+// LoadColumn ...
+func (m *memoryProvider) LoadColumn(pos world.ChunkPos, _ world.Dimension) (*chunk.Column, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	col, ok := m.columns[pos]
+	if !ok {
+		return nil, leveldb.ErrNotFound
+	}
+	return col, nil
+}
+
+// StoreColumn ...
+func (m *memoryProvider) StoreColumn(pos world.ChunkPos, _ world.Dimension, col *chunk.Column) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.columns[pos] = col
+	return nil
+}
+
+// Close ...
+func (m *memoryProvider) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.columns)
+	return nil
+}
@@ -0,0 +1,109 @@
+package replay
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/df-mc/dragonfly/server/block"
+	"github.com/df-mc/dragonfly/server/block/cube"
+	"github.com/df-mc/dragonfly/server/world"
+	"github.com/df-mc/dragonfly/server/world/chunk"
+	"github.com/df-mc/dragonfly/server/world/mcdb"
+	"github.com/df-mc/goleveldb/leveldb/opt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// templateBlockPos is the position of the gold block in the world written by writeTemplate, which is
+// replaced with stone by the replays written using writeTestReplay.
+var templateBlockPos = cube.Pos{1, 63, 0}
+
+// writeTemplate writes a world holding a single chunk with a gold block at templateBlockPos to the directory
+// passed.
+func writeTemplate(t *testing.T, dir string) {
+	t.Helper()
+	db, err := mcdb.Config{}.Open(dir)
+	if err != nil {
+		t.Fatalf("failed to create template world: %v", err)
+	}
+	c := chunk.New(world.BlockRuntimeID(block.Air{}), world.Overworld.Range())
+	c.SetBlock(uint8(templateBlockPos[0]), int16(templateBlockPos[1]), uint8(templateBlockPos[2]), 0, world.BlockRuntimeID(block.Gold{}))
+	if err := db.StoreColumn(world.ChunkPos{}, world.Overworld, &chunk.Column{Chunk: c}); err != nil {
+		t.Fatalf("failed to write template world: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed to close template world: %v", err)
+	}
+}
+
+// dirState returns the contents and modification times of all files in the directory passed by their path.
+func dirState(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	state := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil || e.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		state[path] = fmt.Sprintf("%v %x", info.ModTime().Format(time.RFC3339Nano), sha256.Sum256(b))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read directory: %v", err)
+	}
+	return state
+}
+
+// TestPlaybackWorldTemplate tests that a playback world holds the chunks of its template, that the replay
+// played in it changes them, and that nothing is written to the template when the world is created or
+// closed.
+func TestPlaybackWorldTemplate(t *testing.T) {
+	dir := t.TempDir()
+	writeTemplate(t, dir)
+	before := dirState(t, dir)
+
+	d := openTestReplay(t, writeTestReplay(t, writeOptions{}), nil)
+	p, err := PlaybackWorldConfig{Template: dir}.New(d)
+	if err != nil {
+		t.Fatalf("failed to create playback world: %v", err)
+	}
+	var initial, played world.Block
+	<-p.World().Exec(func(tx *world.Tx) {
+		initial = p.Block(tx, templateBlockPos)
+		p.Seek(tx, testTicks)
+		played = p.Block(tx, templateBlockPos)
+	})
+	p.Close()
+	if _, ok := initial.(block.Gold); !ok {
+		t.Fatalf("playback world holds %#v at %v, expected the gold block of the template", initial, templateBlockPos)
+	}
+	if _, ok := played.(block.Stone); !ok {
+		t.Fatalf("playback world holds %#v at %v after playing the replay, expected stone", played, templateBlockPos)
+	}
+
+	if after := dirState(t, dir); !maps.Equal(before, after) {
+		t.Fatalf("template was changed by the playback world:\n%v\nexpected:\n%v", after, before)
+	}
+	db, err := mcdb.Config{LDBOptions: &opt.Options{ReadOnly: true}}.Open(dir)
+	if err != nil {
+		t.Fatalf("failed to open template world: %v", err)
+	}
+	defer db.Close()
+	col, err := db.LoadColumn(world.ChunkPos{}, world.Overworld)
+	if err != nil {
+		t.Fatalf("failed to read template world: %v", err)
+	}
+	if got := col.Chunk.Block(uint8(templateBlockPos[0]), int16(templateBlockPos[1]), uint8(templateBlockPos[2]), 0); got != world.BlockRuntimeID(block.Gold{}) {
+		t.Fatalf("template holds block %d at %v after playing the replay, expected gold", got, templateBlockPos)
+	}
+}
@@ -2,7 +2,6 @@ package replay
 
 import (
 	"bytes"
-	"encoding/binary"
 	"github.com/akmalfairuz/df-replay/action"
 	"github.com/akmalfairuz/df-replay/internal"
 	"github.com/df-mc/dragonfly/server/block"
@@ -13,24 +12,124 @@ import (
 	"github.com/df-mc/dragonfly/server/player/skin"
 	"github.com/df-mc/dragonfly/server/session"
 	"github.com/df-mc/dragonfly/server/world"
-	"github.com/go-gl/mathgl/mgl32"
 	"github.com/go-gl/mathgl/mgl64"
 	"github.com/google/uuid"
 	"github.com/klauspost/compress/zstd"
 	"github.com/samber/lo"
 	"github.com/sandertv/gophertunnel/minecraft/protocol"
 	"io"
+	"os"
+	"slices"
 	"sync"
 	"time"
 )
 
+// recordedSegment is a compressed segment of ticks that has been recorded.
+type recordedSegment struct {
+	firstTick, lastTick uint32
+	data                []byte
+	// tracks holds the compressed movements split off the segment, or nil if the movements are part of data.
+	tracks []byte
+}
+
+// writeRecordedSegment writes a segment to the containerWriter passed, preceded by its tracks record if its
+// movements were split off.
+func writeRecordedSegment(cw *containerWriter, seg recordedSegment) error {
+	if seg.tracks != nil {
+		if err := cw.writeTracks(seg.firstTick, seg.lastTick, seg.tracks); err != nil {
+			return err
+		}
+	}
+	return cw.writeSegment(seg.firstTick, seg.lastTick, seg.data)
+}
+
+// recordedTables holds the tables shared by all segments of a recording, which are written before the
+// first segment that refers to them.
+type recordedTables struct {
+	// palette is the encoded palette, which is written again every time it grows.
+	palette []byte
+	// skins holds every distinct skin recorded. Skins are only ever appended, so only the skins following
+	// those already written have to be written. If a sink is set, the skins written are dropped instead.
+	skins []recordedSkin
+	// snapshot holds the chunks of the snapshot saved so far, which are only ever appended like skins.
+	snapshot []snapshotChunk
+}
+
 // Recorder ...
 type Recorder struct {
 	id uuid.UUID
 	mu sync.Mutex
 
-	buffer         *bytes.Buffer
-	bufferTickLen  int
+	// buffer holds the encoded ticks of the segment that is currently being recorded.
+	buffer           *bytes.Buffer
+	segmentTicks     uint32
+	segmentFirstTick uint32
+	encoder          *zstd.Encoder
+	// tableEncoder compresses the skins and the chunks of the snapshot recorded, which are never compressed
+	// using a dictionary.
+	tableEncoder *zstd.Encoder
+	// scene tracks the state of the recording to write keyframes, or is nil if keyframes are disabled.
+	scene *scene
+	// palette holds the blocks and items referred to by the actions recorded so far. encodedPalette holds
+	// the encoded palette as of the last flush.
+	palette        *Palette
+	encodedPalette []byte
+	// skins holds every distinct skin recorded, in the order they were first recorded, and skinHashes holds
+	// the hashes of these skins.
+	skins      []recordedSkin
+	skinHashes map[[32]byte]struct{}
+	// snapshotRegion and snapshotRadius specify the chunks saved in the snapshot of the replay. snapshot
+	// holds the chunks saved so far, in the order they were saved, and snapshotted holds the positions of
+	// these chunks.
+	snapshotRegion []cube.BBox
+	snapshotRadius int32
+	snapshot       []snapshotChunk
+	snapshotted    map[world.ChunkPos]struct{}
+	// region holds the boxes that the recording is restricted to, or nil if the whole world is recorded.
+	// outside holds the players and entities added that are outside the region, which are not spawned in
+	// the replay until they enter it.
+	region  []cube.BBox
+	outside map[uuid.UUID]struct{}
+	// time is the time of the world during timeTick as last recorded, and weather the weather of the world as
+	// last recorded. Both are nil until they are first recorded.
+	time     *action.SetTime
+	timeTick uint32
+	weather  *action.SetWeather
+
+	// writeMu is held while finished segments are compressed and stored, so that segments are stored in
+	// order without holding mu.
+	writeMu sync.Mutex
+	// segments holds the compressed segments recorded if no sink is set.
+	segments []recordedSegment
+	// signer is used to sign the replay when it is saved, if set.
+	signer Signer
+	// cipher is used to encrypt the replay, if set. cipherErr holds the error returned when creating the
+	// cipher, which is returned when the replay is saved.
+	cipher    *recordCipher
+	cipherErr error
+	// sink is the writer that segments are streamed to, if set.
+	sink          *containerWriter
+	headerWritten bool
+	sinkPalette   []byte
+	sinkSkins     int
+	sinkSnapshot  int
+	sinkErr       error
+
+	// journalPath is the path of the journal that ticks are appended to, or an empty string if journaling
+	// is disabled.
+	journalPath      string
+	journalTicks     uint32
+	journalFirstTick uint32
+	journalOffset    int
+	journalEncoder   *zstd.Encoder
+	journal          *containerWriter
+	journalFile      *os.File
+	journalMeta      []byte
+	journalPalette   []byte
+	journalSkins     int
+	journalSnapshot  int
+	journalErr       error
+
 	pendingActions map[uint32][]action.Action
 	tick           uint32
 	flushedTick    uint32
@@ -44,41 +143,37 @@ type Recorder struct {
 	closing   chan struct{}
 	once      sync.Once
 
-	lastPushedPlayerMovements map[uuid.UUID]mgl64.Vec3
-	lastPushedEntityMovements map[uuid.UUID]mgl64.Vec3
+	lastPushedPlayerMovements map[uuid.UUID]pushedMovement
+	lastPushedEntityMovements map[uuid.UUID]pushedMovement
+	movementPrecision         action.MovementPrecision
+	movementTracks            bool
+	// dictionaryID is the ID of the Dictionary that the replay is compressed using, or 0 if none is used.
+	dictionaryID uint32
 
 	entityMovementRecorder *WorldEntityMovementRecorder
 
 	enableEntityMovementRecording bool
+
+	startTime time.Time
+	worldName string
+	dimension int32
+	players   []PlayerInfo
+	tags      map[string]string
 }
 
 // NewRecorder creates a new recorder, returning a pointer to the recorder.
 func NewRecorder(id uuid.UUID) *Recorder {
-	return newRecorder(id, true)
+	return RecorderConfig{}.New(id)
 }
 
 // NewRecorderWithoutEntityMovementRecording creates a new recorder that does not record entity movements.
 func NewRecorderWithoutEntityMovementRecording(id uuid.UUID) *Recorder {
-	return newRecorder(id, false)
+	return RecorderConfig{DisableEntityMovementRecording: true}.New(id)
 }
 
-func newRecorder(id uuid.UUID, enableEntityMovementRecording bool) *Recorder {
-	return &Recorder{
-		id:                            id,
-		nextID:                        1,
-		buffer:                        bytes.NewBuffer(make([]byte, 0, 8192)), // 8KB
-		pendingActions:                make(map[uint32][]action.Action, 6000), // 5 minutes
-		closing:                       make(chan struct{}),
-		playerIDs:                     make(map[uuid.UUID]uint32, 32),
-		entityIDs:                     make(map[uuid.UUID]uint32, 32),
-		lastPushedPlayerMovements:     make(map[uuid.UUID]mgl64.Vec3, 32),
-		lastPushedEntityMovements:     make(map[uuid.UUID]mgl64.Vec3, 32),
-		tick:                          1,
-		enableEntityMovementRecording: enableEntityMovementRecording,
-	}
-}
-
-// StartTicking ...
+// StartTicking starts recording the world passed. The chunks of RecorderConfig.SnapshotRegion are saved
+// before StartTicking returns, so that they hold the world as it was before the first tick, and
+// StartTicking must therefore not be called within a transaction of the world.
 func (r *Recorder) StartTicking(w *world.World) {
 	if r.enableEntityMovementRecording {
 		r.entityMovementRecorder = newWorldEntityMovementRecorder(r)
@@ -89,8 +184,19 @@ func (r *Recorder) StartTicking(w *world.World) {
 		panic("recorder already started")
 	}
 	r.w = w
+	r.startTime = time.Now()
+	r.worldName = w.Name()
+	if dim, ok := world.DimensionID(w.Dimension()); ok {
+		r.dimension = int32(dim)
+	}
 	r.mu.Unlock()
 
+	if len(r.snapshotRegion) > 0 {
+		positions := regionChunks(r.snapshotRegion)
+		<-w.Exec(func(tx *world.Tx) {
+			r.snapshotChunks(tx, positions)
+		})
+	}
 	if r.enableEntityMovementRecording {
 		r.recording.Add(2)
 		go r.entityMovementRecorder.StartTicking()
@@ -102,16 +208,18 @@ func (r *Recorder) StartTicking(w *world.World) {
 
 // startTickCounter ...
 func (r *Recorder) startTickCounter() {
-	ticker := time.NewTicker(time.Second / 20)
+	ticker := time.NewTicker(time.Second / tickRate)
 	defer ticker.Stop()
 	for {
 		select {
 		case <-ticker.C:
 			r.mu.Lock()
 			r.tick++
+			tick, w := r.tick, r.w
 			r.mu.Unlock()
 
-			if r.tick%3600 == 0 { // 3 minutes
+			r.recordEnvironment(w)
+			if (tick-1)%r.flushTicks() == 0 {
 				r.Flush()
 			}
 		case <-r.closing:
@@ -121,6 +229,14 @@ func (r *Recorder) startTickCounter() {
 	}
 }
 
+// flushTicks returns the interval in ticks at which the recorder is flushed.
+func (r *Recorder) flushTicks() uint32 {
+	if r.journalPath != "" {
+		return min(r.journalTicks, r.segmentTicks)
+	}
+	return r.segmentTicks
+}
+
 // CloseAndSaveActions ...
 func (r *Recorder) CloseAndSaveActions(w io.Writer) error {
 	var err error
@@ -130,21 +246,79 @@ func (r *Recorder) CloseAndSaveActions(w io.Writer) error {
 	return err
 }
 
+// Close stops the recording. If a sink was configured using RecorderConfig.Sink, the end of the replay is
+// written to it. Otherwise, the recorded actions are discarded.
+func (r *Recorder) Close() error {
+	return r.CloseAndSaveActions(nil)
+}
+
 // doClose ...
 func (r *Recorder) doCloseAndSaveActions(w io.Writer) error {
 	close(r.closing)
 	r.recording.Wait()
 	r.doFlush(true)
-	if err := r.saveActions(w); err != nil {
+	if r.sink != nil {
+		if err := r.closeSink(time.Now()); err != nil {
+			return err
+		}
+	} else if w != nil {
+		if err := r.saveActions(w, time.Now()); err != nil {
+			return err
+		}
+	}
+	if err := r.removeJournal(); err != nil {
 		return err
 	}
 	r.buffer = nil
+	r.segments = nil
 	r.w = nil
 	return nil
 }
 
+// SetTag sets a free-form key/value pair that is stored in the metadata of the replay.
+func (r *Recorder) SetTag(key, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tags[key] = value
+}
+
+// Metadata returns the metadata of the recording so far. The end time is set to the current time if the
+// recording has not yet been closed.
+func (r *Recorder) Metadata() Metadata {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.metadataNoMutex(time.Now())
+}
+
+// metadataNoMutex returns the metadata of the recording without locking the mutex.
+func (r *Recorder) metadataNoMutex(endTime time.Time) Metadata {
+	tags := make(map[string]string, len(r.tags))
+	for k, v := range r.tags {
+		tags[k] = v
+	}
+	return Metadata{
+		ID:           r.id,
+		StartTime:    r.startTime,
+		EndTime:      endTime,
+		TickRate:     tickRate,
+		WorldName:    r.worldName,
+		Dimension:    r.dimension,
+		Players:      append([]PlayerInfo(nil), r.players...),
+		Tags:         tags,
+		DictionaryID: r.dictionaryID,
+	}
+}
+
 // AddPlayer ...
 func (r *Recorder) AddPlayer(p *player.Player) {
+	if !r.enterRegion(p.UUID(), p.Position()) {
+		return
+	}
+	r.spawnPlayer(p, p.Position(), p.Rotation())
+}
+
+// spawnPlayer spawns the player passed in the replay at the position and rotation passed.
+func (r *Recorder) spawnPlayer(p *player.Player, pos mgl64.Vec3, rot cube.Rotation) {
 	addedBefore := false
 	r.mu.Lock()
 	var playerID uint32
@@ -155,12 +329,18 @@ func (r *Recorder) AddPlayer(p *player.Player) {
 		playerID = r.nextID
 		r.playerIDs[p.UUID()] = playerID
 		r.nextID++
+		r.players = append(r.players, PlayerInfo{ID: playerID, UUID: p.UUID(), Name: p.Name()})
 	}
 	r.mu.Unlock()
 
 	if !addedBefore {
-		sk := p.Skin()
-		r.PushAction(skinToAction(playerID, sk))
+		r.pushSkin(playerID, p.Skin())
+	}
+	r.mu.Lock()
+	w := r.w
+	r.mu.Unlock()
+	if r.snapshotRadius > 0 && w != nil && p.Tx().World() == w {
+		r.snapshotChunks(p.Tx(), radiusChunks(chunkPosOf(pos), r.snapshotRadius))
 	}
 
 	mainHand, offHand := p.HeldItems()
@@ -168,9 +348,9 @@ func (r *Recorder) AddPlayer(p *player.Player) {
 		PlayerID:   playerID,
 		PlayerName: p.Name(),
 		NameTag:    p.NameTag(),
-		Position:   vec64To32(p.Position()),
-		Yaw:        action.EncodeYaw16(float32(p.Rotation().Yaw())),
-		Pitch:      action.EncodePitch16(float32(p.Rotation().Pitch())),
+		Position:   vec64To32(pos),
+		Yaw:        action.EncodeYaw16(float32(rot.Yaw())),
+		Pitch:      action.EncodePitch16(float32(rot.Pitch())),
 		Helmet:     action.ItemFromStack(p.Armour().Helmet()),
 		Chestplate: action.ItemFromStack(p.Armour().Chestplate()),
 		Leggings:   action.ItemFromStack(p.Armour().Leggings()),
@@ -182,6 +362,14 @@ func (r *Recorder) AddPlayer(p *player.Player) {
 
 // AddEntity ...
 func (r *Recorder) AddEntity(e world.Entity) {
+	if !r.enterRegion(e.H().UUID(), e.Position()) {
+		return
+	}
+	r.spawnEntity(e, e.Position(), e.Rotation())
+}
+
+// spawnEntity spawns the entity passed in the replay at the position and rotation passed.
+func (r *Recorder) spawnEntity(e world.Entity, pos mgl64.Vec3, rot cube.Rotation) {
 	r.mu.Lock()
 	var entityID uint32
 	if id, ok := r.entityIDs[e.H().UUID()]; ok {
@@ -238,9 +426,9 @@ func (r *Recorder) AddEntity(e world.Entity) {
 		EntityID:         entityID,
 		EntityIdentifier: identifier,
 		NameTag:          nameTag,
-		Position:         vec64To32(e.Position()),
-		Yaw:              action.EncodeYaw16(float32(e.Rotation().Yaw())),
-		Pitch:            action.EncodePitch16(float32(e.Rotation().Pitch())),
+		Position:         vec64To32(pos),
+		Yaw:              action.EncodeYaw16(float32(rot.Yaw())),
+		Pitch:            action.EncodePitch16(float32(rot.Pitch())),
 		ExtraData:        extraData,
 	})
 }
@@ -248,6 +436,7 @@ func (r *Recorder) AddEntity(e world.Entity) {
 // RemoveEntity ...
 func (r *Recorder) RemoveEntity(e world.Entity) {
 	entityID := r.EntityID(e)
+	r.leaveRegion(e.H().UUID())
 	if entityID == 0 {
 		return
 	}
@@ -263,9 +452,10 @@ func (r *Recorder) RemoveEntity(e world.Entity) {
 func (r *Recorder) PlayerID(p *player.Player) uint32 {
 	r.mu.Lock()
 	playerID, ok := r.playerIDs[p.UUID()]
+	_, outside := r.outside[p.UUID()]
 	r.mu.Unlock()
 
-	if !ok {
+	if !ok || outside {
 		return 0
 	}
 	return playerID
@@ -275,9 +465,10 @@ func (r *Recorder) PlayerID(p *player.Player) uint32 {
 func (r *Recorder) PlayerIDByHandle(h *world.EntityHandle) uint32 {
 	r.mu.Lock()
 	playerID, ok := r.playerIDs[h.UUID()]
+	_, outside := r.outside[h.UUID()]
 	r.mu.Unlock()
 
-	if !ok {
+	if !ok || outside {
 		return 0
 	}
 	return playerID
@@ -287,9 +478,10 @@ func (r *Recorder) PlayerIDByHandle(h *world.EntityHandle) uint32 {
 func (r *Recorder) EntityID(e world.Entity) uint32 {
 	r.mu.Lock()
 	entityID, ok := r.entityIDs[e.H().UUID()]
+	_, outside := r.outside[e.H().UUID()]
 	r.mu.Unlock()
 
-	if !ok {
+	if !ok || outside {
 		return 0
 	}
 	return entityID
@@ -299,9 +491,10 @@ func (r *Recorder) EntityID(e world.Entity) uint32 {
 func (r *Recorder) EntityIDByHandle(h *world.EntityHandle) uint32 {
 	r.mu.Lock()
 	entityID, ok := r.entityIDs[h.UUID()]
+	_, outside := r.outside[h.UUID()]
 	r.mu.Unlock()
 
-	if !ok {
+	if !ok || outside {
 		return 0
 	}
 	return entityID
@@ -310,6 +503,7 @@ func (r *Recorder) EntityIDByHandle(h *world.EntityHandle) uint32 {
 // RemovePlayer ...
 func (r *Recorder) RemovePlayer(p *player.Player) {
 	playerID := r.PlayerID(p)
+	r.leaveRegion(p.UUID())
 	if playerID == 0 {
 		return
 	}
@@ -323,7 +517,16 @@ func (r *Recorder) RemovePlayer(p *player.Player) {
 
 // PushPlayerMovement ...
 func (r *Recorder) PushPlayerMovement(p *player.Player, pos mgl64.Vec3, rot cube.Rotation) {
-	playerID := r.PlayerID(p)
+	playerID, entered, left := r.crossRegion(r.playerIDs, p.UUID(), pos)
+	if entered {
+		r.spawnPlayer(p, pos, rot)
+		r.pushPlayerStates(p, internal.GetPlayerState(p))
+		return
+	} else if left {
+		r.PushAction(&action.PlayerDespawn{PlayerID: playerID})
+		r.removeLastPlayerMovement(p)
+		return
+	}
 	if playerID == 0 {
 		return
 	}
@@ -331,23 +534,9 @@ func (r *Recorder) PushPlayerMovement(p *player.Player, pos mgl64.Vec3, rot cube
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if lastPos, ok := r.lastPushedPlayerMovements[p.UUID()]; ok {
-		flags := uint8(0)
-		var changedPos mgl32.Vec3
+	if last, ok := r.lastPushedPlayerMovements[p.UUID()]; ok && r.sameSegment(last.tick) {
+		flags, changedPos, reached := action.EncodeDeltaPosition(last.pos, pos, r.movementPrecision)
 		var yaw, pitch uint16
-		if !mgl64.FloatEqual(pos[0], lastPos[0]) {
-			flags |= action.PlayerDeltaMoveHasXFlag
-			changedPos[0] = float32(pos[0])
-		}
-		if !mgl64.FloatEqual(pos[1], lastPos[1]) {
-			flags |= action.PlayerDeltaMoveHasYFlag
-			changedPos[1] = float32(pos[1])
-		}
-		if !mgl64.FloatEqual(pos[2], lastPos[2]) {
-			flags |= action.PlayerDeltaMoveHasZFlag
-			changedPos[2] = float32(pos[2])
-		}
-
 		prevRot := p.Rotation()
 		if !mgl64.FloatEqual(rot[0], prevRot[0]) {
 			flags |= action.PlayerDeltaMoveHasYawFlag
@@ -357,6 +546,9 @@ func (r *Recorder) PushPlayerMovement(p *player.Player, pos mgl64.Vec3, rot cube
 			flags |= action.PlayerDeltaMoveHasPitchFlag
 			pitch = action.EncodePitch16(float32(rot[1]))
 		}
+		if flags == 0 {
+			return
+		}
 
 		r.pushActionNoMutex(&action.PlayerDeltaMove{
 			Flags:    flags,
@@ -365,20 +557,29 @@ func (r *Recorder) PushPlayerMovement(p *player.Player, pos mgl64.Vec3, rot cube
 			Yaw:      yaw,
 			Pitch:    pitch,
 		})
-	} else {
-		r.pushActionNoMutex(&action.PlayerMove{
-			PlayerID: playerID,
-			Position: vec64To32(pos),
-			Yaw:      action.EncodeYaw16(float32(rot[0])),
-			Pitch:    action.EncodePitch16(float32(rot[1])),
-		})
+		r.lastPushedPlayerMovements[p.UUID()] = pushedMovement{pos: reached, tick: r.tick}
+		return
 	}
-	r.lastPushedPlayerMovements[p.UUID()] = pos
+	r.pushActionNoMutex(&action.PlayerMove{
+		PlayerID: playerID,
+		Position: vec64To32(pos),
+		Yaw:      action.EncodeYaw16(float32(rot[0])),
+		Pitch:    action.EncodePitch16(float32(rot[1])),
+	})
+	r.lastPushedPlayerMovements[p.UUID()] = pushedMovement{pos: vec32To64(vec64To32(pos)), tick: r.tick}
 }
 
 // PushEntityMovement ...
 func (r *Recorder) PushEntityMovement(e world.Entity, pos mgl64.Vec3, rot cube.Rotation) {
-	entityID := r.EntityID(e)
+	entityID, entered, left := r.crossRegion(r.entityIDs, e.H().UUID(), pos)
+	if entered {
+		r.spawnEntity(e, pos, rot)
+		return
+	} else if left {
+		r.PushAction(&action.EntityDespawn{EntityID: entityID})
+		r.removeLastEntityMovement(e)
+		return
+	}
 	if entityID == 0 {
 		return
 	}
@@ -386,23 +587,9 @@ func (r *Recorder) PushEntityMovement(e world.Entity, pos mgl64.Vec3, rot cube.R
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	if lastPos, ok := r.lastPushedEntityMovements[e.H().UUID()]; ok {
-		flags := uint8(0)
-		var changedPos mgl32.Vec3
+	if last, ok := r.lastPushedEntityMovements[e.H().UUID()]; ok && r.sameSegment(last.tick) {
+		flags, changedPos, reached := action.EncodeDeltaPosition(last.pos, pos, r.movementPrecision)
 		var yaw, pitch uint16
-		if pos[0] != lastPos[0] {
-			flags |= action.EntityDeltaMoveHasXFlag
-			changedPos[0] = float32(pos[0])
-		}
-		if pos[1] != lastPos[1] {
-			flags |= action.EntityDeltaMoveHasYFlag
-			changedPos[1] = float32(pos[1])
-		}
-		if pos[2] != lastPos[2] {
-			flags |= action.EntityDeltaMoveHasZFlag
-			changedPos[2] = float32(pos[2])
-		}
-
 		prevRot := e.Rotation()
 		if rot[0] != prevRot[0] {
 			flags |= action.EntityDeltaMoveHasYawFlag
@@ -412,6 +599,9 @@ func (r *Recorder) PushEntityMovement(e world.Entity, pos mgl64.Vec3, rot cube.R
 			flags |= action.EntityDeltaMoveHasPitchFlag
 			pitch = action.EncodePitch16(float32(rot[1]))
 		}
+		if flags == 0 {
+			return
+		}
 
 		r.pushActionNoMutex(&action.EntityDeltaMove{
 			Flags:    flags,
@@ -420,15 +610,33 @@ func (r *Recorder) PushEntityMovement(e world.Entity, pos mgl64.Vec3, rot cube.R
 			Yaw:      yaw,
 			Pitch:    pitch,
 		})
-	} else {
-		r.pushActionNoMutex(&action.EntityMove{
-			EntityID: entityID,
-			Position: vec64To32(pos),
-			Yaw:      action.EncodeYaw16(float32(rot[0])),
-			Pitch:    action.EncodePitch16(float32(rot[1])),
-		})
+		r.lastPushedEntityMovements[e.H().UUID()] = pushedMovement{pos: reached, tick: r.tick}
+		return
 	}
-	r.lastPushedEntityMovements[e.H().UUID()] = pos
+	r.pushActionNoMutex(&action.EntityMove{
+		EntityID: entityID,
+		Position: vec64To32(pos),
+		Yaw:      action.EncodeYaw16(float32(rot[0])),
+		Pitch:    action.EncodePitch16(float32(rot[1])),
+	})
+	r.lastPushedEntityMovements[e.H().UUID()] = pushedMovement{pos: vec32To64(vec64To32(pos)), tick: r.tick}
+}
+
+// pushedMovement is the last movement pushed for a player or entity.
+type pushedMovement struct {
+	// pos is the position that the player or entity is at when the movement is played back, which may
+	// differ slightly from the position recorded as positions are stored with limited precision.
+	pos mgl64.Vec3
+	// tick is the tick in which the movement was pushed.
+	tick uint32
+}
+
+// sameSegment checks if the tick passed is part of the segment that the current tick is part of. Delta
+// movements store the change since the previous position, which playbacks that seek to a keyframe only
+// know with the precision of the keyframe, so the first movement of every segment is stored as an absolute
+// movement instead. sameSegment must be called while holding mu.
+func (r *Recorder) sameSegment(tick uint32) bool {
+	return (tick-1)/r.segmentTicks == (r.tick-1)/r.segmentTicks
 }
 
 // PushPlayerHandChange ...
@@ -496,6 +704,22 @@ func (r *Recorder) pushPlayerState(p *player.Player, stateType uint8, value bool
 	})
 }
 
+// pushPlayerStates pushes every state of the player passed, which is done when the player is first seen
+// and when it enters the region recorded.
+func (r *Recorder) pushPlayerStates(p *player.Player, s internal.PlayerState) {
+	// TODO: higher disk usage
+	r.PushPlayerSneaking(p, s.Sneaking)
+	r.PushPlayerUsingItem(p, s.UsingItem)
+	r.PushPlayerVisibility(p, !s.Invisible)
+	r.PushPlayerSprinting(p, s.Sprinting)
+	r.PushPlayerGliding(p, s.Gliding)
+	r.PushPlayerCrawling(p, s.Crawling)
+	r.PushPlayerSwimming(p, s.Swimming)
+	r.PushSetPlayerNameTag(p, s.NameTag)
+	r.PushPlayerOnFire(p, s.OnFire)
+	r.PushPlayerSetVisibleEffects(p, s.VisibleParticleEffectIDs)
+}
+
 // PushPlayerUsingItem ...
 func (r *Recorder) PushPlayerUsingItem(p *player.Player, usingItem bool) {
 	r.pushPlayerState(p, action.SetPlayerStateTypeUsingItem, usingItem)
@@ -548,6 +772,9 @@ func (r *Recorder) PushBlockPlaceSound(pos cube.Pos, b world.Block) {
 
 // pushBlockSound ...
 func (r *Recorder) pushBlockSound(pos cube.Pos, b world.Block, t uint8) {
+	if !r.inRegion(pos) {
+		return
+	}
 	r.PushAction(&action.BlockSound{
 		Position: cubeToBlockPos(pos),
 		Block:    action.FromBlock(b),
@@ -557,6 +784,9 @@ func (r *Recorder) pushBlockSound(pos cube.Pos, b world.Block, t uint8) {
 
 // PushLiquidSound ...
 func (r *Recorder) PushLiquidSound(pos cube.Pos, l world.Liquid, isFill bool) {
+	if !r.inRegion(pos) {
+		return
+	}
 	_, isWater := l.(block.Water)
 	r.PushAction(&action.LiquidSound{
 		Position: cubeToBlockPos(pos),
@@ -571,6 +801,9 @@ func (r *Recorder) PushGeneralSound(pos mgl64.Vec3, s world.Sound) bool {
 	if !ok {
 		return false
 	}
+	if !r.inRegion(cube.PosFromVec3(pos)) {
+		return true
+	}
 	r.PushAction(&action.GeneralSound{
 		Position: vec64To32(pos),
 		SoundID:  soundID,
@@ -594,6 +827,9 @@ func (r *Recorder) PushGeneralParticle(pos mgl64.Vec3, p world.Particle) bool {
 	if !ok {
 		return false
 	}
+	if !r.inRegion(cube.PosFromVec3(pos)) {
+		return true
+	}
 	r.PushAction(&action.GeneralParticle{
 		Position:   vec64To32(pos),
 		ParticleID: particleId,
@@ -603,6 +839,9 @@ func (r *Recorder) PushGeneralParticle(pos mgl64.Vec3, p world.Particle) bool {
 
 // pushBlockParticle ...
 func (r *Recorder) pushBlockParticle(pos cube.Pos, b world.Block, t uint8, face uint8) {
+	if !r.inRegion(pos) {
+		return
+	}
 	r.PushAction(&action.BlockParticle{
 		Position: cubeToBlockPos(pos),
 		Block:    action.FromBlock(b),
@@ -613,6 +852,9 @@ func (r *Recorder) pushBlockParticle(pos cube.Pos, b world.Block, t uint8, face
 
 // PushPlaceBlock ...
 func (r *Recorder) PushPlaceBlock(pos cube.Pos, b world.Block) {
+	if !r.inRegion(pos) {
+		return
+	}
 	r.PushAction(&action.PlaceBlock{
 		Position: cubeToBlockPos(pos),
 		Block:    action.FromBlock(b),
@@ -621,6 +863,9 @@ func (r *Recorder) PushPlaceBlock(pos cube.Pos, b world.Block) {
 
 // PushBreakBlock ...
 func (r *Recorder) PushBreakBlock(pos cube.Pos) {
+	if !r.inRegion(pos) {
+		return
+	}
 	r.PushAction(&action.BreakBlock{
 		Position: cubeToBlockPos(pos),
 	})
@@ -628,6 +873,9 @@ func (r *Recorder) PushBreakBlock(pos cube.Pos) {
 
 // PushSetBlock ...
 func (r *Recorder) PushSetBlock(pos cube.Pos, b world.Block) {
+	if !r.inRegion(pos) {
+		return
+	}
 	r.PushAction(&action.SetBlock{
 		Position: cubeToBlockPos(pos),
 		Block:    action.FromBlock(b),
@@ -641,11 +889,36 @@ func (r *Recorder) PushSkinChange(p *player.Player, sk skin.Skin) {
 		return
 	}
 
-	r.PushAction(skinToAction(playerID, sk))
+	r.pushSkin(playerID, sk)
+}
+
+// pushSkin pushes a change of the skin of a player. The skin is added to the skin table of the replay if it
+// is not yet in it, so that every distinct skin is only stored once.
+func (r *Recorder) pushSkin(playerID uint32, sk skin.Skin) {
+	encoded, hash := encodeSkin(sk)
+	r.mu.Lock()
+	_, ok := r.skinHashes[hash]
+	r.mu.Unlock()
+	var compressed []byte
+	if !ok {
+		// Skins are compressed without holding mu, as compressing a skin may take a while.
+		compressed = r.tableEncoder.EncodeAll(encoded, nil)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.skinHashes[hash]; !ok && compressed != nil {
+		r.skinHashes[hash] = struct{}{}
+		r.skins = append(r.skins, recordedSkin{hash: hash, compressed: compressed})
+	}
+	r.pushActionNoMutex(&action.PlayerSkinRef{PlayerID: playerID, SkinHash: hash})
 }
 
 // PushSetLiquid ...
 func (r *Recorder) PushSetLiquid(pos cube.Pos, l world.Liquid) {
+	if !r.inRegion(pos) {
+		return
+	}
 	r.PushAction(&action.SetLiquid{
 		Position:   cubeToBlockPos(pos),
 		LiquidHash: internal.BlockToHash(l),
@@ -680,6 +953,9 @@ func (r *Recorder) PushSetEntityNameTag(e world.Entity, nameTag string) {
 
 // PushChestUpdate ...
 func (r *Recorder) PushChestUpdate(pos cube.Pos, open bool) {
+	if !r.inRegion(pos) {
+		return
+	}
 	r.PushAction(&action.ChestUpdate{
 		Position: cubeToBlockPos(pos),
 		Open:     open,
@@ -732,6 +1008,9 @@ func (r *Recorder) PushContinueCrackBlock(pos cube.Pos, dur time.Duration) {
 
 // pushCrackBlock ...
 func (r *Recorder) pushCrackBlock(pos cube.Pos, t uint8, dur time.Duration) {
+	if !r.inRegion(pos) {
+		return
+	}
 	r.PushAction(&action.CrackBlock{
 		Position:     cubeToBlockPos(pos),
 		Type:         t,
@@ -826,58 +1105,216 @@ func (r *Recorder) Flush() {
 
 func (r *Recorder) doFlush(closing bool) {
 	r.mu.Lock()
-	defer r.mu.Unlock()
 
+	var finished, chunks []recordedSegment
+	paletteGrew := false
 	w := protocol.NewWriter(r.buffer, 0)
 	untilTick := r.tick
 	if !closing {
 		untilTick--
 	}
 	for tick := r.flushedTick + 1; tick <= untilTick; tick++ {
-		r.bufferTickLen++
 		w.Varuint32(lo.ToPtr(tick))
-		if actions, ok := r.pendingActions[tick]; ok {
-			w.Varuint32(lo.ToPtr(uint32(len(actions))))
-			for i, a := range actions {
-				action.Write(w, a)
-				// Set to nil to improve GC performance.
-				r.pendingActions[tick][i] = nil
+		actions := r.pendingActions[tick]
+		if r.scene != nil && tick == r.segmentFirstTick && tick > 1 {
+			// Every segment but the first starts with a keyframe, so that playbacks can seek to the segment
+			// without playing the segments before it.
+			actions = append([]action.Action{r.scene.keyframe(tick)}, actions...)
+		}
+		w.Varuint32(lo.ToPtr(uint32(len(actions))))
+		for i, a := range actions {
+			action.Write(w, a)
+			if r.scene != nil {
+				r.scene.apply(tick, a)
+			}
+			if r.palette.add(a) {
+				paletteGrew = true
 			}
-			delete(r.pendingActions, tick)
-		} else {
-			w.Varuint32(lo.ToPtr(uint32(0)))
+			// Set to nil to improve GC performance.
+			actions[i] = nil
+		}
+		delete(r.pendingActions, tick)
+		if tick-r.segmentFirstTick+1 >= r.segmentTicks {
+			if r.journalPath != "" {
+				chunks = append(chunks, r.journalChunk(tick))
+			}
+			finished = append(finished, r.finishSegment(tick))
+			w = protocol.NewWriter(r.buffer, 0)
 		}
 	}
 	r.flushedTick = untilTick
+	if r.journalPath != "" && r.buffer.Len() > r.journalOffset {
+		chunks = append(chunks, r.journalChunk(untilTick))
+	}
+	if closing && r.buffer.Len() > 0 {
+		finished = append(finished, r.finishSegment(untilTick))
+	}
+
+	if paletteGrew {
+		if encoded, err := encodePalette(r.palette); err == nil {
+			r.encodedPalette = encoded
+		}
+	}
+	var meta Metadata
+	if (r.sink != nil && len(finished) > 0) || len(chunks) > 0 {
+		meta = r.metadataNoMutex(time.Time{})
+	}
+
+	// Segments are compressed and stored without holding mu, so that actions may be pushed in the meantime.
+	// writeMu is acquired before releasing mu to make sure segments are stored in the order they were
+	// finished.
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	tables := r.tablesNoMutex()
+	r.mu.Unlock()
+	for _, seg := range finished {
+		r.storeSegment(seg, meta, tables)
+	}
+	for _, chunk := range chunks {
+		r.storeJournalChunk(chunk, meta, tables)
+	}
+}
+
+// finishSegment ends the segment currently being recorded at lastTick and returns it, uncompressed. A new
+// buffer is allocated for the next segment. finishSegment must be called while holding mu.
+func (r *Recorder) finishSegment(lastTick uint32) recordedSegment {
+	seg := recordedSegment{firstTick: r.segmentFirstTick, lastTick: lastTick, data: r.buffer.Bytes()}
+	r.buffer = bytes.NewBuffer(make([]byte, 0, max(8192, len(seg.data))))
+	r.segmentFirstTick = lastTick + 1
+	r.journalOffset = 0
+	return seg
+}
+
+// storeSegment compresses a finished segment and either streams it to the sink or keeps it in memory. The
+// metadata passed is written in the header of the replay if the segment is the first one streamed to the
+// sink, and the tables passed are streamed before the segment if they changed since they were last
+// streamed. storeSegment must be called while holding writeMu.
+func (r *Recorder) storeSegment(seg recordedSegment, meta Metadata, tables recordedTables) {
+	if r.movementTracks {
+		// The segment is stored with its movements if they could not be split off.
+		if events, tracks, err := splitTracks(seg.data); err == nil && tracks != nil {
+			seg.data, seg.tracks = events, r.encoder.EncodeAll(tracks, nil)
+		}
+	}
+	seg.data = r.encoder.EncodeAll(seg.data, nil)
+	if r.sink == nil {
+		r.segments = append(r.segments, seg)
+		return
+	}
+	if r.sinkErr != nil {
+		return
+	}
+	if !r.headerWritten {
+		r.headerWritten = true
+		if r.sinkErr = r.sink.writeHeader(meta); r.sinkErr != nil {
+			return
+		}
+	}
+	if r.sinkErr = r.writeSinkTables(tables); r.sinkErr != nil {
+		return
+	}
+	r.sinkErr = writeRecordedSegment(r.sink, seg)
+}
+
+// tablesNoMutex returns the tables of the recording. If a sink is set, the skins and chunks of the snapshot
+// that were written to the sink, and to the journal if journaling is enabled, are dropped first, as they
+// are never written again. tablesNoMutex must be called while holding both mu and writeMu.
+func (r *Recorder) tablesNoMutex() recordedTables {
+	if r.sink != nil {
+		skins, snapshot := r.sinkSkins, r.sinkSnapshot
+		if r.journalPath != "" {
+			skins, snapshot = min(skins, r.journalSkins), min(snapshot, r.journalSnapshot)
+			r.journalSkins, r.journalSnapshot = r.journalSkins-skins, r.journalSnapshot-snapshot
+		}
+		r.sinkSkins, r.sinkSnapshot = r.sinkSkins-skins, r.sinkSnapshot-snapshot
+		r.skins, r.snapshot = slices.Delete(r.skins, 0, skins), slices.Delete(r.snapshot, 0, snapshot)
+	}
+	return recordedTables{palette: r.encodedPalette, skins: r.skins, snapshot: r.snapshot}
+}
+
+// writeSinkTables streams the parts of the tables passed that were not yet streamed to the sink.
+// writeSinkTables must be called while holding writeMu.
+func (r *Recorder) writeSinkTables(tables recordedTables) error {
+	for _, s := range tables.skins[r.sinkSkins:] {
+		if err := r.sink.writeSkin(s); err != nil {
+			return err
+		}
+		r.sinkSkins++
+	}
+	for _, s := range tables.snapshot[r.sinkSnapshot:] {
+		if err := r.sink.writeSnapshotChunk(s); err != nil {
+			return err
+		}
+		r.sinkSnapshot++
+	}
+	if len(tables.palette) == 0 || bytes.Equal(tables.palette, r.sinkPalette) {
+		return nil
+	}
+	r.sinkPalette = tables.palette
+	return r.sink.writePalette(tables.palette)
 }
 
-// saveActions ...
-func (r *Recorder) saveActions(w io.Writer) error {
-	buf := internal.BufferPool.Get().(*bytes.Buffer)
-	defer func() {
-		buf.Reset()
-		internal.BufferPool.Put(buf)
-	}()
+// closeSink writes the end of the replay to the sink.
+func (r *Recorder) closeSink(endTime time.Time) error {
 	r.mu.Lock()
-	if err := binary.Write(buf, binary.LittleEndian, uint32(r.bufferTickLen)); err != nil {
-		return err
+	meta := r.metadataNoMutex(endTime)
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	tables := r.tablesNoMutex()
+	r.mu.Unlock()
+
+	if r.sinkErr != nil {
+		return r.sinkErr
+	}
+	if !r.headerWritten {
+		r.headerWritten = true
+		if err := r.sink.writeHeader(meta); err != nil {
+			return err
+		}
 	}
-	if _, err := io.Copy(buf, r.buffer); err != nil {
-		r.mu.Unlock()
+	if err := r.writeSinkTables(tables); err != nil {
 		return err
 	}
+	return r.sink.close(meta)
+}
+
+// saveActions writes the replay, holding all segments recorded, to w.
+func (r *Recorder) saveActions(w io.Writer, endTime time.Time) error {
+	r.mu.Lock()
+	meta := r.metadataNoMutex(endTime)
+	palette, skins, snapshot := r.encodedPalette, r.skins, r.snapshot
 	r.mu.Unlock()
 
-	encoder, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
-	if err != nil {
-		return err
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	if r.cipherErr != nil {
+		return r.cipherErr
 	}
-	defer func() {
-		_ = encoder.Close()
-	}()
 
-	if _, err := encoder.Write(buf.Bytes()); err != nil {
+	cw := newContainerWriter(w)
+	cw.signer, cw.cipher = r.signer, r.cipher
+	if err := cw.writeHeader(meta); err != nil {
 		return err
 	}
-	return nil
+	if len(palette) > 0 {
+		if err := cw.writePalette(palette); err != nil {
+			return err
+		}
+	}
+	for _, s := range skins {
+		if err := cw.writeSkin(s); err != nil {
+			return err
+		}
+	}
+	for _, s := range snapshot {
+		if err := cw.writeSnapshotChunk(s); err != nil {
+			return err
+		}
+	}
+	for _, seg := range r.segments {
+		if err := writeRecordedSegment(cw, seg); err != nil {
+			return err
+		}
+	}
+	return cw.close(meta)
 }
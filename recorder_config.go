@@ -0,0 +1,149 @@
+package replay
+
+import (
+	"bytes"
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/df-mc/dragonfly/server/block/cube"
+	"github.com/df-mc/dragonfly/server/world"
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+	"io"
+)
+
+// defaultSegmentTicks is the amount of ticks stored in a single segment of a replay if
+// RecorderConfig.SegmentTicks is not set.
+const defaultSegmentTicks = 600 // 30 seconds
+
+// RecorderConfig may be used to create a Recorder with non-default settings. The zero value records
+// entity movements and keeps the replay in memory until Recorder.CloseAndSaveActions is called.
+type RecorderConfig struct {
+	// DisableEntityMovementRecording disables the recording of the movement of entities other than players.
+	DisableEntityMovementRecording bool
+	// SegmentTicks is the amount of ticks stored in a single segment of the replay. Each segment is
+	// compressed on its own. If set to 0, segments of 600 ticks (30 seconds) are recorded.
+	SegmentTicks uint32
+	// MovementPrecision is the precision with which the changes of the positions of players and entities are
+	// stored by delta movements. Changes are stored as fixed-point values that take up far less space than
+	// absolute positions, except for teleports and the first movement of every segment, which are stored as
+	// absolute positions. The zero value, action.MovementPrecisionFine, stores changes in steps of 1/4096
+	// block. action.MovementPrecisionExact stores every changed axis as an absolute position instead.
+	MovementPrecision action.MovementPrecision
+	// MovementTracks stores the movements of players and entities in every segment as columnar tracks, apart
+	// from the other actions recorded, which compresses a lot better for recordings with many moving players.
+	// Playbacks restore the movements in their original order. Journals always store movements along with
+	// the other actions.
+	MovementTracks bool
+	// Dictionary, if non-nil, is the Dictionary that the segments of the replay are compressed using, which
+	// makes short recordings a lot smaller. The replay can then only be loaded by passing the same Dictionary
+	// to Data.SetDictionaries. Journals and skins are compressed without the Dictionary, so that journals may
+	// always be recovered. Replays streamed to the Sink are salvaged by passing the Dictionary to Salvage.
+	Dictionary *Dictionary
+	// SnapshotRegion holds boxes of the world recorded of which the chunks are saved in the replay when
+	// StartTicking is called. Playbacks restore these chunks before the first tick is played, so that the
+	// replay plays back correctly in worlds that differ from the world recorded, such as maps in rotation or
+	// arenas that are generated randomly.
+	SnapshotRegion []cube.BBox
+	// SnapshotRadius, if not 0, is the radius in chunks around every player added to the Recorder of which
+	// the chunks are saved in the replay, like those of the SnapshotRegion. The chunks around a player are
+	// saved when the player is first added, and every chunk is only saved once. Playbacks restore these
+	// chunks once they reach the tick that they were saved in.
+	SnapshotRadius int
+	// Region, if non-empty, holds boxes of the world that the recording is restricted to, which allows
+	// recording a single match in a world hosting several matches at once. Boxes may span ranges of chunks
+	// using ChunkRegion. Players and entities are spawned in the replay when they enter the region and
+	// despawned when they leave it, and blocks, sounds and particles outside the region are not recorded.
+	// The same boxes are usually passed as SnapshotRegion.
+	Region []cube.BBox
+	// DisableKeyframes disables the keyframes written at the start of every segment. Keyframes hold the
+	// complete state of the players, entities and blocks in the recording, and allow playbacks to seek to
+	// any tick without playing all ticks before it.
+	DisableKeyframes bool
+	// Sink, if non-nil, is the io.Writer that the replay is streamed to while recording. Every segment is
+	// compressed and written to the Sink as soon as it is finished, and skins and chunks of the snapshot are
+	// dropped once they were written to the Sink, so that the memory used by the Recorder does not grow with
+	// the length of the recording, apart from the blocks changed that keyframes hold. The io.Writer passed
+	// to CloseAndSaveActions is ignored when a Sink is set; the index of the replay is written to the Sink
+	// instead.
+	Sink io.Writer
+	// JournalPath, if not empty, is the path of an append-only journal that the Recorder writes recorded
+	// ticks to while recording. The journal is synced to disk every time it is written to, so that the
+	// recording survives a crash of the process. The journal is removed once the Recorder is closed
+	// successfully. RecoverJournal may be used to turn a journal left behind into a replay.
+	JournalPath string
+	// JournalTicks is the interval in ticks at which ticks are written to the journal. If set to 0, ticks
+	// are written to the journal every 20 ticks (1 second).
+	JournalTicks uint32
+	// Signer, if non-nil, is used to sign the replay when it is saved or when the Sink is closed. The
+	// signature covers the header of the replay and a checksum of every segment, so that modifications to
+	// the replay may be detected using Data.Verify. Journals and replays recovered from them are not signed.
+	Signer Signer
+	// Keys, if non-nil, is used to encrypt the replay, including the Sink and the journal. The segments,
+	// palette, skins and snapshot of the replay are encrypted using the encryption key of the KeyProvider,
+	// along with the metadata apart from the ID, start time and end time of the replay, which remain readable
+	// so that ReadMetadata may be used to list replays without the key.
+	// Encrypted replays are loaded by passing the same KeyProvider to Data.SetKeyProvider.
+	Keys KeyProvider
+}
+
+// New creates a new Recorder using the settings of the RecorderConfig.
+func (conf RecorderConfig) New(id uuid.UUID) *Recorder {
+	if conf.SegmentTicks == 0 {
+		conf.SegmentTicks = defaultSegmentTicks
+	}
+	if conf.JournalTicks == 0 {
+		conf.JournalTicks = defaultJournalTicks
+	}
+	encoder, _ := newEncoder(zstd.SpeedBestCompression, conf.Dictionary)
+	tableEncoder := encoder
+	if conf.Dictionary != nil {
+		tableEncoder, _ = newEncoder(zstd.SpeedBestCompression, nil)
+	}
+	r := &Recorder{
+		id:                            id,
+		nextID:                        1,
+		buffer:                        bytes.NewBuffer(make([]byte, 0, 8192)), // 8KB
+		segmentTicks:                  conf.SegmentTicks,
+		segmentFirstTick:              1,
+		encoder:                       encoder,
+		tableEncoder:                  tableEncoder,
+		pendingActions:                make(map[uint32][]action.Action, 6000), // 5 minutes
+		closing:                       make(chan struct{}),
+		playerIDs:                     make(map[uuid.UUID]uint32, 32),
+		entityIDs:                     make(map[uuid.UUID]uint32, 32),
+		lastPushedPlayerMovements:     make(map[uuid.UUID]pushedMovement, 32),
+		lastPushedEntityMovements:     make(map[uuid.UUID]pushedMovement, 32),
+		movementPrecision:             conf.MovementPrecision,
+		movementTracks:                conf.MovementTracks,
+		dictionaryID:                  dictionaryID(conf.Dictionary),
+		tags:                          make(map[string]string),
+		tick:                          1,
+		enableEntityMovementRecording: !conf.DisableEntityMovementRecording,
+		palette:                       newPalette(),
+		skinHashes:                    make(map[[32]byte]struct{}),
+		snapshotRegion:                conf.SnapshotRegion,
+		snapshotRadius:                int32(conf.SnapshotRadius),
+		snapshotted:                   make(map[world.ChunkPos]struct{}),
+		region:                        conf.Region,
+		outside:                       make(map[uuid.UUID]struct{}),
+	}
+	if !conf.DisableKeyframes {
+		r.scene = newScene()
+	}
+	r.signer = conf.Signer
+	if conf.Keys != nil {
+		r.cipher, r.cipherErr = encryptingCipher(conf.Keys)
+	}
+	if conf.Sink != nil {
+		r.sink = newContainerWriter(conf.Sink)
+		r.sink.signer, r.sink.cipher = conf.Signer, r.cipher
+		r.sinkErr = r.cipherErr
+	}
+	if conf.JournalPath != "" {
+		r.journalPath = conf.JournalPath
+		r.journalTicks = conf.JournalTicks
+		r.journalFirstTick = 1
+		r.journalEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+		r.journalErr = r.cipherErr
+	}
+	return r
+}
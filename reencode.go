@@ -0,0 +1,53 @@
+package replay
+
+import (
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/df-mc/dragonfly/server/player/skin"
+	"github.com/klauspost/compress/zstd"
+	"io"
+)
+
+// ReencodeConfig holds the settings used to write a replay again using ReencodeConfig.Reencode.
+type ReencodeConfig struct {
+	// Level is the zstd compression level of the segments and skins of the replay written. If 0,
+	// zstd.SpeedBestCompression is used.
+	Level zstd.EncoderLevel
+	// SegmentTicks is the amount of ticks stored in a single segment of the replay written. Shorter segments
+	// make seeking cheaper, while longer segments compress better. If 0, segments of 600 ticks (30 seconds)
+	// are written.
+	SegmentTicks uint32
+	// MovementTracks stores the movements of players and entities in every segment as columnar tracks, apart
+	// from the other actions, which compresses a lot better for replays with many moving players.
+	MovementTracks bool
+	// Dictionary, if not nil, is the Dictionary that the replay written is compressed using. The replay can
+	// then only be loaded by passing the same Dictionary to Data.SetDictionaries.
+	Dictionary *Dictionary
+}
+
+// Reencode writes the replay passed to w using the settings of the ReencodeConfig. The replay written has the
+// current format version and holds the same metadata, actions and snapshot as d, while its segments,
+// keyframes, palette and skin table are created again. If d is encrypted, the replay written is encrypted
+// using the same key. Signatures are not kept, as the checksums they cover change when the replay is written
+// again.
+func (conf ReencodeConfig) Reencode(w io.Writer, d *Data) error {
+	d.mu.Lock()
+	meta, totalTicks, c := d.meta, uint32(d.totalTicks), d.cipher
+	d.mu.Unlock()
+
+	lookup := func(hash [32]byte) (skin.Skin, bool) {
+		sk, err := d.Skin(hash)
+		return sk, err == nil
+	}
+	snapshot, err := d.snapshotChunks()
+	if err != nil {
+		return err
+	}
+	opts := writeOptions{version: FormatVersion, cipher: c, level: conf.Level, segmentTicks: conf.SegmentTicks, tracks: conf.MovementTracks, dictionary: conf.Dictionary, snapshot: snapshot}
+	return writeReplayWith(w, opts, meta, totalTicks, func(tick uint32) ([]action.Action, error) {
+		actions, err := d.Actions(tick)
+		if err != nil {
+			return nil, err
+		}
+		return resolveSkinRefs(actions, lookup), nil
+	})
+}
@@ -0,0 +1,87 @@
+package replay
+
+import (
+	"github.com/df-mc/dragonfly/server/block/cube"
+	"github.com/df-mc/dragonfly/server/world"
+	"github.com/go-gl/mathgl/mgl64"
+	"github.com/google/uuid"
+	"math"
+)
+
+// ChunkRegion returns a box holding all chunks from the chunk at min up to and including the chunk at max,
+// over the full height of the world. The box may be used as part of RecorderConfig.Region or
+// RecorderConfig.SnapshotRegion to record a range of chunks.
+func ChunkRegion(min, max world.ChunkPos) cube.BBox {
+	return cube.Box(
+		float64(min[0])*16, math.MinInt32, float64(min[1])*16,
+		float64(max[0])*16+15, math.MaxInt32, float64(max[1])*16+15,
+	)
+}
+
+// inRegion checks if the block at the position passed is within the region recorded. The blocks at the
+// edges of the boxes of the region are part of the region.
+func (r *Recorder) inRegion(pos cube.Pos) bool {
+	if len(r.region) == 0 {
+		return true
+	}
+	for _, box := range r.region {
+		minPos, maxPos := cube.PosFromVec3(box.Min()), cube.PosFromVec3(box.Max())
+		if pos[0] >= minPos[0] && pos[0] <= maxPos[0] &&
+			pos[1] >= minPos[1] && pos[1] <= maxPos[1] &&
+			pos[2] >= minPos[2] && pos[2] <= maxPos[2] {
+			return true
+		}
+	}
+	return false
+}
+
+// enterRegion checks if a player or entity with the UUID passed that is added at the position passed is
+// within the region recorded. If not, it is marked as outside the region, so that it is only spawned in the
+// replay once it enters the region.
+func (r *Recorder) enterRegion(id uuid.UUID, pos mgl64.Vec3) bool {
+	if len(r.region) == 0 {
+		return true
+	}
+	in := r.inRegion(cube.PosFromVec3(pos))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if in {
+		delete(r.outside, id)
+	} else {
+		r.outside[id] = struct{}{}
+	}
+	return in
+}
+
+// crossRegion checks if a player or entity with the UUID passed enters or leaves the region recorded by
+// moving to the position passed. The ID of the player or entity in the replay is looked up in the map of
+// IDs passed, which must be guarded by mu, and returned if it is currently spawned in the replay. A player
+// or entity that leaves the region is marked as outside of it, after which it must be despawned by the
+// caller using the ID returned. One that enters the region must be spawned by the caller. The ID is looked
+// up under the same lock as the transition, so that it always matches the transition returned.
+func (r *Recorder) crossRegion(ids map[uuid.UUID]uint32, id uuid.UUID, pos mgl64.Vec3) (replayID uint32, entered, left bool) {
+	in := len(r.region) == 0 || r.inRegion(cube.PosFromVec3(pos))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	replayID, spawned := ids[id]
+	if _, outside := r.outside[id]; outside {
+		if !in {
+			return 0, false, false
+		}
+		delete(r.outside, id)
+		return 0, true, false
+	}
+	if spawned && !in {
+		r.outside[id] = struct{}{}
+		return replayID, false, true
+	}
+	return replayID, false, false
+}
+
+// leaveRegion forgets that a player or entity with the UUID passed is outside the region recorded, which
+// is done once it is removed from the Recorder.
+func (r *Recorder) leaveRegion(id uuid.UUID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.outside, id)
+}
@@ -0,0 +1,84 @@
+package replay
+
+import (
+	"bytes"
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/df-mc/dragonfly/server/block/cube"
+	"github.com/df-mc/dragonfly/server/entity"
+	"github.com/df-mc/dragonfly/server/world"
+	"github.com/go-gl/mathgl/mgl64"
+	"github.com/google/uuid"
+	"slices"
+	"testing"
+)
+
+// TestChunkRegion tests that a box returned by ChunkRegion holds every block of the chunks passed over the
+// full height of the world, and none of the blocks of the chunks around them.
+func TestChunkRegion(t *testing.T) {
+	r := RecorderConfig{Region: []cube.BBox{ChunkRegion(world.ChunkPos{-1, 2}, world.ChunkPos{0, 3})}}.New(uuid.New())
+	inside := []cube.Pos{{-16, 0, 32}, {15, 0, 63}, {-16, -64, 63}, {15, 319, 32}, {0, 1000, 40}}
+	outside := []cube.Pos{{-17, 0, 32}, {16, 0, 40}, {0, 0, 31}, {0, 0, 64}}
+	for _, pos := range inside {
+		if !r.inRegion(pos) {
+			t.Fatalf("block %v is outside the region of chunks -1, 2 to 0, 3", pos)
+		}
+	}
+	for _, pos := range outside {
+		if r.inRegion(pos) {
+			t.Fatalf("block %v is inside the region of chunks -1, 2 to 0, 3", pos)
+		}
+	}
+}
+
+// TestRegionCrossing tests that entities are only spawned in a replay restricted to a region while they
+// are inside it, keeping the same ID every time they enter it, and that their movements outside the region
+// are not recorded.
+func TestRegionCrossing(t *testing.T) {
+	rec := RecorderConfig{Region: []cube.BBox{ChunkRegion(world.ChunkPos{}, world.ChunkPos{})}}.New(uuid.New())
+	w := world.Config{Provider: world.NopProvider{}, Entities: entity.DefaultRegistry, SaveInterval: -1, RandomTickSpeed: -1}.New()
+	defer w.Close()
+
+	in, out := mgl64.Vec3{8, 64, 8}, mgl64.Vec3{40, 64, 8}
+	<-w.Exec(func(tx *world.Tx) {
+		e := tx.AddEntity(entity.NewText("text", out))
+		rec.AddEntity(e)
+		for _, pos := range []mgl64.Vec3{out.Add(mgl64.Vec3{1}), in, in.Add(mgl64.Vec3{1}), out, out.Add(mgl64.Vec3{1}), in} {
+			rec.PushEntityMovement(e, pos, cube.Rotation{})
+		}
+		rec.RemoveEntity(e)
+	})
+
+	buf := bytes.NewBuffer(nil)
+	if err := rec.CloseAndSaveActions(buf); err != nil {
+		t.Fatalf("failed to save replay: %v", err)
+	}
+	actions, err := openTestReplay(t, buf.Bytes(), nil).Actions(1)
+	if err != nil {
+		t.Fatalf("failed to read replay: %v", err)
+	}
+	var got []string
+	ids := make(map[uint32]struct{})
+	for _, a := range actions {
+		switch a := a.(type) {
+		case *action.EntitySpawn:
+			ids[a.EntityID] = struct{}{}
+			if pos := vec32To64(a.Position); pos != in {
+				t.Fatalf("entity was spawned at %v, expected the position it entered the region at %v", pos, in)
+			}
+		case *action.EntityMove:
+			ids[a.EntityID] = struct{}{}
+		case *action.EntityDespawn:
+			ids[a.EntityID] = struct{}{}
+		default:
+			continue
+		}
+		got = append(got, action.Name(a))
+	}
+	want := []string{"EntitySpawn", "EntityMove", "EntityDespawn", "EntitySpawn", "EntityDespawn"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("recorded actions %v, expected %v", got, want)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("entity was recorded using IDs %v, expected a single ID", ids)
+	}
+}
@@ -0,0 +1,379 @@
+package replay
+
+import (
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/df-mc/dragonfly/server/block"
+	"github.com/go-gl/mathgl/mgl64"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"slices"
+	"sort"
+)
+
+// scene tracks the state of all players, entities and blocks of a replay by applying the actions of the
+// replay in order, so that a keyframe of that state can be created at any tick.
+type scene struct {
+	players   map[uint32]*action.KeyframePlayer
+	entities  map[uint32]*action.KeyframeEntity
+	skinTicks map[uint32]uint32
+	blocks    map[protocol.BlockPos]action.Block
+	liquids   map[protocol.BlockPos]uint32
+	chests    map[protocol.BlockPos]struct{}
+	// playerPositions and entityPositions hold the positions of the players and entities with the precision
+	// used by the Recorder and Playback, so that relative delta movements add up to the same positions as
+	// during playback. The positions in players and entities are rounded from these.
+	playerPositions map[uint32]mgl64.Vec3
+	entityPositions map[uint32]mgl64.Vec3
+	// time is the time of the world during timeTick, or nil if no time was recorded yet.
+	time     *action.SetTime
+	timeTick uint32
+	weather  *action.SetWeather
+}
+
+// newScene creates an empty scene, as found at the start of a replay.
+func newScene() *scene {
+	return &scene{
+		players:   make(map[uint32]*action.KeyframePlayer),
+		entities:  make(map[uint32]*action.KeyframeEntity),
+		skinTicks: make(map[uint32]uint32),
+		blocks:    make(map[protocol.BlockPos]action.Block),
+		liquids:   make(map[protocol.BlockPos]uint32),
+		chests:    make(map[protocol.BlockPos]struct{}),
+
+		playerPositions: make(map[uint32]mgl64.Vec3),
+		entityPositions: make(map[uint32]mgl64.Vec3),
+	}
+}
+
+// apply updates the scene with an action recorded at the tick passed.
+func (s *scene) apply(tick uint32, a action.Action) {
+	switch a := a.(type) {
+	case *action.PlayerSpawn:
+		p := &action.KeyframePlayer{
+			PlayerID:   a.PlayerID,
+			PlayerName: a.PlayerName,
+			NameTag:    a.NameTag,
+			Position:   a.Position,
+			Yaw:        a.Yaw,
+			Pitch:      a.Pitch,
+			Helmet:     a.Helmet,
+			Chestplate: a.Chestplate,
+			Leggings:   a.Leggings,
+			Boots:      a.Boots,
+			MainHand:   a.MainHand,
+			OffHand:    a.OffHand,
+		}
+		p.SetState(action.SetPlayerStateTypeVisibility, true)
+		s.players[a.PlayerID] = p
+		s.playerPositions[a.PlayerID] = vec32To64(a.Position)
+	case *action.PlayerDespawn:
+		delete(s.players, a.PlayerID)
+		delete(s.playerPositions, a.PlayerID)
+	case *action.PlayerMove:
+		if p, ok := s.players[a.PlayerID]; ok {
+			p.Position, p.Yaw, p.Pitch = a.Position, a.Yaw, a.Pitch
+			s.playerPositions[a.PlayerID] = vec32To64(a.Position)
+		}
+	case *action.PlayerDeltaMove:
+		if p, ok := s.players[a.PlayerID]; ok {
+			pos := action.ApplyDeltaPosition(s.playerPositions[a.PlayerID], a.Flags, a.Position)
+			s.playerPositions[a.PlayerID], p.Position = pos, vec64To32(pos)
+			applyDeltaRotation(&p.Yaw, &p.Pitch, a.Yaw, a.Pitch, a.HasYaw(), a.HasPitch())
+		}
+	case *action.PlayerHandChange:
+		if p, ok := s.players[a.PlayerID]; ok {
+			p.MainHand, p.OffHand = a.MainHand, a.OffHand
+		}
+	case *action.PlayerArmorChange:
+		if p, ok := s.players[a.PlayerID]; ok {
+			p.Helmet, p.Chestplate, p.Leggings, p.Boots = a.Helmet, a.Chestplate, a.Leggings, a.Boots
+		}
+	case *action.PlayerNameTagUpdate:
+		if p, ok := s.players[a.PlayerID]; ok {
+			p.NameTag = a.NameTag
+		}
+	case *action.SetPlayerState:
+		if p, ok := s.players[a.PlayerID]; ok {
+			p.SetState(a.Type, a.Value)
+		}
+	case *action.PlayerAnimate:
+		// Older replays start and stop sneaking and using items through animations rather than states.
+		if p, ok := s.players[a.PlayerID]; ok {
+			switch a.Animation {
+			case action.PlayerAnimateSneak, action.PlayerAnimateStopSneak:
+				p.SetState(action.SetPlayerStateTypeSneaking, a.Animation == action.PlayerAnimateSneak)
+			case action.PlayerAnimateStartUsingItem, action.PlayerAnimateStopUsingItem:
+				p.SetState(action.SetPlayerStateTypeUsingItem, a.Animation == action.PlayerAnimateStartUsingItem)
+			}
+		}
+	case *action.SetPlayerVisibleEffects:
+		if p, ok := s.players[a.PlayerID]; ok {
+			p.Effects = slices.Clone(a.Effects)
+		}
+	case *action.PlayerSkin:
+		s.skinTicks[a.PlayerID] = tick
+	case *action.PlayerSkinRef:
+		s.skinTicks[a.PlayerID] = tick
+	case *action.EntitySpawn:
+		s.entities[a.EntityID] = &action.KeyframeEntity{
+			EntityID:         a.EntityID,
+			EntityIdentifier: a.EntityIdentifier,
+			NameTag:          a.NameTag,
+			Position:         a.Position,
+			Yaw:              a.Yaw,
+			Pitch:            a.Pitch,
+			ExtraData:        a.ExtraData,
+		}
+		s.entityPositions[a.EntityID] = vec32To64(a.Position)
+	case *action.EntityDespawn:
+		delete(s.entities, a.EntityID)
+		delete(s.entityPositions, a.EntityID)
+	case *action.EntityMove:
+		if e, ok := s.entities[a.EntityID]; ok {
+			e.Position, e.Yaw, e.Pitch = a.Position, a.Yaw, a.Pitch
+			s.entityPositions[a.EntityID] = vec32To64(a.Position)
+		}
+	case *action.EntityDeltaMove:
+		if e, ok := s.entities[a.EntityID]; ok {
+			pos := action.ApplyDeltaPosition(s.entityPositions[a.EntityID], a.Flags, a.Position)
+			s.entityPositions[a.EntityID], e.Position = pos, vec64To32(pos)
+			applyDeltaRotation(&e.Yaw, &e.Pitch, a.Yaw, a.Pitch, a.HasYaw(), a.HasPitch())
+		}
+	case *action.EntityNameTagUpdate:
+		if e, ok := s.entities[a.EntityID]; ok {
+			e.NameTag = a.NameTag
+		}
+	case *action.SetBlock:
+		s.blocks[a.Position] = a.Block
+	case *action.PlaceBlock:
+		s.blocks[a.Position] = a.Block
+	case *action.BreakBlock:
+		s.blocks[a.Position] = action.FromBlock(block.Air{})
+	case *action.SetLiquid:
+		s.liquids[a.Position] = a.LiquidHash
+	case *action.ChestUpdate:
+		if a.Open {
+			s.chests[a.Position] = struct{}{}
+		} else {
+			delete(s.chests, a.Position)
+		}
+	case *action.SetTime:
+		t := *a
+		s.time, s.timeTick = &t, tick
+	case *action.SetWeather:
+		w := *a
+		s.weather = &w
+	case *action.Keyframe:
+		s.restore(tick, a)
+	}
+}
+
+// restore replaces the state of the scene with the state held by a keyframe recorded at the tick passed.
+func (s *scene) restore(tick uint32, k *action.Keyframe) {
+	*s = *newScene()
+	if k.Time != nil {
+		t := *k.Time
+		s.time, s.timeTick = &t, tick
+	}
+	if k.Weather != nil {
+		w := *k.Weather
+		s.weather = &w
+	}
+	for _, p := range k.Players {
+		p.Effects = slices.Clone(p.Effects)
+		s.players[p.PlayerID] = &p
+		s.playerPositions[p.PlayerID] = vec32To64(p.Position)
+		if p.SkinTick != 0 {
+			s.skinTicks[p.PlayerID] = p.SkinTick
+		}
+	}
+	for _, e := range k.Entities {
+		s.entities[e.EntityID] = &e
+		s.entityPositions[e.EntityID] = vec32To64(e.Position)
+	}
+	for _, b := range k.Blocks {
+		s.blocks[b.Position] = b.Block
+	}
+	for _, l := range k.Liquids {
+		s.liquids[l.Position] = l.LiquidHash
+	}
+	for _, pos := range k.OpenChests {
+		s.chests[pos] = struct{}{}
+	}
+}
+
+// keyframe creates a keyframe holding the current state of the scene at the start of the tick passed. The
+// players, entities and blocks in the keyframe are sorted, so that the same state always results in the same
+// keyframe.
+func (s *scene) keyframe(tick uint32) *action.Keyframe {
+	k := &action.Keyframe{
+		Players:    make([]action.KeyframePlayer, 0, len(s.players)),
+		Entities:   make([]action.KeyframeEntity, 0, len(s.entities)),
+		Blocks:     make([]action.KeyframeBlock, 0, len(s.blocks)),
+		Liquids:    make([]action.KeyframeLiquid, 0, len(s.liquids)),
+		OpenChests: make([]protocol.BlockPos, 0, len(s.chests)),
+	}
+	if s.time != nil {
+		t := *s.time
+		if t.Cycle {
+			t.Time += int64(tick) - int64(s.timeTick)
+		}
+		k.Time = &t
+	}
+	if s.weather != nil {
+		w := *s.weather
+		k.Weather = &w
+	}
+	for _, p := range s.players {
+		kp := *p
+		kp.SkinTick = s.skinTicks[p.PlayerID]
+		k.Players = append(k.Players, kp)
+	}
+	for _, e := range s.entities {
+		k.Entities = append(k.Entities, *e)
+	}
+	for pos, b := range s.blocks {
+		k.Blocks = append(k.Blocks, action.KeyframeBlock{Position: pos, Block: b})
+	}
+	for pos, hash := range s.liquids {
+		k.Liquids = append(k.Liquids, action.KeyframeLiquid{Position: pos, LiquidHash: hash})
+	}
+	for pos := range s.chests {
+		k.OpenChests = append(k.OpenChests, pos)
+	}
+	sort.Slice(k.Players, func(i, j int) bool { return k.Players[i].PlayerID < k.Players[j].PlayerID })
+	sort.Slice(k.Entities, func(i, j int) bool { return k.Entities[i].EntityID < k.Entities[j].EntityID })
+	sort.Slice(k.Blocks, func(i, j int) bool { return blockPosLess(k.Blocks[i].Position, k.Blocks[j].Position) })
+	sort.Slice(k.Liquids, func(i, j int) bool { return blockPosLess(k.Liquids[i].Position, k.Liquids[j].Position) })
+	sort.Slice(k.OpenChests, func(i, j int) bool { return blockPosLess(k.OpenChests[i], k.OpenChests[j]) })
+	return k
+}
+
+// applyDeltaRotation applies the rotation of a delta movement to a yaw and pitch, if set.
+func applyDeltaRotation(yaw, pitch *uint16, deltaYaw, deltaPitch uint16, hasYaw, hasPitch bool) {
+	if hasYaw {
+		*yaw = deltaYaw
+	}
+	if hasPitch {
+		*pitch = deltaPitch
+	}
+}
+
+// blockPosLess orders block positions by their X, Y and Z coordinates.
+func blockPosLess(a, b protocol.BlockPos) bool {
+	if a[0] != b[0] {
+		return a[0] < b[0]
+	}
+	if a[1] != b[1] {
+		return a[1] < b[1]
+	}
+	return a[2] < b[2]
+}
+
+// dropRedundantSources removes every action.SetSource that is directly followed by another action.SetSource
+// or that ends the tick, such as those left behind when the keyframes of a merged replay are removed.
+func dropRedundantSources(actions []action.Action) []action.Action {
+	kept := actions[:0]
+	for i, a := range actions {
+		if isSetSource(a) && (i == len(actions)-1 || isSetSource(actions[i+1])) {
+			continue
+		}
+		kept = append(kept, a)
+	}
+	return kept
+}
+
+// isSetSource checks if an action is an action.SetSource.
+func isSetSource(a action.Action) bool {
+	_, ok := a.(*action.SetSource)
+	return ok
+}
+
+// isKeyframe checks if an action is a keyframe.
+func isKeyframe(a action.Action) bool {
+	_, ok := a.(*action.Keyframe)
+	return ok
+}
+
+// sceneSet tracks a scene for every source of a merged replay. Every action is applied to the scene of the
+// source set by the last action.SetSource before it in the same tick, or to the scene of source 0 if there
+// is none. Replays that were not merged only have a scene for source 0.
+type sceneSet struct {
+	scenes  map[uint32]*scene
+	tick    uint32
+	current uint32
+}
+
+// newSceneSet creates an empty sceneSet, as found at the start of a replay.
+func newSceneSet() *sceneSet {
+	return &sceneSet{scenes: make(map[uint32]*scene)}
+}
+
+// apply updates the scene of the current source with an action recorded at the tick passed.
+func (s *sceneSet) apply(tick uint32, a action.Action) {
+	if tick != s.tick {
+		s.tick, s.current = tick, 0
+	}
+	if src, ok := a.(*action.SetSource); ok {
+		s.current = src.Source
+		return
+	}
+	s.scene(s.current).apply(tick, a)
+}
+
+// scene returns the scene of the source passed, creating it if it does not yet exist.
+func (s *sceneSet) scene(source uint32) *scene {
+	sc, ok := s.scenes[source]
+	if !ok {
+		sc = newScene()
+		s.scenes[source] = sc
+	}
+	return sc
+}
+
+// sources returns the sources that the sceneSet holds a scene for in ascending order.
+func (s *sceneSet) sources() []uint32 {
+	sources := make([]uint32, 0, len(s.scenes))
+	for src := range s.scenes {
+		sources = append(sources, src)
+	}
+	slices.Sort(sources)
+	return sources
+}
+
+// keyframes returns the actions holding keyframes of the scenes of all sources at the start of the tick
+// passed. If the replay was merged, every keyframe is preceded by an action.SetSource of its source, and the
+// keyframes are followed by an action.SetSource of source 0, so that the actions following them are not
+// attributed to the wrong source.
+func (s *sceneSet) keyframes(tick uint32) []action.Action {
+	if _, ok := s.scenes[0]; len(s.scenes) == 0 || (ok && len(s.scenes) == 1) {
+		return []action.Action{s.scene(0).keyframe(tick)}
+	}
+	actions := make([]action.Action, 0, len(s.scenes)*2)
+	for _, src := range s.sources() {
+		actions = append(actions, &action.SetSource{Source: src}, s.scenes[src].keyframe(tick))
+	}
+	return append(actions, &action.SetSource{})
+}
+
+// mergeKeyframes combines the keyframes passed into a single keyframe.
+func mergeKeyframes(keyframes []*action.Keyframe) *action.Keyframe {
+	if len(keyframes) == 1 {
+		return keyframes[0]
+	}
+	k := &action.Keyframe{}
+	for _, kf := range keyframes {
+		k.Players = append(k.Players, kf.Players...)
+		k.Entities = append(k.Entities, kf.Entities...)
+		k.Blocks = append(k.Blocks, kf.Blocks...)
+		k.Liquids = append(k.Liquids, kf.Liquids...)
+		k.OpenChests = append(k.OpenChests, kf.OpenChests...)
+		// The world only has a single time and weather, so those of the first source holding them are used.
+		if k.Time == nil {
+			k.Time = kf.Time
+		}
+		if k.Weather == nil {
+			k.Weather = kf.Weather
+		}
+	}
+	return k
+}
@@ -0,0 +1,37 @@
+package replay
+
+import (
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/go-gl/mathgl/mgl64"
+	"testing"
+)
+
+// TestSceneDeltaMoves tests that the scene follows relative delta movements of players and entities far from
+// the origin of the world without losing the changes too small for float32 to hold.
+func TestSceneDeltaMoves(t *testing.T) {
+	spawn := mgl32.Vec3{3000000.25, 70, -3000000.5}
+	sc := newSceneSet()
+	sc.apply(1, &action.PlayerSpawn{PlayerID: 1, PlayerName: "alice", Position: spawn})
+	sc.apply(1, &action.EntitySpawn{EntityID: 2, EntityIdentifier: "minecraft:pig", Position: spawn, ExtraData: map[string]any{}})
+
+	prev := vec32To64(spawn)
+	for tick := uint32(2); tick <= 1200; tick++ {
+		pos := vec32To64(spawn).Add(mgl64.Vec3{float64(tick) * 0.1301, 0, float64(tick) * -0.0457})
+		flags, delta, reached := action.EncodeDeltaPosition(prev, pos, action.MovementPrecisionFine)
+		sc.apply(tick, &action.PlayerDeltaMove{PlayerID: 1, Flags: flags, Position: delta})
+		sc.apply(tick, &action.EntityDeltaMove{EntityID: 2, Flags: flags, Position: delta})
+		prev = reached
+	}
+	s := sc.scene(0)
+	if s.playerPositions[1] != prev || s.entityPositions[2] != prev {
+		t.Fatalf("scene holds positions %v and %v, expected %v", s.playerPositions[1], s.entityPositions[2], prev)
+	}
+	k := s.keyframe(1200)
+	if len(k.Players) != 1 || k.Players[0].Position != vec64To32(prev) {
+		t.Fatalf("keyframe holds players %+v, expected one at %v", k.Players, vec64To32(prev))
+	}
+	if len(k.Entities) != 1 || k.Entities[0].Position != vec64To32(prev) {
+		t.Fatalf("keyframe holds entities %+v, expected one at %v", k.Entities, vec64To32(prev))
+	}
+}
@@ -0,0 +1,252 @@
+package replay
+
+import (
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/df-mc/dragonfly/server/block/cube"
+	"github.com/df-mc/dragonfly/server/item"
+	"github.com/df-mc/dragonfly/server/world"
+	"github.com/samber/lo"
+)
+
+// Seek moves the playback to the tick passed, leaving the scene as if all ticks up to and including it were
+// played. If the tick is close to the current tick, the ticks in between are played or reversed. Otherwise,
+// the scene is restored from the nearest keyframe before the tick, after which only the ticks following the
+// keyframe are played. No particles, sounds or animations are shown for the ticks played while seeking. If
+// an OverlayProvider was set using SetOverlay, seeking to tick 0 restores the world to its state before the
+// playback started.
+func (w *Playback) Seek(tx *world.Tx, tick int) {
+	target := uint(max(0, min(tick, int(w.data.totalTicks))))
+	w.seeking = true
+	defer func() {
+		w.seeking = false
+		w.showTime(tx)
+	}()
+
+	if target == 0 && w.overlay != nil {
+		w.restoreWorld(tx)
+		w.playbackTick = 0
+		w.ended = w.err != nil
+		return
+	}
+
+	if target < w.playbackTick && w.canReverse(target) {
+		for w.playbackTick > target {
+			w.reverseTick(tx, w.playbackTick)
+			w.playbackTick--
+		}
+		w.ended = w.err != nil
+		return
+	}
+
+	keyframeTick, k, err := w.data.keyframe(uint32(target), w.includeSource)
+	if err != nil {
+		w.err = err
+		w.ended = true
+		return
+	}
+	// Without a keyframe, the scene is reset to the start of the replay.
+	from := uint(max(keyframeTick, 1))
+	if target < w.playbackTick || target-w.playbackTick > target-from+1 {
+		w.restore(tx, k, from)
+		w.playbackTick = from - 1
+	}
+	w.playUntil(tx, target)
+}
+
+// reload restores the scene from the nearest keyframe before the tick passed and plays the ticks following
+// the keyframe up to and including the tick passed.
+func (w *Playback) reload(tx *world.Tx, target uint) {
+	keyframeTick, k, err := w.data.keyframe(uint32(target), w.includeSource)
+	if err != nil {
+		w.err = err
+		w.ended = true
+		return
+	}
+	// Without a keyframe, the scene is reset to the start of the replay.
+	from := uint(max(keyframeTick, 1))
+	w.restore(tx, k, from)
+	w.playbackTick = from - 1
+	w.playUntil(tx, target)
+	w.showTime(tx)
+}
+
+// playUntil plays the ticks following the current tick up to and including the tick passed.
+func (w *Playback) playUntil(tx *world.Tx, target uint) {
+	for w.playbackTick < target && w.err == nil {
+		w.playTick(tx, w.playbackTick+1)
+		w.playbackTick++
+	}
+	w.ended = w.err != nil
+}
+
+// canReverse checks if all ticks after the tick passed, up to the current tick, may be reversed.
+func (w *Playback) canReverse(tick uint) bool {
+	for t := tick + 1; t <= w.playbackTick; t++ {
+		if _, ok := w.reverseHandlers[uint32(t)]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// restore replaces the scene of the playback with the state held by a keyframe recorded at the tick passed.
+// If the keyframe is nil, the scene is reset to the state at the start of the replay.
+func (w *Playback) restore(tx *world.Tx, k *action.Keyframe, tick uint) {
+	if k == nil {
+		k = &action.Keyframe{}
+	}
+	// The reverse handlers of ticks played before were created for a scene that no longer exists.
+	clear(w.reverseHandlers)
+
+	w.restoreSnapshot(tx, tick)
+
+	w.restoreBlocks(tx, k)
+	w.restoreScene(tx, k, tick)
+}
+
+// restoreScene replaces the players, entities, time, weather and open chests of the scene with those held
+// by a keyframe recorded at the tick passed, leaving the blocks of the world as they are.
+func (w *Playback) restoreScene(tx *world.Tx, k *action.Keyframe, tick uint) {
+	w.restorePlayers(tx, k.Players)
+	w.restoreEntities(tx, k.Entities)
+	w.restoreEnvironment(tx, k, tick)
+
+	open := make(map[cube.Pos]bool, len(k.OpenChests))
+	for _, pos := range k.OpenChests {
+		open[blockPosToCubePos(pos)] = true
+	}
+	for pos := range w.chestState {
+		if !open[pos] {
+			w.UpdateChestState(tx, pos, false)
+		}
+	}
+	for pos := range open {
+		if !w.chestState[pos] {
+			w.UpdateChestState(tx, pos, true)
+		}
+	}
+}
+
+// restoreBlocks sets every block and liquid changed by the playback or held by the keyframe to the state
+// held by the keyframe, or to its original state if the keyframe does not hold it.
+func (w *Playback) restoreBlocks(tx *world.Tx, k *action.Keyframe) {
+	blocks := make(map[cube.Pos]world.Block, len(k.Blocks)+len(w.originalBlocks))
+	for _, b := range k.Blocks {
+		blocks[blockPosToCubePos(b.Position)] = b.Block.ToBlock()
+	}
+	for pos, b := range w.originalBlocks {
+		if _, ok := blocks[pos]; !ok {
+			blocks[pos] = b
+		}
+	}
+	for pos, b := range blocks {
+		if _, hasNBT := b.(world.NBTer); !hasNBT && world.BlockRuntimeID(tx.Block(pos)) == world.BlockRuntimeID(b) {
+			continue
+		}
+		w.SetBlock(tx, pos, b)
+	}
+
+	liquids := make(map[cube.Pos]world.Liquid, len(k.Liquids)+len(w.originalLiquids))
+	for _, l := range k.Liquids {
+		if liq, ok := l.Liquid(); ok {
+			liquids[blockPosToCubePos(l.Position)] = liq
+		}
+	}
+	for pos, l := range w.originalLiquids {
+		if _, ok := liquids[pos]; !ok {
+			liquids[pos] = l
+		}
+	}
+	for pos, l := range liquids {
+		w.SetLiquid(tx, pos, l)
+	}
+}
+
+// restorePlayers spawns, updates and despawns players to match the players passed.
+func (w *Playback) restorePlayers(tx *world.Tx, players []action.KeyframePlayer) {
+	keep := make(map[uint32]struct{}, len(players))
+	for _, p := range players {
+		keep[p.PlayerID] = struct{}{}
+	}
+	for id := range w.players {
+		if _, ok := keep[id]; !ok {
+			w.DespawnPlayer(tx, id)
+		}
+	}
+
+	for _, p := range players {
+		w.restoreSkin(tx, p.PlayerID, p.SkinTick)
+		pos, rot := vec32To64(p.Position), action.DecodeRotation16(p.Yaw, p.Pitch)
+		armour := [4]item.Stack{p.Helmet.ToStack(), p.Chestplate.ToStack(), p.Leggings.ToStack(), p.Boots.ToStack()}
+		heldItems := [2]item.Stack{p.MainHand.ToStack(), p.OffHand.ToStack()}
+		if _, ok := w.players[p.PlayerID]; ok {
+			w.MovePlayer(tx, p.PlayerID, pos, rot)
+			w.UpdatePlayerArmours(tx, p.PlayerID, armour[0], armour[1], armour[2], armour[3])
+			w.UpdatePlayerHeldItems(tx, p.PlayerID, heldItems[0], heldItems[1])
+			w.SetPlayerNameTag(tx, p.PlayerID, p.NameTag)
+		} else {
+			w.SpawnPlayer(tx, p.PlayerName, p.NameTag, p.PlayerID, pos, rot, armour, heldItems)
+		}
+
+		w.SetPlayerVisibility(tx, p.PlayerID, p.State(action.SetPlayerStateTypeVisibility))
+		w.SetPlayerSneaking(tx, p.PlayerID, p.State(action.SetPlayerStateTypeSneaking))
+		w.SetPlayerSprinting(tx, p.PlayerID, p.State(action.SetPlayerStateTypeSprinting))
+		w.SetPlayerGliding(tx, p.PlayerID, p.State(action.SetPlayerStateTypeGliding))
+		w.SetPlayerUsingItem(tx, p.PlayerID, p.State(action.SetPlayerStateTypeUsingItem))
+		w.SetPlayerSwimming(tx, p.PlayerID, p.State(action.SetPlayerStateTypeSwimming))
+		w.SetPlayerCrawling(tx, p.PlayerID, p.State(action.SetPlayerStateTypeCrawling))
+		w.SetPlayerOnFire(tx, p.PlayerID, p.State(action.SetPlayerStateTypeOnFire))
+		w.SetPlayerVisibleEffects(tx, p.PlayerID, lo.Map(p.Effects, func(e uint8, _ int) int {
+			return int(e)
+		}))
+	}
+}
+
+// restoreEntities spawns, updates and despawns entities to match the entities passed.
+func (w *Playback) restoreEntities(tx *world.Tx, entities []action.KeyframeEntity) {
+	keep := make(map[uint32]struct{}, len(entities))
+	for _, e := range entities {
+		keep[e.EntityID] = struct{}{}
+	}
+	for id := range w.entities {
+		if _, ok := keep[id]; !ok {
+			w.DespawnEntity(tx, id)
+		}
+	}
+
+	for _, e := range entities {
+		pos, rot := vec32To64(e.Position), action.DecodeRotation16(e.Yaw, e.Pitch)
+		if _, ok := w.entities[e.EntityID]; ok {
+			w.MoveEntity(tx, e.EntityID, pos, rot)
+			w.SetEntityNameTag(tx, e.EntityID, e.NameTag)
+			continue
+		}
+		w.SpawnEntity(tx, e.EntityID, e.EntityIdentifier, e.NameTag, pos, rot, e.ExtraData)
+	}
+}
+
+// restoreSkin restores the skin of a player recorded in the PlayerSkin or PlayerSkinRef action at the tick
+// passed.
+func (w *Playback) restoreSkin(tx *world.Tx, id, tick uint32) {
+	if tick == 0 {
+		return
+	}
+	actions, err := w.data.Actions(tick)
+	if err != nil {
+		return
+	}
+	for i := len(actions) - 1; i >= 0; i-- {
+		switch a := actions[i].(type) {
+		case *action.PlayerSkin:
+			if a.PlayerID == id {
+				w.UpdatePlayerSkin(tx, id, a.Skin())
+				return
+			}
+		case *action.PlayerSkinRef:
+			if a.PlayerID == id {
+				w.UpdatePlayerSkinRef(tx, id, a.SkinHash)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/df-mc/dragonfly/server/block/cube"
+	"github.com/df-mc/dragonfly/server/world"
+	"strings"
+	"testing"
+)
+
+// playbackState describes the players, entities and blocks of the scene of the playback passed that are
+// changed by testActions, so that the scenes of two playbacks may be compared.
+func playbackState(tx *world.Tx, p *Playback) string {
+	var s strings.Builder
+	for _, id := range []uint32{1, 2} {
+		pos, ok := p.PlayerPosition(tx, id)
+		rot, _ := p.PlayerRotation(tx, id)
+		fmt.Fprintf(&s, "player %d: present=%v pos=%v rot=%v sneaking=%v\n", id, ok, pos, rot, p.PlayerSneaking(tx, id))
+	}
+	pos, ok := p.EntityPosition(tx, 3)
+	fmt.Fprintf(&s, "entity 3: present=%v pos=%v\n", ok, pos)
+	for x := 1; x <= testTicks/10; x++ {
+		fmt.Fprintf(&s, "block %d: %d\n", x, world.BlockRuntimeID(p.Block(tx, cube.Pos{x, 63, 0})))
+	}
+	return s.String()
+}
+
+// TestSeek tests that seeking to ticks inside and at the boundaries of segments, forwards and backwards,
+// leaves the scene in the same state as playing every tick up to the tick from the start of the replay.
+func TestSeek(t *testing.T) {
+	b := writeTestReplay(t, writeOptions{})
+
+	linear := newTestPlayback(t, openTestReplay(t, b, nil))
+	want := make([]string, testTicks+1)
+	<-linear.World().Exec(func(tx *world.Tx) {
+		for tick := uint(1); tick <= testTicks; tick++ {
+			linear.playUntil(tx, tick)
+			want[tick] = playbackState(tx, linear)
+		}
+	})
+	if err := linear.Err(); err != nil {
+		t.Fatalf("failed to play replay: %v", err)
+	}
+
+	p := newTestPlayback(t, openTestReplay(t, b, nil))
+	// The test may not fail inside the transaction, as that would stop the goroutine of the world.
+	var failures []string
+	<-p.World().Exec(func(tx *world.Tx) {
+		for _, tick := range []int{50, 51, 49, 1, 101, 100, 120, 119, 121, 150, 151, 149, 201, 200, 250, 199, 52, 51, 2} {
+			p.Seek(tx, tick)
+			if p.PlaybackTick() != tick {
+				failures = append(failures, fmt.Sprintf("playback is at tick %d after seeking to tick %d", p.PlaybackTick(), tick))
+			} else if got := playbackState(tx, p); got != want[tick] {
+				failures = append(failures, fmt.Sprintf("scene after seeking to tick %d differs from playing up to it:\n%s\nexpected:\n%s", tick, got, want[tick]))
+			}
+		}
+	})
+	if err := p.Err(); err != nil {
+		t.Fatalf("failed to seek replay: %v", err)
+	}
+	for _, f := range failures {
+		t.Error(f)
+	}
+}
+
+// TestKeyframes tests that the keyframe written at the start of every segment holds the scene reached by
+// playing all ticks before it.
+func TestKeyframes(t *testing.T) {
+	d := openTestReplay(t, writeTestReplay(t, writeOptions{}), nil)
+	sc := newScene()
+	for tick := uint32(1); tick <= testTicks; tick++ {
+		if tick%50 == 1 {
+			keyframeTick, k, err := d.Keyframe(tick)
+			if err != nil {
+				t.Fatalf("failed to read keyframe of tick %d: %v", tick, err)
+			}
+			if tick == 1 {
+				if k != nil {
+					t.Fatalf("replay holds a keyframe at tick %d, expected none before the second segment", keyframeTick)
+				}
+			} else if k == nil || keyframeTick != tick {
+				t.Fatalf("found keyframe at tick %d for tick %d, expected one at the start of its segment", keyframeTick, tick)
+			} else if !bytes.Equal(encodeKeyframe(k), encodeKeyframe(sc.keyframe(tick))) {
+				t.Fatalf("keyframe of tick %d differs from the scene played up to it", tick)
+			}
+		}
+		actions, _ := testActions(tick)
+		for _, a := range actions {
+			sc.apply(tick, a)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package replay
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+)
+
+// A replay may be signed by passing a Signer to RecorderConfig.Signer. Signed replays end with a signature
+// record, directly before the index, holding the SHA-256 checksum of every record written before it along
+// with a signature over the header of the replay and these checksums. Data.Verify uses the signature record
+// to detect replays that were modified after they were recorded.
+
+// SignatureAlgorithm is the algorithm used to sign a replay.
+type SignatureAlgorithm uint8
+
+const (
+	// SignatureHMACSHA256 signs replays using HMAC-SHA256 with a shared secret key.
+	SignatureHMACSHA256 SignatureAlgorithm = iota + 1
+	// SignatureEd25519 signs replays using an Ed25519 private key, so that they may be verified by anyone
+	// holding the public key.
+	SignatureEd25519
+)
+
+// signedEntrySize is the size of a single record checksum in a signature record.
+const signedEntrySize = indexEntrySize + sha256.Size
+
+// Signer signs replays written by a Recorder.
+type Signer interface {
+	// Algorithm returns the algorithm of the signatures created by Sign.
+	Algorithm() SignatureAlgorithm
+	// Sign signs the message passed.
+	Sign(msg []byte) ([]byte, error)
+}
+
+// Verifier verifies the signatures of replays.
+type Verifier interface {
+	// Algorithm returns the algorithm of the signatures verified by Verify.
+	Algorithm() SignatureAlgorithm
+	// Verify checks if sig is a valid signature of the message passed.
+	Verify(msg, sig []byte) bool
+}
+
+// HMACKey is a secret key used to both sign and verify replays using HMAC-SHA256.
+type HMACKey []byte
+
+// Algorithm ...
+func (HMACKey) Algorithm() SignatureAlgorithm {
+	return SignatureHMACSHA256
+}
+
+// Sign ...
+func (k HMACKey) Sign(msg []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, k)
+	mac.Write(msg)
+	return mac.Sum(nil), nil
+}
+
+// Verify ...
+func (k HMACKey) Verify(msg, sig []byte) bool {
+	expected, _ := k.Sign(msg)
+	return hmac.Equal(expected, sig)
+}
+
+// Ed25519PrivateKey is a private key used to sign replays using Ed25519.
+type Ed25519PrivateKey ed25519.PrivateKey
+
+// Algorithm ...
+func (Ed25519PrivateKey) Algorithm() SignatureAlgorithm {
+	return SignatureEd25519
+}
+
+// Sign ...
+func (k Ed25519PrivateKey) Sign(msg []byte) ([]byte, error) {
+	if len(k) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 private key size %d", len(k))
+	}
+	return ed25519.Sign(ed25519.PrivateKey(k), msg), nil
+}
+
+// Ed25519PublicKey is a public key used to verify replays signed using Ed25519.
+type Ed25519PublicKey ed25519.PublicKey
+
+// Algorithm ...
+func (Ed25519PublicKey) Algorithm() SignatureAlgorithm {
+	return SignatureEd25519
+}
+
+// Verify ...
+func (k Ed25519PublicKey) Verify(msg, sig []byte) bool {
+	return len(k) == ed25519.PublicKeySize && ed25519.Verify(ed25519.PublicKey(k), msg, sig)
+}
+
+// TickRange is an inclusive range of ticks.
+type TickRange struct {
+	First, Last uint32
+}
+
+// VerificationReport is the result of verifying a replay using Data.Verify.
+type VerificationReport struct {
+	// Signed is true if the replay holds a signature record.
+	Signed bool
+	// SignatureValid is true if the signature of the replay was created using the key of the Verifier
+	// passed and the checksums it covers were not changed.
+	SignatureValid bool
+	// FailedTicks holds the tick ranges of segments, or of the movement tracks of segments, that do not match
+	// their checksum, that were added after the replay was signed or that were removed from the replay.
+	FailedTicks []TickRange
+	// FailedRecords holds the amount of records other than segments, such as metadata records, that do not
+	// match their checksum, were added after the replay was signed or were removed from the replay.
+	FailedRecords int
+}
+
+// Valid checks if the replay is signed with a valid signature and no part of it failed verification.
+func (r VerificationReport) Valid() bool {
+	return r.Signed && r.SignatureValid && len(r.FailedTicks) == 0 && r.FailedRecords == 0
+}
+
+// signedMessage returns the message signed for a replay of the format version passed, holding the record
+// checksums encoded as in a signature record.
+func signedMessage(version uint16, checksums []byte) []byte {
+	msg := make([]byte, 0, len(formatMagic)+2+len(checksums))
+	msg = append(msg, formatMagic...)
+	msg = binary.LittleEndian.AppendUint16(msg, version)
+	return append(msg, checksums...)
+}
+
+// writeSignature writes a signature record holding the checksums of all records written so far, signed
+// using the signer of the containerWriter.
+func (c *containerWriter) writeSignature() error {
+	checksums := make([]byte, 0, 5+len(c.index)*signedEntrySize)
+	checksums = append(checksums, byte(c.signer.Algorithm()))
+	checksums = binary.LittleEndian.AppendUint32(checksums, uint32(len(c.index)))
+	for i, e := range c.index {
+		checksums = appendIndexEntry(checksums, e)
+		checksums = append(checksums, c.checksums[i][:]...)
+	}
+	sig, err := c.signer.Sign(signedMessage(c.version, checksums))
+	if err != nil {
+		return fmt.Errorf("failed to sign replay: %w", err)
+	}
+	payload := binary.LittleEndian.AppendUint32(checksums, uint32(len(sig)))
+	payload = append(payload, sig...)
+	return c.writeRecord(recordSignature, payload, 0, 0)
+}
+
+// signedRecord is a record covered by a signature record.
+type signedRecord struct {
+	indexEntry
+	checksum [32]byte
+}
+
+// decodeSignature decodes the payload of a signature record, returning the algorithm, the records covered,
+// the message signed and the signature.
+func decodeSignature(version uint16, payload []byte) (SignatureAlgorithm, []signedRecord, []byte, []byte, error) {
+	if len(payload) < 5 {
+		return 0, nil, nil, nil, errors.New("signature record is too short")
+	}
+	algorithm := SignatureAlgorithm(payload[0])
+	count := binary.LittleEndian.Uint32(payload[1:])
+	end := 5 + uint64(count)*signedEntrySize
+	if uint64(len(payload)) < end+4 {
+		return 0, nil, nil, nil, errors.New("signature record is too short")
+	}
+	records := make([]signedRecord, count)
+	for i := range records {
+		b := payload[5+i*signedEntrySize:]
+		records[i].indexEntry = decodeIndexEntry(b)
+		copy(records[i].checksum[:], b[indexEntrySize:])
+	}
+	sigLen := binary.LittleEndian.Uint32(payload[end:])
+	if uint64(len(payload)) != end+4+uint64(sigLen) {
+		return 0, nil, nil, nil, errors.New("signature record has an invalid signature length")
+	}
+	return algorithm, records, signedMessage(version, payload[:end]), payload[end+4:], nil
+}
+
+// Verify verifies the replay against the last signature record it holds. The checksum of every record of
+// the replay is compared against the checksum in the signature record, and the signature is verified using
+// the Verifier passed. If the Verifier is nil, only the checksums are compared, which detects corruption
+// but not deliberate modification. An error is returned if the replay could not be read.
+func (d *Data) Verify(v Verifier) (VerificationReport, error) {
+	var report VerificationReport
+	d.mu.Lock()
+	r, entries, version := d.r, d.entries, d.version
+	d.mu.Unlock()
+
+	sigIndex := -1
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].kind == recordSignature {
+			sigIndex = i
+			break
+		}
+	}
+	if sigIndex == -1 {
+		return report, nil
+	}
+	report.Signed = true
+	payload, err := readRecordAt(r, entries[sigIndex])
+	if err != nil {
+		return report, err
+	}
+	algorithm, records, msg, sig, err := decodeSignature(version, payload)
+	if err != nil {
+		return report, err
+	}
+	report.SignatureValid = v != nil && v.Algorithm() == algorithm && v.Verify(msg, sig)
+
+	fail := func(e indexEntry) {
+		if e.kind == recordSegment || e.kind == recordTracks {
+			report.FailedTicks = append(report.FailedTicks, TickRange{First: e.firstTick, Last: e.lastTick})
+		} else {
+			report.FailedRecords++
+		}
+	}
+	signed := make(map[int64]signedRecord, len(records))
+	for _, rec := range records {
+		signed[rec.offset] = rec
+	}
+	present := make(map[int64]struct{}, len(entries))
+	for i, e := range entries {
+		if i == sigIndex {
+			continue
+		}
+		present[e.offset] = struct{}{}
+		rec, ok := signed[e.offset]
+		if !ok || rec.indexEntry != e {
+			fail(e)
+			continue
+		}
+		payload, err := readRecordAt(r, e)
+		if err != nil {
+			return report, err
+		}
+		if sha256.Sum256(payload) != rec.checksum {
+			fail(e)
+		}
+	}
+	for _, rec := range records {
+		if _, ok := present[rec.offset]; !ok {
+			fail(rec.indexEntry)
+		}
+	}
+	sort.Slice(report.FailedTicks, func(i, j int) bool {
+		return report.FailedTicks[i].First < report.FailedTicks[j].First
+	})
+	// The segment and the tracks record of the same ticks may both have failed.
+	report.FailedTicks = slices.Compact(report.FailedTicks)
+	return report, nil
+}
@@ -0,0 +1,129 @@
+package replay
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"slices"
+	"testing"
+)
+
+// signedTestReplay records a replay signed using the Signer passed, with segments of 50 ticks, and returns
+// it. If sink is true, the replay is streamed to a sink rather than saved when the Recorder is closed.
+func signedTestReplay(t *testing.T, signer Signer, sink bool) []byte {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	conf := RecorderConfig{SegmentTicks: 50, Signer: signer}
+	if sink {
+		conf.Sink = buf
+	}
+	r := conf.New(testMetadata().ID)
+	r.SetTag("map", "lobby")
+	recordTestActions(r, testTicks)
+	var err error
+	if sink {
+		err = r.Close()
+	} else {
+		err = r.CloseAndSaveActions(buf)
+	}
+	if err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestVerify tests that replays signed using HMAC and Ed25519 keys are valid if verified using the right
+// key, and that wrong keys, modified segments and modified records are detected.
+func TestVerify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	keys := []struct {
+		name          string
+		signer        Signer
+		verifier      Verifier
+		wrongVerifier Verifier
+	}{
+		{"hmac", HMACKey("secret"), HMACKey("secret"), HMACKey("wrong")},
+		{"ed25519", Ed25519PrivateKey(priv), Ed25519PublicKey(pub), Ed25519PublicKey(otherPub)},
+	}
+	for _, k := range keys {
+		for _, sink := range []bool{false, true} {
+			b := signedTestReplay(t, k.signer, sink)
+			d := openTestReplay(t, b, nil)
+			requireActions(t, d, testTicks, testActions)
+			report, err := d.Verify(k.verifier)
+			if err != nil {
+				t.Fatalf("%s: failed to verify replay: %v", k.name, err)
+			}
+			if !report.Valid() {
+				t.Fatalf("%s: replay signed using the right key is not valid: %+v", k.name, report)
+			}
+			if report, err = d.Verify(k.wrongVerifier); err != nil || !report.Signed || report.SignatureValid {
+				t.Fatalf("%s: replay verified using the wrong key reported %+v, %v", k.name, report, err)
+			}
+			if report, err = d.Verify(nil); err != nil || report.SignatureValid || len(report.FailedTicks) != 0 || report.FailedRecords != 0 {
+				t.Fatalf("%s: replay verified without a key reported %+v, %v", k.name, report, err)
+			}
+
+			var segments []indexEntry
+			var metadata indexEntry
+			for _, e := range d.entries {
+				switch {
+				case e.kind == recordSegment:
+					segments = append(segments, e)
+				case e.kind == recordMetadata && metadata.length == 0:
+					metadata = e
+				}
+			}
+
+			// Modifying a segment is detected, also without a key, while the signature remains valid.
+			seg := segments[2]
+			tampered := bytes.Clone(b)
+			tampered[seg.offset+recordHeaderSize+int64(seg.length)/2] ^= 0xff
+			td := openTestReplay(t, tampered, nil)
+			want := []TickRange{{First: seg.firstTick, Last: seg.lastTick}}
+			for _, v := range []Verifier{k.verifier, nil} {
+				report, err := td.Verify(v)
+				if err != nil {
+					t.Fatalf("%s: failed to verify modified replay: %v", k.name, err)
+				}
+				if report.Valid() || !slices.Equal(report.FailedTicks, want) || report.FailedRecords != 0 {
+					t.Fatalf("%s: modified segment reported %+v, expected failed ticks %v", k.name, report, want)
+				}
+			}
+
+			// Modifying the metadata in the header is detected as a failed record.
+			record := b[metadata.offset : metadata.offset+recordHeaderSize+int64(metadata.length)]
+			i := bytes.Index(record, []byte("lobby"))
+			if i == -1 {
+				t.Fatalf("%s: metadata record does not hold the tag set", k.name)
+			}
+			tampered = bytes.Clone(b)
+			tampered[metadata.offset+int64(i)] = 'h'
+			report, err = openTestReplay(t, tampered, nil).Verify(k.verifier)
+			if err != nil {
+				t.Fatalf("%s: failed to verify modified replay: %v", k.name, err)
+			}
+			if report.Valid() || len(report.FailedTicks) != 0 || report.FailedRecords != 1 {
+				t.Fatalf("%s: modified metadata reported %+v, expected one failed record", k.name, report)
+			}
+		}
+	}
+}
+
+// TestVerifyUnsigned tests that replays written without a Signer are reported as unsigned.
+func TestVerifyUnsigned(t *testing.T) {
+	d := openTestReplay(t, writeTestReplay(t, writeOptions{}), nil)
+	report, err := d.Verify(HMACKey("secret"))
+	if err != nil {
+		t.Fatalf("failed to verify replay: %v", err)
+	}
+	if report.Signed || report.Valid() {
+		t.Fatalf("unsigned replay reported %+v", report)
+	}
+}
@@ -0,0 +1,119 @@
+package replay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/df-mc/dragonfly/server/player/skin"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"io"
+)
+
+// Skins are stored once per replay in skin records, so that PlayerSkinRef actions only have to hold the hash
+// of the skin. A skin record holds the SHA-256 hash of the encoded skin, followed by the encoded skin
+// compressed using zstd. Skins are encoded like a PlayerSkin action with a player ID of 0.
+
+// skinHashSize is the size of the hash at the start of a skin record payload.
+const skinHashSize = sha256.Size
+
+// recordedSkin is a skin in the skin table of a replay, compressed for a skin record.
+type recordedSkin struct {
+	hash       [32]byte
+	compressed []byte
+}
+
+// encodeSkin encodes a skin for the skin table and returns the encoded skin along with its hash.
+func encodeSkin(sk skin.Skin) ([]byte, [32]byte) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(sk.Pix)+len(sk.Cape.Pix)+len(sk.Model)+64))
+	skinToAction(0, sk).Marshal(protocol.NewWriter(buf, 0))
+	return buf.Bytes(), sha256.Sum256(buf.Bytes())
+}
+
+// decodeSkin decodes a skin encoded using encodeSkin.
+func decodeSkin(b []byte) (sk skin.Skin, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("skin read error: %v", r)
+		}
+	}()
+	a := &action.PlayerSkin{}
+	a.Marshal(protocol.NewReader(bytes.NewReader(b), 0, false))
+	return a.Skin(), nil
+}
+
+// writeSkin writes a skin record holding a skin of the skin table.
+func (c *containerWriter) writeSkin(s recordedSkin) error {
+	payload := make([]byte, 0, skinHashSize+len(s.compressed))
+	payload = append(payload, s.hash[:]...)
+	payload = append(payload, s.compressed...)
+	return c.writeRecord(recordSkin, payload, 0, 0)
+}
+
+// decodeSkinRecord decodes the payload of a skin record, checking that the skin matches its hash.
+func decodeSkinRecord(payload []byte) ([32]byte, skin.Skin, error) {
+	var hash [32]byte
+	if len(payload) < skinHashSize {
+		return hash, skin.Skin{}, fmt.Errorf("skin record is too short")
+	}
+	copy(hash[:], payload)
+	decompressed, err := segmentDecoder.DecodeAll(payload[skinHashSize:], nil)
+	if err != nil {
+		return hash, skin.Skin{}, fmt.Errorf("failed to decompress skin %x: %w", hash, err)
+	}
+	if sha256.Sum256(decompressed) != hash {
+		return hash, skin.Skin{}, fmt.Errorf("skin %x does not match its hash", hash)
+	}
+	sk, err := decodeSkin(decompressed)
+	return hash, sk, err
+}
+
+// readSkinHashes reads the hashes of all skin records pointed to by the index entries passed, so that the
+// skins may be read once they are needed. If c is not nil, the skin records are encrypted and have to be
+// read and decrypted completely.
+func readSkinHashes(r io.ReaderAt, entries []indexEntry, c *recordCipher) (map[[32]byte]indexEntry, error) {
+	skins := make(map[[32]byte]indexEntry)
+	for _, e := range entries {
+		if e.kind != recordSkin {
+			continue
+		}
+		if c != nil {
+			payload, err := readPayloadAt(r, e, c)
+			if err != nil {
+				return nil, err
+			}
+			if len(payload) < skinHashSize {
+				return nil, fmt.Errorf("skin record at offset %d is too short", e.offset)
+			}
+			skins[[32]byte(payload)] = e
+			continue
+		}
+		if e.length < skinHashSize {
+			return nil, fmt.Errorf("skin record at offset %d is too short", e.offset)
+		}
+		var hash [32]byte
+		if _, err := r.ReadAt(hash[:], e.offset+recordHeaderSize); err != nil {
+			return nil, fmt.Errorf("failed to read skin record at offset %d: %w", e.offset, err)
+		}
+		skins[hash] = e
+	}
+	return skins, nil
+}
+
+// resolveSkinRefs returns the actions passed with every PlayerSkinRef replaced by a PlayerSkin holding the
+// skin it refers to, as looked up using the function passed. References to skins that cannot be found are
+// dropped.
+func resolveSkinRefs(actions []action.Action, lookup func(hash [32]byte) (skin.Skin, bool)) []action.Action {
+	resolved := make([]action.Action, 0, len(actions))
+	for _, a := range actions {
+		ref, ok := a.(*action.PlayerSkinRef)
+		if !ok {
+			resolved = append(resolved, a)
+			continue
+		}
+		if sk, ok := lookup(ref.SkinHash); ok {
+			resolved = append(resolved, skinToAction(ref.PlayerID, sk))
+		}
+	}
+	return resolved
+}
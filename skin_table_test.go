@@ -0,0 +1,83 @@
+package replay
+
+import (
+	"bytes"
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/df-mc/dragonfly/server/player/skin"
+	"testing"
+)
+
+// testSkin returns a PlayerSkin action for the player passed, holding a skin of which the pixels depend on
+// the seed passed.
+func testSkin(playerID uint32, seed byte) *action.PlayerSkin {
+	pix := make([]byte, 64*32*4)
+	for i := range pix {
+		pix[i] = byte(i) * seed
+	}
+	return &action.PlayerSkin{PlayerID: playerID, SkinWidth: 64, SkinHeight: 32, SkinData: pix, GeometryName: "geometry.humanoid.custom"}
+}
+
+// TestSkinTable tests that every skin is stored once in the skin table of a replay, however often players
+// change to it, that players refer to the skin by its hash, and that hashes not in the table are not found.
+func TestSkinTable(t *testing.T) {
+	skins := map[uint32]*action.PlayerSkin{5: testSkin(1, 3), 6: testSkin(2, 3), 105: testSkin(1, 7), 130: testSkin(1, 3)}
+	actionsAt := func(tick uint32) ([]action.Action, error) {
+		actions, err := testActions(tick)
+		if sk, ok := skins[tick]; ok {
+			actions = append(actions, sk)
+		}
+		return actions, err
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := writeReplayWith(buf, writeOptions{version: FormatVersion, segmentTicks: 50}, testMetadata(), testTicks, actionsAt); err != nil {
+		t.Fatalf("failed to write replay: %v", err)
+	}
+	d := openTestReplay(t, buf.Bytes(), nil)
+
+	var records int
+	for _, e := range d.entries {
+		if e.kind == recordSkin {
+			records++
+		}
+	}
+	if records != 2 || len(d.skins) != 2 {
+		t.Fatalf("replay holds %d skin records with %d hashes, expected one for each of the 2 skins", records, len(d.skins))
+	}
+
+	for tick, want := range skins {
+		actions, err := d.Actions(tick)
+		if err != nil {
+			t.Fatalf("failed to read tick %d: %v", tick, err)
+		}
+		ref, ok := actions[len(actions)-1].(*action.PlayerSkinRef)
+		if !ok || ref.PlayerID != want.PlayerID {
+			t.Fatalf("tick %d ends with %#v, expected a skin reference of player %d", tick, actions[len(actions)-1], want.PlayerID)
+		}
+		encoded, hash := encodeSkin(want.Skin())
+		if ref.SkinHash != hash {
+			t.Fatalf("skin reference of tick %d holds hash %x, expected %x", tick, ref.SkinHash, hash)
+		}
+		sk, err := d.Skin(ref.SkinHash)
+		if err != nil {
+			t.Fatalf("failed to read skin of tick %d: %v", tick, err)
+		}
+		if got, _ := encodeSkin(sk); !bytes.Equal(got, encoded) {
+			t.Fatalf("skin of tick %d differs from the skin written", tick)
+		}
+	}
+
+	missing := [32]byte{1, 2, 3}
+	if _, err := d.Skin(missing); err == nil {
+		t.Fatalf("found skin for hash %x that is not in the skin table", missing)
+	}
+	resolved := resolveSkinRefs([]action.Action{
+		&action.PlayerSkinRef{PlayerID: 1, SkinHash: missing},
+		&action.PlayerMove{PlayerID: 1},
+	}, func(hash [32]byte) (skin.Skin, bool) {
+		sk, err := d.Skin(hash)
+		return sk, err == nil
+	})
+	if len(resolved) != 1 {
+		t.Fatalf("resolved skin references into %d actions, expected the reference to the missing skin dropped", len(resolved))
+	}
+}
@@ -0,0 +1,445 @@
+package replay
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"github.com/df-mc/dragonfly/server/block/cube"
+	"github.com/df-mc/dragonfly/server/world"
+	"github.com/df-mc/dragonfly/server/world/chunk"
+	"github.com/go-gl/mathgl/mgl64"
+	"github.com/samber/lo"
+	"github.com/sandertv/gophertunnel/minecraft/nbt"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"io"
+	"math"
+	"slices"
+)
+
+// The snapshot of a replay holds chunks of the world recorded as they were when they were first saved by the
+// Recorder, so that the replay plays back correctly in worlds that differ from the world recorded. Every
+// chunk is stored in a snapshot record holding the X and Z coordinates of the chunk, followed by the chunk
+// compressed using zstd. Chunks saved after the recording started, such as those around players added
+// later, are stored in ticked snapshot records instead, which hold the tick the chunk was saved in after
+// its coordinates, so that playbacks restore the chunk once they reach that tick. Readers that do not know
+// ticked snapshot records skip them, which only leaves those chunks unrestored. Chunks are encoded using the
+// disk encoding of dragonfly, which stores blocks by their name and properties, followed by the NBT of the
+// block entities in the chunk. Like skins, chunks are never compressed using a dictionary.
+
+const (
+	// snapshotHeaderSize is the size of the chunk position at the start of a snapshot record payload.
+	snapshotHeaderSize = 8
+	// tickedSnapshotHeaderSize is the size of the chunk position and tick at the start of a ticked snapshot
+	// record payload.
+	tickedSnapshotHeaderSize = 12
+)
+
+// snapshotChunk is a chunk in the snapshot of a replay, compressed for a snapshot record. tick is the tick
+// that the chunk was saved in, which is 0 for chunks saved before the first tick.
+type snapshotChunk struct {
+	pos        world.ChunkPos
+	tick       uint32
+	compressed []byte
+}
+
+// snapshotEntry points to the record of a chunk in the snapshot of a replay, along with the tick that the
+// chunk was saved in.
+type snapshotEntry struct {
+	indexEntry
+	tick uint32
+}
+
+// encodeSnapshotChunk encodes a chunk of a world along with the block entities in it.
+func encodeSnapshotChunk(col *world.Column) []byte {
+	data := chunk.Encode(col.Chunk, chunk.DiskEncoding)
+	buf := bytes.NewBuffer(make([]byte, 0, 4096))
+	w := protocol.NewWriter(buf, 0)
+	r := col.Chunk.Range()
+	w.Varint32(lo.ToPtr(int32(r[0])))
+	w.Varint32(lo.ToPtr(int32(r[1])))
+	protocol.FuncSlice(w, &data.SubChunks, w.ByteSlice)
+	w.ByteSlice(&data.Biomes)
+
+	positions := make([]cube.Pos, 0, len(col.BlockEntities))
+	for pos, b := range col.BlockEntities {
+		if _, ok := b.(world.NBTer); ok {
+			positions = append(positions, pos)
+		}
+	}
+	slices.SortFunc(positions, func(a, b cube.Pos) int {
+		return cmp.Or(cmp.Compare(a[1], b[1]), cmp.Compare(a[0], b[0]), cmp.Compare(a[2], b[2]))
+	})
+	w.Varuint32(lo.ToPtr(uint32(len(positions))))
+	for _, pos := range positions {
+		w.BlockPos(lo.ToPtr(protocol.BlockPos{int32(pos[0]), int32(pos[1]), int32(pos[2])}))
+		data := col.BlockEntities[pos].(world.NBTer).EncodeNBT()
+		w.NBT(&data, nbt.LittleEndian)
+	}
+	return buf.Bytes()
+}
+
+// decodeSnapshotChunk decodes a chunk encoded using encodeSnapshotChunk.
+func decodeSnapshotChunk(b []byte) (col *chunk.Column, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("chunk read error: %v", r)
+		}
+	}()
+	r := protocol.NewReader(bytes.NewReader(b), 0, false)
+	var (
+		minY, maxY int32
+		data       chunk.SerialisedData
+		count      uint32
+	)
+	r.Varint32(&minY)
+	r.Varint32(&maxY)
+	protocol.FuncSlice(r, &data.SubChunks, r.ByteSlice)
+	r.ByteSlice(&data.Biomes)
+	c, err := chunk.DiskDecode(data, cube.Range{int(minY), int(maxY)})
+	if err != nil {
+		return nil, err
+	}
+	col = &chunk.Column{Chunk: c}
+	r.Varuint32(&count)
+	for range count {
+		var (
+			pos protocol.BlockPos
+			m   map[string]any
+		)
+		r.BlockPos(&pos)
+		r.NBT(&m, nbt.LittleEndian)
+		col.BlockEntities = append(col.BlockEntities, chunk.BlockEntity{Pos: blockPosToCubePos(pos), Data: m})
+	}
+	return col, nil
+}
+
+// writeSnapshotChunk writes a snapshot record holding a chunk of the snapshot, or a ticked snapshot record if
+// the chunk was saved after the first tick.
+func (c *containerWriter) writeSnapshotChunk(s snapshotChunk) error {
+	kind, size := recordSnapshot, snapshotHeaderSize
+	if s.tick != 0 {
+		kind, size = recordTickedSnapshot, tickedSnapshotHeaderSize
+	}
+	payload := make([]byte, 0, size+len(s.compressed))
+	payload = binary.LittleEndian.AppendUint32(payload, uint32(s.pos[0]))
+	payload = binary.LittleEndian.AppendUint32(payload, uint32(s.pos[1]))
+	if s.tick != 0 {
+		payload = binary.LittleEndian.AppendUint32(payload, s.tick)
+	}
+	payload = append(payload, s.compressed...)
+	return c.writeRecord(kind, payload, 0, 0)
+}
+
+// decodeSnapshotRecord decodes the payload of a snapshot record of the kind passed into the chunk it holds,
+// which remains compressed.
+func decodeSnapshotRecord(kind uint8, payload []byte) (snapshotChunk, error) {
+	size := snapshotHeaderSize
+	if kind == recordTickedSnapshot {
+		size = tickedSnapshotHeaderSize
+	}
+	if len(payload) < size {
+		return snapshotChunk{}, fmt.Errorf("snapshot record is too short")
+	}
+	pos, tick := snapshotHeader(kind, payload)
+	return snapshotChunk{pos: pos, tick: tick, compressed: payload[size:]}, nil
+}
+
+// snapshotHeader reads the chunk position, and the tick for ticked snapshot records, at the start of a
+// snapshot record payload of the kind passed.
+func snapshotHeader(kind uint8, b []byte) (world.ChunkPos, uint32) {
+	pos := world.ChunkPos{int32(binary.LittleEndian.Uint32(b)), int32(binary.LittleEndian.Uint32(b[4:]))}
+	if kind != recordTickedSnapshot {
+		return pos, 0
+	}
+	return pos, binary.LittleEndian.Uint32(b[8:])
+}
+
+// readSnapshotPositions reads the chunk positions and ticks of all snapshot records pointed to by the index
+// entries passed, so that the chunks may be read once they are needed. If c is not nil, the snapshot records
+// are encrypted and have to be read and decrypted completely.
+func readSnapshotPositions(r io.ReaderAt, entries []indexEntry, c *recordCipher) (map[world.ChunkPos]snapshotEntry, error) {
+	chunks := make(map[world.ChunkPos]snapshotEntry)
+	for _, e := range entries {
+		size := snapshotHeaderSize
+		switch e.kind {
+		case recordSnapshot:
+		case recordTickedSnapshot:
+			size = tickedSnapshotHeaderSize
+		default:
+			continue
+		}
+		header := make([]byte, size)
+		if c != nil {
+			payload, err := readPayloadAt(r, e, c)
+			if err != nil {
+				return nil, err
+			}
+			if len(payload) < size {
+				return nil, fmt.Errorf("snapshot record at offset %d is too short", e.offset)
+			}
+			header = payload
+		} else {
+			if e.length < uint32(size) {
+				return nil, fmt.Errorf("snapshot record at offset %d is too short", e.offset)
+			}
+			if _, err := r.ReadAt(header, e.offset+recordHeaderSize); err != nil {
+				return nil, fmt.Errorf("failed to read snapshot record at offset %d: %w", e.offset, err)
+			}
+		}
+		pos, tick := snapshotHeader(e.kind, header)
+		chunks[pos] = snapshotEntry{indexEntry: e, tick: tick}
+	}
+	return chunks, nil
+}
+
+// SnapshotChunks returns the positions of the chunks in the snapshot of the replay, sorted by their X and
+// then their Z coordinate. The snapshot holds chunks of the world recorded as they were when they were saved,
+// which is returned by SnapshotTick, and is empty if the replay was recorded without
+// RecorderConfig.SnapshotRegion or RecorderConfig.SnapshotRadius.
+func (d *Data) SnapshotChunks() []world.ChunkPos {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	positions := make([]world.ChunkPos, 0, len(d.snapshot))
+	for pos := range d.snapshot {
+		positions = append(positions, pos)
+	}
+	slices.SortFunc(positions, func(a, b world.ChunkPos) int {
+		return cmp.Or(cmp.Compare(a[0], b[0]), cmp.Compare(a[1], b[1]))
+	})
+	return positions
+}
+
+// SnapshotTick returns the tick that the chunk at the position passed was saved in, which is 0 if it was saved
+// before the first tick. Playbacks restore the chunk before the actions of that tick are played. False is
+// returned if the chunk is not in the snapshot of the replay.
+func (d *Data) SnapshotTick(pos world.ChunkPos) (uint32, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.snapshot[pos]
+	return e.tick, ok
+}
+
+// SnapshotChunk reads the chunk at the position passed from the snapshot of the replay. The chunk is read
+// from the replay every time it is requested, and may be stored in a world.Provider as it is.
+func (d *Data) SnapshotChunk(pos world.ChunkPos) (*chunk.Column, error) {
+	d.mu.Lock()
+	e, ok := d.snapshot[pos]
+	r, c := d.r, d.cipher
+	d.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("chunk %v not found in snapshot", pos)
+	}
+	payload, err := readPayloadAt(r, e.indexEntry, c)
+	if err != nil {
+		return nil, err
+	}
+	s, err := decodeSnapshotRecord(e.kind, payload)
+	if err != nil {
+		return nil, err
+	}
+	decompressed, err := segmentDecoder.DecodeAll(s.compressed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress chunk %v: %w", pos, err)
+	}
+	col, err := decodeSnapshotChunk(decompressed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode chunk %v: %w", pos, err)
+	}
+	return col, nil
+}
+
+// snapshotOrder returns the positions and ticks of the chunks in the snapshot of the replay, sorted by the
+// tick that they were saved in. The compressed chunks are not read.
+func (d *Data) snapshotOrder() []snapshotChunk {
+	positions := d.SnapshotChunks()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	order := make([]snapshotChunk, len(positions))
+	for i, pos := range positions {
+		order[i] = snapshotChunk{pos: pos, tick: d.snapshot[pos].tick}
+	}
+	slices.SortStableFunc(order, func(a, b snapshotChunk) int {
+		return cmp.Compare(a.tick, b.tick)
+	})
+	return order
+}
+
+// snapshotChunks reads all chunks of the snapshot of the replay, which remain compressed, so that they may
+// be written to another replay as they are.
+func (d *Data) snapshotChunks() ([]snapshotChunk, error) {
+	positions := d.SnapshotChunks()
+	d.mu.Lock()
+	r, c := d.r, d.cipher
+	entries := make([]snapshotEntry, len(positions))
+	for i, pos := range positions {
+		entries[i] = d.snapshot[pos]
+	}
+	d.mu.Unlock()
+
+	chunks := make([]snapshotChunk, 0, len(entries))
+	for _, e := range entries {
+		payload, err := readPayloadAt(r, e.indexEntry, c)
+		if err != nil {
+			return nil, err
+		}
+		s, err := decodeSnapshotRecord(e.kind, payload)
+		if err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, s)
+	}
+	return chunks, nil
+}
+
+// regionChunks returns the positions of all chunks that intersect with any of the boxes passed.
+func regionChunks(boxes []cube.BBox) []world.ChunkPos {
+	var positions []world.ChunkPos
+	seen := make(map[world.ChunkPos]struct{})
+	for _, box := range boxes {
+		minX, minZ := int32(math.Floor(box.Min().X()))>>4, int32(math.Floor(box.Min().Z()))>>4
+		maxX, maxZ := int32(math.Floor(box.Max().X()))>>4, int32(math.Floor(box.Max().Z()))>>4
+		for x := minX; x <= maxX; x++ {
+			for z := minZ; z <= maxZ; z++ {
+				pos := world.ChunkPos{x, z}
+				if _, ok := seen[pos]; !ok {
+					seen[pos] = struct{}{}
+					positions = append(positions, pos)
+				}
+			}
+		}
+	}
+	return positions
+}
+
+// chunkPosOf returns the position of the chunk holding the position passed.
+func chunkPosOf(pos mgl64.Vec3) world.ChunkPos {
+	return world.ChunkPos{int32(math.Floor(pos[0])) >> 4, int32(math.Floor(pos[2])) >> 4}
+}
+
+// radiusChunks returns the positions of all chunks within the radius passed of the chunk at the centre
+// passed.
+func radiusChunks(centre world.ChunkPos, radius int32) []world.ChunkPos {
+	var positions []world.ChunkPos
+	for x := -radius; x <= radius; x++ {
+		for z := -radius; z <= radius; z++ {
+			if x*x+z*z <= radius*radius {
+				positions = append(positions, world.ChunkPos{centre[0] + x, centre[1] + z})
+			}
+		}
+	}
+	return positions
+}
+
+// snapshotChunks saves the chunks at the positions passed in the snapshot of the replay along with the
+// current tick, skipping chunks that were saved before. Chunks that are not loaded are loaded or generated
+// first. snapshotChunks must be called within a transaction of the world recorded.
+func (r *Recorder) snapshotChunks(tx *world.Tx, positions []world.ChunkPos) {
+	for _, pos := range positions {
+		select {
+		case <-r.closing:
+			return
+		default:
+		}
+		r.mu.Lock()
+		_, saved := r.snapshotted[pos]
+		r.snapshotted[pos] = struct{}{}
+		tick := r.tick
+		r.mu.Unlock()
+		if saved {
+			continue
+		}
+		compressed := r.tableEncoder.EncodeAll(encodeSnapshotChunk(world_chunk(tx.World(), pos)), nil)
+
+		r.mu.Lock()
+		r.snapshot = append(r.snapshot, snapshotChunk{pos: pos, tick: tick, compressed: compressed})
+		r.mu.Unlock()
+	}
+}
+
+// restoreSnapshot sets the chunks of the world held by the snapshot of the replay that were saved at or before
+// the tick passed to the state they were in when they were saved, before the actions of the tick are played.
+// Chunks saved before the first tick are restored before anything else is played, so that the blocks
+// restored are considered the original blocks of the world. Chunks saved later are reverted to the state
+// they were in before they were restored if the playback returns to a tick before the one they were saved in.
+func (w *Playback) restoreSnapshot(tx *world.Tx, tick uint) {
+	if w.snapshot == nil {
+		w.snapshot = w.data.snapshotOrder()
+	}
+	for w.snapshotRestored < len(w.snapshot) && uint(w.snapshot[w.snapshotRestored].tick) <= tick {
+		s := w.snapshot[w.snapshotRestored]
+		col, err := w.data.SnapshotChunk(s.pos)
+		if err != nil {
+			w.err = err
+			w.ended = true
+			return
+		}
+		if s.tick != 0 {
+			w.snapshotOriginals[s.pos] = encodeSnapshotChunk(world_chunk(tx.World(), s.pos))
+		}
+		restoreColumn(tx, s.pos, col)
+		w.snapshotRestored++
+	}
+	for w.snapshotRestored > 0 && uint(w.snapshot[w.snapshotRestored-1].tick) > tick {
+		s := w.snapshot[w.snapshotRestored-1]
+		col, err := decodeSnapshotChunk(w.snapshotOriginals[s.pos])
+		if err != nil {
+			w.err = fmt.Errorf("failed to decode chunk %v: %w", s.pos, err)
+			w.ended = true
+			return
+		}
+		restoreColumn(tx, s.pos, col)
+		delete(w.snapshotOriginals, s.pos)
+		w.snapshotRestored--
+	}
+}
+
+// restoreColumn sets every block, liquid and block entity of the chunk at the position passed that differs
+// from the chunk passed to that of the chunk passed.
+func restoreColumn(tx *world.Tx, pos world.ChunkPos, col *chunk.Column) {
+	current := world_chunk(tx.World(), pos)
+	r, wr := col.Chunk.Range(), tx.Range()
+	minY, maxY := max(r[0], wr[0]), min(r[1], wr[1])
+	opts := &world.SetOpts{DisableBlockUpdates: true, DisableLiquidDisplacement: true}
+	air := world.BlockRuntimeID(nil)
+
+	for subY := minY; subY <= maxY; subY = subY&^15 + 16 {
+		if col.Chunk.SubChunk(int16(subY)).Equals(current.Chunk.SubChunk(int16(subY))) {
+			continue
+		}
+		for y := subY; y <= min(maxY, subY|15); y++ {
+			for x := uint8(0); x < 16; x++ {
+				for z := uint8(0); z < 16; z++ {
+					blockPos := cube.Pos{int(pos[0])<<4 | int(x), y, int(pos[1])<<4 | int(z)}
+					block, liquid := col.Chunk.Block(x, int16(y), z, 0), col.Chunk.Block(x, int16(y), z, 1)
+					if liquid == air && current.Chunk.Block(x, int16(y), z, 1) != air {
+						// Liquids are removed first, as removing them may also remove a liquid on the first layer.
+						tx.SetLiquid(blockPos, nil)
+					}
+					if block != current.Chunk.Block(x, int16(y), z, 0) {
+						b, _ := world.BlockByRuntimeID(block)
+						tx.SetBlock(blockPos, b, opts)
+					}
+					if liquid != air && liquid != current.Chunk.Block(x, int16(y), z, 1) {
+						if b, ok := world.BlockByRuntimeID(liquid); ok {
+							if l, ok := b.(world.Liquid); ok {
+								tx.SetLiquid(blockPos, l)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	for _, be := range col.BlockEntities {
+		if be.Pos.OutOfBounds(wr) {
+			continue
+		}
+		b, _ := world.BlockByRuntimeID(col.Chunk.Block(uint8(be.Pos[0]), int16(be.Pos[1]), uint8(be.Pos[2]), 0))
+		if nbter, ok := b.(world.NBTer); ok {
+			if decoded, ok := nbter.DecodeNBT(be.Data).(world.Block); ok {
+				tx.SetBlock(be.Pos, decoded, opts)
+			}
+		}
+	}
+}
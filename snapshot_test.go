@@ -0,0 +1,173 @@
+package replay
+
+import (
+	"bytes"
+	"github.com/df-mc/dragonfly/server/block"
+	"github.com/df-mc/dragonfly/server/block/cube"
+	"github.com/df-mc/dragonfly/server/world"
+	"github.com/df-mc/dragonfly/server/world/chunk"
+	"github.com/klauspost/compress/zstd"
+	"slices"
+	"testing"
+)
+
+// testColumn returns a chunk at the position passed holding a column of stone of a height depending on the
+// position, and a chest named after the position on top of it.
+func testColumn(pos world.ChunkPos) *world.Column {
+	c := chunk.New(world.BlockRuntimeID(block.Air{}), cube.Range{-64, 319})
+	height := int16(60 + pos[0] - pos[1])
+	for y := int16(-64); y < height; y++ {
+		c.SetBlock(3, y, 5, 0, world.BlockRuntimeID(block.Stone{}))
+	}
+	chestPos := cube.Pos{int(pos[0])<<4 + 3, int(height), int(pos[1])<<4 + 5}
+	c.SetBlock(3, height, 5, 0, world.BlockRuntimeID(block.Chest{}))
+	chest := block.NewChest()
+	chest.CustomName = chestPos.String()
+	return &world.Column{Chunk: c, BlockEntities: map[cube.Pos]world.Block{chestPos: chest}}
+}
+
+// requireColumn fails the test if the chunk passed does not hold the blocks and block entity of the chunk
+// returned by testColumn for the position passed.
+func requireColumn(t *testing.T, pos world.ChunkPos, col *chunk.Column) {
+	t.Helper()
+	want := testColumn(pos)
+	for y := int16(-64); y <= 319; y++ {
+		for _, xz := range [][2]uint8{{3, 5}, {0, 0}, {15, 15}} {
+			if got, exp := col.Chunk.Block(xz[0], y, xz[1], 0), want.Chunk.Block(xz[0], y, xz[1], 0); got != exp {
+				t.Fatalf("chunk %v holds block %d at %v, %d, expected %d", pos, got, xz, y, exp)
+			}
+		}
+	}
+	if len(col.BlockEntities) != 1 {
+		t.Fatalf("chunk %v holds %d block entities, expected 1", pos, len(col.BlockEntities))
+	}
+	for chestPos := range want.BlockEntities {
+		e := col.BlockEntities[0]
+		if e.Pos != chestPos || e.Data["CustomName"] != chestPos.String() {
+			t.Fatalf("chunk %v holds block entity %+v, expected a chest at %v", pos, e, chestPos)
+		}
+	}
+}
+
+// testSnapshot returns a snapshot of two chunks saved before the first tick and one chunk saved during tick
+// 100, compressed like the snapshot of a recording.
+func testSnapshot(t *testing.T) []snapshotChunk {
+	t.Helper()
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("failed to create encoder: %v", err)
+	}
+	defer enc.Close()
+	var snapshot []snapshotChunk
+	for _, s := range []snapshotChunk{{pos: world.ChunkPos{0, 0}}, {pos: world.ChunkPos{-1, 2}}, {pos: world.ChunkPos{1, -1}, tick: 100}} {
+		s.compressed = enc.EncodeAll(encodeSnapshotChunk(testColumn(s.pos)), nil)
+		snapshot = append(snapshot, s)
+	}
+	return snapshot
+}
+
+// requireSnapshot fails the test if the snapshot of the replay passed does not hold the chunks at the ticks
+// passed, with the contents returned by testColumn.
+func requireSnapshot(t *testing.T, d *Data, ticks map[world.ChunkPos]uint32) {
+	t.Helper()
+	positions := d.SnapshotChunks()
+	if len(positions) != len(ticks) {
+		t.Fatalf("snapshot holds chunks %v, expected %d chunks", positions, len(ticks))
+	}
+	for _, pos := range positions {
+		want, ok := ticks[pos]
+		if !ok {
+			t.Fatalf("snapshot holds unexpected chunk %v", pos)
+		}
+		if tick, _ := d.SnapshotTick(pos); tick != want {
+			t.Fatalf("chunk %v was saved at tick %d, expected %d", pos, tick, want)
+		}
+		col, err := d.SnapshotChunk(pos)
+		if err != nil {
+			t.Fatalf("failed to read chunk %v: %v", pos, err)
+		}
+		requireColumn(t, pos, col)
+	}
+}
+
+// TestSnapshotChunkRoundTrip tests that chunks are decoded as they were encoded, including their block
+// entities.
+func TestSnapshotChunkRoundTrip(t *testing.T) {
+	for _, pos := range []world.ChunkPos{{0, 0}, {-3, 7}} {
+		col, err := decodeSnapshotChunk(encodeSnapshotChunk(testColumn(pos)))
+		if err != nil {
+			t.Fatalf("failed to decode chunk %v: %v", pos, err)
+		}
+		requireColumn(t, pos, col)
+	}
+	if _, err := decodeSnapshotChunk([]byte{1, 2, 3}); err == nil {
+		t.Fatal("invalid chunk was decoded")
+	}
+}
+
+// TestSnapshotReplay tests that the chunks of the snapshot of a replay are read back along with the tick
+// they were saved in, also if the replay is encrypted or was salvaged.
+func TestSnapshotReplay(t *testing.T) {
+	snapshot := testSnapshot(t)
+	ticks := map[world.ChunkPos]uint32{{0, 0}: 0, {-1, 2}: 0, {1, -1}: 100}
+	c, err := encryptingCipher(testKey)
+	if err != nil {
+		t.Fatalf("failed to create cipher: %v", err)
+	}
+	plain := writeTestReplay(t, writeOptions{snapshot: snapshot})
+	requireSnapshot(t, openTestReplay(t, plain, nil), ticks)
+	encrypted := writeTestReplay(t, writeOptions{snapshot: snapshot, cipher: c})
+	requireSnapshot(t, openTestReplay(t, encrypted, testKey), ticks)
+
+	d := openTestReplay(t, plain, nil)
+	order := d.snapshotOrder()
+	if !slices.IsSortedFunc(order, func(a, b snapshotChunk) int { return int(a.tick) - int(b.tick) }) || order[len(order)-1].pos != (world.ChunkPos{1, -1}) {
+		t.Fatalf("snapshot is restored in order %v, expected the chunk of tick 100 last", order)
+	}
+
+	for name, b := range map[string][]byte{"plain": plain, "encrypted": encrypted} {
+		out := bytes.NewBuffer(nil)
+		if _, err := SalvageEncrypted(bytes.NewReader(b[:len(b)-trailerSize]), out, testKey); err != nil {
+			t.Fatalf("%s: failed to salvage replay: %v", name, err)
+		}
+		requireSnapshot(t, openTestReplay(t, out.Bytes(), testKey), ticks)
+	}
+}
+
+// TestSnapshotClip tests that clips hold the chunks saved up to the end of their range, with the chunks saved
+// during the range restored at the same tick of the clip.
+func TestSnapshotClip(t *testing.T) {
+	d := openTestReplay(t, writeTestReplay(t, writeOptions{snapshot: testSnapshot(t)}), nil)
+	ranges := []struct {
+		r     TickRange
+		ticks map[world.ChunkPos]uint32
+	}{
+		{TickRange{First: 1, Last: 99}, map[world.ChunkPos]uint32{{0, 0}: 0, {-1, 2}: 0}},
+		{TickRange{First: 60, Last: 180}, map[world.ChunkPos]uint32{{0, 0}: 0, {-1, 2}: 0, {1, -1}: 41}},
+		{TickRange{First: 100, Last: 180}, map[world.ChunkPos]uint32{{0, 0}: 0, {-1, 2}: 0, {1, -1}: 1}},
+		{TickRange{First: 101, Last: 180}, map[world.ChunkPos]uint32{{0, 0}: 0, {-1, 2}: 0, {1, -1}: 0}},
+	}
+	for _, r := range ranges {
+		buf := bytes.NewBuffer(nil)
+		if err := d.Clip(buf, r.r); err != nil {
+			t.Fatalf("failed to clip range %d-%d: %v", r.r.First, r.r.Last, err)
+		}
+		requireSnapshot(t, openTestReplay(t, buf.Bytes(), nil), r.ticks)
+	}
+}
+
+// TestSnapshotNDJSON tests that the snapshot of a replay is exported as NDJSON and imported again.
+func TestSnapshotNDJSON(t *testing.T) {
+	d := openTestReplay(t, writeTestReplay(t, writeOptions{snapshot: testSnapshot(t)}), nil)
+	exported := bytes.NewBuffer(nil)
+	if err := d.ExportNDJSON(exported); err != nil {
+		t.Fatalf("failed to export replay: %v", err)
+	}
+	imported := bytes.NewBuffer(nil)
+	if err := ImportNDJSON(exported, imported); err != nil {
+		t.Fatalf("failed to import replay: %v", err)
+	}
+	i := openTestReplay(t, imported.Bytes(), nil)
+	requireSnapshot(t, i, map[world.ChunkPos]uint32{{0, 0}: 0, {-1, 2}: 0, {1, -1}: 100})
+	requireActions(t, i, testTicks, testActions)
+}
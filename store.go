@@ -0,0 +1,112 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"time"
+)
+
+// ErrReplayNotFound is returned by a ReplayStore when no replay with the ID passed is stored.
+var ErrReplayNotFound = errors.New("replay not found")
+
+// ReplayStore stores replays by their ID. DirStore stores replays in a directory, while MemoryStore keeps
+// them in memory. Implementations must be safe for concurrent use.
+type ReplayStore interface {
+	// Save stores the replay read from r under the ID passed, replacing any replay stored under the same ID.
+	// The replay is only visible to the other methods once it was read completely.
+	Save(id uuid.UUID, r io.Reader) error
+	// Open returns a reader of the replay stored under the ID passed, which must be closed once the replay
+	// was read. Data.LoadActions may be used to load the replay.
+	Open(id uuid.UUID) (io.ReadCloser, error)
+	// Delete removes the replay stored under the ID passed.
+	Delete(id uuid.UUID) error
+	// List returns every replay stored, ordered by the time at which they were saved, oldest first.
+	List() ([]StoredReplay, error)
+	// Stat returns the replay stored under the ID passed.
+	Stat(id uuid.UUID) (StoredReplay, error)
+}
+
+// StoredReplay describes a replay stored in a ReplayStore.
+type StoredReplay struct {
+	// ID is the ID that the replay is stored under.
+	ID uuid.UUID
+	// Metadata is the metadata read from the header of the replay. It is empty except for its ID if the
+	// replay was written in the legacy format without a header, and only holds the ID, start time and end
+	// time of encrypted replays.
+	Metadata Metadata
+	// Size is the size of the replay in bytes.
+	Size int64
+	// Saved is the time at which the replay was saved.
+	Saved time.Time
+}
+
+// Query returns the replays stored in the ReplayStore passed of which the metadata matches the function
+// passed, in the order returned by ReplayStore.List.
+func Query(s ReplayStore, match func(meta Metadata) bool) ([]StoredReplay, error) {
+	replays, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	matched := replays[:0]
+	for _, r := range replays {
+		if match(r.Metadata) {
+			matched = append(matched, r)
+		}
+	}
+	return matched, nil
+}
+
+// Load loads the replay stored in the ReplayStore passed under the ID passed. If keys is not nil, it is used
+// to decrypt the replay if it is encrypted.
+func Load(s ReplayStore, id uuid.UUID, keys KeyProvider) (*Data, error) {
+	r, err := s.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	d := NewData(id)
+	if keys != nil {
+		d.SetKeyProvider(keys)
+	}
+	if err := d.LoadActions(r); err != nil {
+		return nil, fmt.Errorf("failed to load replay %v: %w", id, err)
+	}
+	return d, nil
+}
+
+// expiredReplays returns the replays passed, which must be ordered by the time at which they were saved,
+// that are older than maxAge or that make the replays exceed maxTotalSize, oldest first. A maxAge or
+// maxTotalSize of 0 is not enforced. The replay with the ID keep is never returned, and the replay saved
+// last is never returned to make room.
+func expiredReplays(replays []StoredReplay, maxAge time.Duration, maxTotalSize int64, keep uuid.UUID) []StoredReplay {
+	total := int64(0)
+	for _, r := range replays {
+		total += r.Size
+	}
+	var expired []StoredReplay
+	for i, r := range replays {
+		tooOld := maxAge != 0 && time.Since(r.Saved) > maxAge
+		tooLarge := maxTotalSize != 0 && total > maxTotalSize && i != len(replays)-1
+		if r.ID == keep || (!tooOld && !tooLarge) {
+			continue
+		}
+		expired = append(expired, r)
+		total -= r.Size
+	}
+	return expired
+}
+
+// storedMetadata reads the metadata of a stored replay using ReadMetadata. Replays without a header are
+// given metadata holding only the ID they are stored under.
+func storedMetadata(id uuid.UUID, r io.Reader) (Metadata, error) {
+	meta, err := ReadMetadata(r)
+	if errors.Is(err, ErrNoHeader) {
+		return Metadata{ID: id}, nil
+	}
+	if err != nil {
+		return meta, fmt.Errorf("failed to read metadata of replay %v: %w", id, err)
+	}
+	return meta, nil
+}
@@ -0,0 +1,251 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dirStoreExt is the extension of the files of the replays in a DirStore.
+const dirStoreExt = ".replay"
+
+// dirStoreTempPrefix is the prefix of the temporary files that replays are written to before they are
+// saved in a DirStore.
+const dirStoreTempPrefix = ".save-"
+
+// staleTempAge is the time after which a temporary file in a DirStore that was not written to is assumed to
+// be left behind by a save that never finished, such as one interrupted by a crash, and is deleted.
+const staleTempAge = time.Hour
+
+// DirStoreConfig holds the settings of a DirStore.
+type DirStoreConfig struct {
+	// Dir is the directory that replays are stored in. It is created if it does not exist.
+	Dir string
+	// MaxAge, if not 0, is the time for which replays are kept after they were saved. Older replays are
+	// deleted when a replay is saved or when DirStore.Prune is called.
+	MaxAge time.Duration
+	// MaxTotalSize, if not 0, is the maximum size in bytes of all replays stored together. If a replay saved
+	// makes the replays exceed it, the replays that were saved first are deleted until they no longer do.
+	// The replay saved last is never deleted to make room.
+	MaxTotalSize int64
+}
+
+// DirStore is a ReplayStore that stores every replay as a file in a directory, named after the ID of the
+// replay. Replays are written to a temporary file first, so that a replay is never partially visible.
+type DirStore struct {
+	conf DirStoreConfig
+	// mu is held while replays are saved, deleted or pruned.
+	mu sync.Mutex
+}
+
+// New creates a DirStore using the settings of the DirStoreConfig, creating its directory if needed.
+func (conf DirStoreConfig) New() (*DirStore, error) {
+	if conf.Dir == "" {
+		return nil, errors.New("replay store directory must be set")
+	}
+	if err := os.MkdirAll(conf.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create replay store directory: %w", err)
+	}
+	return &DirStore{conf: conf}, nil
+}
+
+var _ ReplayStore = (*DirStore)(nil)
+
+// path returns the path of the file of the replay with the ID passed.
+func (s *DirStore) path(id uuid.UUID) string {
+	return filepath.Join(s.conf.Dir, id.String()+dirStoreExt)
+}
+
+// Save ...
+func (s *DirStore) Save(id uuid.UUID, r io.Reader) error {
+	f, err := os.CreateTemp(s.conf.Dir, dirStoreTempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("failed to create replay file: %w", err)
+	}
+	tmp := f.Name()
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write replay: %w", err)
+	}
+	if err := errors.Join(f.Sync(), f.Close()); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write replay: %w", err)
+	}
+	// The metadata is read once before the replay is saved, so that replays with a malformed header are
+	// rejected. Data without a header is accepted as a replay written in the legacy format.
+	if _, err := readStoredMetadata(id, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Rename(tmp, s.path(id)); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to save replay: %w", err)
+	}
+	_, err = s.prune(id)
+	return err
+}
+
+// Open ...
+func (s *DirStore) Open(id uuid.UUID) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("replay %v: %w", id, ErrReplayNotFound)
+	}
+	return f, err
+}
+
+// Delete ...
+func (s *DirStore) Delete(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.Remove(s.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("replay %v: %w", id, ErrReplayNotFound)
+	}
+	return err
+}
+
+// List ...
+func (s *DirStore) List() ([]StoredReplay, error) {
+	replays, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+	listed := replays[:0]
+	for _, r := range replays {
+		if r.Metadata, err = readStoredMetadata(r.ID, s.path(r.ID)); errors.Is(err, fs.ErrNotExist) {
+			// The replay was deleted after the directory was read.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		listed = append(listed, r)
+	}
+	return listed, nil
+}
+
+// Stat ...
+func (s *DirStore) Stat(id uuid.UUID) (StoredReplay, error) {
+	info, err := os.Stat(s.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return StoredReplay{}, fmt.Errorf("replay %v: %w", id, ErrReplayNotFound)
+	} else if err != nil {
+		return StoredReplay{}, err
+	}
+	meta, err := readStoredMetadata(id, s.path(id))
+	if err != nil {
+		return StoredReplay{}, err
+	}
+	return StoredReplay{ID: id, Metadata: meta, Size: info.Size(), Saved: info.ModTime()}, nil
+}
+
+// Prune deletes the replays that are older than DirStoreConfig.MaxAge or that make the replays exceed
+// DirStoreConfig.MaxTotalSize, and returns the amount of replays deleted. Replays are also pruned every time
+// a replay is saved. Temporary files left behind by saves that never finished are deleted too.
+func (s *DirStore) Prune() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.prune(uuid.Nil)
+}
+
+// prune deletes the replays that fall outside the retention policy of the DirStore, except for the replay
+// with the ID passed, and the stale temporary files in the directory of the DirStore.
+func (s *DirStore) prune(keep uuid.UUID) (int, error) {
+	if err := s.removeStaleTemp(); err != nil {
+		return 0, err
+	}
+	if s.conf.MaxAge == 0 && s.conf.MaxTotalSize == 0 {
+		return 0, nil
+	}
+	replays, err := s.files()
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, r := range expiredReplays(replays, s.conf.MaxAge, s.conf.MaxTotalSize, keep) {
+		if err := os.Remove(s.path(r.ID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return deleted, fmt.Errorf("failed to delete replay %v: %w", r.ID, err)
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// removeStaleTemp deletes the temporary files in the directory of the DirStore that were not written to for
+// staleTempAge.
+func (s *DirStore) removeStaleTemp() error {
+	entries, err := os.ReadDir(s.conf.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to read replay store directory: %w", err)
+	}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), dirStoreTempPrefix) || !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// The save finished or failed after the directory was read.
+			continue
+		} else if err != nil {
+			return err
+		}
+		if time.Since(info.ModTime()) < staleTempAge {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.conf.Dir, e.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete temporary file %v: %w", e.Name(), err)
+		}
+	}
+	return nil
+}
+
+// files returns the replays in the directory of the DirStore without their metadata, ordered by the time at
+// which they were saved.
+func (s *DirStore) files() ([]StoredReplay, error) {
+	entries, err := os.ReadDir(s.conf.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replay store directory: %w", err)
+	}
+	replays := make([]StoredReplay, 0, len(entries))
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), dirStoreExt)
+		if !ok || !e.Type().IsRegular() {
+			continue
+		}
+		id, err := uuid.Parse(name)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// The replay was deleted after the directory was read.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		replays = append(replays, StoredReplay{ID: id, Size: info.Size(), Saved: info.ModTime()})
+	}
+	sortStoredReplays(replays)
+	return replays, nil
+}
+
+// readStoredMetadata reads the metadata of the replay with the ID passed from the file at the path passed.
+func readStoredMetadata(id uuid.UUID, path string) (Metadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer f.Close()
+	return storedMetadata(id, f)
+}
@@ -0,0 +1,145 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryStoreConfig holds the settings of a MemoryStore.
+type MemoryStoreConfig struct {
+	// MaxAge, if not 0, is the time for which replays are kept after they were saved. Older replays are
+	// deleted when a replay is saved or when MemoryStore.Prune is called.
+	MaxAge time.Duration
+	// MaxTotalSize, if not 0, is the maximum size in bytes of all replays stored together. If a replay saved
+	// makes the replays exceed it, the replays that were saved first are deleted until they no longer do.
+	// The replay saved last is never deleted to make room.
+	MaxTotalSize int64
+}
+
+// MemoryStore is a ReplayStore that keeps replays in memory. It is mostly useful in tests, as it applies
+// the same retention policy as a DirStore.
+type MemoryStore struct {
+	conf    MemoryStoreConfig
+	mu      sync.Mutex
+	replays map[uuid.UUID]memoryReplay
+}
+
+// memoryReplay is a replay stored in a MemoryStore.
+type memoryReplay struct {
+	info StoredReplay
+	data []byte
+}
+
+// New creates an empty MemoryStore using the settings of the MemoryStoreConfig.
+func (conf MemoryStoreConfig) New() *MemoryStore {
+	return &MemoryStore{conf: conf, replays: make(map[uuid.UUID]memoryReplay)}
+}
+
+var _ ReplayStore = (*MemoryStore)(nil)
+
+// Save ...
+func (s *MemoryStore) Save(id uuid.UUID, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read replay: %w", err)
+	}
+	meta, err := storedMetadata(id, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replays[id] = memoryReplay{
+		info: StoredReplay{ID: id, Metadata: meta, Size: int64(len(b)), Saved: time.Now()},
+		data: b,
+	}
+	s.prune(id)
+	return nil
+}
+
+// Open ...
+func (s *MemoryStore) Open(id uuid.UUID) (io.ReadCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.replays[id]
+	if !ok {
+		return nil, fmt.Errorf("replay %v: %w", id, ErrReplayNotFound)
+	}
+	return io.NopCloser(bytes.NewReader(r.data)), nil
+}
+
+// Delete ...
+func (s *MemoryStore) Delete(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.replays[id]; !ok {
+		return fmt.Errorf("replay %v: %w", id, ErrReplayNotFound)
+	}
+	delete(s.replays, id)
+	return nil
+}
+
+// List ...
+func (s *MemoryStore) List() ([]StoredReplay, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	replays := make([]StoredReplay, 0, len(s.replays))
+	for _, r := range s.replays {
+		replays = append(replays, r.info)
+	}
+	sortStoredReplays(replays)
+	return replays, nil
+}
+
+// Stat ...
+func (s *MemoryStore) Stat(id uuid.UUID) (StoredReplay, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.replays[id]
+	if !ok {
+		return StoredReplay{}, fmt.Errorf("replay %v: %w", id, ErrReplayNotFound)
+	}
+	return r.info, nil
+}
+
+// Prune deletes the replays that are older than MemoryStoreConfig.MaxAge or that make the replays exceed
+// MemoryStoreConfig.MaxTotalSize, and returns the amount of replays deleted. Replays are also pruned every
+// time a replay is saved.
+func (s *MemoryStore) Prune() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.prune(uuid.Nil), nil
+}
+
+// prune deletes the replays that fall outside the retention policy of the MemoryStore, except for the
+// replay with the ID passed, and returns the amount of replays deleted.
+func (s *MemoryStore) prune(keep uuid.UUID) int {
+	if s.conf.MaxAge == 0 && s.conf.MaxTotalSize == 0 {
+		return 0
+	}
+	replays := make([]StoredReplay, 0, len(s.replays))
+	for _, r := range s.replays {
+		replays = append(replays, r.info)
+	}
+	sortStoredReplays(replays)
+	expired := expiredReplays(replays, s.conf.MaxAge, s.conf.MaxTotalSize, keep)
+	for _, r := range expired {
+		delete(s.replays, r.ID)
+	}
+	return len(expired)
+}
+
+// sortStoredReplays sorts replays by the time at which they were saved, oldest first.
+func sortStoredReplays(replays []StoredReplay) {
+	slices.SortFunc(replays, func(a, b StoredReplay) int {
+		if c := a.Saved.Compare(b.Saved); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.ID[:], b.ID[:])
+	})
+}
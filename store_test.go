@@ -0,0 +1,204 @@
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"github.com/google/uuid"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// testStore is a ReplayStore used by the tests, along with the functions to prune it and to change the time
+// at which a replay stored in it was saved.
+type testStore struct {
+	name     string
+	store    ReplayStore
+	prune    func() (int, error)
+	setSaved func(id uuid.UUID, saved time.Time)
+}
+
+// testStores returns a DirStore and a MemoryStore that both use the retention policy passed.
+func testStores(t *testing.T, maxAge time.Duration, maxTotalSize int64) []testStore {
+	t.Helper()
+	dir, err := DirStoreConfig{Dir: t.TempDir(), MaxAge: maxAge, MaxTotalSize: maxTotalSize}.New()
+	if err != nil {
+		t.Fatalf("failed to create directory store: %v", err)
+	}
+	mem := MemoryStoreConfig{MaxAge: maxAge, MaxTotalSize: maxTotalSize}.New()
+	return []testStore{
+		{name: "dir", store: dir, prune: dir.Prune, setSaved: func(id uuid.UUID, saved time.Time) {
+			if err := os.Chtimes(dir.path(id), saved, saved); err != nil {
+				t.Fatalf("failed to change time of replay %v: %v", id, err)
+			}
+		}},
+		{name: "memory", store: mem, prune: mem.Prune, setSaved: func(id uuid.UUID, saved time.Time) {
+			mem.mu.Lock()
+			defer mem.mu.Unlock()
+			r := mem.replays[id]
+			r.info.Saved = saved
+			mem.replays[id] = r
+		}},
+	}
+}
+
+// storedIDs returns the IDs of the replays listed by the ReplayStore passed, in the order listed.
+func storedIDs(t *testing.T, s ReplayStore) []uuid.UUID {
+	t.Helper()
+	replays, err := s.List()
+	if err != nil {
+		t.Fatalf("failed to list replays: %v", err)
+	}
+	ids := make([]uuid.UUID, 0, len(replays))
+	for _, r := range replays {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+// saveTestReplays saves the replay passed under every ID passed, each one minute after the one before, with
+// the last one saved now.
+func saveTestReplays(t *testing.T, ts testStore, b []byte, ids ...uuid.UUID) {
+	t.Helper()
+	for i, id := range ids {
+		if err := ts.store.Save(id, bytes.NewReader(b)); err != nil {
+			t.Fatalf("%s: failed to save replay %v: %v", ts.name, id, err)
+		}
+		ts.setSaved(id, time.Now().Add(time.Duration(i-len(ids)+1)*time.Minute))
+	}
+}
+
+// TestStore tests that replays saved in a ReplayStore are listed in the order they were saved, may be opened
+// and deleted, and that data with a malformed header is rejected.
+func TestStore(t *testing.T) {
+	b := writeTestReplay(t, writeOptions{})
+	ids := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for _, ts := range testStores(t, 0, 0) {
+		saveTestReplays(t, ts, b, ids[2], ids[0], ids[1])
+		// Changing the time a replay was saved at changes its position in the list.
+		ts.setSaved(ids[2], time.Now().Add(time.Minute))
+		if got := storedIDs(t, ts.store); !slices.Equal(got, ids) {
+			t.Fatalf("%s: listed replays %v, expected %v", ts.name, got, ids)
+		}
+
+		info, err := ts.store.Stat(ids[0])
+		if err != nil {
+			t.Fatalf("%s: failed to stat replay: %v", ts.name, err)
+		}
+		if info.ID != ids[0] || info.Size != int64(len(b)) || info.Metadata.ID != testMetadata().ID || info.Metadata.Tags["map"] != "lobby" {
+			t.Fatalf("%s: stat returned %+v", ts.name, info)
+		}
+		matched, err := Query(ts.store, func(meta Metadata) bool { return meta.Tags["map"] == "lobby" })
+		if err != nil || len(matched) != len(ids) {
+			t.Fatalf("%s: query matched %d replays, %v, expected all %d", ts.name, len(matched), err, len(ids))
+		}
+		r, err := ts.store.Open(ids[1])
+		if err != nil {
+			t.Fatalf("%s: failed to open replay: %v", ts.name, err)
+		}
+		stored, err := io.ReadAll(r)
+		_ = r.Close()
+		if err != nil || !bytes.Equal(stored, b) {
+			t.Fatalf("%s: opened replay differs from the replay saved: %v", ts.name, err)
+		}
+
+		if err := ts.store.Delete(ids[1]); err != nil {
+			t.Fatalf("%s: failed to delete replay: %v", ts.name, err)
+		}
+		if _, err := ts.store.Open(ids[1]); !errors.Is(err, ErrReplayNotFound) {
+			t.Fatalf("%s: opening deleted replay returned %v, expected ErrReplayNotFound", ts.name, err)
+		}
+		if err := ts.store.Delete(ids[1]); !errors.Is(err, ErrReplayNotFound) {
+			t.Fatalf("%s: deleting deleted replay returned %v, expected ErrReplayNotFound", ts.name, err)
+		}
+
+		malformed := append([]byte(formatMagic), 0xff, 0xff, 1, 2, 3)
+		if err := ts.store.Save(ids[1], bytes.NewReader(malformed)); err == nil {
+			t.Fatalf("%s: saved data with a malformed header", ts.name)
+		}
+		if got := storedIDs(t, ts.store); !slices.Equal(got, []uuid.UUID{ids[0], ids[2]}) {
+			t.Fatalf("%s: listed replays %v after deleting one and rejecting another", ts.name, got)
+		}
+	}
+}
+
+// TestStoreMaxAge tests that replays older than the maximum age are deleted once pruned, and that the replay
+// saved last is kept regardless of its age.
+func TestStoreMaxAge(t *testing.T) {
+	b := writeTestReplay(t, writeOptions{})
+	ids := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for _, ts := range testStores(t, time.Hour, 0) {
+		saveTestReplays(t, ts, b, ids...)
+		ts.setSaved(ids[0], time.Now().Add(-2*time.Hour))
+		if n, err := ts.prune(); err != nil || n != 1 {
+			t.Fatalf("%s: pruning deleted %d replays, %v, expected 1", ts.name, n, err)
+		}
+		if got := storedIDs(t, ts.store); !slices.Equal(got, ids[1:]) {
+			t.Fatalf("%s: listed replays %v after pruning, expected %v", ts.name, got, ids[1:])
+		}
+
+		// Saving a replay prunes the others, but never the replay saved.
+		ts.setSaved(ids[1], time.Now().Add(-2*time.Hour))
+		ts.setSaved(ids[2], time.Now().Add(-2*time.Hour))
+		if err := ts.store.Save(ids[2], bytes.NewReader(b)); err != nil {
+			t.Fatalf("%s: failed to save replay: %v", ts.name, err)
+		}
+		if got := storedIDs(t, ts.store); !slices.Equal(got, ids[2:]) {
+			t.Fatalf("%s: listed replays %v after saving, expected %v", ts.name, got, ids[2:])
+		}
+	}
+}
+
+// TestStoreMaxTotalSize tests that the replays saved first are deleted once the replays exceed the maximum
+// total size, and that the replay saved last is kept even if it exceeds the size by itself.
+func TestStoreMaxTotalSize(t *testing.T) {
+	b := writeTestReplay(t, writeOptions{})
+	ids := []uuid.UUID{uuid.New(), uuid.New(), uuid.New(), uuid.New()}
+	for _, ts := range testStores(t, 0, int64(len(b))*5/2) {
+		saveTestReplays(t, ts, b, ids...)
+		if got := storedIDs(t, ts.store); !slices.Equal(got, ids[2:]) {
+			t.Fatalf("%s: listed replays %v, expected the last two %v", ts.name, got, ids[2:])
+		}
+	}
+	for _, ts := range testStores(t, 0, int64(len(b))/2) {
+		saveTestReplays(t, ts, b, ids...)
+		if got := storedIDs(t, ts.store); !slices.Equal(got, ids[3:]) {
+			t.Fatalf("%s: listed replays %v, expected only the replay saved last", ts.name, got)
+		}
+		if n, err := ts.prune(); err != nil || n != 0 {
+			t.Fatalf("%s: pruning deleted %d replays, %v, expected the replay saved last to be kept", ts.name, n, err)
+		}
+	}
+}
+
+// TestDirStoreTempFiles tests that pruning a DirStore deletes temporary files left behind by saves that
+// never finished, but not temporary files of saves that may still be in progress.
+func TestDirStoreTempFiles(t *testing.T) {
+	dir := t.TempDir()
+	s, err := DirStoreConfig{Dir: dir}.New()
+	if err != nil {
+		t.Fatalf("failed to create directory store: %v", err)
+	}
+	stale, fresh := filepath.Join(dir, dirStoreTempPrefix+"1"), filepath.Join(dir, dirStoreTempPrefix+"2")
+	for _, path := range []string{stale, fresh} {
+		if err := os.WriteFile(path, []byte("partial"), 0o644); err != nil {
+			t.Fatalf("failed to write temporary file: %v", err)
+		}
+	}
+	old := time.Now().Add(-2 * staleTempAge)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatalf("failed to change time of temporary file: %v", err)
+	}
+	if n, err := s.Prune(); err != nil || n != 0 {
+		t.Fatalf("pruning deleted %d replays, %v, expected none", n, err)
+	}
+	if _, err := os.Stat(stale); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("stale temporary file was not deleted: %v", err)
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Fatalf("temporary file of a save in progress was deleted: %v", err)
+	}
+}
@@ -0,0 +1,335 @@
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/samber/lo"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"math"
+	"slices"
+	"sort"
+)
+
+// Segments may store the movement of players and entities apart from the other actions recorded, in a tracks
+// record preceding the segment record. The segment then only holds the remaining actions, while the tracks
+// record holds a track for every player and entity that moved. The movements of all tracks are stored column
+// by column, so that similar values, such as the ticks or the X coordinates of all movements, are compressed
+// together instead of being interleaved with unrelated actions. Every movement stores the index it had among
+// the actions of its tick, so that the actions of a tick are restored in their original order.
+//
+// The decompressed payload of a tracks record starts with the amount of tracks, followed by the kind, the ID
+// and the amount of movements of every track. The columns follow as length prefixed byte slices, in the
+// order of trackColumn. The movements of every track are stored in the columns one after another.
+
+// trackColumn is one of the columns of a tracks record.
+type trackColumn int
+
+const (
+	// columnTick holds the tick of every movement as the difference to the tick of the previous movement of
+	// the track.
+	columnTick trackColumn = iota
+	// columnIndex holds the index of every movement among the actions of its tick.
+	columnIndex
+	// columnKind holds the kind of every movement, either trackMove or trackDeltaMove.
+	columnKind
+	// columnFlags holds the flags of every delta movement.
+	columnFlags
+	// columnX, columnY and columnZ hold the axes of the position of every movement. Axes are stored as
+	// float32s, except for the axes of relative delta movements, which are stored as the amount of steps of
+	// their precision.
+	columnX
+	columnY
+	columnZ
+	// columnYaw and columnPitch hold the rotation of every movement that has one.
+	columnYaw
+	columnPitch
+	trackColumns
+)
+
+const (
+	// trackPlayer and trackEntity are the kinds of tracks, holding the movements of a player or an entity.
+	trackPlayer uint8 = iota
+	trackEntity
+)
+
+const (
+	// trackMove and trackDeltaMove are the kinds of movements in a track, being action.PlayerMove or
+	// action.EntityMove, and action.PlayerDeltaMove or action.EntityDeltaMove respectively.
+	trackMove uint8 = iota
+	trackDeltaMove
+)
+
+// trackKey identifies the track of a player or an entity.
+type trackKey struct {
+	kind uint8
+	id   uint32
+}
+
+// trackedMove is a movement stored in a track, along with its tick and its index among the actions of the
+// tick.
+type trackedMove struct {
+	tick, index uint32
+	a           action.Action
+}
+
+// trackOf returns the track that the action passed is stored in, or false if the action is not a movement.
+func trackOf(a action.Action) (trackKey, bool) {
+	switch a := a.(type) {
+	case *action.PlayerMove:
+		return trackKey{kind: trackPlayer, id: a.PlayerID}, true
+	case *action.PlayerDeltaMove:
+		return trackKey{kind: trackPlayer, id: a.PlayerID}, true
+	case *action.EntityMove:
+		return trackKey{kind: trackEntity, id: a.EntityID}, true
+	case *action.EntityDeltaMove:
+		return trackKey{kind: trackEntity, id: a.EntityID}, true
+	}
+	return trackKey{}, false
+}
+
+// splitTracks splits a stream of ticks of the current format version, as held by a segment, into a stream
+// holding all actions but movements and the uncompressed payload of a tracks record holding the movements.
+// The payload returned is nil if the stream does not hold any movements.
+func splitTracks(stream []byte) (events, tracks []byte, err error) {
+	actions := make(map[uint32][]action.Action)
+	if _, err := decodeTicks(stream, actions, FormatVersion); err != nil {
+		return nil, nil, err
+	}
+	ticks := make([]uint32, 0, len(actions))
+	for tick := range actions {
+		ticks = append(ticks, tick)
+	}
+	slices.Sort(ticks)
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(stream)))
+	w := protocol.NewWriter(buf, 0)
+	var keys []trackKey
+	moves := make(map[trackKey][]trackedMove)
+	for _, tick := range ticks {
+		remaining := make([]action.Action, 0, len(actions[tick]))
+		for i, a := range actions[tick] {
+			key, ok := trackOf(a)
+			if !ok {
+				remaining = append(remaining, a)
+				continue
+			}
+			if _, ok := moves[key]; !ok {
+				keys = append(keys, key)
+			}
+			moves[key] = append(moves[key], trackedMove{tick: tick, index: uint32(i), a: a})
+		}
+		w.Varuint32(&tick)
+		w.Varuint32(lo.ToPtr(uint32(len(remaining))))
+		for _, a := range remaining {
+			action.Write(w, a)
+		}
+	}
+	if len(keys) == 0 {
+		return stream, nil, nil
+	}
+	return buf.Bytes(), encodeTracks(keys, moves), nil
+}
+
+// encodeTracks encodes the movements passed into the uncompressed payload of a tracks record, storing the
+// tracks in the order of the keys passed.
+func encodeTracks(keys []trackKey, moves map[trackKey][]trackedMove) []byte {
+	var columns [trackColumns]*bytes.Buffer
+	var writers [trackColumns]*protocol.Writer
+	for i := range columns {
+		columns[i] = bytes.NewBuffer(nil)
+		writers[i] = protocol.NewWriter(columns[i], 0)
+	}
+	axes := [3]*protocol.Writer{writers[columnX], writers[columnY], writers[columnZ]}
+
+	buf := bytes.NewBuffer(nil)
+	w := protocol.NewWriter(buf, 0)
+	w.Varuint32(lo.ToPtr(uint32(len(keys))))
+	for _, key := range keys {
+		w.Uint8(lo.ToPtr(key.kind))
+		w.Varuint32(lo.ToPtr(key.id))
+		w.Varuint32(lo.ToPtr(uint32(len(moves[key]))))
+
+		prevTick := uint32(0)
+		for _, m := range moves[key] {
+			writers[columnTick].Varuint32(lo.ToPtr(m.tick - prevTick))
+			writers[columnIndex].Varuint32(lo.ToPtr(m.index))
+			prevTick = m.tick
+
+			var (
+				kind, flags uint8
+				pos         mgl32.Vec3
+				yaw, pitch  uint16
+			)
+			switch a := m.a.(type) {
+			case *action.PlayerMove:
+				kind, flags, pos, yaw, pitch = trackMove, trackMoveFlags, a.Position, a.Yaw, a.Pitch
+			case *action.EntityMove:
+				kind, flags, pos, yaw, pitch = trackMove, trackMoveFlags, a.Position, a.Yaw, a.Pitch
+			case *action.PlayerDeltaMove:
+				kind, flags, pos, yaw, pitch = trackDeltaMove, a.Flags, a.Position, a.Yaw, a.Pitch
+			case *action.EntityDeltaMove:
+				kind, flags, pos, yaw, pitch = trackDeltaMove, a.Flags, a.Position, a.Yaw, a.Pitch
+			}
+			writers[columnKind].Uint8(&kind)
+			if kind == trackDeltaMove {
+				writers[columnFlags].Uint8(&flags)
+			}
+			writeTrackPosition(axes, flags, &pos)
+			if flags&action.PlayerDeltaMoveHasYawFlag != 0 {
+				writers[columnYaw].Uint16(&yaw)
+			}
+			if flags&action.PlayerDeltaMoveHasPitchFlag != 0 {
+				writers[columnPitch].Uint16(&pitch)
+			}
+		}
+	}
+	for _, c := range columns {
+		w.ByteSlice(lo.ToPtr(c.Bytes()))
+	}
+	return buf.Bytes()
+}
+
+// trackMoveFlags are the flags used to store the axes and rotation of absolute movements, which always have
+// all of them.
+const trackMoveFlags = action.PlayerDeltaMoveHasXFlag | action.PlayerDeltaMoveHasYFlag | action.PlayerDeltaMoveHasZFlag |
+	action.PlayerDeltaMoveHasYawFlag | action.PlayerDeltaMoveHasPitchFlag
+
+// writeTrackPosition writes the axes of a position with the flags passed, using a separate column for every
+// axis. The flags of player and entity delta movements are the same.
+func writeTrackPosition(axes [3]*protocol.Writer, flags uint8, pos *mgl32.Vec3) {
+	step := (&action.PlayerDeltaMove{Flags: flags}).Precision().Step()
+	for i, w := range axes {
+		switch {
+		case flags&(1<<i) == 0:
+		case step != 0:
+			w.Varint32(lo.ToPtr(int32(math.Round(float64(pos[i]) / step))))
+		default:
+			w.Float32(&pos[i])
+		}
+	}
+}
+
+// decodeTracks decodes the uncompressed payload of a tracks record, returning the movements it holds by
+// their tick.
+func decodeTracks(payload []byte) (moves map[uint32][]trackedMove, err error) {
+	buf := bytes.NewBuffer(payload)
+	r := protocol.NewReader(buf, 0, false)
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("failed to decode tracks: %v", rec)
+		}
+	}()
+	var count uint32
+	r.Varuint32(&count)
+	// Every track takes up at least three bytes, which limits the allocation for corrupted counts.
+	keys := make([]trackKey, 0, min(int(count), buf.Len()/3))
+	lengths := make([]uint32, 0, cap(keys))
+	for range count {
+		var (
+			key    trackKey
+			length uint32
+		)
+		r.Uint8(&key.kind)
+		r.Varuint32(&key.id)
+		r.Varuint32(&length)
+		if key.kind != trackPlayer && key.kind != trackEntity {
+			return nil, fmt.Errorf("unknown track kind %d", key.kind)
+		}
+		keys = append(keys, key)
+		lengths = append(lengths, length)
+	}
+	var readers [trackColumns]*protocol.Reader
+	for i := range readers {
+		var column []byte
+		r.ByteSlice(&column)
+		readers[i] = protocol.NewReader(bytes.NewReader(column), 0, false)
+	}
+	axes := [3]*protocol.Reader{readers[columnX], readers[columnY], readers[columnZ]}
+
+	moves = make(map[uint32][]trackedMove)
+	for i, key := range keys {
+		tick := uint32(0)
+		for range lengths[i] {
+			var delta, index uint32
+			readers[columnTick].Varuint32(&delta)
+			readers[columnIndex].Varuint32(&index)
+			tick += delta
+
+			var (
+				kind, flags uint8
+				pos         mgl32.Vec3
+				yaw, pitch  uint16
+			)
+			readers[columnKind].Uint8(&kind)
+			switch kind {
+			case trackMove:
+				flags = trackMoveFlags
+			case trackDeltaMove:
+				readers[columnFlags].Uint8(&flags)
+			default:
+				return nil, fmt.Errorf("unknown movement kind %d in track %d", kind, key.id)
+			}
+			readTrackPosition(axes, flags, &pos)
+			if flags&action.PlayerDeltaMoveHasYawFlag != 0 {
+				readers[columnYaw].Uint16(&yaw)
+			}
+			if flags&action.PlayerDeltaMoveHasPitchFlag != 0 {
+				readers[columnPitch].Uint16(&pitch)
+			}
+
+			var a action.Action
+			switch {
+			case key.kind == trackPlayer && kind == trackMove:
+				a = &action.PlayerMove{PlayerID: key.id, Position: pos, Yaw: yaw, Pitch: pitch}
+			case key.kind == trackPlayer:
+				a = &action.PlayerDeltaMove{Flags: flags, PlayerID: key.id, Position: pos, Yaw: yaw, Pitch: pitch}
+			case kind == trackMove:
+				a = &action.EntityMove{EntityID: key.id, Position: pos, Yaw: yaw, Pitch: pitch}
+			default:
+				a = &action.EntityDeltaMove{Flags: flags, EntityID: key.id, Position: pos, Yaw: yaw, Pitch: pitch}
+			}
+			moves[tick] = append(moves[tick], trackedMove{tick: tick, index: index, a: a})
+		}
+	}
+	return moves, nil
+}
+
+// readTrackPosition reads the axes of a position written using writeTrackPosition.
+func readTrackPosition(axes [3]*protocol.Reader, flags uint8, pos *mgl32.Vec3) {
+	step := (&action.PlayerDeltaMove{Flags: flags}).Precision().Step()
+	for i, r := range axes {
+		switch {
+		case flags&(1<<i) == 0:
+		case step != 0:
+			var steps int32
+			r.Varint32(&steps)
+			pos[i] = float32(float64(steps) * step)
+		default:
+			r.Float32(&pos[i])
+		}
+	}
+}
+
+// mergeTracks inserts the movements of a tick back into the remaining actions of the tick, at the indices
+// they had before they were split off.
+func mergeTracks(events []action.Action, moves []trackedMove) ([]action.Action, error) {
+	sort.SliceStable(moves, func(i, j int) bool {
+		return moves[i].index < moves[j].index
+	})
+	merged := make([]action.Action, 0, len(events)+len(moves))
+	for len(events) > 0 || len(moves) > 0 {
+		if len(moves) > 0 && moves[0].index == uint32(len(merged)) {
+			merged = append(merged, moves[0].a)
+			moves = moves[1:]
+			continue
+		}
+		if len(events) == 0 {
+			return nil, fmt.Errorf("movement at index %d of tick %d is out of range", moves[0].index, moves[0].tick)
+		}
+		merged = append(merged, events[0])
+		events = events[1:]
+	}
+	return merged, nil
+}
@@ -0,0 +1,109 @@
+package replay
+
+import (
+	"bytes"
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/google/uuid"
+	"github.com/samber/lo"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"testing"
+)
+
+// trackTestActions returns the actions returned by testActions, with movements of the entity and further
+// movements of the first player interleaved with the other actions.
+func trackTestActions(tick uint32) ([]action.Action, error) {
+	actions, err := testActions(tick)
+	if tick < 120 {
+		actions = append(actions,
+			&action.EntityMove{EntityID: 3, Position: mgl32.Vec3{4, 64, float32(tick)}, Yaw: uint16(tick)},
+			&action.PlayerAnimate{PlayerID: 1, Animation: 2},
+			&action.EntityDeltaMove{EntityID: 3, Flags: action.EntityDeltaMoveHasXFlag | action.EntityDeltaMoveRelativeFlag, Position: mgl32.Vec3{0.25}},
+		)
+	}
+	if tick%3 == 0 {
+		actions = append(actions, &action.PlayerMove{PlayerID: 1, Position: mgl32.Vec3{float32(tick), 65, 1}, Pitch: uint16(tick)})
+	}
+	return actions, err
+}
+
+// TestTracksRoundTrip tests that movements split off into tracks are merged back into the actions of their
+// tick in their original order.
+func TestTracksRoundTrip(t *testing.T) {
+	stream := bytes.NewBuffer(nil)
+	w := protocol.NewWriter(stream, 0)
+	for tick := uint32(1); tick <= testTicks; tick++ {
+		actions, _ := trackTestActions(tick)
+		w.Varuint32(lo.ToPtr(tick))
+		w.Varuint32(lo.ToPtr(uint32(len(actions))))
+		for _, a := range actions {
+			action.Write(w, a)
+		}
+	}
+	events, tracks, err := splitTracks(stream.Bytes())
+	if err != nil {
+		t.Fatalf("failed to split tracks: %v", err)
+	}
+	if tracks == nil || len(events) >= stream.Len() {
+		t.Fatalf("no movements were split off a stream of %d bytes", stream.Len())
+	}
+
+	decoded := make(map[uint32][]action.Action)
+	if _, err := decodeTicks(events, decoded, FormatVersion); err != nil {
+		t.Fatalf("failed to decode events: %v", err)
+	}
+	moves, err := decodeTracks(tracks)
+	if err != nil {
+		t.Fatalf("failed to decode tracks: %v", err)
+	}
+	for tick := uint32(1); tick <= testTicks; tick++ {
+		for _, a := range decoded[tick] {
+			if _, ok := trackOf(a); ok {
+				t.Fatalf("events of tick %d still hold a %T", tick, a)
+			}
+		}
+		merged, err := mergeTracks(decoded[tick], moves[tick])
+		if err != nil {
+			t.Fatalf("failed to merge tracks of tick %d: %v", tick, err)
+		}
+		want, _ := trackTestActions(tick)
+		if !bytes.Equal(encodeActions(merged), encodeActions(want)) {
+			t.Fatalf("merged actions of tick %d differ from the actions split", tick)
+		}
+	}
+}
+
+// TestTracksReplay tests that replays written and recorded with movement tracks hold the same actions as
+// replays without them.
+func TestTracksReplay(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	if err := writeReplayWith(buf, writeOptions{version: FormatVersion, segmentTicks: 50, tracks: true}, testMetadata(), testTicks, trackTestActions); err != nil {
+		t.Fatalf("failed to write replay: %v", err)
+	}
+	d := openTestReplay(t, buf.Bytes(), nil)
+	requireActions(t, d, testTicks, trackTestActions)
+	requireTracks(t, d)
+
+	r := RecorderConfig{SegmentTicks: 50, MovementTracks: true}.New(testMetadata().ID)
+	recordTestActions(r, testTicks)
+	buf.Reset()
+	if err := r.CloseAndSaveActions(buf); err != nil {
+		t.Fatalf("failed to save replay: %v", err)
+	}
+	loaded := NewData(uuid.Nil)
+	if err := loaded.LoadActions(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("failed to load replay: %v", err)
+	}
+	requireActions(t, loaded, testTicks, testActions)
+	requireTracks(t, loaded)
+}
+
+// requireTracks fails the test if any of the segments of the replay passed does not have a tracks record.
+func requireTracks(t *testing.T, d *Data) {
+	t.Helper()
+	for _, seg := range d.segments {
+		if seg.tracks == nil {
+			t.Fatalf("segment of ticks %d through %d has no tracks record", seg.firstTick, seg.lastTick)
+		}
+	}
+}
@@ -91,6 +91,9 @@ func getEntTx(e *entity.Ent) *world.Tx {
 	return reflect.NewAt(rf.Type(), unsafe.Pointer(rf.UnsafeAddr())).Elem().Interface().(*world.Tx)
 }
 
+//go:linkname world_chunk github.com/df-mc/dragonfly/server/world.(*World).chunk
+func world_chunk(*world.World, world.ChunkPos) *world.Column
+
 //go:linkname player_viewers github.com/df-mc/dragonfly/server/player.(*Player).viewers
 func player_viewers(*player.Player) []world.Viewer
 
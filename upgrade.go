@@ -0,0 +1,203 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/sandertv/gophertunnel/minecraft/nbt"
+	"io"
+	"os"
+)
+
+// transform rewrites a complete replay of one format version, passed as b, to the next format version,
+// writing it to w.
+type transform func(b []byte, w io.Writer) error
+
+// transforms holds the transform for every format version that is not the current one, indexed by the
+// format version it upgrades from. Replays of older format versions are upgraded by applying the transforms
+// for every version in between in order. Changing the file format requires bumping FormatVersion and
+// registering a transform from the previous version here. The transform is nil if replays of the previous
+// version are read correctly as they are, in which case they are not rewritten at all, unless an earlier
+// transform was applied.
+var transforms = map[uint16]transform{
+	0: upgradeV0,
+	1: upgradeV1,
+	2: upgradeV2,
+	// Format version 4 only added records that replays of format version 3 do not hold.
+	3: nil,
+}
+
+// Upgrade rewrites the replay read from r to the current format version and writes it to w. The format
+// version the replay was written in is returned. Replays that already have the current format version, or
+// a format version that is read as it is, are copied to w unchanged.
+func Upgrade(r io.Reader, w io.Writer) (uint16, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read replay: %w", err)
+	}
+	upgraded, version, err := upgrade(b)
+	if err != nil {
+		return version, err
+	}
+	if _, err := w.Write(upgraded); err != nil {
+		return version, fmt.Errorf("failed to write replay: %w", err)
+	}
+	return version, nil
+}
+
+// UpgradeFile upgrades the replay file at the path passed to the current format version in place. The file
+// is only replaced once the upgraded replay was written completely, so that the original file remains
+// intact if upgrading fails. True is returned if the file was upgraded, or false if it was left as it is
+// because it needed no transform.
+func UpgradeFile(path string) (bool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read replay: %w", err)
+	}
+	upgraded, version, err := upgrade(b)
+	if err != nil {
+		return false, err
+	}
+	if upToDate(version) {
+		return false, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	tmp := path + ".upgrade"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return false, fmt.Errorf("failed to create upgraded replay: %w", err)
+	}
+	if _, err := f.Write(upgraded); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return false, fmt.Errorf("failed to write upgraded replay: %w", err)
+	}
+	if err := errors.Join(f.Sync(), f.Close()); err != nil {
+		_ = os.Remove(tmp)
+		return false, fmt.Errorf("failed to write upgraded replay: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return false, fmt.Errorf("failed to replace replay: %w", err)
+	}
+	return true, nil
+}
+
+// UpgradeFiles upgrades all replay files at the paths passed in place, as done by UpgradeFile. Files that
+// could not be upgraded do not stop the other files from being upgraded: the errors of all files are
+// returned joined together. The amount of files upgraded is returned.
+func UpgradeFiles(paths ...string) (int, error) {
+	var upgraded int
+	var errs []error
+	for _, path := range paths {
+		ok, err := UpgradeFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		if ok {
+			upgraded++
+		}
+	}
+	return upgraded, errors.Join(errs...)
+}
+
+// upgrade applies the transforms needed to upgrade the replay passed to the current format version. The
+// format version the replay was written in is returned.
+func upgrade(b []byte) ([]byte, uint16, error) {
+	original, _, _, err := readHeader(bytes.NewReader(b))
+	if err != nil {
+		return nil, original, err
+	}
+	for version := original; version < FormatVersion; version++ {
+		t, ok := transforms[version]
+		if !ok {
+			return nil, original, fmt.Errorf("no transform to upgrade format version %d", version)
+		}
+		if t == nil {
+			if version != original {
+				// The replay was rewritten by an earlier transform, so only its format version is changed.
+				binary.LittleEndian.PutUint16(b[len(formatMagic):], version+1)
+			}
+			continue
+		}
+		buf := bytes.NewBuffer(make([]byte, 0, len(b)))
+		if err := t(b, buf); err != nil {
+			return nil, original, fmt.Errorf("failed to upgrade format version %d: %w", version, err)
+		}
+		b = buf.Bytes()
+	}
+	return b, original, nil
+}
+
+// upToDate checks if replays of the format version passed are read as they are, as no transform has to be
+// applied to upgrade them to the current format version.
+func upToDate(version uint16) bool {
+	for ; version < FormatVersion; version++ {
+		if transforms[version] != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// upgradeV0 adds a header to a replay written before the introduction of the header. The metadata in the
+// header only holds the tick rate, as nothing else is known about the replay.
+func upgradeV0(b []byte, w io.Writer) error {
+	encoded, err := nbt.MarshalEncoding(Metadata{TickRate: tickRate}.EncodeNBT(), nbt.LittleEndian)
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata: %w", err)
+	}
+	buf := make([]byte, 0, len(formatMagic)+6+len(encoded)+len(b))
+	buf = append(buf, formatMagic...)
+	buf = binary.LittleEndian.AppendUint16(buf, 1)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(encoded)))
+	buf = append(buf, encoded...)
+	buf = append(buf, b...)
+	_, err = w.Write(buf)
+	return err
+}
+
+// upgradeV1 splits a replay compressed as a whole into segments held by records.
+func upgradeV1(b []byte, w io.Writer) error {
+	_, meta, rest, err := readHeader(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	compressed, err := io.ReadAll(rest)
+	if err != nil {
+		return fmt.Errorf("failed to read compressed data: %w", err)
+	}
+	decompressed, err := segmentDecoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return fmt.Errorf("failed to decompress data: %w", err)
+	}
+	if len(decompressed) < 4 {
+		return errors.New("replay is too short to hold a tick count")
+	}
+	// The tick count is not needed, as the tick stream is read until it is exhausted.
+	actions := make(map[uint32][]action.Action)
+	lastTick, err := decodeTicks(decompressed[4:], actions, 1)
+	if err != nil {
+		return err
+	}
+	return writeReplayWith(w, writeOptions{version: 2}, meta, lastTick, func(tick uint32) ([]action.Action, error) {
+		return actions[tick], nil
+	})
+}
+
+// upgradeV2 prefixes every action with its version and length.
+func upgradeV2(b []byte, w io.Writer) error {
+	d := &Data{}
+	if err := d.Open(bytes.NewReader(b), int64(len(b))); err != nil {
+		return err
+	}
+	// Ticks are read in order, so segments may be evicted as soon as the next one is read.
+	d.SetWindow(1, 0)
+	return writeReplayWith(w, writeOptions{version: 3}, d.meta, uint32(d.totalTicks), d.Actions)
+}
@@ -0,0 +1,139 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/google/uuid"
+	"github.com/klauspost/compress/zstd"
+	"github.com/sandertv/gophertunnel/minecraft/nbt"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// legacyTestReplay returns the actions returned by testActions as written before the introduction of the
+// header: a tick count followed by every tick, of which the actions are prefixed with only their ID, all
+// compressed as a whole.
+func legacyTestReplay(t *testing.T) []byte {
+	t.Helper()
+	buf := bytes.NewBuffer(nil)
+	w := protocol.NewWriter(buf, 0)
+	ticks := uint32(testTicks)
+	w.Uint32(&ticks)
+	for tick := uint32(1); tick <= testTicks; tick++ {
+		actions, _ := testActions(tick)
+		count := uint32(len(actions))
+		w.Varuint32(&tick)
+		w.Varuint32(&count)
+		for _, a := range actions {
+			id := a.ID()
+			w.Uint8(&id)
+			a.Marshal(w)
+		}
+	}
+	enc, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatalf("failed to create encoder: %v", err)
+	}
+	defer enc.Close()
+	return enc.EncodeAll(buf.Bytes(), nil)
+}
+
+// TestUpgrade tests that replays of every format version are upgraded to the current format version without
+// changing their actions or metadata, and that replays of format versions that are read as they are are left
+// unchanged.
+func TestUpgrade(t *testing.T) {
+	v0 := legacyTestReplay(t)
+	encoded, err := nbt.MarshalEncoding(testMetadata().EncodeNBT(), nbt.LittleEndian)
+	if err != nil {
+		t.Fatalf("failed to encode metadata: %v", err)
+	}
+	v1 := append([]byte(formatMagic), 0, 0)
+	binary.LittleEndian.PutUint16(v1[len(formatMagic):], 1)
+	v1 = binary.LittleEndian.AppendUint32(v1, uint32(len(encoded)))
+	v1 = append(append(v1, encoded...), v0...)
+
+	replays := []struct {
+		version uint16
+		b       []byte
+	}{
+		{0, v0},
+		{1, v1},
+		{2, writeTestReplay(t, writeOptions{version: 2})},
+		{3, writeTestReplay(t, writeOptions{version: 3})},
+		{FormatVersion, writeTestReplay(t, writeOptions{})},
+	}
+	for _, r := range replays {
+		out := bytes.NewBuffer(nil)
+		version, err := Upgrade(bytes.NewReader(r.b), out)
+		if err != nil {
+			t.Fatalf("failed to upgrade format version %d: %v", r.version, err)
+		}
+		if version != r.version {
+			t.Fatalf("upgrade of format version %d returned format version %d", r.version, version)
+		}
+		wantVersion := FormatVersion
+		if upToDate(r.version) {
+			if !bytes.Equal(out.Bytes(), r.b) {
+				t.Fatalf("replay of format version %d was changed by upgrading it", r.version)
+			}
+			wantVersion = r.version
+		}
+		d := openTestReplay(t, out.Bytes(), nil)
+		if d.Version() != wantVersion {
+			t.Fatalf("upgraded replay of format version %d has format version %d, expected %d", r.version, d.Version(), wantVersion)
+		}
+		requireActions(t, d, testTicks, testActions)
+
+		wantID := testMetadata().ID
+		if r.version == 0 {
+			// Replays without a header do not have an ID, so the ID passed to NewData is kept.
+			wantID = uuid.Nil
+		}
+		if d.Metadata().ID != wantID {
+			t.Fatalf("upgraded replay of format version %d has ID %v, expected %v", r.version, d.Metadata().ID, wantID)
+		}
+
+		loaded := NewData(uuid.Nil)
+		if err := loaded.LoadActions(bytes.NewReader(r.b)); err != nil {
+			t.Fatalf("failed to load replay of format version %d: %v", r.version, err)
+		}
+		if loaded.Version() != r.version {
+			t.Fatalf("replay of format version %d was loaded as format version %d", r.version, loaded.Version())
+		}
+		requireActions(t, loaded, testTicks, testActions)
+	}
+}
+
+// TestUpgradeSigned tests that signed replays of format versions that are read as they are are left
+// unchanged by upgrading them, so that their signature remains valid.
+func TestUpgradeSigned(t *testing.T) {
+	b := writeTestReplay(t, writeOptions{version: 3, signer: HMACKey("secret")})
+	out := bytes.NewBuffer(nil)
+	if _, err := Upgrade(bytes.NewReader(b), out); err != nil {
+		t.Fatalf("failed to upgrade replay: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), b) {
+		t.Fatal("signed replay was changed by upgrading it")
+	}
+
+	path := filepath.Join(t.TempDir(), "replay.dfr")
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatalf("failed to write replay: %v", err)
+	}
+	if upgraded, err := UpgradeFile(path); err != nil || upgraded {
+		t.Fatalf("upgrading signed replay file returned %v, %v, expected it to be left as it is", upgraded, err)
+	}
+	upgraded, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read replay: %v", err)
+	}
+	report, err := openTestReplay(t, upgraded, nil).Verify(HMACKey("secret"))
+	if err != nil {
+		t.Fatalf("failed to verify replay: %v", err)
+	}
+	if !report.Valid() {
+		t.Fatalf("signature of upgraded replay is no longer valid: %+v", report)
+	}
+}
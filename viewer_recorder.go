@@ -156,17 +156,7 @@ func (r *RecorderViewer) ViewEntityState(e world.Entity) {
 		r.playerStatesMu.Unlock()
 
 		if !ok {
-			// TODO: higher disk usage
-			r.r.PushPlayerSneaking(e, s.Sneaking)
-			r.r.PushPlayerUsingItem(e, s.UsingItem)
-			r.r.PushPlayerVisibility(e, !s.Invisible)
-			r.r.PushPlayerSprinting(e, s.Sprinting)
-			r.r.PushPlayerGliding(e, s.Gliding)
-			r.r.PushPlayerCrawling(e, s.Crawling)
-			r.r.PushPlayerSwimming(e, s.Swimming)
-			r.r.PushSetPlayerNameTag(e, s.NameTag)
-			r.r.PushPlayerOnFire(e, s.OnFire)
-			r.r.PushPlayerSetVisibleEffects(e, s.VisibleParticleEffectIDs)
+			r.r.pushPlayerStates(e, s)
 			return
 		}
 
@@ -0,0 +1,160 @@
+package replay
+
+import (
+	"bytes"
+	"github.com/akmalfairuz/df-replay/action"
+	"github.com/klauspost/compress/zstd"
+	"github.com/samber/lo"
+	"github.com/sandertv/gophertunnel/minecraft/protocol"
+	"io"
+	"slices"
+)
+
+// writeReplay writes a complete replay holding the ticks 1 through lastTick to w, as done when a replay is
+// salvaged, converted or edited. actionsAt is called for every tick in order. Every segment but the first
+// starts with a keyframe. The block and item hashes of the actions must be those of the registry of the
+// server, as the palette of the replay written is created from it. PlayerSkin actions are replaced with
+// PlayerSkinRef actions referring to the skin table of the replay written, while PlayerSkinRef actions must
+// not be passed.
+func writeReplay(w io.Writer, meta Metadata, lastTick uint32, actionsAt func(tick uint32) ([]action.Action, error)) error {
+	return writeReplayWith(w, writeOptions{version: FormatVersion}, meta, lastTick, actionsAt)
+}
+
+// writeOptions holds the options used to write a replay using writeReplayWith.
+type writeOptions struct {
+	// version is the format version of the replay written, which must be 2 or up.
+	version uint16
+	// cipher, if not nil, is used to encrypt the replay written. It may only be set for the current format
+	// version.
+	cipher *recordCipher
+	// level is the compression level of the segments and skins written. If 0, zstd.SpeedBestCompression is
+	// used.
+	level zstd.EncoderLevel
+	// segmentTicks is the amount of ticks in every segment written. If 0, defaultSegmentTicks is used.
+	segmentTicks uint32
+	// tracks specifies if the movements of every segment are written to a tracks record. It may only be set
+	// for the current format version.
+	tracks bool
+	// dictionary, if not nil, is the Dictionary that the segments written are compressed using. It may only
+	// be set for the current format version.
+	dictionary *Dictionary
+	// snapshot holds the chunks of the snapshot of the replay written, which are written before the first
+	// segment. It may only be set for the current format version.
+	snapshot []snapshotChunk
+	// signer, if not nil, is used to sign the replay written.
+	signer Signer
+}
+
+// writeReplayWith writes a complete replay like writeReplay, using the options passed.
+func writeReplayWith(w io.Writer, opts writeOptions, meta Metadata, lastTick uint32, actionsAt func(tick uint32) ([]action.Action, error)) error {
+	version := opts.version
+	if opts.level == 0 {
+		opts.level = zstd.SpeedBestCompression
+	}
+	if opts.segmentTicks == 0 {
+		opts.segmentTicks = defaultSegmentTicks
+	}
+	encoder, err := newEncoder(opts.level, opts.dictionary)
+	if err != nil {
+		return err
+	}
+	defer encoder.Close()
+	skinEncoder := encoder
+	if opts.dictionary != nil {
+		// Skins are always compressed without a dictionary, so that they may be read by Salvage.
+		if skinEncoder, err = newEncoder(opts.level, nil); err != nil {
+			return err
+		}
+		defer skinEncoder.Close()
+	}
+	// The metadata of the replay read refers to the dictionary it was compressed using, which need not be
+	// the one used to write the replay.
+	meta.DictionaryID = dictionaryID(opts.dictionary)
+
+	cw := newContainerWriter(w)
+	cw.version, cw.cipher, cw.signer = version, opts.cipher, opts.signer
+	if err := cw.writeHeader(meta); err != nil {
+		return err
+	}
+	for _, s := range opts.snapshot {
+		if err := cw.writeSnapshotChunk(s); err != nil {
+			return err
+		}
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, 8192))
+	pw := protocol.NewWriter(buf, 0)
+	firstTick := uint32(1)
+	sc := newSceneSet()
+	palette := newPalette()
+	skinHashes := make(map[[32]byte]struct{})
+	var skins []recordedSkin
+	for tick := uint32(1); tick <= lastTick; tick++ {
+		actions, err := actionsAt(tick)
+		if err != nil {
+			return err
+		}
+		// Keyframes of the replay read do not necessarily line up with the segments written, so they are
+		// replaced with keyframes at the start of every segment.
+		actions = slices.DeleteFunc(slices.Clone(actions), isKeyframe)
+		if tick == firstTick && tick > 1 {
+			actions = append(sc.keyframes(tick), actions...)
+		}
+		actions = dropRedundantSources(actions)
+		pw.Varuint32(lo.ToPtr(tick))
+		pw.Varuint32(lo.ToPtr(uint32(len(actions))))
+		for i, a := range actions {
+			if sk, ok := a.(*action.PlayerSkin); ok && version >= prefixedActionsVersion {
+				encoded, hash := encodeSkin(sk.Skin())
+				if _, ok := skinHashes[hash]; !ok {
+					skinHashes[hash] = struct{}{}
+					skins = append(skins, recordedSkin{hash: hash, compressed: skinEncoder.EncodeAll(encoded, nil)})
+				}
+				a = &action.PlayerSkinRef{PlayerID: sk.PlayerID, SkinHash: hash}
+				actions[i] = a
+			}
+			if version < prefixedActionsVersion {
+				pw.Uint8(lo.ToPtr(a.ID()))
+				a.Marshal(pw)
+			} else {
+				action.Write(pw, a)
+			}
+			sc.apply(tick, a)
+			palette.add(a)
+		}
+		if tick-firstTick+1 >= opts.segmentTicks || tick == lastTick {
+			// Skins are written before the first segment referring to them, so that they are still found if
+			// the replay is salvaged.
+			for _, s := range skins {
+				if err := cw.writeSkin(s); err != nil {
+					return err
+				}
+			}
+			skins = skins[:0]
+			events := buf.Bytes()
+			if opts.tracks {
+				var tracks []byte
+				if events, tracks, err = splitTracks(events); err != nil {
+					return err
+				}
+				if tracks != nil {
+					if err := cw.writeTracks(firstTick, tick, encoder.EncodeAll(tracks, nil)); err != nil {
+						return err
+					}
+				}
+			}
+			if err := cw.writeSegment(firstTick, tick, encoder.EncodeAll(events, nil)); err != nil {
+				return err
+			}
+			buf.Reset()
+			firstTick = tick + 1
+		}
+	}
+	encoded, err := encodePalette(palette)
+	if err != nil {
+		return err
+	}
+	if err := cw.writePalette(encoded); err != nil {
+		return err
+	}
+	return cw.close(meta)
+}